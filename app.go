@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/cmd/http/handler"
+	"github.com/bhanupbalusu/gocomboums_v4/cmd/http/openapi"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/middleware"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+// app is the services built on one set of repositories and the HTTP routes
+// that serve them
+type app struct {
+	router *gin.Engine
+
+	users       *service.UserService
+	roles       *service.RoleService
+	permissions *service.PermissionService
+	authzCache  *service.AuthzCache
+	revocations *service.TokenRevocations
+	unitOfWork  repository.UnitOfWork
+}
+
+// newApp builds the services and routes on repos, signing tokens with tokenKey
+func newApp(cfg *config.Config, repos *repositories, tokenKey []byte) (*app, error) {
+	// Destructive and privileged routes require a login no older than this
+	recentAuth := middleware.RequireRecentAuth(time.Duration(cfg.Tokens.StepUpMaxAge))
+
+	spec, err := openapi.Load()
+	if err != nil {
+		return nil, fmt.Errorf("loading the OpenAPI document: %w", err)
+	}
+
+	r := gin.Default()
+	r.Use(middleware.RequestID())
+	// Response checks wrap the error handler so that problem responses are checked too
+	if cfg.Server.ValidateResponses {
+		r.Use(middleware.ValidateResponses(spec))
+	}
+	r.Use(middleware.ErrorHandler())
+	if cfg.Server.ValidateRequests {
+		r.Use(middleware.ValidateRequests(spec))
+	}
+	r.Use(middleware.QueryDeadline(time.Duration(cfg.Database.QueryTimeout), cfg.Database.RouteTimeouts()))
+
+	// Handlers run multi-step writes in one transaction carried by the request context
+	uow := repos.unitOfWork
+
+	userRepo := repos.users
+	roleRepo := repos.roles
+	permissionRepo := repos.permissions
+
+	// Authorization checks read roles and permissions through one cache, which
+	// every service that changes them invalidates, here and on the other
+	// instances. Deleted users' tokens are refused until they expire.
+	tokenLifetime := time.Duration(cfg.Tokens.AccessTTL)
+	if impersonation := time.Duration(cfg.Tokens.ImpersonationTTL); impersonation > tokenLifetime {
+		tokenLifetime = impersonation
+	}
+	revocations := service.NewTokenRevocations(tokenLifetime)
+	authzCache := service.NewAuthzCache(userRepo, roleRepo, permissionRepo, repos.policies, repos.publisher, revocations, time.Duration(cfg.AuthzCache.TTL), cfg.AuthzCache.MaxUsers, cfg.AuthzCache.MaxRoles)
+	authzCache.Resync()
+
+	// The configured admins hold every permission, so that someone can grant the rest
+	err = uow.Do(context.Background(), func(ctx context.Context) error {
+		return service.BootstrapAdmins(ctx, userRepo, roleRepo, permissionRepo, authzCache, cfg.BootstrapAdmins)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bootstrapping admins: %w", err)
+	}
+
+	// Create User Service and User Handler
+	userService := service.NewUserService(userRepo, authzCache)
+
+	// Create Role Service and Role Handler
+	roleService := service.NewRoleService(roleRepo, authzCache)
+	roleHandler := handler.NewRoleHandler(roleService, uow)
+
+	// Create Permission Service and Permission Handler
+	permissionService := service.NewPermissionService(permissionRepo, authzCache)
+	permissionHandler := handler.NewPermissionHandler(permissionService, uow)
+
+	// Create Policy Service and Policy Handler
+	policyService := service.NewPolicyService(repos.policies, authzCache)
+	policyHandler := handler.NewPolicyHandler(policyService, uow)
+
+	// Check passwords against LDAP first when configured, then against the users table
+	authenticators := service.ChainAuthenticator{}
+	if cfg.LDAP != nil {
+		authenticators = append(authenticators, service.NewLDAPAuthenticator(*cfg.LDAP, userRepo, roleRepo, authzCache))
+	}
+	authenticators = append(authenticators, service.NewPasswordAuthenticator(userRepo))
+	userHandler := handler.NewUserHandler(userService, authenticators, tokenKey)
+
+	// Create Federation Service and Federation Handler, one connector per configured provider
+	identityRepo := repos.identities
+	federationService := service.NewFederationService(userRepo, roleRepo, identityRepo, authzCache)
+	for _, provider := range cfg.OIDCProviders {
+		if !cfg.Features.Federation {
+			break
+		}
+		connector, err := service.NewOIDCConnector(context.Background(), provider, nil)
+		if err != nil {
+			logs.Error("failed to set up identity provider "+provider.ID, err)
+			continue
+		}
+		federationService.RegisterConnector(connector, provider.RoleMappings)
+	}
+	federationHandler := handler.NewFederationHandler(federationService, tokenKey)
+
+	r.GET("/openapi.json", handler.OpenAPIDocument)
+
+	routes := &apiRoutes{
+		features:    cfg.Features,
+		users:       userHandler,
+		roles:       roleHandler,
+		permissions: permissionHandler,
+		authz:       handler.NewAuthzHandler(permissionService, authzCache),
+		policies:    policyHandler,
+		federation:  federationHandler,
+		tokenKey:    tokenKey,
+		revocations: revocations,
+		recentAuth:  recentAuth,
+		require: func(permission string) gin.HandlerFunc {
+			return middleware.RequirePermission(permissionService, permission)
+		},
+		access: func(permission string, resource string) gin.HandlerFunc {
+			return middleware.RequireAccess(permissionService, permission, resource)
+		},
+	}
+	routes.registerV1(r)
+	routes.registerLegacy(r)
+
+	// SCIM provisioning routes authenticate with their own shared bearer token
+	if cfg.SCIMToken != "" {
+		scimHandler := handler.NewSCIMHandler(service.NewSCIMService(userRepo, roleRepo, authzCache), uow)
+
+		scimRoutes := r.Group("/scim/v2")
+		scimRoutes.Use(middleware.StaticBearerToken(cfg.SCIMToken))
+		{
+			scimRoutes.GET("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+			scimRoutes.GET("/ResourceTypes", scimHandler.ResourceTypes)
+			scimRoutes.GET("/ResourceTypes/:id", scimHandler.GetResourceType)
+			scimRoutes.GET("/Schemas", scimHandler.Schemas)
+			scimRoutes.GET("/Schemas/:id", scimHandler.GetSchema)
+
+			scimRoutes.GET("/Users", scimHandler.ListUsers)
+			scimRoutes.POST("/Users", scimHandler.CreateUser)
+			scimRoutes.GET("/Users/:id", scimHandler.GetUser)
+			scimRoutes.PUT("/Users/:id", scimHandler.ReplaceUser)
+			scimRoutes.PATCH("/Users/:id", scimHandler.PatchUser)
+			scimRoutes.DELETE("/Users/:id", scimHandler.DeleteUser)
+
+			scimRoutes.GET("/Groups", scimHandler.ListGroups)
+			scimRoutes.POST("/Groups", scimHandler.CreateGroup)
+			scimRoutes.GET("/Groups/:id", scimHandler.GetGroup)
+			scimRoutes.PUT("/Groups/:id", scimHandler.ReplaceGroup)
+			scimRoutes.PATCH("/Groups/:id", scimHandler.PatchGroup)
+			scimRoutes.DELETE("/Groups/:id", scimHandler.DeleteGroup)
+		}
+	}
+
+	return &app{
+		router:      r,
+		users:       userService,
+		roles:       roleService,
+		permissions: permissionService,
+		authzCache:  authzCache,
+		revocations: revocations,
+		unitOfWork:  uow,
+	}, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/database"
+)
+
+// repositories is the storage the services are built on, from one backend
+type repositories struct {
+	users       repository.UserRepository
+	roles       repository.RoleRepository
+	permissions repository.PermissionRepository
+	identities  repository.IdentityRepository
+	policies    repository.PolicyRepository
+	unitOfWork  repository.UnitOfWork
+
+	// changes reach the other instances on the same database through
+	// publisher and arrive from them through listener, which is nil when
+	// there can be no other instances or notifyChannel is empty
+	publisher repository.ChangePublisher
+	listener  *repository.ChangeListener
+}
+
+// openRepositories builds the repositories of the configured driver and
+// brings its schema up to date when MigrateOnStart is set
+func openRepositories(cfg config.Database, notifyChannel string, openDB func() (*gorm.DB, error)) (*repositories, error) {
+	if cfg.Driver == config.DriverMemory {
+		store := repository.NewMemoryStore()
+		return &repositories{
+			users:       repository.NewMemoryUserRepository(store),
+			roles:       repository.NewMemoryRoleRepository(store),
+			permissions: repository.NewMemoryPermissionRepository(store),
+			identities:  repository.NewMemoryIdentityRepository(store),
+			policies:    repository.NewMemoryPolicyRepository(store),
+			unitOfWork:  repository.NewMemoryUnitOfWork(store),
+			publisher:   repository.NewNopPublisher(),
+		}, nil
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MigrateOnStart {
+		// SQLite's tables come from the models, Postgres's from the SQL migrations
+		if cfg.Driver == config.DriverSQLite {
+			err = db.AutoMigrate(database.SQLiteModels...)
+		} else {
+			err = migrateUp(db)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	repos := &repositories{
+		users:       repository.NewUserRepository(db),
+		roles:       repository.NewRoleRepository(db),
+		permissions: repository.NewPermissionRepository(db),
+		identities:  repository.NewIdentityRepository(db),
+		policies:    repository.NewPolicyRepository(db),
+		unitOfWork:  repository.NewUnitOfWork(db),
+		publisher:   repository.NewNopPublisher(),
+	}
+	// Only Postgres can be shared by several instances
+	if cfg.Driver == config.DriverPostgres && notifyChannel != "" {
+		repos.publisher = repository.NewNotifyPublisher(db, notifyChannel)
+		repos.listener = repository.NewChangeListener(cfg.ConnectionString(), notifyChannel)
+	}
+	return repos, nil
+}
+
+// openDatabase connects to Postgres or SQLite and sizes the connection pool
+func openDatabase(cfg config.Database) (*gorm.DB, error) {
+	dialector := postgres.Open(cfg.ConnectionString())
+	if cfg.Driver == config.DriverSQLite {
+		dialector = database.SQLiteDialector(cfg.SQLiteDSN())
+	}
+	// TranslateError reports unique violations of either driver as gorm.ErrDuplicatedKey
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+	sqlDB.SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTime))
+	if cfg.Driver == config.DriverSQLite {
+		// SQLite allows one writer at a time; a single connection queues
+		// writers instead of failing them with "database is locked"
+		sqlDB.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	umsv1 "github.com/bhanupbalusu/gocomboums_v4/api/ums/v1"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/middleware"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+// RecentAuthMethods are the calls that need a recent login, matching the
+// HTTP routes guarded by middleware.RequireRecentAuth
+var RecentAuthMethods = map[string]bool{
+	umsv1.UserService_DeleteUser_FullMethodName:                 true,
+	umsv1.RoleService_DeleteRole_FullMethodName:                 true,
+	umsv1.PermissionService_DeletePermission_FullMethodName:     true,
+	umsv1.PermissionService_GrantRolePermission_FullMethodName:  true,
+	umsv1.PermissionService_RevokeRolePermission_FullMethodName: true,
+}
+
+// MethodAccess is the permission a call requires and the resource it is
+// checked on, where {id} stands for the ID the request names. Calls without a
+// resource are checked globally, so only global grants count.
+type MethodAccess struct {
+	Permission string
+	Resource   string
+}
+
+// MethodPermissions names the access each call that changes users, roles,
+// permissions or assignments requires, matching the HTTP routes
+var MethodPermissions = map[string]MethodAccess{
+	umsv1.UserService_UpdateUser_FullMethodName:                 {service.PermissionUpdateUsers, "users:{id}"},
+	umsv1.UserService_DeleteUser_FullMethodName:                 {service.PermissionDeleteUsers, "users:{id}"},
+	umsv1.RoleService_CreateRole_FullMethodName:                 {Permission: service.PermissionManageRoles},
+	umsv1.RoleService_UpdateRole_FullMethodName:                 {Permission: service.PermissionManageRoles},
+	umsv1.RoleService_DeleteRole_FullMethodName:                 {Permission: service.PermissionManageRoles},
+	umsv1.RoleService_AssignUserRole_FullMethodName:             {Permission: service.PermissionAssignRoles},
+	umsv1.RoleService_RevokeUserRole_FullMethodName:             {Permission: service.PermissionAssignRoles},
+	umsv1.PermissionService_CreatePermission_FullMethodName:     {Permission: service.PermissionManagePermissions},
+	umsv1.PermissionService_UpdatePermission_FullMethodName:     {Permission: service.PermissionManagePermissions},
+	umsv1.PermissionService_DeletePermission_FullMethodName:     {Permission: service.PermissionManagePermissions},
+	umsv1.PermissionService_GrantRolePermission_FullMethodName:  {Permission: service.PermissionGrantPermissions},
+	umsv1.PermissionService_RevokeRolePermission_FullMethodName: {Permission: service.PermissionGrantPermissions},
+}
+
+type claimsKey struct{}
+
+// UnaryAuthInterceptor verifies the token in the "authorization" metadata of
+// every call, with or without a "Bearer " prefix, against key and stores its claims in
+// the call's context. Tokens in revocations are refused, and calls to the
+// methods in recentAuth are refused unless the user logged in within stepUpMaxAge. Calls made with an impersonation
+// token are logged with both identities.
+func UnaryAuthInterceptor(key []byte, revocations *service.TokenRevocations, stepUpMaxAge time.Duration, recentAuth map[string]bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var token string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) > 0 {
+				token = strings.TrimPrefix(values[0], "Bearer ")
+			}
+		}
+		if token == "" {
+			return nil, status.Error(codes.Unauthenticated, "Authorization metadata is missing")
+		}
+
+		claims, err := service.VerifyAndExtractClaims(token, key)
+		if err != nil || revocations.Revoked(claims) {
+			return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+		}
+
+		if recentAuth[info.FullMethod] && (claims.AuthTime == 0 || time.Since(time.Unix(claims.AuthTime, 0)) > stepUpMaxAge) {
+			return nil, stepUpStatus(stepUpMaxAge)
+		}
+
+		resp, err := handler(context.WithValue(ctx, claimsKey{}, claims), req)
+
+		if claims.Actor != nil {
+			logs.WithFields(logrus.Fields{
+				"event":          "impersonated_request",
+				"user_id":        claims.UserID,
+				"username":       claims.Username,
+				"actor_id":       claims.Actor.UserID,
+				"actor_username": claims.Actor.Username,
+				"method":         info.FullMethod,
+				"code":           status.Code(err).String(),
+			}).Info("request made with impersonation token")
+		}
+		return resp, err
+	}
+}
+
+// UnaryPermissionInterceptor refuses calls to the methods in required unless
+// the caller holds the permission named for the method on its resource. It
+// must run after UnaryAuthInterceptor.
+func UnaryPermissionInterceptor(permissionService *service.PermissionService, required map[string]MethodAccess) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		access, ok := required[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		claims := CurrentClaims(ctx)
+		if claims == nil {
+			return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+		}
+		userID, err := strconv.ParseUint(claims.UserID, 10, 64)
+		if err != nil {
+			return nil, status.Error(codes.PermissionDenied, "Forbidden")
+		}
+		decision, err := permissionService.Authorize(ctx, service.AccessCheck{
+			UserID:     userID,
+			Permission: access.Permission,
+			Resource:   expandResource(access.Resource, req),
+			Claims:     claims,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !decision.Allowed {
+			return nil, status.Error(codes.PermissionDenied, "Forbidden")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// expandResource fills {id} in resource with the ID req names
+func expandResource(resource string, req interface{}) string {
+	if withID, ok := req.(interface{ GetId() uint64 }); ok {
+		return strings.ReplaceAll(resource, "{id}", strconv.FormatUint(withID.GetId(), 10))
+	}
+	return resource
+}
+
+// stepUpStatus asks for a fresh login, carrying the same error code and
+// max_age as the HTTP step-up response
+func stepUpStatus(maxAge time.Duration) error {
+	seconds := int64(maxAge / time.Second)
+	st := status.New(codes.Unauthenticated, "Re-authenticate within the last "+strconv.FormatInt(seconds, 10)+" seconds to continue")
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   middleware.StepUpRequired,
+		Metadata: map[string]string{"max_age": fmt.Sprint(seconds)},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// CurrentClaims returns the claims stored by UnaryAuthInterceptor, or nil
+func CurrentClaims(ctx context.Context) *service.Claims {
+	claims, _ := ctx.Value(claimsKey{}).(*service.Claims)
+	return claims
+}
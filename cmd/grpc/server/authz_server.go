@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	umsv1 "github.com/bhanupbalusu/gocomboums_v4/api/ums/v1"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+type AuthorizationServer struct {
+	umsv1.UnimplementedAuthorizationServiceServer
+	PermissionService *service.PermissionService
+	RoleService       *service.RoleService
+}
+
+func NewAuthorizationServer(permissionService *service.PermissionService, roleService *service.RoleService) *AuthorizationServer {
+	return &AuthorizationServer{
+		PermissionService: permissionService,
+		RoleService:       roleService,
+	}
+}
+
+func (s *AuthorizationServer) CheckPermission(ctx context.Context, req *umsv1.CheckPermissionRequest) (*umsv1.CheckResponse, error) {
+	var v validation.Validator
+	if !v.Required("permission_name", req.GetPermissionName()) {
+		return nil, v.Err()
+	}
+	userID, err := subjectID(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	decision, err := s.PermissionService.Authorize(ctx, service.AccessCheck{
+		UserID:     userID,
+		Permission: req.GetPermissionName(),
+		Resource:   req.GetResource(),
+		Claims:     CurrentClaims(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &umsv1.CheckResponse{Allowed: decision.Allowed}, nil
+}
+
+func (s *AuthorizationServer) CheckRole(ctx context.Context, req *umsv1.CheckRoleRequest) (*umsv1.CheckResponse, error) {
+	var v validation.Validator
+	if !v.Required("role_name", req.GetRoleName()) {
+		return nil, v.Err()
+	}
+	userID, err := subjectID(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := s.RoleService.UserHasRole(ctx, userID, req.GetRoleName())
+	if err != nil {
+		return nil, err
+	}
+	return &umsv1.CheckResponse{Allowed: allowed}, nil
+}
+
+// subjectID is the user a check is about: the one in the request, or the
+// caller when the request names none
+func subjectID(ctx context.Context, userID uint64) (uint64, error) {
+	if userID != 0 {
+		return userID, nil
+	}
+	claims := CurrentClaims(ctx)
+	if claims == nil {
+		return 0, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	id, err := strconv.ParseUint(claims.UserID, 10, 64)
+	if err != nil {
+		return 0, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	return id, nil
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/url"
+	"strconv"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	umsv1 "github.com/bhanupbalusu/gocomboums_v4/api/ums/v1"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+)
+
+// listQuery reads a ListRequest with the grammar of the HTTP list query
+// parameters, so both APIs accept the same sorts and filters
+func listQuery(req *umsv1.ListRequest, fields repository.ListFields) (repository.ListQuery, error) {
+	params := url.Values{}
+	for key, value := range req.GetFilter() {
+		params.Set(key, value)
+	}
+	if req.GetLimit() != 0 {
+		params.Set("limit", strconv.Itoa(int(req.GetLimit())))
+	}
+	if req.GetCursor() != "" {
+		params.Set("cursor", req.GetCursor())
+	}
+	if req.GetSort() != "" {
+		params.Set("sort", req.GetSort())
+	}
+	if req.GetTotal() {
+		params.Set("total", "true")
+	}
+	return repository.ParseListParams(params, fields)
+}
+
+// nextCursor encodes the cursor of the following page, empty on the last
+func nextCursor(next *repository.Cursor) string {
+	if next == nil {
+		return ""
+	}
+	return next.Encode()
+}
+
+func toUser(user *model.User) *umsv1.User {
+	return &umsv1.User{
+		Id:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		CreatedAt: timestamppb.New(user.CreatedAt),
+		UpdatedAt: timestamppb.New(user.UpdatedAt),
+	}
+}
+
+func toUsers(page repository.Page[*model.User]) *umsv1.ListUsersResponse {
+	resp := &umsv1.ListUsersResponse{
+		Users:      make([]*umsv1.User, len(page.Items)),
+		NextCursor: nextCursor(page.Next),
+		Total:      page.Total,
+	}
+	for i, user := range page.Items {
+		resp.Users[i] = toUser(user)
+	}
+	return resp
+}
+
+func toUserMatch(match repository.UserMatch) *umsv1.UserMatch {
+	highlights := make(map[string]*umsv1.Spans, len(match.Highlights))
+	for field, spans := range match.Highlights {
+		converted := &umsv1.Spans{}
+		for _, span := range spans {
+			converted.Spans = append(converted.Spans, &umsv1.Span{Start: int32(span.Start), End: int32(span.End)})
+		}
+		highlights[field] = converted
+	}
+	return &umsv1.UserMatch{
+		User:       toUser(match.User),
+		Score:      match.Score,
+		Highlights: highlights,
+	}
+}
+
+func toRole(role *model.Role) *umsv1.Role {
+	return &umsv1.Role{
+		Id:        role.ID,
+		RoleName:  role.RoleName,
+		CreatedAt: timestamppb.New(role.CreatedAt),
+		UpdatedAt: timestamppb.New(role.UpdatedAt),
+	}
+}
+
+func toRoles(page repository.Page[model.Role]) *umsv1.ListRolesResponse {
+	resp := &umsv1.ListRolesResponse{
+		Roles:      make([]*umsv1.Role, len(page.Items)),
+		NextCursor: nextCursor(page.Next),
+		Total:      page.Total,
+	}
+	for i := range page.Items {
+		resp.Roles[i] = toRole(&page.Items[i])
+	}
+	return resp
+}
+
+func toRoleAssignments(assignments []repository.RoleAssignment) *umsv1.ListRoleAssignmentsResponse {
+	resp := &umsv1.ListRoleAssignmentsResponse{
+		Assignments: make([]*umsv1.RoleAssignment, len(assignments)),
+	}
+	for i := range assignments {
+		resp.Assignments[i] = &umsv1.RoleAssignment{
+			Role:  toRole(&assignments[i].Role),
+			Scope: assignments[i].Scope,
+		}
+	}
+	return resp
+}
+
+func toPermission(permission *model.Permission) *umsv1.Permission {
+	return &umsv1.Permission{
+		Id:             permission.ID,
+		PermissionName: permission.PermissionName,
+		CreatedAt:      timestamppb.New(permission.CreatedAt),
+		UpdatedAt:      timestamppb.New(permission.UpdatedAt),
+	}
+}
+
+func toPermissions(page repository.Page[model.Permission]) *umsv1.ListPermissionsResponse {
+	resp := &umsv1.ListPermissionsResponse{
+		Permissions: make([]*umsv1.Permission, len(page.Items)),
+		NextCursor:  nextCursor(page.Next),
+		Total:       page.Total,
+	}
+	for i := range page.Items {
+		resp.Permissions[i] = toPermission(&page.Items[i])
+	}
+	return resp
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/bhanupbalusu/gocomboums_v4/middleware"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// statusCodes maps the HTTP status of a problem to its gRPC code
+var statusCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusUnprocessableEntity: codes.InvalidArgument,
+	http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// UnaryErrorInterceptor converts the errors the servers return into gRPC
+// statuses; errors that already are statuses pass through
+func UnaryErrorInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if _, ok := status.FromError(err); ok {
+			return resp, err
+		}
+		return resp, toStatus(info.FullMethod, err)
+	}
+}
+
+// toStatus converts an error from a service into a gRPC status, classifying
+// it as the HTTP API does. Rejected fields become BadRequest details, and
+// server errors are logged without showing their cause.
+func toStatus(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	problem := middleware.NewProblem(err)
+	code, ok := statusCodes[problem.Status]
+	if !ok {
+		code = codes.Unknown
+	}
+	if problem.Status >= http.StatusInternalServerError {
+		logs.WithFields(logrus.Fields{
+			"method": method,
+			"error":  err.Error(),
+		}).Error("request failed")
+	}
+
+	st := status.New(code, problem.Detail)
+	if len(problem.Errors) == 0 {
+		return st.Err()
+	}
+	details := &errdetails.BadRequest{}
+	for _, field := range problem.Errors {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field.Field,
+			Description: field.Message,
+		})
+	}
+	if detailed, err := st.WithDetails(details); err == nil {
+		return detailed.Err()
+	}
+	return st.Err()
+}
+
+// requireID rejects a zero ID the way pathID does for HTTP
+func requireID(field string, id uint64) error {
+	var v validation.Validator
+	v.RequiredID(field, id)
+	return v.Err()
+}
+
+// requireIDs rejects each zero ID of a request naming two resources
+func requireIDs(field string, id uint64, otherField string, otherID uint64) error {
+	var v validation.Validator
+	v.RequiredID(field, id)
+	v.RequiredID(otherField, otherID)
+	return v.Err()
+}
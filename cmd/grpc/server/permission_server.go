@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	umsv1 "github.com/bhanupbalusu/gocomboums_v4/api/ums/v1"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+)
+
+type PermissionServer struct {
+	umsv1.UnimplementedPermissionServiceServer
+	PermissionService *service.PermissionService
+	UnitOfWork        repository.UnitOfWork
+}
+
+func NewPermissionServer(permissionService *service.PermissionService, uow repository.UnitOfWork) *PermissionServer {
+	return &PermissionServer{
+		PermissionService: permissionService,
+		UnitOfWork:        uow,
+	}
+}
+
+func (s *PermissionServer) GetPermission(ctx context.Context, req *umsv1.IDRequest) (*umsv1.Permission, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+
+	permission, err := s.PermissionService.GetPermissionByID(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toPermission(&permission), nil
+}
+
+func (s *PermissionServer) ListPermissions(ctx context.Context, req *umsv1.ListRequest) (*umsv1.ListPermissionsResponse, error) {
+	query, err := listQuery(req, repository.PermissionListFields)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.PermissionService.QueryPermissions(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return toPermissions(page), nil
+}
+
+func (s *PermissionServer) ListRolePermissions(ctx context.Context, req *umsv1.ListRelatedRequest) (*umsv1.ListPermissionsResponse, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+	query, err := listQuery(req.GetList(), repository.PermissionListFields)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.PermissionService.ListRolePermissions(ctx, req.GetId(), query)
+	if err != nil {
+		return nil, err
+	}
+	return toPermissions(page), nil
+}
+
+func (s *PermissionServer) CreatePermission(ctx context.Context, req *umsv1.CreatePermissionRequest) (*umsv1.Permission, error) {
+	permission, err := s.PermissionService.CreatePermission(ctx, model.Permission{PermissionName: req.GetPermissionName()})
+	if err != nil {
+		return nil, err
+	}
+	return toPermission(&permission), nil
+}
+
+func (s *PermissionServer) UpdatePermission(ctx context.Context, req *umsv1.UpdatePermissionRequest) (*umsv1.Permission, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+
+	permission, err := s.PermissionService.UpdatePermission(ctx, model.Permission{ID: req.GetId(), PermissionName: req.GetPermissionName()})
+	if err != nil {
+		return nil, err
+	}
+	return toPermission(&permission), nil
+}
+
+func (s *PermissionServer) DeletePermission(ctx context.Context, req *umsv1.IDRequest) (*emptypb.Empty, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.PermissionService.DeletePermission(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *PermissionServer) GrantRolePermission(ctx context.Context, req *umsv1.RolePermissionRequest) (*emptypb.Empty, error) {
+	return s.changeRolePermission(ctx, req, s.PermissionService.AssignPermissionToRole)
+}
+
+func (s *PermissionServer) RevokeRolePermission(ctx context.Context, req *umsv1.RolePermissionRequest) (*emptypb.Empty, error) {
+	return s.changeRolePermission(ctx, req, s.PermissionService.RemovePermissionFromRole)
+}
+
+func (s *PermissionServer) changeRolePermission(ctx context.Context, req *umsv1.RolePermissionRequest, change func(ctx context.Context, roleID, permissionID uint64) error) (*emptypb.Empty, error) {
+	if err := requireIDs("role_id", req.GetRoleId(), "permission_id", req.GetPermissionId()); err != nil {
+		return nil, err
+	}
+
+	err := s.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+		return change(ctx, req.GetRoleId(), req.GetPermissionId())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
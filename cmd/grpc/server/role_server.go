@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	umsv1 "github.com/bhanupbalusu/gocomboums_v4/api/ums/v1"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+)
+
+type RoleServer struct {
+	umsv1.UnimplementedRoleServiceServer
+	RoleService *service.RoleService
+	UnitOfWork  repository.UnitOfWork
+}
+
+func NewRoleServer(roleService *service.RoleService, uow repository.UnitOfWork) *RoleServer {
+	return &RoleServer{
+		RoleService: roleService,
+		UnitOfWork:  uow,
+	}
+}
+
+func (s *RoleServer) GetRole(ctx context.Context, req *umsv1.IDRequest) (*umsv1.Role, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+
+	role, err := s.RoleService.GetRoleByID(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toRole(role), nil
+}
+
+func (s *RoleServer) ListRoles(ctx context.Context, req *umsv1.ListRequest) (*umsv1.ListRolesResponse, error) {
+	query, err := listQuery(req, repository.RoleListFields)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.RoleService.QueryRoles(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return toRoles(page), nil
+}
+
+func (s *RoleServer) ListUserRoles(ctx context.Context, req *umsv1.ListRelatedRequest) (*umsv1.ListRolesResponse, error) {
+	return s.listRelatedRoles(ctx, req, s.RoleService.ListUserRoles)
+}
+
+func (s *RoleServer) ListRoleAssignments(ctx context.Context, req *umsv1.IDRequest) (*umsv1.ListRoleAssignmentsResponse, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+
+	assignments, err := s.RoleService.GetRoleAssignments(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toRoleAssignments(assignments), nil
+}
+
+func (s *RoleServer) ListPermissionRoles(ctx context.Context, req *umsv1.ListRelatedRequest) (*umsv1.ListRolesResponse, error) {
+	return s.listRelatedRoles(ctx, req, s.RoleService.ListPermissionRoles)
+}
+
+func (s *RoleServer) listRelatedRoles(ctx context.Context, req *umsv1.ListRelatedRequest, list func(ctx context.Context, id uint64, query repository.ListQuery) (repository.Page[model.Role], error)) (*umsv1.ListRolesResponse, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+	query, err := listQuery(req.GetList(), repository.RoleListFields)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := list(ctx, req.GetId(), query)
+	if err != nil {
+		return nil, err
+	}
+	return toRoles(page), nil
+}
+
+func (s *RoleServer) CreateRole(ctx context.Context, req *umsv1.CreateRoleRequest) (*umsv1.Role, error) {
+	role := model.Role{RoleName: req.GetRoleName()}
+
+	var newRole *model.Role
+	err := s.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+		var err error
+		newRole, err = s.RoleService.CreateRole(ctx, &role)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toRole(newRole), nil
+}
+
+func (s *RoleServer) UpdateRole(ctx context.Context, req *umsv1.UpdateRoleRequest) (*umsv1.Role, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+	role := model.Role{ID: req.GetId(), RoleName: req.GetRoleName()}
+
+	var updatedRole *model.Role
+	err := s.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+		var err error
+		updatedRole, err = s.RoleService.UpdateRole(ctx, &role)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toRole(updatedRole), nil
+}
+
+func (s *RoleServer) DeleteRole(ctx context.Context, req *umsv1.IDRequest) (*emptypb.Empty, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+
+	err := s.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+		return s.RoleService.DeleteRole(ctx, req.GetId())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *RoleServer) AssignUserRole(ctx context.Context, req *umsv1.UserRoleRequest) (*emptypb.Empty, error) {
+	return s.changeUserRole(ctx, req, s.RoleService.AddScopedUserRole)
+}
+
+func (s *RoleServer) RevokeUserRole(ctx context.Context, req *umsv1.UserRoleRequest) (*emptypb.Empty, error) {
+	return s.changeUserRole(ctx, req, s.RoleService.RemoveScopedUserRole)
+}
+
+func (s *RoleServer) changeUserRole(ctx context.Context, req *umsv1.UserRoleRequest, change func(ctx context.Context, userID, roleID uint64, scope string) error) (*emptypb.Empty, error) {
+	if err := requireIDs("user_id", req.GetUserId(), "role_id", req.GetRoleId()); err != nil {
+		return nil, err
+	}
+
+	err := s.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+		return change(ctx, req.GetUserId(), req.GetRoleId(), req.GetScope())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
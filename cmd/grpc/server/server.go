@@ -0,0 +1,30 @@
+// Package server serves the users, roles, permissions and authorization
+// checks of the HTTP API over gRPC, on the same service instances
+package server
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+
+	umsv1 "github.com/bhanupbalusu/gocomboums_v4/api/ums/v1"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+)
+
+// New builds a gRPC server for the services. Every call needs a token signed
+// with tokenKey that is not in revocations, the calls in RecentAuthMethods a
+// login within stepUpMaxAge and those in MethodPermissions their permission.
+// The options, such as grpc.Creds for TLS, are added to the server's own.
+func New(tokenKey []byte, userService *service.UserService, roleService *service.RoleService, permissionService *service.PermissionService, uow repository.UnitOfWork, revocations *service.TokenRevocations, stepUpMaxAge time.Duration, opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(append([]grpc.ServerOption{grpc.ChainUnaryInterceptor(
+		UnaryAuthInterceptor(tokenKey, revocations, stepUpMaxAge, RecentAuthMethods),
+		UnaryErrorInterceptor(),
+		UnaryPermissionInterceptor(permissionService, MethodPermissions),
+	)}, opts...)...)
+	umsv1.RegisterUserServiceServer(srv, NewUserServer(userService))
+	umsv1.RegisterRoleServiceServer(srv, NewRoleServer(roleService, uow))
+	umsv1.RegisterPermissionServiceServer(srv, NewPermissionServer(permissionService, uow))
+	umsv1.RegisterAuthorizationServiceServer(srv, NewAuthorizationServer(permissionService, roleService))
+	return srv
+}
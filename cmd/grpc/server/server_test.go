@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	umsv1 "github.com/bhanupbalusu/gocomboums_v4/api/ums/v1"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/middleware"
+)
+
+const stepUpMaxAge = 5 * time.Minute
+
+// testServer is New serving over an in-memory connection
+type testServer struct {
+	key         []byte
+	users       map[string]*model.User
+	revocations *service.TokenRevocations
+	conn        *grpc.ClientConn
+}
+
+// newTestServer serves the memory backend with alice holding users:delete
+// and roles:manage globally and bob holding users:update on bob alone.
+// carol holds no permission.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ctx := context.Background()
+
+	store := repository.NewMemoryStore()
+	users := repository.NewMemoryUserRepository(store)
+	roles := repository.NewMemoryRoleRepository(store)
+	permissions := repository.NewMemoryPermissionRepository(store)
+	revocations := service.NewTokenRevocations(time.Minute)
+	authzCache := service.NewAuthzCache(users, roles, permissions, repository.NewMemoryPolicyRepository(store), repository.NewNopPublisher(), revocations, time.Minute, 10, 10)
+
+	s := &testServer{revocations: revocations, users: map[string]*model.User{}}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user := &model.User{Username: name, Email: name + "@example.com", PasswordHash: "stored password hash"}
+		if err := users.CreateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		s.users[name] = user
+	}
+	grant := func(user string, permissionName string, scope string) {
+		t.Helper()
+		role, err := roles.CreateRole(ctx, &model.Role{RoleName: user + " " + permissionName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		permission, err := permissions.CreatePermission(ctx, model.Permission{PermissionName: permissionName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := permissions.AssignPermissionToRole(ctx, role.ID, permission.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := roles.AddScopedUserRole(ctx, s.users[user].ID, role.ID, scope); err != nil {
+			t.Fatal(err)
+		}
+	}
+	grant("alice", service.PermissionDeleteUsers, "")
+	grant("alice", service.PermissionManageRoles, "")
+	grant("bob", service.PermissionUpdateUsers, "users:"+strconv.FormatUint(s.users["bob"].ID, 10))
+
+	key, err := service.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.key = key
+	srv := New(key,
+		service.NewUserService(users, authzCache),
+		service.NewRoleService(roles, authzCache),
+		service.NewPermissionService(permissions, authzCache),
+		repository.NewMemoryUnitOfWork(store), revocations, stepUpMaxAge)
+
+	listener := bufconn.Listen(1 << 20)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	s.conn, err = grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.conn.Close() })
+	return s
+}
+
+// token signs a token for the user, who logged in at authTime
+func (s *testServer) token(t *testing.T, username string, authTime time.Time) string {
+	t.Helper()
+	user := s.users[username]
+	token, err := service.GeneratePasetoToken(&service.Claims{
+		UserID:   strconv.FormatUint(user.ID, 10),
+		Username: user.Username,
+		AuthTime: authTime.Unix(),
+	}, s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestInterceptors(t *testing.T) {
+	s := newTestServer(t)
+	userClient := umsv1.NewUserServiceClient(s.conn)
+	roleClient := umsv1.NewRoleServiceClient(s.conn)
+	now := time.Now()
+	alice, bob, carol := s.users["alice"], s.users["bob"], s.users["carol"]
+
+	fresh := s.token(t, "alice", now)
+	stale := s.token(t, "alice", now.Add(-time.Hour))
+	bobToken := s.token(t, "bob", now)
+	revoked := s.token(t, "carol", now)
+	s.revocations.Revoke(carol.ID, now.Unix())
+
+	tests := []struct {
+		name       string
+		authorize  string
+		call       func(ctx context.Context) error
+		want       codes.Code
+		wantStepUp bool
+	}{
+		{"no token", "", func(ctx context.Context) error {
+			_, err := userClient.GetUser(ctx, &umsv1.IDRequest{Id: alice.ID})
+			return err
+		}, codes.Unauthenticated, false},
+		{"malformed token", "Bearer not-a-token", func(ctx context.Context) error {
+			_, err := userClient.GetUser(ctx, &umsv1.IDRequest{Id: alice.ID})
+			return err
+		}, codes.Unauthenticated, false},
+		{"revoked token", "Bearer " + revoked, func(ctx context.Context) error {
+			_, err := userClient.GetUser(ctx, &umsv1.IDRequest{Id: alice.ID})
+			return err
+		}, codes.Unauthenticated, false},
+		{"read with a token missing the Bearer prefix", bobToken, func(ctx context.Context) error {
+			_, err := userClient.GetUser(ctx, &umsv1.IDRequest{Id: alice.ID})
+			return err
+		}, codes.OK, false},
+		{"write without the permission", "Bearer " + bobToken, func(ctx context.Context) error {
+			_, err := roleClient.CreateRole(ctx, &umsv1.CreateRoleRequest{RoleName: "editors"})
+			return err
+		}, codes.PermissionDenied, false},
+		{"write on the user the grant is scoped to", "Bearer " + bobToken, func(ctx context.Context) error {
+			_, err := userClient.UpdateUser(ctx, &umsv1.UpdateUserRequest{Id: bob.ID, Username: "bob", Email: "bob@example.org"})
+			return err
+		}, codes.OK, false},
+		{"write on another user", "Bearer " + bobToken, func(ctx context.Context) error {
+			_, err := userClient.UpdateUser(ctx, &umsv1.UpdateUserRequest{Id: alice.ID, Username: "alice", Email: "alice@example.org"})
+			return err
+		}, codes.PermissionDenied, false},
+		{"write with a global grant", "Bearer " + fresh, func(ctx context.Context) error {
+			_, err := roleClient.CreateRole(ctx, &umsv1.CreateRoleRequest{RoleName: "editors"})
+			return err
+		}, codes.OK, false},
+		{"sensitive call after a stale login", "Bearer " + stale, func(ctx context.Context) error {
+			_, err := userClient.DeleteUser(ctx, &umsv1.IDRequest{Id: carol.ID})
+			return err
+		}, codes.Unauthenticated, true},
+		{"sensitive call without the permission", "Bearer " + bobToken, func(ctx context.Context) error {
+			_, err := userClient.DeleteUser(ctx, &umsv1.IDRequest{Id: carol.ID})
+			return err
+		}, codes.PermissionDenied, false},
+		{"sensitive call after a fresh login", "Bearer " + fresh, func(ctx context.Context) error {
+			_, err := userClient.DeleteUser(ctx, &umsv1.IDRequest{Id: carol.ID})
+			return err
+		}, codes.OK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorize != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.authorize)
+			}
+			err := tt.call(ctx)
+			st := status.Convert(err)
+			if st.Code() != tt.want {
+				t.Fatalf("got %v, want %s", err, tt.want)
+			}
+
+			var info *errdetails.ErrorInfo
+			for _, detail := range st.Details() {
+				if detail, ok := detail.(*errdetails.ErrorInfo); ok {
+					info = detail
+				}
+			}
+			stepUp := info != nil && info.GetReason() == middleware.StepUpRequired
+			if stepUp != tt.wantStepUp {
+				t.Fatalf("got details %v, want a step-up: %v", st.Details(), tt.wantStepUp)
+			}
+			if stepUp && info.GetMetadata()["max_age"] != "300" {
+				t.Errorf("got max_age %q, want 300", info.GetMetadata()["max_age"])
+			}
+		})
+	}
+}
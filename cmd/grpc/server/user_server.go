@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	umsv1 "github.com/bhanupbalusu/gocomboums_v4/api/ums/v1"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// searchPageSize is the page size of SearchUsers when the request sets none,
+// as for the HTTP search
+const searchPageSize = 100
+
+type UserServer struct {
+	umsv1.UnimplementedUserServiceServer
+	UserService *service.UserService
+}
+
+func NewUserServer(userService *service.UserService) *UserServer {
+	return &UserServer{
+		UserService: userService,
+	}
+}
+
+func (s *UserServer) GetUser(ctx context.Context, req *umsv1.IDRequest) (*umsv1.User, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+
+	user, err := s.UserService.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toUser(user), nil
+}
+
+func (s *UserServer) GetUserByUsername(ctx context.Context, req *umsv1.GetUserByUsernameRequest) (*umsv1.User, error) {
+	var v validation.Validator
+	if !v.Required("username", req.GetUsername()) {
+		return nil, v.Err()
+	}
+
+	user, err := s.UserService.GetUserByUsername(ctx, req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+	return toUser(user), nil
+}
+
+func (s *UserServer) ListUsers(ctx context.Context, req *umsv1.ListRequest) (*umsv1.ListUsersResponse, error) {
+	query, err := listQuery(req, repository.UserListFields)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.UserService.QueryUsers(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return toUsers(page), nil
+}
+
+func (s *UserServer) ListRoleUsers(ctx context.Context, req *umsv1.ListRelatedRequest) (*umsv1.ListUsersResponse, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+	query, err := listQuery(req.GetList(), repository.UserListFields)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.UserService.ListRoleUsers(ctx, req.GetId(), query)
+	if err != nil {
+		return nil, err
+	}
+	return toUsers(page), nil
+}
+
+// SearchUsers is the fuzzy search of GET /v1/users/search?mode=fuzzy
+func (s *UserServer) SearchUsers(ctx context.Context, req *umsv1.SearchUsersRequest) (*umsv1.SearchUsersResponse, error) {
+	var v validation.Validator
+	v.Required("query", req.GetQuery())
+	if req.GetPage() < 0 {
+		v.Add("page", validation.CodeInvalidValue, "must be at least 1")
+	}
+	if req.GetPageSize() < 0 {
+		v.Add("page_size", validation.CodeInvalidValue, "must be at least 1")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	page, pageSize := int(req.GetPage()), int(req.GetPageSize())
+	if page == 0 {
+		page = 1
+	}
+	if pageSize == 0 {
+		pageSize = searchPageSize
+	}
+
+	matches, err := s.UserService.FuzzySearchUsers(ctx, req.GetQuery(), page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	resp := &umsv1.SearchUsersResponse{Matches: make([]*umsv1.UserMatch, len(matches))}
+	for i, match := range matches {
+		resp.Matches[i] = toUserMatch(match)
+	}
+	return resp, nil
+}
+
+func (s *UserServer) UpdateUser(ctx context.Context, req *umsv1.UpdateUserRequest) (*umsv1.User, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+
+	user, err := s.UserService.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	user.Username = req.GetUsername()
+	user.Email = req.GetEmail()
+
+	if err := s.UserService.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return toUser(user), nil
+}
+
+func (s *UserServer) DeleteUser(ctx context.Context, req *umsv1.IDRequest) (*emptypb.Empty, error) {
+	if err := requireID("id", req.GetId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.UserService.DeleteUser(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
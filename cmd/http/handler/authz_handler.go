@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/cache"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// AccessCheckRequest asks whether the subject, a user ID, holds the
+// permission on the resource, e.g. project:42/documents:7, or globally when
+// there is none. ResourceAttributes and Context describe the resource and the
+// request to the policies.
+type AccessCheckRequest struct {
+	Subject            uint64                 `json:"subject"`
+	Permission         string                 `json:"permission"`
+	Resource           string                 `json:"resource,omitempty"`
+	ResourceAttributes map[string]interface{} `json:"resource_attributes,omitempty"`
+	Context            map[string]interface{} `json:"context,omitempty"`
+}
+
+// AccessCheckBatchRequest is the body of POST /v1/authz/check
+type AccessCheckBatchRequest struct {
+	Checks []AccessCheckRequest `json:"checks"`
+}
+
+func (r *AccessCheckBatchRequest) Validate() error {
+	var v validation.Validator
+	if len(r.Checks) == 0 {
+		v.Add("checks", validation.CodeRequired, "must list at least one check")
+	}
+	if len(r.Checks) > service.MaxAccessChecks {
+		v.Add("checks", validation.CodeInvalidValue, fmt.Sprintf("must list at most %d checks", service.MaxAccessChecks))
+	}
+	for i, check := range r.Checks {
+		v.RequiredID(fmt.Sprintf("checks[%d].subject", i), check.Subject)
+		v.Required(fmt.Sprintf("checks[%d].permission", i), strings.TrimSpace(check.Permission))
+		service.ValidateResource(&v, fmt.Sprintf("checks[%d].resource", i), check.Resource)
+	}
+	return v.Err()
+}
+
+// AccessCheckResult answers one check, echoing it; Role names the role that
+// granted an allowed check and Scope the scope it was assigned in, empty for
+// a global role. Policy names the policy that decided the check, if any.
+type AccessCheckResult struct {
+	AccessCheckRequest
+	Allowed bool   `json:"allowed"`
+	Role    string `json:"role,omitempty"`
+	Scope   string `json:"scope,omitempty"`
+	Policy  string `json:"policy,omitempty"`
+}
+
+// CacheStatsResponse reports on one part of the authorization cache
+type CacheStatsResponse struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+	MaxEntries    int    `json:"max_entries"`
+	TTLSeconds    int64  `json:"ttl_seconds"`
+}
+
+func newCacheStatsResponse(stats cache.Stats) CacheStatsResponse {
+	return CacheStatsResponse{
+		Hits:          stats.Hits,
+		Misses:        stats.Misses,
+		Evictions:     stats.Evictions,
+		Invalidations: stats.Invalidations,
+		Entries:       stats.Entries,
+		MaxEntries:    stats.MaxEntries,
+		TTLSeconds:    int64(stats.TTL / time.Second),
+	}
+}
+
+type AuthzHandler struct {
+	PermissionService *service.PermissionService
+	Cache             *service.AuthzCache
+}
+
+func NewAuthzHandler(permissionService *service.PermissionService, authzCache *service.AuthzCache) *AuthzHandler {
+	return &AuthzHandler{
+		PermissionService: permissionService,
+		Cache:             authzCache,
+	}
+}
+
+// CheckAccess answers a batch of access checks in the order given
+func (h *AuthzHandler) CheckAccess(c *gin.Context) {
+	var req AccessCheckBatchRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	// Checks about the caller also see the caller's token
+	value, _ := c.Get("claims")
+	claims, _ := value.(*service.Claims)
+	checks := make([]service.AccessCheck, len(req.Checks))
+	for i, check := range req.Checks {
+		checks[i] = service.AccessCheck{
+			UserID:             check.Subject,
+			Permission:         check.Permission,
+			Resource:           check.Resource,
+			ResourceAttributes: check.ResourceAttributes,
+			Context:            check.Context,
+			Claims:             claims,
+		}
+	}
+	decisions, err := h.PermissionService.CheckAccess(c.Request.Context(), checks)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	results := make([]AccessCheckResult, len(decisions))
+	for i, decision := range decisions {
+		results[i] = AccessCheckResult{
+			AccessCheckRequest: req.Checks[i],
+			Allowed:            decision.Allowed,
+			Role:               decision.Role,
+			Scope:              decision.Scope,
+			Policy:             decision.Policy,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// CacheStats reports the hits, misses and size of the authorization cache
+func (h *AuthzHandler) CacheStats(c *gin.Context) {
+	stats := h.Cache.Stats()
+	c.JSON(http.StatusOK, gin.H{
+		"user_roles":       newCacheStatsResponse(stats.UserRoles),
+		"role_permissions": newCacheStatsResponse(stats.RolePermissions),
+		"policies":         newCacheStatsResponse(stats.Policies),
+	})
+}
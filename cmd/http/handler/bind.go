@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// validatable is implemented by request bodies that check their own fields
+type validatable interface {
+	Validate() error
+}
+
+// bindJSON decodes the request body into req and validates it. A body that
+// is not JSON is a 400; a field of the wrong type or one failing validation
+// is a 422 naming the field.
+func bindJSON(c *gin.Context, req interface{}) error {
+	if err := c.ShouldBindJSON(req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			var v validation.Validator
+			v.Add(typeErr.Field, validation.CodeInvalidType, "must be "+jsonTypeName(typeErr.Type))
+			return v.Err()
+		}
+		return errors.NewAppError(errors.CodeBadRequest, err.Error())
+	}
+
+	if body, ok := req.(validatable); ok {
+		return body.Validate()
+	}
+	return nil
+}
+
+// pathID reads a numeric path parameter; a value that is not a positive
+// integer names no resource, so it is a 400 naming the parameter.
+func pathID(c *gin.Context, name string) (uint64, error) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		var v validation.Validator
+		v.Add(name, validation.CodeInvalidType, "must be a positive integer")
+		return 0, v.BadRequest()
+	}
+	return id, nil
+}
+
+// queryIDs reads a repeated numeric query parameter, e.g. ?id=1&id=2, which
+// must be given at least once.
+func queryIDs(c *gin.Context, name string) ([]uint64, error) {
+	var v validation.Validator
+	values := c.QueryArray(name)
+	if len(values) == 0 {
+		v.Add(name, validation.CodeRequired, "must be given at least once")
+	}
+	ids := make([]uint64, 0, len(values))
+	for i, value := range values {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == 0 {
+			v.Add(fmt.Sprintf("%s[%d]", name, i), validation.CodeInvalidType, "must be a positive integer")
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, v.Err()
+}
+
+// jsonTypeName describes a Go type the way a JSON client would see it
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
@@ -0,0 +1,13 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+)
+
+// bindListQuery reads the paging, sorting and filtering parameters of a list
+// request from its query string; see repository.ParseListParams
+func bindListQuery(c *gin.Context, fields repository.ListFields) (repository.ListQuery, error) {
+	return repository.ParseListParams(c.Request.URL.Query(), fields)
+}
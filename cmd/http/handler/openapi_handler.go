@@ -0,0 +1,14 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/cmd/http/openapi"
+)
+
+// OpenAPIDocument serves the OpenAPI 3 description of the API
+func OpenAPIDocument(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openapi.Document())
+}
@@ -1,11 +1,17 @@
 package handler
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
 	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,191 +20,374 @@ type RolePermissions struct {
 	PermissionIDs []uint64 `json:"permission_ids"`
 }
 
+func (r *RolePermissions) Validate() error {
+	var v validation.Validator
+	v.RequiredID("role_id", r.RoleID)
+	validatePermissionIDs(&v, r.PermissionIDs)
+	return v.Err()
+}
+
+type RolePermissionRequest struct {
+	RoleID       uint64 `json:"role_id"`
+	PermissionID uint64 `json:"permission_id"`
+}
+
+func (r *RolePermissionRequest) Validate() error {
+	var v validation.Validator
+	v.RequiredID("role_id", r.RoleID)
+	v.RequiredID("permission_id", r.PermissionID)
+	return v.Err()
+}
+
+type PermissionRequest struct {
+	ID             uint64 `json:"id"`
+	PermissionName string `json:"permission_name"`
+}
+
+func (r *PermissionRequest) Validate() error {
+	var v validation.Validator
+	service.ValidatePermissionName(&v, strings.TrimSpace(strings.ToLower(r.PermissionName)))
+	return v.Err()
+}
+
+// PermissionNameRequest is the body of PUT /v1/permissions/{id}
+type PermissionNameRequest struct {
+	PermissionName string `json:"permission_name"`
+}
+
+func (r *PermissionNameRequest) Validate() error {
+	var v validation.Validator
+	service.ValidatePermissionName(&v, strings.TrimSpace(strings.ToLower(r.PermissionName)))
+	return v.Err()
+}
+
+// PermissionIDsRequest is the body of POST /v1/roles/{id}/permissions
+type PermissionIDsRequest struct {
+	PermissionIDs []uint64 `json:"permission_ids"`
+}
+
+func (r *PermissionIDsRequest) Validate() error {
+	var v validation.Validator
+	validatePermissionIDs(&v, r.PermissionIDs)
+	return v.Err()
+}
+
+func validatePermissionIDs(v *validation.Validator, ids []uint64) {
+	if len(ids) == 0 {
+		v.Add("permission_ids", validation.CodeRequired, "must list at least one permission")
+	}
+	for i, id := range ids {
+		if id == 0 {
+			v.Add(fmt.Sprintf("permission_ids[%d]", i), validation.CodeInvalidValue, "must be a permission id")
+		}
+	}
+}
+
 type PermissionHandler struct {
-	PermissionService  *service.PermissionService
-	TransactionHandler *TransactionHandler
+	PermissionService *service.PermissionService
+	UnitOfWork        repository.UnitOfWork
 }
 
-func NewPermissionHandler(permissionService *service.PermissionService, txHandler *TransactionHandler) *PermissionHandler {
+func NewPermissionHandler(permissionService *service.PermissionService, uow repository.UnitOfWork) *PermissionHandler {
 	return &PermissionHandler{
-		PermissionService:  permissionService,
-		TransactionHandler: txHandler,
+		PermissionService: permissionService,
+		UnitOfWork:        uow,
 	}
 }
 
 // CreatePermission handles the request to create a new permission.
 func (h *PermissionHandler) CreatePermission(c *gin.Context) {
-	var permission model.Permission
-	if err := c.ShouldBindJSON(&permission); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req PermissionRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
-	permission, err := h.PermissionService.CreatePermission(permission)
+	permission, err := h.PermissionService.CreatePermission(c.Request.Context(), model.Permission{ID: req.ID, PermissionName: req.PermissionName})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"permission": permission})
+	c.JSON(http.StatusOK, gin.H{"permission": NewPermissionResponse(&permission)})
 }
 
 // UpdatePermission handles the request to update an existing permission.
 func (h *PermissionHandler) UpdatePermission(c *gin.Context) {
-	var permission model.Permission
-	if err := c.ShouldBindJSON(&permission); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req PermissionRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
-	permission, err := h.PermissionService.UpdatePermission(permission)
+	permission, err := h.PermissionService.UpdatePermission(c.Request.Context(), model.Permission{ID: req.ID, PermissionName: req.PermissionName})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"permission": permission})
+	c.JSON(http.StatusOK, gin.H{"permission": NewPermissionResponse(&permission)})
+}
+
+// UpdatePermissionByID renames the permission named in the path
+func (h *PermissionHandler) UpdatePermissionByID(c *gin.Context) {
+	id, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var req PermissionNameRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+	permission, err := h.PermissionService.UpdatePermission(c.Request.Context(), model.Permission{ID: id, PermissionName: req.PermissionName})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"permission": NewPermissionResponse(&permission)})
 }
 
 // DeletePermission handles the request to delete an existing permission.
 func (h *PermissionHandler) DeletePermission(c *gin.Context) {
-	permissionID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
-	err := h.PermissionService.DeletePermission(permissionID)
+	permissionID, err := pathID(c, "id")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
+		return
+	}
+	err = h.PermissionService.DeletePermission(c.Request.Context(), permissionID)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Permission deleted successfully."})
 }
 
 func (h *PermissionHandler) GetAllPermissions(c *gin.Context) {
-	permissions, err := h.PermissionService.GetAllPermissions()
+	permissions, err := h.PermissionService.GetAllPermissions(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, NewPermissionResponses(permissions))
+}
+
+// ExpandPermission lists the permissions the wildcard in the pattern query
+// parameter expands to
+func (h *PermissionHandler) ExpandPermission(c *gin.Context) {
+	permissions, err := h.PermissionService.ExpandPermission(c.Request.Context(), c.Query("pattern"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, permissions)
+	c.JSON(http.StatusOK, gin.H{"data": NewPermissionResponses(permissions)})
 }
 
 func (h *PermissionHandler) GetPermissionByID(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission id"})
+		c.Error(errors.NewAppError(errors.CodeBadRequest, "Invalid permission id"))
 		return
 	}
 
-	permission, err := h.PermissionService.GetPermissionByID(id)
+	permission, err := h.PermissionService.GetPermissionByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, permission)
+	c.JSON(http.StatusOK, NewPermissionResponse(&permission))
 }
 
 // AssignPermissionToRole handles the request to assign a permission to a role.
 func (h *PermissionHandler) AssignPermissionToRole(c *gin.Context) {
-	var rolePermission model.RolePermission
-	if err := c.ShouldBindJSON(&rolePermission); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var rolePermission RolePermissionRequest
+	if err := bindJSON(c, &rolePermission); err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.StartTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.PermissionService.AssignPermissionToRole(ctx, rolePermission.RoleID, rolePermission.PermissionID)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	err := h.PermissionService.AssignPermissionToRole(rolePermission.RoleID, rolePermission.PermissionID)
+	c.JSON(http.StatusOK, gin.H{"message": "Permission assigned to role successfully."})
+}
+
+// RemovePermissionFromRole handles the request to remove a permission from a role.
+func (h *PermissionHandler) RemovePermissionFromRole(c *gin.Context) {
+	var rolePermission RolePermissionRequest
+	if err := bindJSON(c, &rolePermission); err != nil {
+		c.Error(err)
+		return
+	}
+
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.PermissionService.RemovePermissionFromRole(ctx, rolePermission.RoleID, rolePermission.PermissionID)
+	})
 	if err != nil {
-		h.TransactionHandler.RollbackTransaction()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Permission removed from role successfully."})
+}
+
+// AddMultiplePermissionsToRole handles the request to add multiple permissions to a role.
+func (h *PermissionHandler) AddMultiplePermissionsToRole(c *gin.Context) {
+	var rolePermissions RolePermissions
+	if err := bindJSON(c, &rolePermissions); err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.CommitTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.PermissionService.AddMultiplePermissionsToRole(ctx, rolePermissions.RoleID, rolePermissions.PermissionIDs)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Permission assigned to role successfully."})
+	c.JSON(http.StatusOK, gin.H{"message": "Permissions added to role successfully."})
 }
 
-// RemovePermissionFromRole handles the request to remove a permission from a role.
-func (h *PermissionHandler) RemovePermissionFromRole(c *gin.Context) {
-	var rolePermission model.RolePermission
-	if err := c.ShouldBindJSON(&rolePermission); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// RemoveMultiplePermissionsFromRole handles the request to remove multiple permissions from a role.
+func (h *PermissionHandler) RemoveMultiplePermissionsFromRole(c *gin.Context) {
+	var rolePermissions RolePermissions
+	if err := bindJSON(c, &rolePermissions); err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.StartTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.PermissionService.RemoveMultiplePermissionsFromRole(ctx, rolePermissions.RoleID, rolePermissions.PermissionIDs)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	err := h.PermissionService.RemovePermissionFromRole(rolePermission.RoleID, rolePermission.PermissionID)
+	c.JSON(http.StatusOK, gin.H{"message": "Permissions removed from role successfully."})
+}
+
+// QueryPermissions lists permissions a page at a time; see repository.ParseListParams for the parameters
+func (h *PermissionHandler) QueryPermissions(c *gin.Context) {
+	query, err := bindListQuery(c, repository.PermissionListFields)
 	if err != nil {
-		h.TransactionHandler.RollbackTransaction()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.CommitTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	page, err := h.PermissionService.QueryPermissions(c.Request.Context(), query)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Permission removed from role successfully."})
+	c.JSON(http.StatusOK, NewPermissionListResponse(page))
 }
 
-// AddMultiplePermissionsToRole handles the request to add multiple permissions to a role.
-func (h *PermissionHandler) AddMultiplePermissionsToRole(c *gin.Context) {
-	var rolePermissions RolePermissions
-	if err := c.ShouldBindJSON(&rolePermissions); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// ListRolePermissions lists the permissions granted to the role in the path
+func (h *PermissionHandler) ListRolePermissions(c *gin.Context) {
+	roleID, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	query, err := bindListQuery(c, repository.PermissionListFields)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.StartTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	page, err := h.PermissionService.ListRolePermissions(c.Request.Context(), roleID, query)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	err := h.PermissionService.AddMultiplePermissionsToRole(rolePermissions.RoleID, rolePermissions.PermissionIDs)
+	c.JSON(http.StatusOK, NewPermissionListResponse(page))
+}
+
+// GrantRolePermission grants the permission in the path to the role in the path
+func (h *PermissionHandler) GrantRolePermission(c *gin.Context) {
+	h.changeRolePermission(c, h.PermissionService.AssignPermissionToRole, "Permission assigned to role successfully.")
+}
+
+// RevokeRolePermission revokes the permission in the path from the role in the path
+func (h *PermissionHandler) RevokeRolePermission(c *gin.Context) {
+	h.changeRolePermission(c, h.PermissionService.RemovePermissionFromRole, "Permission removed from role successfully.")
+}
+
+func (h *PermissionHandler) changeRolePermission(c *gin.Context, change func(ctx context.Context, roleID, permissionID uint64) error, message string) {
+	roleID, err := pathID(c, "id")
 	if err != nil {
-		h.TransactionHandler.RollbackTransaction()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
+		return
+	}
+	permissionID, err := pathID(c, "permissionID")
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.CommitTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err = h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return change(ctx, roleID, permissionID)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Permissions added to role successfully."})
+	c.JSON(http.StatusOK, gin.H{"message": message})
 }
 
-// RemoveMultiplePermissionsFromRole handles the request to remove multiple permissions from a role.
-func (h *PermissionHandler) RemoveMultiplePermissionsFromRole(c *gin.Context) {
-	var rolePermissions RolePermissions
-	if err := c.ShouldBindJSON(&rolePermissions); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// GrantRolePermissions grants the permissions listed in the body to the role in the path
+func (h *PermissionHandler) GrantRolePermissions(c *gin.Context) {
+	roleID, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var req PermissionIDsRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.StartTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err = h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.PermissionService.AddMultiplePermissionsToRole(ctx, roleID, req.PermissionIDs)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	err := h.PermissionService.RemoveMultiplePermissionsFromRole(rolePermissions.RoleID, rolePermissions.PermissionIDs)
+	c.JSON(http.StatusOK, gin.H{"message": "Permissions added to role successfully."})
+}
+
+// RevokeRolePermissions revokes the permissions listed in the permission_id
+// query parameters from the role in the path
+func (h *PermissionHandler) RevokeRolePermissions(c *gin.Context) {
+	roleID, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	permissionIDs, err := queryIDs(c, "permission_id")
 	if err != nil {
-		h.TransactionHandler.RollbackTransaction()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.CommitTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err = h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.PermissionService.RemoveMultiplePermissionsFromRole(ctx, roleID, permissionIDs)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
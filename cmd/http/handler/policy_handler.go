@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// PolicyRequest is the body of POST /v1/policies and PUT /v1/policies/{id}.
+// A missing or null condition makes the policy apply unconditionally.
+type PolicyRequest struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Effect      string          `json:"effect"`
+	Permission  string          `json:"permission"`
+	Scope       string          `json:"scope"`
+	Condition   json.RawMessage `json:"condition"`
+}
+
+func (r *PolicyRequest) Validate() error {
+	var v validation.Validator
+	service.ValidatePolicy(&v, r.policy())
+	return v.Err()
+}
+
+// policy returns the sanitized policy the request describes
+func (r *PolicyRequest) policy() model.Policy {
+	condition := strings.TrimSpace(string(r.Condition))
+	if condition == "null" {
+		condition = ""
+	}
+	return model.Policy{
+		Name:        strings.TrimSpace(r.Name),
+		Description: strings.TrimSpace(r.Description),
+		Effect:      strings.TrimSpace(strings.ToLower(r.Effect)),
+		Permission:  strings.TrimSpace(strings.ToLower(r.Permission)),
+		Scope:       strings.TrimSpace(r.Scope),
+		Condition:   condition,
+	}
+}
+
+type PolicyHandler struct {
+	PolicyService *service.PolicyService
+	UnitOfWork    repository.UnitOfWork
+}
+
+func NewPolicyHandler(policyService *service.PolicyService, uow repository.UnitOfWork) *PolicyHandler {
+	return &PolicyHandler{
+		PolicyService: policyService,
+		UnitOfWork:    uow,
+	}
+}
+
+// ListPolicies lists every policy, oldest first
+func (h *PolicyHandler) ListPolicies(c *gin.Context) {
+	policies, err := h.PolicyService.GetAllPolicies(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": NewPolicyResponses(policies)})
+}
+
+func (h *PolicyHandler) CreatePolicy(c *gin.Context) {
+	var req PolicyRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	var policy model.Policy
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		policy, err = h.PolicyService.CreatePolicy(ctx, req.policy())
+		return err
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, NewPolicyResponse(&policy))
+}
+
+func (h *PolicyHandler) GetPolicyByID(c *gin.Context) {
+	id, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	policy, err := h.PolicyService.GetPolicyByID(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, NewPolicyResponse(&policy))
+}
+
+// UpdatePolicyByID replaces the policy named in the path
+func (h *PolicyHandler) UpdatePolicyByID(c *gin.Context) {
+	id, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var req PolicyRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	policy := req.policy()
+	policy.ID = id
+	err = h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		policy, err = h.PolicyService.UpdatePolicy(ctx, policy)
+		return err
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, NewPolicyResponse(&policy))
+}
+
+func (h *PolicyHandler) DeletePolicy(c *gin.Context) {
+	id, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	err = h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.PolicyService.DeletePolicy(ctx, id)
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Policy deleted"})
+}
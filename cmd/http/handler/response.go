@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+)
+
+// Response types are the allow-list of fields the API exposes. Handlers map
+// models onto them instead of serializing models, so credentials, soft-delete
+// markers and preloaded join rows never reach a client.
+
+// UserResponse is the public view of a user
+type UserResponse struct {
+	ID        uint64    `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RoleResponse is the public view of a role
+type RoleResponse struct {
+	ID        uint64    `json:"id"`
+	RoleName  string    `json:"role_name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PermissionResponse is the public view of a permission
+type PermissionResponse struct {
+	ID             uint64    `json:"id"`
+	PermissionName string    `json:"permission_name"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// PolicyResponse is the public view of a policy. Condition is null when the
+// policy applies unconditionally.
+type PolicyResponse struct {
+	ID          uint64          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Effect      string          `json:"effect"`
+	Permission  string          `json:"permission"`
+	Scope       string          `json:"scope"`
+	Condition   json.RawMessage `json:"condition"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// ListResponse is one page of a list. NextCursor fetches the page after it
+// and is null on the last page; Total is only present when requested.
+type ListResponse[T any] struct {
+	Data       []T     `json:"data"`
+	NextCursor *string `json:"next_cursor"`
+	Total      *int64  `json:"total,omitempty"`
+}
+
+func newListResponse[M any, R any](page repository.Page[M], convert func(M) R) ListResponse[R] {
+	response := ListResponse[R]{
+		Data:  make([]R, len(page.Items)),
+		Total: page.Total,
+	}
+	for i, item := range page.Items {
+		response.Data[i] = convert(item)
+	}
+	if page.Next != nil {
+		cursor := page.Next.Encode()
+		response.NextCursor = &cursor
+	}
+	return response
+}
+
+func NewUserResponse(user *model.User) UserResponse {
+	return UserResponse{
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+}
+
+func NewUserResponses(users []*model.User) []UserResponse {
+	responses := make([]UserResponse, len(users))
+	for i, user := range users {
+		responses[i] = NewUserResponse(user)
+	}
+	return responses
+}
+
+// UserSearchResult is a user found by a fuzzy search. Highlights gives, for
+// each matching field, the [start, end) character ranges that match.
+type UserSearchResult struct {
+	UserResponse
+	Score      float64             `json:"score"`
+	Highlights map[string][][2]int `json:"highlights"`
+}
+
+func NewUserSearchResults(matches []repository.UserMatch) []UserSearchResult {
+	results := make([]UserSearchResult, len(matches))
+	for i, match := range matches {
+		highlights := make(map[string][][2]int, len(match.Highlights))
+		for field, spans := range match.Highlights {
+			for _, span := range spans {
+				highlights[field] = append(highlights[field], [2]int{span.Start, span.End})
+			}
+		}
+		results[i] = UserSearchResult{
+			UserResponse: NewUserResponse(match.User),
+			Score:        match.Score,
+			Highlights:   highlights,
+		}
+	}
+	return results
+}
+
+func NewRoleResponse(role *model.Role) RoleResponse {
+	return RoleResponse{
+		ID:        role.ID,
+		RoleName:  role.RoleName,
+		CreatedAt: role.CreatedAt,
+		UpdatedAt: role.UpdatedAt,
+	}
+}
+
+func NewRoleResponses(roles []model.Role) []RoleResponse {
+	responses := make([]RoleResponse, len(roles))
+	for i := range roles {
+		responses[i] = NewRoleResponse(&roles[i])
+	}
+	return responses
+}
+
+// RoleAssignmentResponse is a role a user holds within a scope, empty when global
+type RoleAssignmentResponse struct {
+	RoleResponse
+	Scope string `json:"scope"`
+}
+
+func NewRoleAssignmentResponses(assignments []repository.RoleAssignment) []RoleAssignmentResponse {
+	responses := make([]RoleAssignmentResponse, len(assignments))
+	for i := range assignments {
+		responses[i] = RoleAssignmentResponse{
+			RoleResponse: NewRoleResponse(&assignments[i].Role),
+			Scope:        assignments[i].Scope,
+		}
+	}
+	return responses
+}
+
+func NewPermissionResponse(permission *model.Permission) PermissionResponse {
+	return PermissionResponse{
+		ID:             permission.ID,
+		PermissionName: permission.PermissionName,
+		CreatedAt:      permission.CreatedAt,
+		UpdatedAt:      permission.UpdatedAt,
+	}
+}
+
+func NewPermissionResponses(permissions []model.Permission) []PermissionResponse {
+	responses := make([]PermissionResponse, len(permissions))
+	for i := range permissions {
+		responses[i] = NewPermissionResponse(&permissions[i])
+	}
+	return responses
+}
+
+func NewPolicyResponse(policy *model.Policy) PolicyResponse {
+	var condition json.RawMessage
+	if policy.Condition != "" {
+		condition = json.RawMessage(policy.Condition)
+	}
+	return PolicyResponse{
+		ID:          policy.ID,
+		Name:        policy.Name,
+		Description: policy.Description,
+		Effect:      policy.Effect,
+		Permission:  policy.Permission,
+		Scope:       policy.Scope,
+		Condition:   condition,
+		CreatedAt:   policy.CreatedAt,
+		UpdatedAt:   policy.UpdatedAt,
+	}
+}
+
+func NewPolicyResponses(policies []model.Policy) []PolicyResponse {
+	responses := make([]PolicyResponse, len(policies))
+	for i := range policies {
+		responses[i] = NewPolicyResponse(&policies[i])
+	}
+	return responses
+}
+
+func NewUserListResponse(page repository.Page[*model.User]) ListResponse[UserResponse] {
+	return newListResponse(page, NewUserResponse)
+}
+
+func NewRoleListResponse(page repository.Page[model.Role]) ListResponse[RoleResponse] {
+	return newListResponse(page, func(role model.Role) RoleResponse { return NewRoleResponse(&role) })
+}
+
+func NewPermissionListResponse(page repository.Page[model.Permission]) ListResponse[PermissionResponse] {
+	return newListResponse(page, func(permission model.Permission) PermissionResponse { return NewPermissionResponse(&permission) })
+}
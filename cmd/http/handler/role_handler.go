@@ -1,108 +1,149 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
 	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
 	"github.com/gin-gonic/gin"
 )
 
 type RoleHandler struct {
-	RoleService        *service.RoleService
-	TransactionHandler *TransactionHandler
+	RoleService *service.RoleService
+	UnitOfWork  repository.UnitOfWork
 }
 
-func NewRoleHandler(roleService *service.RoleService, txHandler *TransactionHandler) *RoleHandler {
+func NewRoleHandler(roleService *service.RoleService, uow repository.UnitOfWork) *RoleHandler {
 	return &RoleHandler{
-		RoleService:        roleService,
-		TransactionHandler: txHandler,
+		RoleService: roleService,
+		UnitOfWork:  uow,
 	}
 }
 
+type RoleRequest struct {
+	RoleName string `json:"role_name"`
+}
+
+func (r *RoleRequest) Validate() error {
+	var v validation.Validator
+	service.ValidateRoleName(&v, strings.TrimSpace(r.RoleName))
+	return v.Err()
+}
+
+type UpdateRoleRequest struct {
+	ID       uint64 `json:"id"`
+	RoleName string `json:"role_name"`
+}
+
+func (r *UpdateRoleRequest) Validate() error {
+	var v validation.Validator
+	v.RequiredID("id", r.ID)
+	service.ValidateRoleName(&v, strings.TrimSpace(r.RoleName))
+	return v.Err()
+}
+
+type UserRoleRequest struct {
+	UserID uint64 `json:"userID"`
+	RoleID uint64 `json:"roleID"`
+}
+
+func (r *UserRoleRequest) Validate() error {
+	var v validation.Validator
+	v.RequiredID("userID", r.UserID)
+	v.RequiredID("roleID", r.RoleID)
+	return v.Err()
+}
+
 func (h *RoleHandler) CreateRole(c *gin.Context) {
-	var role model.Role
-	if err := c.ShouldBindJSON(&role); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req RoleRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
-
-	err := h.TransactionHandler.StartTransaction()
+	role := model.Role{RoleName: req.RoleName}
+
+	var newRole *model.Role
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		newRole, err = h.RoleService.CreateRole(ctx, &role)
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			_ = h.TransactionHandler.RollbackTransaction()
-		}
-	}()
+	c.JSON(http.StatusCreated, NewRoleResponse(newRole))
+}
 
-	newRole, err := h.RoleService.CreateRole(&role)
-	if err != nil {
-		_ = h.TransactionHandler.RollbackTransaction()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	var req UpdateRoleRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
-
-	err = h.TransactionHandler.CommitTransaction()
+	role := model.Role{ID: req.ID, RoleName: req.RoleName}
+
+	var updatedRole *model.Role
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		updatedRole, err = h.RoleService.UpdateRole(ctx, &role)
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, newRole)
+	c.JSON(http.StatusOK, NewRoleResponse(updatedRole))
 }
 
-func (h *RoleHandler) UpdateRole(c *gin.Context) {
-	var role model.Role
-	if err := c.ShouldBindJSON(&role); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// UpdateRoleByID renames the role named in the path
+func (h *RoleHandler) UpdateRoleByID(c *gin.Context) {
+	id, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
 		return
 	}
-
-	if err := h.TransactionHandler.StartTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var req RoleRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
-
-	updatedRole, err := h.RoleService.UpdateRole(&role)
+	role := model.Role{ID: id, RoleName: req.RoleName}
+
+	var updatedRole *model.Role
+	err = h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		updatedRole, err = h.RoleService.UpdateRole(ctx, &role)
+		return err
+	})
 	if err != nil {
-		h.TransactionHandler.RollbackTransaction()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.CommitTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, updatedRole)
+	c.JSON(http.StatusOK, NewRoleResponse(updatedRole))
 }
 
 func (h *RoleHandler) DeleteRole(c *gin.Context) {
 	roleID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	if err := h.TransactionHandler.StartTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if err := h.RoleService.DeleteRole(roleID); err != nil {
-		h.TransactionHandler.RollbackTransaction()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(errors.NewAppError(errors.CodeBadRequest, err.Error()))
 		return
 	}
 
-	if err := h.TransactionHandler.CommitTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err = h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.RoleService.DeleteRole(ctx, roleID)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -112,57 +153,57 @@ func (h *RoleHandler) DeleteRole(c *gin.Context) {
 func (h *RoleHandler) GetRoleByID(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(errors.NewAppError(errors.CodeBadRequest, err.Error()))
 		return
 	}
 
-	role, err := h.RoleService.GetRoleByID(id)
+	role, err := h.RoleService.GetRoleByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, role)
+	c.JSON(http.StatusOK, NewRoleResponse(role))
 }
 
 func (h *RoleHandler) GetAllRoles(c *gin.Context) {
-	roles, err := h.RoleService.GetAllRoles()
+	roles, err := h.RoleService.GetAllRoles(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, roles)
+	c.JSON(http.StatusOK, NewRoleResponses(roles))
 }
 
 func (h *RoleHandler) GetRolesByUserID(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	id, err := strconv.ParseUint(c.Param("userID"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(errors.NewAppError(errors.CodeBadRequest, err.Error()))
 		return
 	}
 
-	roles, err := h.RoleService.GetRolesByUserID(id)
+	roles, err := h.RoleService.GetRolesByUserID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, roles)
+	c.JSON(http.StatusOK, NewRoleResponses(roles))
 }
 
 func (h *RoleHandler) UserHasRole(c *gin.Context) {
 	userID, err := strconv.ParseUint(c.Param("userID"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(errors.NewAppError(errors.CodeBadRequest, err.Error()))
 		return
 	}
 
 	roleName := c.Param("roleName")
 
-	hasRole, err := h.RoleService.UserHasRole(userID, roleName)
+	hasRole, err := h.RoleService.UserHasRole(c.Request.Context(), userID, roleName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -170,61 +211,165 @@ func (h *RoleHandler) UserHasRole(c *gin.Context) {
 }
 
 func (h *RoleHandler) AddUserRole(c *gin.Context) {
-	var req struct {
-		UserID uint64 `json:"userID"`
-		RoleID uint64 `json:"roleID"`
+	var req UserRoleRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.RoleService.AddUserRole(ctx, req.UserID, req.RoleID)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.StartTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	c.JSON(http.StatusOK, gin.H{"status": "Role added to user"})
+}
+
+func (h *RoleHandler) RemoveUserRole(c *gin.Context) {
+	var req UserRoleRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.RoleService.AddUserRole(req.UserID, req.RoleID); err != nil {
-		h.TransactionHandler.RollbackTransaction()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.RoleService.RemoveUserRole(ctx, req.UserID, req.RoleID)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.CommitTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	c.JSON(http.StatusOK, gin.H{"status": "Role removed from user"})
+}
+
+// QueryRoles lists roles a page at a time; see repository.ParseListParams for the parameters
+func (h *RoleHandler) QueryRoles(c *gin.Context) {
+	query, err := bindListQuery(c, repository.RoleListFields)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "Role added to user"})
+	page, err := h.RoleService.QueryRoles(c.Request.Context(), query)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, NewRoleListResponse(page))
 }
 
-func (h *RoleHandler) RemoveUserRole(c *gin.Context) {
-	var req struct {
-		UserID uint64 `json:"userID"`
-		RoleID uint64 `json:"roleID"`
+// ListUserRoles lists the roles of the user named in the path
+func (h *RoleHandler) ListUserRoles(c *gin.Context) {
+	h.listRelatedRoles(c, h.RoleService.ListUserRoles)
+}
+
+// ListPermissionRoles lists the roles the permission in the path is granted to
+func (h *RoleHandler) ListPermissionRoles(c *gin.Context) {
+	h.listRelatedRoles(c, h.RoleService.ListPermissionRoles)
+}
+
+func (h *RoleHandler) listRelatedRoles(c *gin.Context, list func(ctx context.Context, id uint64, query repository.ListQuery) (repository.Page[model.Role], error)) {
+	id, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	query, err := bindListQuery(c, repository.RoleListFields)
+	if err != nil {
+		c.Error(err)
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	page, err := list(c.Request.Context(), id, query)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.TransactionHandler.StartTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	c.JSON(http.StatusOK, NewRoleListResponse(page))
+}
+
+// GetUserRole returns a role of the user, or 404 when the user does not have it
+func (h *RoleHandler) GetUserRole(c *gin.Context) {
+	userID, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	roleID, err := pathID(c, "roleID")
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := h.RoleService.RemoveUserRole(req.UserID, req.RoleID); err != nil {
-		h.TransactionHandler.RollbackTransaction()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	roles, err := h.RoleService.GetRolesByUserID(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
 		return
 	}
+	for i := range roles {
+		if roles[i].ID == roleID {
+			c.JSON(http.StatusOK, NewRoleResponse(&roles[i]))
+			return
+		}
+	}
+
+	c.Error(errors.NewAppError(errors.CodeNotFound, "The user does not have this role"))
+}
 
-	if err := h.TransactionHandler.CommitTransaction(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+// AssignUserRole gives the user in the path the role in the path, within
+// the scope in the query or globally when there is none
+func (h *RoleHandler) AssignUserRole(c *gin.Context) {
+	h.changeUserRole(c, h.RoleService.AddScopedUserRole, "Role added to user")
+}
+
+// RevokeUserRole takes the role in the path, assigned within the scope in the
+// query or globally when there is none, away from the user in the path
+func (h *RoleHandler) RevokeUserRole(c *gin.Context) {
+	h.changeUserRole(c, h.RoleService.RemoveScopedUserRole, "Role removed from user")
+}
+
+// ListRoleAssignments lists the roles of the user in the path in every scope
+func (h *RoleHandler) ListRoleAssignments(c *gin.Context) {
+	userID, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "Role removed from user"})
+	assignments, err := h.RoleService.GetRoleAssignments(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": NewRoleAssignmentResponses(assignments)})
+}
+
+func (h *RoleHandler) changeUserRole(c *gin.Context, change func(ctx context.Context, userID, roleID uint64, scope string) error, status string) {
+	userID, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	roleID, err := pathID(c, "roleID")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return change(ctx, userID, roleID, c.Query("scope"))
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": status})
 }
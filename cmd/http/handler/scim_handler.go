@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/scim"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+)
+
+// scimDefaultCount is the page size when a list request has no count
+const scimDefaultCount = 100
+
+// SCIMHandler serves the SCIM routes, running each write in one unit of work
+type SCIMHandler struct {
+	SCIMService *service.SCIMService
+	UnitOfWork  repository.UnitOfWork
+}
+
+func NewSCIMHandler(scimService *service.SCIMService, uow repository.UnitOfWork) *SCIMHandler {
+	return &SCIMHandler{
+		SCIMService: scimService,
+		UnitOfWork:  uow,
+	}
+}
+
+func (h *SCIMHandler) respond(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(status, body)
+}
+
+func (h *SCIMHandler) fail(c *gin.Context, err error) {
+	scimErr, ok := err.(*scim.Error)
+	if !ok {
+		scimErr = scim.NewError(http.StatusInternalServerError, "", "Internal server error occurred")
+	}
+	h.respond(c, scimErr.StatusCode(), scimErr)
+}
+
+// location returns the absolute URL of a resource under the SCIM base path
+func (h *SCIMHandler) location(c *gin.Context, endpoint string, id string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := c.GetHeader("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	}
+	return scheme + "://" + c.Request.Host + "/scim/v2/" + endpoint + "/" + id
+}
+
+func listParams(c *gin.Context) (string, int, int) {
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(scimDefaultCount)))
+	if err != nil {
+		count = scimDefaultCount
+	}
+	return c.Query("filter"), startIndex, count
+}
+
+func (h *SCIMHandler) bind(c *gin.Context, body interface{}) bool {
+	if err := c.ShouldBindJSON(body); err != nil {
+		h.fail(c, scim.BadRequest(scim.ErrInvalidSyntax, "%s", err.Error()))
+		return false
+	}
+	return true
+}
+
+func (h *SCIMHandler) bindPatch(c *gin.Context) (*scim.PatchRequest, bool) {
+	var patch scim.PatchRequest
+	if !h.bind(c, &patch) {
+		return nil, false
+	}
+	if len(patch.Operations) == 0 {
+		h.fail(c, scim.BadRequest(scim.ErrInvalidSyntax, "patch request has no Operations"))
+		return nil, false
+	}
+	return &patch, true
+}
+
+func (h *SCIMHandler) ServiceProviderConfig(c *gin.Context) {
+	h.respond(c, http.StatusOK, scim.DefaultServiceProviderConfig())
+}
+
+func (h *SCIMHandler) ResourceTypes(c *gin.Context) {
+	var resources []interface{}
+	for _, resourceType := range scim.ResourceTypes() {
+		resources = append(resources, resourceType)
+	}
+	h.respond(c, http.StatusOK, scim.NewListResponse(resources, int64(len(resources)), 1))
+}
+
+func (h *SCIMHandler) GetResourceType(c *gin.Context) {
+	for _, resourceType := range scim.ResourceTypes() {
+		if resourceType.ID == c.Param("id") {
+			h.respond(c, http.StatusOK, resourceType)
+			return
+		}
+	}
+	h.fail(c, scim.NotFound("ResourceType %s not found", c.Param("id")))
+}
+
+func (h *SCIMHandler) Schemas(c *gin.Context) {
+	var resources []interface{}
+	for _, schema := range scim.Schemas() {
+		resources = append(resources, schema)
+	}
+	h.respond(c, http.StatusOK, scim.NewListResponse(resources, int64(len(resources)), 1))
+}
+
+func (h *SCIMHandler) GetSchema(c *gin.Context) {
+	for _, schema := range scim.Schemas() {
+		if schema.ID == c.Param("id") {
+			h.respond(c, http.StatusOK, schema)
+			return
+		}
+	}
+	h.fail(c, scim.NotFound("Schema %s not found", c.Param("id")))
+}
+
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	filter, startIndex, count := listParams(c)
+	list, err := h.SCIMService.ListUsers(c.Request.Context(), filter, startIndex, count)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	for _, resource := range list.Resources {
+		user := resource.(*scim.User)
+		user.Meta.Location = h.location(c, "Users", user.ID)
+	}
+	h.respond(c, http.StatusOK, list)
+}
+
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	user, err := h.SCIMService.GetUser(c.Request.Context(), c.Param("id"))
+	h.respondUser(c, http.StatusOK, user, err)
+}
+
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var resource scim.User
+	if !h.bind(c, &resource) {
+		return
+	}
+	var user *scim.User
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		user, err = h.SCIMService.CreateUser(ctx, &resource)
+		return err
+	})
+	h.respondUser(c, http.StatusCreated, user, err)
+}
+
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	var resource scim.User
+	if !h.bind(c, &resource) {
+		return
+	}
+	var user *scim.User
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		user, err = h.SCIMService.ReplaceUser(ctx, c.Param("id"), &resource)
+		return err
+	})
+	h.respondUser(c, http.StatusOK, user, err)
+}
+
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	patch, ok := h.bindPatch(c)
+	if !ok {
+		return
+	}
+	var user *scim.User
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		user, err = h.SCIMService.PatchUser(ctx, c.Param("id"), patch)
+		return err
+	})
+	h.respondUser(c, http.StatusOK, user, err)
+}
+
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.SCIMService.DeleteUser(ctx, c.Param("id"))
+	})
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) respondUser(c *gin.Context, status int, user *scim.User, err error) {
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	user.Meta.Location = h.location(c, "Users", user.ID)
+	if status == http.StatusCreated {
+		c.Header("Location", user.Meta.Location)
+	}
+	h.respond(c, status, user)
+}
+
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	filter, startIndex, count := listParams(c)
+	list, err := h.SCIMService.ListGroups(c.Request.Context(), filter, startIndex, count)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	for _, resource := range list.Resources {
+		group := resource.(*scim.Group)
+		group.Meta.Location = h.location(c, "Groups", group.ID)
+	}
+	h.respond(c, http.StatusOK, list)
+}
+
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	group, err := h.SCIMService.GetGroup(c.Request.Context(), c.Param("id"))
+	h.respondGroup(c, http.StatusOK, group, err)
+}
+
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	var resource scim.Group
+	if !h.bind(c, &resource) {
+		return
+	}
+	var group *scim.Group
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		group, err = h.SCIMService.CreateGroup(ctx, &resource)
+		return err
+	})
+	h.respondGroup(c, http.StatusCreated, group, err)
+}
+
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	var resource scim.Group
+	if !h.bind(c, &resource) {
+		return
+	}
+	var group *scim.Group
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		group, err = h.SCIMService.ReplaceGroup(ctx, c.Param("id"), &resource)
+		return err
+	})
+	h.respondGroup(c, http.StatusOK, group, err)
+}
+
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	patch, ok := h.bindPatch(c)
+	if !ok {
+		return
+	}
+	var group *scim.Group
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		group, err = h.SCIMService.PatchGroup(ctx, c.Param("id"), patch)
+		return err
+	})
+	h.respondGroup(c, http.StatusOK, group, err)
+}
+
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	err := h.UnitOfWork.Do(c.Request.Context(), func(ctx context.Context) error {
+		return h.SCIMService.DeleteGroup(ctx, c.Param("id"))
+	})
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) respondGroup(c *gin.Context, status int, group *scim.Group, err error) {
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	group.Meta.Location = h.location(c, "Groups", group.ID)
+	if status == http.StatusCreated {
+		c.Header("Location", group.Meta.Location)
+	}
+	h.respond(c, status, group)
+}
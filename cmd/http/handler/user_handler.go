@@ -3,21 +3,33 @@ package handler
 import (
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
 	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
 )
 
 type UserHandler struct {
-	UserService *service.UserService
+	UserService   *service.UserService
+	Authenticator service.Authenticator
+	// TokenKey signs the tokens the handler issues
+	TokenKey []byte
 }
 
-func NewUserHandler(userService *service.UserService) *UserHandler {
+func NewUserHandler(userService *service.UserService, authenticator service.Authenticator, tokenKey []byte) *UserHandler {
 	return &UserHandler{
-		UserService: userService,
+		UserService:   userService,
+		Authenticator: authenticator,
+		TokenKey:      tokenKey,
 	}
 }
 
@@ -29,74 +41,167 @@ func hashPassword(password string) (string, error) {
 	return string(hash), nil
 }
 
+// issueUserToken creates the access token returned by every login flow
+func issueUserToken(auth *service.Authentication, key []byte) (string, error) {
+	authTime := auth.AuthTime
+	if authTime.IsZero() {
+		authTime = time.Now()
+	}
+
+	claims := service.Claims{
+		Username: auth.User.Username,
+		Email:    auth.User.Email,
+		Roles:    auth.Roles,
+		AuthTime: authTime.Unix(),
+		AMR:      auth.Methods,
+	}
+	// Directory users that are not shadowed locally have no user ID
+	if auth.User.ID != 0 {
+		claims.UserID = strconv.FormatUint(auth.User.ID, 10)
+	}
+	return service.GeneratePasetoToken(&claims, key)
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (r *LoginRequest) Validate() error {
+	var v validation.Validator
+	v.Required("username", r.Username)
+	v.Required("password", r.Password)
+	return v.Err()
+}
+
 func (h *UserHandler) LoginUser(c *gin.Context) {
-	var login struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+	var login LoginRequest
+	if err := bindJSON(c, &login); err != nil {
+		c.Error(err)
+		return
 	}
 
-	if err := c.ShouldBindJSON(&login); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Verify the credentials against the configured authenticators
+	auth, err := h.Authenticator.Authenticate(c.Request.Context(), login.Username, login.Password)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	// Check if user exists
-	user, err := h.UserService.GetUserByUsername(login.Username)
+	// Create a token
+	token, err := issueUserToken(auth, h.TokenKey)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
+		return
+	}
+
+	// Return the token
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// ImpersonationTokenTTL is how long an impersonation token stays valid
+var ImpersonationTokenTTL = 5 * time.Minute
+
+// ImpersonateUser issues a short-lived token for the target user that names the
+// calling admin as its actor. Impersonation tokens cannot impersonate again.
+func (h *UserHandler) ImpersonateUser(c *gin.Context) {
+	value, _ := c.Get("claims")
+	admin, ok := value.(*service.Claims)
+	if !ok {
+		c.Error(errors.NewAppError(errors.CodeUnauthorized, "Unauthorized"))
 		return
 	}
-	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+	if admin.Actor != nil {
+		c.Error(errors.NewAppError(errors.CodeForbidden, "Impersonation tokens cannot be used to impersonate"))
 		return
 	}
 
-	// Verify password
-	if !service.CheckPassword(user.PasswordHash, login.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(errors.NewAppError(errors.CodeBadRequest, err.Error()))
+		return
+	}
+	if strconv.FormatUint(id, 10) == admin.UserID {
+		c.Error(errors.NewAppError(errors.CodeBadRequest, "Cannot impersonate yourself"))
+		return
+	}
+
+	user, err := h.UserService.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !user.Active {
+		c.Error(errors.NewAppError(errors.CodeForbidden, "Deactivated users cannot be impersonated"))
 		return
 	}
 
-	// Create a token
 	claims := service.Claims{
 		UserID:   strconv.FormatUint(user.ID, 10),
 		Username: user.Username,
 		Email:    user.Email,
+		Actor: &service.Actor{
+			UserID:   admin.UserID,
+			Username: admin.Username,
+		},
+		// Step-up checks judge the admin's own proof of identity
+		AuthTime: admin.AuthTime,
+		AMR:      admin.AMR,
 	}
 
-	symmetricKey := service.GenerateKey()
-	service.StoreKeyInFile(symmetricKey)
-
-	token, err := service.GeneratePasetoToken(&claims, symmetricKey)
+	token, err := service.GeneratePasetoTokenWithTTL(&claims, h.TokenKey, ImpersonationTokenTTL)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	// Return the token
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	logs.WithFields(logrus.Fields{
+		"event":          "impersonation_started",
+		"user_id":        claims.UserID,
+		"username":       claims.Username,
+		"actor_id":       admin.UserID,
+		"actor_username": admin.Username,
+		"expires_at":     claims.ExpiresAt,
+	}).Info("impersonation token issued")
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": claims.ExpiresAt})
+}
+
+type RegisterUserRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (r *RegisterUserRequest) Validate() error {
+	var v validation.Validator
+	service.ValidateUserFields(&v, strings.TrimSpace(r.Username), strings.TrimSpace(r.Email))
+	// bcrypt only looks at the first 72 bytes
+	v.Length("password", r.Password, 6, 72)
+	return v.Err()
 }
 
 func (h *UserHandler) RegisterUser(c *gin.Context) {
-	var request struct {
-		model.User
-		Password string `json:"password"`
-	}
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var request RegisterUserRequest
+	if err := bindJSON(c, &request); err != nil {
+		c.Error(err)
 		return
 	}
 
 	hashedPassword, err := hashPassword(request.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
-	request.PasswordHash = string(hashedPassword)
 
-	err = h.UserService.CreateUser(&request.User)
+	user := model.User{
+		Username:     request.Username,
+		Email:        request.Email,
+		PasswordHash: hashedPassword,
+	}
+	err = h.UserService.CreateUser(c.Request.Context(), &user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -106,29 +211,29 @@ func (h *UserHandler) RegisterUser(c *gin.Context) {
 func (h *UserHandler) GetUserByUsername(c *gin.Context) {
 	username := c.Param("username")
 
-	user, err := h.UserService.GetUserByUsername(username)
+	user, err := h.UserService.GetUserByUsername(c.Request.Context(), username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, NewUserResponse(user))
 }
 
 func (h *UserHandler) GetUserByID(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(errors.NewAppError(errors.CodeBadRequest, err.Error()))
 		return
 	}
 
-	user, err := h.UserService.GetUserByID(id)
+	user, err := h.UserService.GetUserByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, NewUserResponse(user))
 }
 
 type UpdateUserRequest struct {
@@ -137,41 +242,91 @@ type UpdateUserRequest struct {
 	Email    string `json:"email"`
 }
 
+func (r *UpdateUserRequest) Validate() error {
+	var v validation.Validator
+	v.RequiredID("id", r.ID)
+	service.ValidateUserFields(&v, strings.TrimSpace(r.Username), strings.TrimSpace(r.Email))
+	return v.Err()
+}
+
 func (h *UserHandler) UpdateUser(c *gin.Context) {
 	var req UpdateUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
 
-	user, err := h.UserService.GetUserByID(req.ID)
+	user, err := h.UserService.GetUserByID(c.Request.Context(), req.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+		c.Error(err)
 		return
 	}
 
 	user.Username = req.Username
 	user.Email = req.Email
 
-	err = h.UserService.UpdateUser(user)
+	err = h.UserService.UpdateUser(c.Request.Context(), user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"status": "User updated"})
 }
 
+// UserRequest is the body of PUT /v1/users/{id}
+type UserRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+func (r *UserRequest) Validate() error {
+	var v validation.Validator
+	service.ValidateUserFields(&v, strings.TrimSpace(r.Username), strings.TrimSpace(r.Email))
+	return v.Err()
+}
+
+// UpdateUserByID changes the username and email of the user named in the path
+func (h *UserHandler) UpdateUserByID(c *gin.Context) {
+	id, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var req UserRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	user, err := h.UserService.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	user.Username = req.Username
+	user.Email = req.Email
+
+	err = h.UserService.UpdateUser(c.Request.Context(), user)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, NewUserResponse(user))
+}
+
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(errors.NewAppError(errors.CodeBadRequest, err.Error()))
 		return
 	}
 
-	err = h.UserService.DeleteUser(id)
+	err = h.UserService.DeleteUser(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -181,32 +336,102 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 func (h *UserHandler) ListUsers(c *gin.Context) {
 	page, pageSize := getPaginationParams(c)
 
-	users, err := h.UserService.ListUsers(page, pageSize)
+	users, err := h.UserService.ListUsers(c.Request.Context(), page, pageSize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, NewUserResponses(users))
+}
+
+// QueryUsers lists users a page at a time; see repository.ParseListParams for the parameters
+func (h *UserHandler) QueryUsers(c *gin.Context) {
+	query, err := bindListQuery(c, repository.UserListFields)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	page, err := h.UserService.QueryUsers(c.Request.Context(), query)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, NewUserListResponse(page))
+}
+
+// ListRoleUsers lists the users that have the role in the path
+func (h *UserHandler) ListRoleUsers(c *gin.Context) {
+	roleID, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	query, err := bindListQuery(c, repository.UserListFields)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	page, err := h.UserService.ListRoleUsers(c.Request.Context(), roleID, query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, NewUserListResponse(page))
 }
 
+// SearchUsers finds users by username or email. By default it returns the
+// users that contain the query, as typed; mode=fuzzy ignores case, also finds
+// near misses and ranks the results, best first.
 func (h *UserHandler) SearchUsers(c *gin.Context) {
 	page, pageSize := getPaginationParams(c)
 	query := c.Query("query")
 
-	users, err := h.UserService.SearchUsers(query, page, pageSize)
+	switch c.DefaultQuery("mode", "contains") {
+	case "contains":
+	case "fuzzy":
+		h.fuzzySearchUsers(c, query, page, pageSize)
+		return
+	default:
+		var v validation.Validator
+		v.Add("mode", validation.CodeInvalidValue, "must be contains or fuzzy")
+		c.Error(v.Err())
+		return
+	}
+
+	users, err := h.UserService.SearchUsers(c.Request.Context(), query, page, pageSize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, NewUserResponses(users))
+}
+
+func (h *UserHandler) fuzzySearchUsers(c *gin.Context, query string, page int, pageSize int) {
+	var v validation.Validator
+	if !v.Required("query", query) {
+		c.Error(v.Err())
+		return
+	}
+
+	matches, err := h.UserService.FuzzySearchUsers(c.Request.Context(), query, page, pageSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, NewUserSearchResults(matches))
 }
 
 func (h *UserHandler) CountUsers(c *gin.Context) {
-	count, err := h.UserService.CountUsers()
+	count, err := h.UserService.CountUsers(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -214,9 +439,10 @@ func (h *UserHandler) CountUsers(c *gin.Context) {
 }
 
 func getPaginationParams(c *gin.Context) (int, int) {
-	page, err := strconv.Atoi(c.DefaultQuery("page", "0"))
-	if err != nil || page < 0 {
-		page = 0
+	// Pages count from 1
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
 	}
 
 	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "100"))
@@ -0,0 +1,234 @@
+// Package openapi embeds the OpenAPI 3 description of the HTTP API and checks
+// requests and responses against it. Only the parts of OpenAPI the document
+// uses are modelled.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//go:embed openapi.json
+var document []byte
+
+// Document returns the raw OpenAPI document
+func Document() []byte {
+	return document
+}
+
+type Spec struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+type Components struct {
+	Schemas    map[string]*Schema    `json:"schemas"`
+	Parameters map[string]*Parameter `json:"parameters"`
+	Responses  map[string]*Response  `json:"responses"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref     string                `json:"$ref"`
+	Content map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of the OpenAPI 3.0 schema object the validator enforces
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	MinItems             *int               `json:"minItems"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+}
+
+// Load parses the embedded document and checks that every reference in it
+// resolves, so a broken spec fails at startup rather than on a request.
+func Load() (*Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal(document, &spec); err != nil {
+		return nil, fmt.Errorf("parse openapi document: %w", err)
+	}
+
+	for path, item := range spec.Paths {
+		for method, op := range item {
+			where := strings.ToUpper(method) + " " + path
+			for i, param := range op.Parameters {
+				resolved, err := spec.parameter(param)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", where, err)
+				}
+				op.Parameters[i] = resolved
+			}
+			for status, response := range op.Responses {
+				resolved, err := spec.response(response)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", where, err)
+				}
+				op.Responses[status] = resolved
+			}
+			if err := spec.checkOperationRefs(op); err != nil {
+				return nil, fmt.Errorf("%s: %w", where, err)
+			}
+		}
+	}
+	for name, schema := range spec.Components.Schemas {
+		if err := spec.checkRefs(schema); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+	return &spec, nil
+}
+
+// Operation returns the operation for a method and a route as gin registers
+// it, e.g. "/user/:id", or nil when the spec does not describe it.
+func (s *Spec) Operation(method string, route string) *Operation {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	item, ok := s.Paths[strings.Join(segments, "/")]
+	if !ok {
+		return nil
+	}
+	return item[strings.ToLower(method)]
+}
+
+// Response returns the documented response for a status code, falling back
+// to the operation's default response.
+func (op *Operation) Response(status int) *Response {
+	if response, ok := op.Responses[fmt.Sprint(status)]; ok {
+		return response
+	}
+	return op.Responses["default"]
+}
+
+func (s *Spec) parameter(param *Parameter) (*Parameter, error) {
+	if param.Ref == "" {
+		return param, nil
+	}
+	resolved, ok := s.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+	if !ok {
+		return nil, fmt.Errorf("unresolved parameter %s", param.Ref)
+	}
+	return resolved, nil
+}
+
+func (s *Spec) response(response *Response) (*Response, error) {
+	if response.Ref == "" {
+		return response, nil
+	}
+	resolved, ok := s.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	if !ok {
+		return nil, fmt.Errorf("unresolved response %s", response.Ref)
+	}
+	return resolved, nil
+}
+
+// schema follows a $ref to the named component schema
+func (s *Spec) schema(schema *Schema) *Schema {
+	if schema.Ref == "" {
+		return schema
+	}
+	return s.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+}
+
+func (s *Spec) checkRefs(schema *Schema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" && s.schema(schema) == nil {
+		return fmt.Errorf("unresolved schema %s", schema.Ref)
+	}
+	for _, property := range schema.Properties {
+		if err := s.checkRefs(property); err != nil {
+			return err
+		}
+	}
+	return s.checkRefs(schema.Items)
+}
+
+func (s *Spec) checkOperationRefs(op *Operation) error {
+	var schemas []*Schema
+	for _, param := range op.Parameters {
+		schemas = append(schemas, param.Schema)
+	}
+	if op.RequestBody != nil {
+		for _, media := range op.RequestBody.Content {
+			schemas = append(schemas, media.Schema)
+		}
+	}
+	for _, response := range op.Responses {
+		for _, media := range response.Content {
+			schemas = append(schemas, media.Schema)
+		}
+	}
+	for _, schema := range schemas {
+		if err := s.checkRefs(schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mediaType strips parameters such as charset from a Content-Type header
+func mediaType(contentType string) string {
+	media, _, _ := strings.Cut(contentType, ";")
+	return strings.TrimSpace(strings.ToLower(media))
+}
+
+// content picks the schema for a media type. Requests that name no media
+// type, or one the operation does not list, are read as JSON when JSON is
+// accepted, since the handlers decode them that way regardless.
+func content(media map[string]*MediaType, contentType string, lenient bool) (*MediaType, bool) {
+	if m, ok := media[mediaType(contentType)]; ok {
+		return m, true
+	}
+	if lenient {
+		m, ok := media["application/json"]
+		return m, ok
+	}
+	return nil, false
+}
+
+// statusText is used in violation messages
+func statusText(status int) string {
+	return fmt.Sprintf("%d %s", status, http.StatusText(status))
+}
@@ -0,0 +1,314 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// ValidateRequest checks the parameters and body of a request against op.
+// params holds the path parameters by name. A path parameter that does not
+// match names no resource, so those are reported in one 400; every other
+// violation is reported in one 422 validation error, and a body that is not
+// JSON is a 400.
+func (s *Spec) ValidateRequest(op *Operation, r *http.Request, params map[string]string, body []byte) error {
+	var path validation.Validator
+	for _, param := range op.Parameters {
+		if value, present := params[param.Name]; param.In == "path" && present && param.Schema != nil {
+			s.validateParameter(param.Schema, param.Name, value, &path)
+		}
+	}
+	if err := path.BadRequest(); err != nil {
+		return err
+	}
+
+	var v validation.Validator
+	query := r.URL.Query()
+	for _, param := range op.Parameters {
+		var value string
+		var present bool
+		switch param.In {
+		case "path":
+			if _, present = params[param.Name]; present {
+				continue
+			}
+		case "query":
+			present = query.Has(param.Name)
+			value = query.Get(param.Name)
+		default:
+			continue
+		}
+		if !present {
+			if param.Required {
+				v.Add(param.Name, validation.CodeRequired, "is required")
+			}
+			continue
+		}
+		if param.Schema != nil {
+			s.validateParameter(param.Schema, param.Name, value, &v)
+		}
+	}
+
+	if op.RequestBody != nil {
+		if len(bytes.TrimSpace(body)) == 0 {
+			if op.RequestBody.Required {
+				v.Add("body", validation.CodeRequired, "is required")
+			}
+			return v.Err()
+		}
+
+		media, ok := content(op.RequestBody.Content, r.Header.Get("Content-Type"), true)
+		if !ok {
+			return errors.NewAppErrorf(errors.CodeBadRequest, "Content type %s is not accepted", r.Header.Get("Content-Type"))
+		}
+		value, err := decode(body)
+		if err != nil {
+			return errors.NewAppError(errors.CodeBadRequest, "Request body is not valid JSON")
+		}
+		if media.Schema != nil {
+			s.validate(media.Schema, value, "", &v)
+		}
+	}
+
+	return v.Err()
+}
+
+// ValidateResponse reports how a response departs from op, or returns nil
+// when it matches. Bodies of responses documented without content are not
+// checked.
+func (s *Spec) ValidateResponse(op *Operation, status int, contentType string, body []byte) error {
+	response := op.Response(status)
+	if response == nil {
+		return fmt.Errorf("status %s is not documented", statusText(status))
+	}
+	if len(response.Content) == 0 {
+		return nil
+	}
+	if len(body) == 0 {
+		return fmt.Errorf("%s has no body", statusText(status))
+	}
+
+	media, ok := content(response.Content, contentType, false)
+	if !ok {
+		return fmt.Errorf("%s has undocumented content type %q", statusText(status), contentType)
+	}
+	value, err := decode(body)
+	if err != nil {
+		return fmt.Errorf("%s body is not valid JSON: %w", statusText(status), err)
+	}
+	if media.Schema == nil {
+		return nil
+	}
+
+	var v validation.Validator
+	s.validate(media.Schema, value, "", &v)
+	if err := v.Err(); err != nil {
+		return fmt.Errorf("%s body does not match the schema: %w", statusText(status), err)
+	}
+	return nil
+}
+
+// decode parses JSON keeping numbers exact, so integers can be told apart
+func decode(body []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// validateParameter converts a path or query string to the schema's type before validating it
+func (s *Spec) validateParameter(schema *Schema, name string, raw string, v *validation.Validator) {
+	schema = s.schema(schema)
+	var value interface{} = raw
+	switch schema.Type {
+	case "integer", "number":
+		value = json.Number(raw)
+	case "boolean":
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			v.Add(name, validation.CodeInvalidType, "must be true or false")
+			return
+		}
+		value = parsed
+	}
+	s.validate(schema, value, name, v)
+}
+
+// validate checks value against schema, recording violations under field
+func (s *Spec) validate(schema *Schema, value interface{}, field string, v *validation.Validator) {
+	schema = s.schema(schema)
+	name := field
+	if name == "" {
+		name = "body"
+	}
+
+	if value == nil {
+		if !schema.Nullable && schema.Type != "" {
+			v.Add(name, validation.CodeInvalidType, "must not be null")
+		}
+		return
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		v.Add(name, validation.CodeInvalidValue, fmt.Sprintf("must be one of %v", schema.Enum))
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.Add(name, validation.CodeInvalidType, "must be an object")
+			return
+		}
+		s.validateObject(schema, object, field, v)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.Add(name, validation.CodeInvalidType, "must be an array")
+			return
+		}
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			if *schema.MinItems == 1 {
+				v.Add(name, validation.CodeRequired, "must not be empty")
+			} else {
+				v.Add(name, validation.CodeTooShort, fmt.Sprintf("must have at least %d items", *schema.MinItems))
+			}
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", name, i), v)
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			v.Add(name, validation.CodeInvalidType, "must be a string")
+			return
+		}
+		validateString(schema, text, name, v)
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			v.Add(name, validation.CodeInvalidType, "must be "+article(schema.Type))
+			return
+		}
+		validateNumber(schema, number, name, v)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.Add(name, validation.CodeInvalidType, "must be a boolean")
+		}
+	}
+}
+
+func (s *Spec) validateObject(schema *Schema, object map[string]interface{}, field string, v *validation.Validator) {
+	join := func(key string) string {
+		if field == "" {
+			return key
+		}
+		return field + "." + key
+	}
+
+	for _, key := range schema.Required {
+		if _, ok := object[key]; !ok {
+			v.Add(join(key), validation.CodeRequired, "is required")
+		}
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		property, ok := schema.Properties[key]
+		if !ok {
+			if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				v.Add(join(key), validation.CodeInvalidValue, "is not allowed")
+			}
+			continue
+		}
+		s.validate(property, object[key], join(key), v)
+	}
+}
+
+func validateString(schema *Schema, text string, name string, v *validation.Validator) {
+	length := utf8.RuneCountInString(text)
+	if schema.MinLength != nil && length < *schema.MinLength {
+		if *schema.MinLength == 1 && length == 0 {
+			v.Add(name, validation.CodeRequired, "is required")
+		} else {
+			v.Add(name, validation.CodeTooShort, fmt.Sprintf("must be at least %d characters", *schema.MinLength))
+		}
+		return
+	}
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		v.Add(name, validation.CodeTooLong, fmt.Sprintf("must be at most %d characters", *schema.MaxLength))
+		return
+	}
+
+	switch schema.Format {
+	case "email":
+		if addr, err := mail.ParseAddress(text); err != nil || addr.Address != text {
+			v.Add(name, validation.CodeInvalidFormat, "must be a valid email address")
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, text); err != nil {
+			v.Add(name, validation.CodeInvalidFormat, "must be an RFC 3339 date-time")
+		}
+	}
+}
+
+func validateNumber(schema *Schema, number json.Number, name string, v *validation.Validator) {
+	value, err := number.Float64()
+	if err != nil {
+		v.Add(name, validation.CodeInvalidType, "must be "+article(schema.Type))
+		return
+	}
+	if schema.Type == "integer" {
+		if _, err := number.Int64(); err != nil && !isUint64(number) {
+			v.Add(name, validation.CodeInvalidType, "must be an integer")
+			return
+		}
+	}
+	if schema.Minimum != nil && value < *schema.Minimum {
+		v.Add(name, validation.CodeInvalidValue, fmt.Sprintf("must be at least %v", *schema.Minimum))
+	}
+	if schema.Maximum != nil && value > *schema.Maximum {
+		v.Add(name, validation.CodeInvalidValue, fmt.Sprintf("must be at most %v", *schema.Maximum))
+	}
+}
+
+// article names a numeric schema type for messages
+func article(schemaType string) string {
+	if schemaType == "integer" {
+		return "an integer"
+	}
+	return "a number"
+}
+
+func isUint64(number json.Number) bool {
+	_, err := strconv.ParseUint(number.String(), 10, 64)
+	return err == nil
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
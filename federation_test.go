@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service/oidctest"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+)
+
+// newFederatedTestApp builds the test app with the stub provider registered
+// as "stub"
+func newFederatedTestApp(t *testing.T) (*app, *oidctest.Provider) {
+	t.Helper()
+	provider, err := oidctest.NewProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(provider.Close)
+
+	cfg := testConfig()
+	cfg.OIDCProviders = []config.OIDCProvider{{
+		ID:          "stub",
+		Issuer:      provider.Issuer(),
+		ClientID:    "ums",
+		RedirectURL: "http://example.com/v1/auth/stub/callback",
+	}}
+	return newConfiguredTestApp(t, cfg, nil), provider
+}
+
+// startFederatedLogin starts a login at the stub provider and returns the
+// state cookie and the state and nonce the provider was sent
+func startFederatedLogin(t *testing.T, a *app) (cookie *http.Cookie, state string, nonce string) {
+	t.Helper()
+	rec := a.serve(http.MethodGet, "/v1/auth/stub/login", "", nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "federation_state" {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("login set no state cookie")
+	}
+	return cookie, location.Query().Get("state"), location.Query().Get("nonce")
+}
+
+// callback completes the login, sending the cookie when there is one
+func callback(a *app, query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/v1/auth/stub/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFederatedLoginChecksState(t *testing.T) {
+	a, provider := newFederatedTestApp(t)
+
+	tests := []struct {
+		name string
+		// query builds the callback query from the login's state and code
+		query      func(state string, code string) url.Values
+		sendCookie bool
+		want       int
+	}{
+		{"matching state", func(state, code string) url.Values {
+			return url.Values{"state": {state}, "code": {code}}
+		}, true, http.StatusOK},
+		{"no login cookie", func(state, code string) url.Values {
+			return url.Values{"state": {state}, "code": {code}}
+		}, false, http.StatusBadRequest},
+		{"state of another login", func(state, code string) url.Values {
+			return url.Values{"state": {"forged"}, "code": {code}}
+		}, true, http.StatusBadRequest},
+		{"no state", func(state, code string) url.Values {
+			return url.Values{"code": {code}}
+		}, true, http.StatusBadRequest},
+		{"provider error", func(state, code string) url.Values {
+			return url.Values{"state": {state}, "error": {"access_denied"}}
+		}, true, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookie, state, nonce := startFederatedLogin(t, a)
+			code, err := provider.IssueCode("ums", map[string]interface{}{
+				"sub": "subject-1", "nonce": nonce, "email": "alice@example.com", "email_verified": true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.sendCookie {
+				cookie = nil
+			}
+
+			rec := callback(a, tt.query(state, code), cookie)
+			if rec.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var body struct {
+				Token string `json:"token"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if rec := a.serve(http.MethodGet, "/v1/users/by-username/alice@example.com", body.Token, nil); rec.Code != http.StatusOK {
+				t.Errorf("the issued token does not work: %d %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// TestFederatedLoginRoundTrip follows the login as a browser would, so the
+// state cookie only reaches the callback if its path covers it
+func TestFederatedLoginRoundTrip(t *testing.T) {
+	a, provider := newFederatedTestApp(t)
+
+	for _, prefix := range []string{"/v1/auth/stub", "/auth/stub"} {
+		t.Run(prefix, func(t *testing.T) {
+			jar, err := cookiejar.New(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			browse := func(target string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, target, nil)
+				for _, c := range jar.Cookies(req.URL) {
+					req.AddCookie(c)
+				}
+				rec := httptest.NewRecorder()
+				a.router.ServeHTTP(rec, req)
+				jar.SetCookies(req.URL, rec.Result().Cookies())
+				return rec
+			}
+
+			rec := browse("http://example.com" + prefix + "/login")
+			if rec.Code != http.StatusFound {
+				t.Fatalf("login: %d %s", rec.Code, rec.Body)
+			}
+			location, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, err := provider.IssueCode("ums", map[string]interface{}{
+				"sub": "subject-1", "nonce": location.Query().Get("nonce"), "email": "alice@example.com", "email_verified": true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			callbackURL := "http://example.com" + prefix + "/callback?" + url.Values{"state": {location.Query().Get("state")}, "code": {code}}.Encode()
+			if rec := browse(callbackURL); rec.Code != http.StatusOK {
+				t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+			}
+			if rec := browse(callbackURL); rec.Code != http.StatusBadRequest {
+				t.Errorf("the state cookie outlived the login: replaying the callback got %d %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package cache is a size-bounded, expiring in-process cache
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Stats counts the lookups a cache answered and the entries it dropped
+type Stats struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+	Entries       int
+	MaxEntries    int
+	TTL           time.Duration
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// Cache holds up to maxEntries values for ttl each, evicting the least
+// recently used entry when full. A zero ttl or maxEntries disables it: Get
+// always misses and Set stores nothing. It is safe for concurrent use.
+//
+// A value loaded while an invalidation happens may already be stale, so Set
+// takes the Generation read before loading and drops the value if the cache
+// was invalidated since.
+type Cache[K comparable, V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[K]*list.Element
+	order      *list.List
+	generation uint64
+	stats      Stats
+}
+
+func New[K comparable, V any](ttl time.Duration, maxEntries int) *Cache[K, V] {
+	return &Cache[K, V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[K]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (c *Cache[K, V]) enabled() bool {
+	return c.ttl > 0 && c.maxEntries > 0
+}
+
+// Get returns the live value stored under key
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry[K, V])
+		if time.Now().Before(e.expires) {
+			c.order.MoveToFront(elem)
+			c.stats.Hits++
+			return e.value, true
+		}
+		c.remove(elem)
+	}
+	c.stats.Misses++
+	var zero V
+	return zero, false
+}
+
+// Generation identifies the current state of the cache for Set
+func (c *Cache[K, V]) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Set stores value under key unless the cache was invalidated after generation
+func (c *Cache[K, V]) Set(key K, value V, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.enabled() || generation != c.generation {
+		return
+	}
+	expires := time.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry[K, V])
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry[K, V]{key: key, value: value, expires: expires})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Delete drops the entries stored under keys
+func (c *Cache[K, V]) Delete(keys ...K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.stats.Invalidations++
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+// Clear drops every entry
+func (c *Cache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.stats.Invalidations++
+	c.entries = make(map[K]*list.Element)
+	c.order.Init()
+}
+
+// Stats returns the counters so far and the current size
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.MaxEntries = c.maxEntries
+	stats.TTL = c.ttl
+	return stats
+}
+
+// remove drops an entry. The caller must hold the lock.
+func (c *Cache[K, V]) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*entry[K, V]).key)
+}
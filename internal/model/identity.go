@@ -0,0 +1,15 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a local user to an account at an external identity provider.
+type UserIdentity struct {
+	gorm.Model
+	UserID   uint64 `gorm:"not null;index" json:"user_id"`
+	Provider string `gorm:"size:255;not null;uniqueIndex:idx_user_identities_provider_subject" json:"provider"`
+	Subject  string `gorm:"size:255;not null;uniqueIndex:idx_user_identities_provider_subject" json:"subject"`
+	Email    string `gorm:"size:255" json:"email"`
+	User     User   `gorm:"foreignKey:UserID"`
+}
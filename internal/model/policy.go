@@ -0,0 +1,21 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+// Policy is an attribute-based rule checked after the roles. It applies to
+// checks of Permission, or of any permission when it is *, on the resources
+// Scope covers. When its Condition, a JSON expression over the subject, the
+// resource and the request context, holds, an allow policy grants the check
+// and a deny policy refuses it whatever the roles and other policies grant.
+type Policy struct {
+	gorm.Model
+	ID          uint64 `gorm:"primary_key;auto_increment" json:"id"`
+	Name        string `gorm:"size:255;not null;unique" json:"name"`
+	Description string `gorm:"size:1024;not null;default:''" json:"description"`
+	Effect      string `gorm:"size:16;not null" json:"effect"`
+	Permission  string `gorm:"size:255;not null" json:"permission"`
+	Scope       string `gorm:"size:255;not null;default:''" json:"scope"`
+	Condition   string `gorm:"type:text;not null;default:''" json:"condition"`
+}
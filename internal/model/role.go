@@ -12,10 +12,13 @@ type Role struct {
 	RolePermissions []RolePermission `gorm:"foreignKey:RoleID"`
 }
 
+// UserRole assigns a role to a user within a scope, a resource path such as
+// project:42 whose IDs may be the wildcard *. The empty scope is global.
 type UserRole struct {
 	gorm.Model
-	UserID uint64 `gorm:"not null" json:"user_id"`
+	UserID uint64 `gorm:"not null;index:idx_user_roles_user_id_scope" json:"user_id"`
 	RoleID uint64 `gorm:"not null" json:"role_id"`
+	Scope  string `gorm:"size:255;not null;default:'';index:idx_user_roles_user_id_scope" json:"scope"`
 	User   User   `gorm:"foreignKey:UserID"`
 	Role   Role   `gorm:"foreignKey:RoleID"`
 }
@@ -8,7 +8,8 @@ type User struct {
 	gorm.Model
 	ID           uint64     `gorm:"primary_key;auto_increment" json:"id"`
 	Username     string     `gorm:"size:255;not null;unique" json:"username"`
-	PasswordHash string     `gorm:"size:255;not null;" json:"password_hash"`
+	PasswordHash string     `gorm:"size:255;not null;" json:"-"` // never serialized, even if a user is encoded whole
 	Email        string     `gorm:"size:255;not null;unique" json:"email"`
+	Active       bool       `gorm:"not null;default:true" json:"active"` // false once deactivated, which stops logins; new users are active
 	UserRoles    []UserRole `gorm:"foreignKey:UserID"`
 }
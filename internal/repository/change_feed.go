@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// Change kinds. IDs are user IDs for the user kinds and role IDs for the role
+// kinds; ChangePolicies has none.
+const (
+	ChangeUserRoles          = "user_roles"
+	ChangeAllUserRoles       = "all_user_roles"
+	ChangeRolePermissions    = "role_permissions"
+	ChangeAllRolePermissions = "all_role_permissions"
+	ChangeUsersDeleted       = "users_deleted"
+	ChangePolicies           = "policies"
+)
+
+// Change tells the other instances sharing the database that authorization
+// data changed
+type Change struct {
+	// Origin identifies the publishing instance, which ignores its own changes
+	Origin string   `json:"origin"`
+	Kind   string   `json:"kind"`
+	IDs    []uint64 `json:"ids,omitempty"`
+	// At is when the change was made, in unix seconds
+	At int64 `json:"at"`
+}
+
+// ChangePublisher announces changes to the other instances
+type ChangePublisher interface {
+	Publish(ctx context.Context, change Change) error
+}
+
+// notifyPublisher sends changes with Postgres NOTIFY
+type notifyPublisher struct {
+	db      *gorm.DB
+	channel string
+}
+
+// NewNotifyPublisher publishes on the Postgres channel. A change published
+// inside a unit of work is delivered only if the unit commits.
+func NewNotifyPublisher(db *gorm.DB, channel string) ChangePublisher {
+	return &notifyPublisher{
+		db:      db,
+		channel: channel,
+	}
+}
+
+func (p *notifyPublisher) Publish(ctx context.Context, change Change) error {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return translate(conn(ctx, p.db).Exec("SELECT pg_notify(?, ?)", p.channel, string(payload)).Error)
+}
+
+// nopPublisher serves backends that only one instance can use
+type nopPublisher struct{}
+
+// NewNopPublisher returns a publisher that drops every change
+func NewNopPublisher() ChangePublisher {
+	return nopPublisher{}
+}
+
+func (nopPublisher) Publish(ctx context.Context, change Change) error {
+	return nil
+}
+
+// ChangeHandler receives what a ChangeListener hears
+type ChangeHandler interface {
+	// Apply handles one change published by another instance
+	Apply(change Change)
+	// Resync is called whenever changes may have been missed: on connecting,
+	// after a reconnect and on a malformed payload
+	Resync()
+	// ListenerError reports a lost connection or a failed reconnect
+	ListenerError(err error)
+}
+
+// ChangeListener receives the changes published on a Postgres channel over
+// its own connection, reconnecting with backoff when the connection drops
+type ChangeListener struct {
+	dsn     string
+	channel string
+}
+
+// NewChangeListener listens on channel of the database at dsn
+func NewChangeListener(dsn string, channel string) *ChangeListener {
+	return &ChangeListener{
+		dsn:     dsn,
+		channel: channel,
+	}
+}
+
+// Run delivers changes to handler until ctx is done
+func (l *ChangeListener) Run(ctx context.Context, handler ChangeHandler) error {
+	listener := pq.NewListener(l.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			handler.ListenerError(err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(l.channel); err != nil {
+		return err
+	}
+	// Anything published before LISTEN took effect is unknown
+	handler.Resync()
+
+	// A connection that died quietly is only noticed when used
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-listener.Notify:
+			// pq sends nil after reconnecting, when notifications may have been lost
+			if notification == nil {
+				handler.Resync()
+				continue
+			}
+			var change Change
+			if err := json.Unmarshal([]byte(notification.Extra), &change); err != nil {
+				handler.ListenerError(err)
+				handler.Resync()
+				continue
+			}
+			handler.Apply(change)
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Repositories report missing rows and unique key violations with these
+// sentinels, whatever the backend, so callers can test them with errors.Is.
+var (
+	ErrNotFound = errors.New("record not found")
+	ErrConflict = errors.New("record conflicts with an existing one")
+)
+
+// translate maps GORM's errors onto the repository sentinels
+func translate(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrConflict
+	}
+	return err
+}
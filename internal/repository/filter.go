@@ -0,0 +1,342 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FilterOp is a comparison or logical operator in a Filter
+type FilterOp string
+
+const (
+	FilterEq         FilterOp = "eq"
+	FilterNe         FilterOp = "ne"
+	FilterContains   FilterOp = "co"
+	FilterStartsWith FilterOp = "sw"
+	FilterEndsWith   FilterOp = "ew"
+	FilterPresent    FilterOp = "pr"
+	FilterGt         FilterOp = "gt"
+	FilterGe         FilterOp = "ge"
+	FilterLt         FilterOp = "lt"
+	FilterLe         FilterOp = "le"
+	FilterAnd        FilterOp = "and"
+	FilterOr         FilterOp = "or"
+	FilterNot        FilterOp = "not"
+)
+
+// Filter is a condition over the logical fields of a resource, such as
+// "username" or "created_at". Comparisons use Field and Value; and, or and
+// not combine Operands. String comparisons are case-insensitive.
+type Filter struct {
+	Op       FilterOp
+	Field    string
+	Value    interface{}
+	Operands []Filter
+}
+
+// filterFields maps the logical fields of a resource to SQL
+type filterFields struct {
+	columns map[string]string
+	// relations are fields of related rows
+	relations map[string]filterRelation
+}
+
+// filterRelation is a field of rows related to a record, such as the names
+// of a user's roles. A record matches when any related row does.
+type filterRelation struct {
+	// column holds the field in the related rows
+	column string
+	// match is a condition selecting the records with a related row matching %s
+	match string
+}
+
+// userFilterFields maps the filterable user fields to their columns
+var userFilterFields = filterFields{
+	columns: map[string]string{
+		"id":         "users.id",
+		"username":   "users.username",
+		"email":      "users.email",
+		"created_at": "users.created_at",
+		"updated_at": "users.updated_at",
+	},
+	relations: map[string]filterRelation{
+		"role": {
+			column: "roles.role_name",
+			match:  "users.id IN (SELECT user_roles.user_id FROM user_roles JOIN roles ON roles.id = user_roles.role_id AND roles.deleted_at IS NULL WHERE user_roles.deleted_at IS NULL AND user_roles.scope = '' AND %s)",
+		},
+		"role_id": {
+			column: "user_roles.role_id",
+			match:  "users.id IN (SELECT user_roles.user_id FROM user_roles WHERE user_roles.deleted_at IS NULL AND user_roles.scope = '' AND %s)",
+		},
+	},
+}
+
+// roleFilterFields maps the filterable role fields to their columns
+var roleFilterFields = filterFields{
+	columns: map[string]string{
+		"id":         "roles.id",
+		"role_name":  "roles.role_name",
+		"created_at": "roles.created_at",
+		"updated_at": "roles.updated_at",
+	},
+	relations: map[string]filterRelation{
+		"user_id": {
+			column: "user_roles.user_id",
+			match:  "roles.id IN (SELECT user_roles.role_id FROM user_roles WHERE user_roles.deleted_at IS NULL AND user_roles.scope = '' AND %s)",
+		},
+		"permission": {
+			column: "permissions.permission_name",
+			match:  "roles.id IN (SELECT role_permissions.role_id FROM role_permissions JOIN permissions ON permissions.id = role_permissions.permission_id AND permissions.deleted_at IS NULL WHERE role_permissions.deleted_at IS NULL AND %s)",
+		},
+		"permission_id": {
+			column: "role_permissions.permission_id",
+			match:  "roles.id IN (SELECT role_permissions.role_id FROM role_permissions WHERE role_permissions.deleted_at IS NULL AND %s)",
+		},
+	},
+}
+
+// permissionFilterFields maps the filterable permission fields to their columns
+var permissionFilterFields = filterFields{
+	columns: map[string]string{
+		"id":              "permissions.id",
+		"permission_name": "permissions.permission_name",
+		"created_at":      "permissions.created_at",
+		"updated_at":      "permissions.updated_at",
+	},
+	relations: map[string]filterRelation{
+		"role": {
+			column: "roles.role_name",
+			match:  "permissions.id IN (SELECT role_permissions.permission_id FROM role_permissions JOIN roles ON roles.id = role_permissions.role_id AND roles.deleted_at IS NULL WHERE role_permissions.deleted_at IS NULL AND %s)",
+		},
+		"role_id": {
+			column: "role_permissions.role_id",
+			match:  "permissions.id IN (SELECT role_permissions.permission_id FROM role_permissions WHERE role_permissions.deleted_at IS NULL AND %s)",
+		},
+	},
+}
+
+var filterComparisons = map[FilterOp]string{
+	FilterEq: "=",
+	FilterNe: "<>",
+	FilterGt: ">",
+	FilterGe: ">=",
+	FilterLt: "<",
+	FilterLe: "<=",
+}
+
+// filterClause renders a filter as a SQL condition and its arguments
+func filterClause(f Filter, fields filterFields) (string, []interface{}, error) {
+	switch f.Op {
+	case FilterAnd, FilterOr:
+		if len(f.Operands) == 0 {
+			return "", nil, fmt.Errorf("filter %s has no operands", f.Op)
+		}
+		clauses := make([]string, 0, len(f.Operands))
+		var args []interface{}
+		for _, operand := range f.Operands {
+			clause, operandArgs, err := filterClause(operand, fields)
+			if err != nil {
+				return "", nil, err
+			}
+			clauses = append(clauses, clause)
+			args = append(args, operandArgs...)
+		}
+		return "(" + strings.Join(clauses, " "+strings.ToUpper(string(f.Op))+" ") + ")", args, nil
+
+	case FilterNot:
+		if len(f.Operands) != 1 {
+			return "", nil, fmt.Errorf("filter not needs exactly one operand")
+		}
+		clause, args, err := filterClause(f.Operands[0], fields)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + clause, args, nil
+	}
+
+	if column, ok := fields.columns[f.Field]; ok {
+		return comparisonClause(f, column)
+	}
+	relation, ok := fields.relations[f.Field]
+	if !ok {
+		return "", nil, fmt.Errorf("field %q cannot be filtered", f.Field)
+	}
+	clause, args, err := comparisonClause(f, relation.column)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf(relation.match, clause), args, nil
+}
+
+// comparisonClause renders a comparison of one column as a SQL condition
+func comparisonClause(f Filter, column string) (string, []interface{}, error) {
+	if f.Op == FilterPresent {
+		return column + " IS NOT NULL", nil, nil
+	}
+
+	value, isString := f.Value.(string)
+	switch f.Op {
+	case FilterContains, FilterStartsWith, FilterEndsWith:
+		if !isString {
+			return "", nil, fmt.Errorf("filter %s needs a string value", f.Op)
+		}
+		pattern := escapeLike(strings.ToLower(value))
+		switch f.Op {
+		case FilterContains:
+			pattern = "%" + pattern + "%"
+		case FilterStartsWith:
+			pattern = pattern + "%"
+		case FilterEndsWith:
+			pattern = "%" + pattern
+		}
+		return fmt.Sprintf("LOWER(%s) LIKE ? ESCAPE '\\'", column), []interface{}{pattern}, nil
+	}
+
+	comparison, ok := filterComparisons[f.Op]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown filter operator %q", f.Op)
+	}
+	if isString {
+		return fmt.Sprintf("LOWER(%s) %s ?", column, comparison), []interface{}{strings.ToLower(value)}, nil
+	}
+	return fmt.Sprintf("%s %s ?", column, comparison), []interface{}{f.Value}, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// filterMatches evaluates a filter against a record's field values the way
+// filterClause does in SQL, for backends that filter in memory.
+func filterMatches(f Filter, fields map[string]interface{}) (bool, error) {
+	switch f.Op {
+	case FilterAnd, FilterOr:
+		if len(f.Operands) == 0 {
+			return false, fmt.Errorf("filter %s has no operands", f.Op)
+		}
+		for _, operand := range f.Operands {
+			matched, err := filterMatches(operand, fields)
+			if err != nil {
+				return false, err
+			}
+			if f.Op == FilterOr && matched {
+				return true, nil
+			}
+			if f.Op == FilterAnd && !matched {
+				return false, nil
+			}
+		}
+		return f.Op == FilterAnd, nil
+
+	case FilterNot:
+		if len(f.Operands) != 1 {
+			return false, fmt.Errorf("filter not needs exactly one operand")
+		}
+		matched, err := filterMatches(f.Operands[0], fields)
+		return !matched, err
+	}
+
+	actual, ok := fields[f.Field]
+	if !ok {
+		return false, fmt.Errorf("field %q cannot be filtered", f.Field)
+	}
+
+	// A relation holds the values of every related row, any of which may match
+	if related, ok := actual.([]interface{}); ok {
+		if f.Op == FilterPresent {
+			return len(related) > 0, nil
+		}
+		for _, value := range related {
+			matched, err := filterMatches(f, map[string]interface{}{f.Field: value})
+			if matched || err != nil {
+				return matched, err
+			}
+		}
+		return false, nil
+	}
+
+	if f.Op == FilterPresent {
+		return actual != nil, nil
+	}
+
+	value, isString := f.Value.(string)
+	switch f.Op {
+	case FilterContains, FilterStartsWith, FilterEndsWith:
+		if !isString {
+			return false, fmt.Errorf("filter %s needs a string value", f.Op)
+		}
+		text := strings.ToLower(fmt.Sprint(actual))
+		value = strings.ToLower(value)
+		switch f.Op {
+		case FilterContains:
+			return strings.Contains(text, value), nil
+		case FilterStartsWith:
+			return strings.HasPrefix(text, value), nil
+		default:
+			return strings.HasSuffix(text, value), nil
+		}
+	}
+
+	if _, ok := filterComparisons[f.Op]; !ok {
+		return false, fmt.Errorf("unknown filter operator %q", f.Op)
+	}
+	cmp, err := compareValues(actual, f.Value)
+	if err != nil {
+		return false, err
+	}
+	switch f.Op {
+	case FilterEq:
+		return cmp == 0, nil
+	case FilterNe:
+		return cmp != 0, nil
+	case FilterGt:
+		return cmp > 0, nil
+	case FilterGe:
+		return cmp >= 0, nil
+	case FilterLt:
+		return cmp < 0, nil
+	default:
+		return cmp <= 0, nil
+	}
+}
+
+// compareValues orders a field value against a filter value: strings
+// case-insensitively, integers numerically and times chronologically.
+func compareValues(actual interface{}, value interface{}) (int, error) {
+	switch a := actual.(type) {
+	case string:
+		if v, ok := value.(string); ok {
+			return strings.Compare(strings.ToLower(a), strings.ToLower(v)), nil
+		}
+	case uint64:
+		if v, ok := toUint64(value); ok {
+			switch {
+			case a < v:
+				return -1, nil
+			case a > v:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case time.Time:
+		if v, ok := value.(time.Time); ok {
+			return a.Compare(v), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T with %T", actual, value)
+}
+
+func toUint64(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint64:
+		return v, true
+	case uint:
+		return uint64(v), true
+	case int:
+		return uint64(v), v >= 0
+	case int64:
+		return uint64(v), v >= 0
+	}
+	return 0, false
+}
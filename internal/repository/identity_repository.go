@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"gorm.io/gorm"
+)
+
+type IdentityRepository interface {
+	CreateIdentity(ctx context.Context, identity *model.UserIdentity) error
+	GetIdentity(ctx context.Context, provider string, subject string) (*model.UserIdentity, error)
+}
+
+type identityRepository struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepository(db *gorm.DB) IdentityRepository {
+	return &identityRepository{
+		db: db,
+	}
+}
+
+func (r *identityRepository) conn(ctx context.Context) *gorm.DB {
+	return conn(ctx, r.db)
+}
+
+func (r *identityRepository) CreateIdentity(ctx context.Context, identity *model.UserIdentity) error {
+	return translate(r.conn(ctx).Create(identity).Error)
+}
+
+// GetIdentity returns ErrNotFound when the provider subject is not linked yet.
+func (r *identityRepository) GetIdentity(ctx context.Context, provider string, subject string) (*model.UserIdentity, error) {
+	var identity model.UserIdentity
+	err := r.conn(ctx).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &identity, nil
+}
@@ -0,0 +1,360 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ListQuery selects one page of a list ordered by a sort field and then by
+// ID. Pages are keyset paginated: After is the position of the last record
+// of the previous page, so records inserted or deleted meanwhile do not shift
+// later pages.
+type ListQuery struct {
+	// Filter restricts the list; nil matches every record
+	Filter *Filter
+	// Sort is the field to order by, "id" when empty
+	Sort string
+	Desc bool
+	// After continues a list from the cursor of the previous page
+	After *Cursor
+	// Limit is the page size; zero or less returns every record
+	Limit int
+	// Total asks for the number of records matching Filter
+	Total bool
+}
+
+// And narrows the query's filter with another condition
+func (q ListQuery) And(f Filter) ListQuery {
+	if q.Filter != nil {
+		f = Filter{Op: FilterAnd, Operands: []Filter{*q.Filter, f}}
+	}
+	q.Filter = &f
+	return q
+}
+
+func (q ListQuery) sortField() string {
+	if q.Sort == "" {
+		return "id"
+	}
+	return q.Sort
+}
+
+// Page is one page of a list. Next is nil on the last page and Total is
+// nil unless the query asked for it.
+type Page[T any] struct {
+	Items []T
+	Next  *Cursor
+	Total *int64
+}
+
+// Cursor is the position of a record in a list: its value of the sort field
+// and its ID, which breaks ties.
+type Cursor struct {
+	Sort  string
+	Desc  bool
+	Value interface{}
+	ID    uint64
+}
+
+// FieldKind is the type of a field's values
+type FieldKind int
+
+const (
+	FieldString FieldKind = iota
+	FieldID
+	FieldTime
+)
+
+// ListFields describes what a list of a resource can be sorted and filtered by
+type ListFields struct {
+	Sortable   []string
+	Filterable map[string]FieldKind
+}
+
+// UserListFields allows filtering users by the name or ID of a role they have
+var UserListFields = ListFields{
+	Sortable: []string{"id", "username", "email", "created_at", "updated_at"},
+	Filterable: map[string]FieldKind{
+		"id":         FieldID,
+		"username":   FieldString,
+		"email":      FieldString,
+		"created_at": FieldTime,
+		"updated_at": FieldTime,
+		"role":       FieldString,
+		"role_id":    FieldID,
+	},
+}
+
+// RoleListFields allows filtering roles by a user who has them and by the
+// name or ID of a permission they grant
+var RoleListFields = ListFields{
+	Sortable: []string{"id", "role_name", "created_at", "updated_at"},
+	Filterable: map[string]FieldKind{
+		"id":            FieldID,
+		"role_name":     FieldString,
+		"created_at":    FieldTime,
+		"updated_at":    FieldTime,
+		"user_id":       FieldID,
+		"permission":    FieldString,
+		"permission_id": FieldID,
+	},
+}
+
+// PermissionListFields allows filtering permissions by the name or ID of a
+// role they are granted to
+var PermissionListFields = ListFields{
+	Sortable: []string{"id", "permission_name", "created_at", "updated_at"},
+	Filterable: map[string]FieldKind{
+		"id":              FieldID,
+		"permission_name": FieldString,
+		"created_at":      FieldTime,
+		"updated_at":      FieldTime,
+		"role":            FieldString,
+		"role_id":         FieldID,
+	},
+}
+
+// CanSort reports whether the list can be ordered by field
+func (f ListFields) CanSort(field string) bool {
+	for _, sortable := range f.Sortable {
+		if sortable == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseValue converts the text form of a value of field to its type. Its
+// errors describe the value, e.g. "must be a non-negative integer".
+func (f ListFields) ParseValue(field string, raw string) (interface{}, error) {
+	kind, ok := f.Filterable[field]
+	if !ok {
+		return nil, fmt.Errorf("field %q cannot be filtered", field)
+	}
+	switch kind {
+	case FieldID:
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a non-negative integer")
+		}
+		return id, nil
+	case FieldTime:
+		t, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be an RFC 3339 date-time")
+		}
+		return t, nil
+	}
+	return raw, nil
+}
+
+// cursorJSON is the wire form of a Cursor
+type cursorJSON struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    uint64 `json:"i"`
+}
+
+// Encode renders the cursor as an opaque URL-safe token
+func (c Cursor) Encode() string {
+	value := fmt.Sprint(c.Value)
+	if t, ok := c.Value.(time.Time); ok {
+		value = t.UTC().Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(cursorJSON{Sort: c.Sort, Desc: c.Desc, Value: value, ID: c.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a token made by Cursor.Encode for a list of fields
+func DecodeCursor(token string, fields ListFields) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	var wire cursorJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	if !fields.CanSort(wire.Sort) {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	value, err := fields.ParseValue(wire.Sort, wire.Value)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	return &Cursor{Sort: wire.Sort, Desc: wire.Desc, Value: value, ID: wire.ID}, nil
+}
+
+// cursorAt is the position of a record given its field values
+func cursorAt(q ListQuery, values map[string]interface{}) *Cursor {
+	id, _ := values["id"].(uint64)
+	return &Cursor{Sort: q.sortField(), Desc: q.Desc, Value: values[q.sortField()], ID: id}
+}
+
+// findPage runs q against query, a GORM query over one model. list is what
+// q may sort by and values gives the field values of a record, from which
+// the next cursor is taken.
+func findPage[T any](query *gorm.DB, q ListQuery, list ListFields, fields filterFields, values func(T) map[string]interface{}) (Page[T], error) {
+	var page Page[T]
+	if q.Filter != nil {
+		clause, args, err := filterClause(*q.Filter, fields)
+		if err != nil {
+			return page, err
+		}
+		query = query.Where(clause, args...)
+	}
+	query = query.Session(&gorm.Session{})
+
+	if q.Total {
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return page, translate(err)
+		}
+		page.Total = &total
+	}
+
+	field := q.sortField()
+	sortColumn, ok := fields.columns[field]
+	if !ok || !list.CanSort(field) {
+		return page, fmt.Errorf("field %q cannot be sorted", field)
+	}
+	idColumn := fields.columns["id"]
+	// Text sorts ignore case, as text filters do
+	if list.Filterable[field] == FieldString {
+		sortColumn = "LOWER(" + sortColumn + ")"
+	}
+
+	if q.After != nil {
+		if q.After.Sort != field || q.After.Desc != q.Desc {
+			return page, fmt.Errorf("the cursor belongs to a different sort order")
+		}
+		comparison := ">"
+		if q.Desc {
+			comparison = "<"
+		}
+		value := q.After.Value
+		if text, ok := value.(string); ok {
+			value = strings.ToLower(text)
+		}
+		if field == "id" {
+			query = query.Where(fmt.Sprintf("%s %s ?", idColumn, comparison), q.After.ID)
+		} else {
+			query = query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", sortColumn, comparison, sortColumn, idColumn, comparison), value, value, q.After.ID)
+		}
+	}
+
+	direction := " ASC"
+	if q.Desc {
+		direction = " DESC"
+	}
+	if field != "id" {
+		query = query.Order(sortColumn + direction)
+	}
+	query = query.Order(idColumn + direction)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit + 1)
+	}
+
+	var items []T
+	if err := query.Find(&items).Error; err != nil {
+		return page, translate(err)
+	}
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+		page.Next = cursorAt(q, values(items[len(items)-1]))
+	}
+	page.Items = items
+	return page, nil
+}
+
+// memoryPage runs q over the rows of an in-memory table, ordering and
+// comparing values the way findPage does in SQL.
+func memoryPage[T any](rows []T, q ListQuery, list ListFields, values func(T) map[string]interface{}) (Page[T], error) {
+	var page Page[T]
+	type row struct {
+		item   T
+		values map[string]interface{}
+	}
+
+	var matches []row
+	for _, item := range rows {
+		fields := values(item)
+		if q.Filter != nil {
+			matched, err := filterMatches(*q.Filter, fields)
+			if err != nil {
+				return page, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		matches = append(matches, row{item, fields})
+	}
+	if q.Total {
+		total := int64(len(matches))
+		page.Total = &total
+	}
+
+	field := q.sortField()
+	if !list.CanSort(field) {
+		return page, fmt.Errorf("field %q cannot be sorted", field)
+	}
+	var sortErr error
+	// order compares two positions in the list's direction
+	order := func(value interface{}, id uint64, other interface{}, otherID uint64) int {
+		cmp, err := compareValues(value, other)
+		if err != nil {
+			sortErr = err
+		}
+		if cmp == 0 {
+			switch {
+			case id < otherID:
+				cmp = -1
+			case id > otherID:
+				cmp = 1
+			}
+		}
+		if q.Desc {
+			cmp = -cmp
+		}
+		return cmp
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return order(matches[i].values[field], matches[i].values["id"].(uint64), matches[j].values[field], matches[j].values["id"].(uint64)) < 0
+	})
+
+	if q.After != nil {
+		if q.After.Sort != field || q.After.Desc != q.Desc {
+			return page, fmt.Errorf("the cursor belongs to a different sort order")
+		}
+		start := len(matches)
+		for i, match := range matches {
+			if order(match.values[field], match.values["id"].(uint64), q.After.Value, q.After.ID) > 0 {
+				start = i
+				break
+			}
+		}
+		matches = matches[start:]
+	}
+	if sortErr != nil {
+		return page, sortErr
+	}
+
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+		page.Next = cursorAt(q, matches[len(matches)-1].values)
+	}
+	page.Items = make([]T, len(matches))
+	for i, match := range matches {
+		page.Items[i] = match.item
+	}
+	return page, nil
+}
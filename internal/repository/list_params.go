@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// A list page holds DefaultPageSize records unless the request asks for up
+// to MaxPageSize
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// pagingParams are the query parameters every list takes besides its filters
+var pagingParams = map[string]bool{
+	"limit":  true,
+	"cursor": true,
+	"sort":   true,
+	"total":  true,
+}
+
+// ParseListParams reads the paging, sorting and filtering parameters of a
+// list request:
+//
+//	limit    page size, 1 to 200, 50 by default
+//	cursor   next_cursor of the previous page
+//	sort     a sortable field, descending when prefixed with -
+//	total    true to count every match
+//
+// Any other parameter filters on a field of the same name, e.g. role=admin,
+// and may be repeated to match any of several values. Time fields also take
+// _after and _before in place of _at, e.g. created_after=2024-01-01T00:00:00Z.
+// Every rejected parameter is reported in one validation error.
+func ParseListParams(params url.Values, fields ListFields) (ListQuery, error) {
+	var v validation.Validator
+	query := ListQuery{Limit: DefaultPageSize}
+
+	if params.Has("limit") {
+		raw := params.Get("limit")
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > MaxPageSize {
+			v.Add("limit", validation.CodeInvalidValue, fmt.Sprintf("must be between 1 and %d", MaxPageSize))
+		}
+		query.Limit = limit
+	}
+
+	if params.Has("sort") {
+		raw := params.Get("sort")
+		field := strings.TrimPrefix(raw, "-")
+		if !fields.CanSort(field) {
+			v.Add("sort", validation.CodeInvalidValue, "must be one of "+strings.Join(fields.Sortable, ", ")+", optionally prefixed with -")
+		}
+		query.Sort = field
+		query.Desc = strings.HasPrefix(raw, "-")
+	}
+
+	if params.Has("cursor") {
+		cursor, err := DecodeCursor(params.Get("cursor"), fields)
+		switch {
+		case err != nil:
+			v.Add("cursor", validation.CodeInvalidFormat, "is not a cursor from this list")
+		case !params.Has("sort"):
+			// The cursor carries the order of the list it came from
+			query.Sort, query.Desc = cursor.Sort, cursor.Desc
+			query.After = cursor
+		case cursor.Sort != query.Sort || cursor.Desc != query.Desc:
+			v.Add("cursor", validation.CodeInvalidValue, "belongs to a list in a different order")
+		default:
+			query.After = cursor
+		}
+	}
+
+	if params.Has("total") {
+		total, err := strconv.ParseBool(params.Get("total"))
+		if err != nil {
+			v.Add("total", validation.CodeInvalidType, "must be true or false")
+		}
+		query.Total = total
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if !pagingParams[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		filter, ok := listFilter(key, params[key], fields, &v)
+		if ok {
+			query = query.And(filter)
+		}
+	}
+
+	return query, v.Err()
+}
+
+// listFilter turns one filter parameter into a condition, recording what is
+// wrong with it in v
+func listFilter(key string, values []string, fields ListFields, v *validation.Validator) (Filter, bool) {
+	field, op := key, FilterEq
+	// created_after and created_before bound created_at
+	if _, ok := fields.Filterable[key]; !ok {
+		if base := strings.TrimSuffix(key, "_after"); base != key && isTimeField(fields, base+"_at") {
+			field, op = base+"_at", FilterGt
+		} else if base := strings.TrimSuffix(key, "_before"); base != key && isTimeField(fields, base+"_at") {
+			field, op = base+"_at", FilterLt
+		}
+	}
+	if _, ok := fields.Filterable[field]; !ok {
+		v.Add(key, validation.CodeInvalidValue, "is not a supported filter")
+		return Filter{}, false
+	}
+
+	any := Filter{Op: FilterOr}
+	for _, raw := range values {
+		value, err := fields.ParseValue(field, raw)
+		if err != nil {
+			v.Add(key, validation.CodeInvalidFormat, err.Error())
+			return Filter{}, false
+		}
+		any.Operands = append(any.Operands, Filter{Op: op, Field: field, Value: value})
+	}
+	if len(any.Operands) == 1 {
+		return any.Operands[0], true
+	}
+	return any, true
+}
+
+func isTimeField(fields ListFields, field string) bool {
+	kind, ok := fields.Filterable[field]
+	return ok && kind == FieldTime
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+)
+
+type memoryIdentityRepository struct {
+	store *MemoryStore
+}
+
+func NewMemoryIdentityRepository(store *MemoryStore) IdentityRepository {
+	return &memoryIdentityRepository{
+		store: store,
+	}
+}
+
+func (r *memoryIdentityRepository) CreateIdentity(ctx context.Context, identity *model.UserIdentity) error {
+	defer r.store.lock(ctx)()
+	tables := &r.store.tables
+	if _, ok := tables.users.get(identity.UserID); !ok {
+		return foreignKeyViolation("fk_user_identities_user")
+	}
+	for _, id := range tables.identities.ids() {
+		existing, _ := tables.identities.get(id)
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return uniqueViolation("idx_user_identities_provider_subject")
+		}
+	}
+
+	if identity.ID == 0 {
+		identity.ID = uint(tables.identities.nextID())
+	}
+	timestamps(&identity.Model, true)
+	row := *identity
+	row.User = model.User{}
+	tables.identities.put(uint64(row.ID), row)
+	return nil
+}
+
+// GetIdentity returns ErrNotFound when the provider subject is not linked yet.
+func (r *memoryIdentityRepository) GetIdentity(ctx context.Context, provider string, subject string) (*model.UserIdentity, error) {
+	defer r.store.lock(ctx)()
+	for _, id := range r.store.tables.identities.ids() {
+		identity, _ := r.store.tables.identities.get(id)
+		if !identity.DeletedAt.Valid && identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,305 @@
+package repository
+
+import (
+	"context"
+	"sort"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+)
+
+// memoryPermissionRepository struct
+type memoryPermissionRepository struct {
+	store *MemoryStore
+}
+
+// NewMemoryPermissionRepository returns a PermissionRepository over the in-memory store
+func NewMemoryPermissionRepository(store *MemoryStore) PermissionRepository {
+	return &memoryPermissionRepository{
+		store: store,
+	}
+}
+
+// save inserts or replaces a permission after checking its unique name, which
+// soft-deleted permissions keep holding. The caller must hold the lock.
+func (repo *memoryPermissionRepository) save(permission *model.Permission, creating bool) error {
+	permissions := &repo.store.tables.permissions
+	if creating && permission.ID != 0 {
+		if _, ok := permissions.get(permission.ID); ok {
+			return uniqueViolation("permissions_pkey")
+		}
+	}
+	for _, id := range permissions.ids() {
+		existing, _ := permissions.get(id)
+		if id != permission.ID && existing.PermissionName == permission.PermissionName {
+			return uniqueViolation("permissions_permission_name_key")
+		}
+	}
+
+	if permission.ID == 0 {
+		permission.ID = permissions.nextID()
+	}
+	timestamps(&permission.Model, creating)
+	row := *permission
+	row.RolePermissions = nil
+	permissions.put(row.ID, row)
+	return nil
+}
+
+// liveRolePermissions returns the IDs of the live assignments matching fn, in order. The caller must hold the lock.
+func (repo *memoryPermissionRepository) liveRolePermissions(match func(model.RolePermission) bool) []uint64 {
+	var ids []uint64
+	for _, id := range repo.store.tables.rolePermissions.ids() {
+		rolePermission, _ := repo.store.tables.rolePermissions.get(id)
+		if !rolePermission.DeletedAt.Valid && match(rolePermission) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// assign links a permission to a role. The caller must hold the lock.
+func (repo *memoryPermissionRepository) assign(roleID, permissionID uint64) error {
+	tables := &repo.store.tables
+	if _, ok := tables.roles.get(roleID); !ok {
+		return foreignKeyViolation("fk_roles_role_permissions")
+	}
+	if _, ok := tables.permissions.get(permissionID); !ok {
+		return foreignKeyViolation("fk_permissions_role_permissions")
+	}
+
+	rolePermission := model.RolePermission{
+		RoleID:       roleID,
+		PermissionID: permissionID,
+	}
+	timestamps(&rolePermission.Model, true)
+	tables.rolePermissions.put(tables.rolePermissions.nextID(), rolePermission)
+	return nil
+}
+
+// remove deletes the oldest live link between a role and a permission, or
+// reports ErrNotFound when there is none. The caller must hold the lock.
+func (repo *memoryPermissionRepository) remove(roleID, permissionID uint64) error {
+	ids := repo.liveRolePermissions(func(rolePermission model.RolePermission) bool {
+		return rolePermission.RoleID == roleID && rolePermission.PermissionID == permissionID
+	})
+	if len(ids) == 0 {
+		return ErrNotFound
+	}
+
+	rolePermission, _ := repo.store.tables.rolePermissions.get(ids[0])
+	softDelete(&rolePermission.Model)
+	repo.store.tables.rolePermissions.put(ids[0], rolePermission)
+	return nil
+}
+
+// GetAllPermissions gets all permissions from the store
+func (repo *memoryPermissionRepository) GetAllPermissions(ctx context.Context) ([]model.Permission, error) {
+	defer repo.store.lock(ctx)()
+	var permissions []model.Permission
+	for _, id := range repo.store.tables.permissions.ids() {
+		permission, _ := repo.store.tables.permissions.get(id)
+		if !permission.DeletedAt.Valid {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
+}
+
+// GetPermissionByID gets a permission by its ID
+func (repo *memoryPermissionRepository) GetPermissionByID(ctx context.Context, permissionID uint64) (model.Permission, error) {
+	defer repo.store.lock(ctx)()
+	permission, ok := repo.store.tables.permissions.get(permissionID)
+	if !ok || permission.DeletedAt.Valid {
+		return model.Permission{}, ErrNotFound
+	}
+	return permission, nil
+}
+
+// CreatePermission creates a new permission
+func (repo *memoryPermissionRepository) CreatePermission(ctx context.Context, permission model.Permission) (model.Permission, error) {
+	defer repo.store.lock(ctx)()
+	if err := repo.save(&permission, true); err != nil {
+		return model.Permission{}, err
+	}
+	return permission, nil
+}
+
+// UpdatePermission updates a permission
+func (repo *memoryPermissionRepository) UpdatePermission(ctx context.Context, permission model.Permission) (model.Permission, error) {
+	defer repo.store.lock(ctx)()
+	_, exists := repo.store.tables.permissions.get(permission.ID)
+	if err := repo.save(&permission, !exists); err != nil {
+		return model.Permission{}, err
+	}
+	return permission, nil
+}
+
+// DeletePermission deletes a permission by its ID
+func (repo *memoryPermissionRepository) DeletePermission(ctx context.Context, permissionID uint64) error {
+	defer repo.store.lock(ctx)()
+	if permission, ok := repo.store.tables.permissions.get(permissionID); ok && !permission.DeletedAt.Valid {
+		softDelete(&permission.Model)
+		repo.store.tables.permissions.put(permissionID, permission)
+	}
+	return nil
+}
+
+// AssignPermissionToRole assigns a permission to a role
+func (repo *memoryPermissionRepository) AssignPermissionToRole(ctx context.Context, roleID, permissionID uint64) error {
+	defer repo.store.lock(ctx)()
+	return repo.assign(roleID, permissionID)
+}
+
+// RemovePermissionFromRole removes a permission from a role
+func (repo *memoryPermissionRepository) RemovePermissionFromRole(ctx context.Context, roleID, permissionID uint64) error {
+	defer repo.store.lock(ctx)()
+	return repo.remove(roleID, permissionID)
+}
+
+// GetPermissionsByRoleID gets the live permissions granted to a role
+func (repo *memoryPermissionRepository) GetPermissionsByRoleID(ctx context.Context, roleID uint64) ([]model.Permission, error) {
+	defer repo.store.lock(ctx)()
+	tables := &repo.store.tables
+	permissions := []model.Permission{}
+	for _, id := range repo.liveRolePermissions(func(rolePermission model.RolePermission) bool { return rolePermission.RoleID == roleID }) {
+		rolePermission, _ := tables.rolePermissions.get(id)
+		if permission, ok := tables.permissions.get(rolePermission.PermissionID); ok && !permission.DeletedAt.Valid {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
+}
+
+// GetPermissionsByRoleIDs gets the live permissions granted to each of the roles
+func (repo *memoryPermissionRepository) GetPermissionsByRoleIDs(ctx context.Context, roleIDs []uint64) (map[uint64][]model.Permission, error) {
+	defer repo.store.lock(ctx)()
+	tables := &repo.store.tables
+	roles := make(map[uint64]bool, len(roleIDs))
+	for _, id := range roleIDs {
+		roles[id] = true
+	}
+	permissions := make(map[uint64][]model.Permission)
+	for _, id := range repo.liveRolePermissions(func(rolePermission model.RolePermission) bool { return roles[rolePermission.RoleID] }) {
+		rolePermission, _ := tables.rolePermissions.get(id)
+		if permission, ok := tables.permissions.get(rolePermission.PermissionID); ok && !permission.DeletedAt.Valid {
+			permissions[rolePermission.RoleID] = append(permissions[rolePermission.RoleID], permission)
+		}
+	}
+	return permissions, nil
+}
+
+// GetRolesByPermissionID gets the live roles a permission is granted to
+func (repo *memoryPermissionRepository) GetRolesByPermissionID(ctx context.Context, permissionID uint64) ([]model.Role, error) {
+	defer repo.store.lock(ctx)()
+	tables := &repo.store.tables
+	roles := []model.Role{}
+	for _, id := range repo.liveRolePermissions(func(rolePermission model.RolePermission) bool { return rolePermission.PermissionID == permissionID }) {
+		rolePermission, _ := tables.rolePermissions.get(id)
+		if role, ok := tables.roles.get(rolePermission.RoleID); ok && !role.DeletedAt.Valid {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+func (repo *memoryPermissionRepository) AddMultiplePermissionsToRole(ctx context.Context, roleID uint64, permissionIDs []uint64) error {
+	defer repo.store.lock(ctx)()
+	return repo.store.atomic(func() error {
+		for _, permissionID := range permissionIDs {
+			if err := repo.assign(roleID, permissionID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (repo *memoryPermissionRepository) RemoveMultiplePermissionsFromRole(ctx context.Context, roleID uint64, permissionIDs []uint64) error {
+	defer repo.store.lock(ctx)()
+	return repo.store.atomic(func() error {
+		for _, permissionID := range permissionIDs {
+			if err := repo.remove(roleID, permissionID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UserHasPermission reports whether any of the user's global roles grants the permission
+func (repo *memoryPermissionRepository) UserHasPermission(ctx context.Context, userID uint64, permissionName string) (bool, error) {
+	defer repo.store.lock(ctx)()
+	tables := &repo.store.tables
+	for _, userRoleID := range tables.userRoles.ids() {
+		userRole, _ := tables.userRoles.get(userRoleID)
+		if userRole.UserID != userID || userRole.Scope != "" || userRole.DeletedAt.Valid {
+			continue
+		}
+		for _, id := range repo.liveRolePermissions(func(rolePermission model.RolePermission) bool { return rolePermission.RoleID == userRole.RoleID }) {
+			rolePermission, _ := tables.rolePermissions.get(id)
+			permission, ok := tables.permissions.get(rolePermission.PermissionID)
+			if ok && !permission.DeletedAt.Valid && permission.PermissionName == permissionName {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// GetGrants returns every way the users hold the named permissions in any
+// scope, ordered by role ID and then by assignment
+func (repo *memoryPermissionRepository) GetGrants(ctx context.Context, userIDs []uint64, permissionNames []string) ([]Grant, error) {
+	defer repo.store.lock(ctx)()
+	tables := &repo.store.tables
+	users := make(map[uint64]bool, len(userIDs))
+	for _, id := range userIDs {
+		users[id] = true
+	}
+	names := make(map[string]bool, len(permissionNames))
+	for _, name := range permissionNames {
+		names[name] = true
+	}
+
+	var grants []Grant
+	for _, userRoleID := range tables.userRoles.ids() {
+		userRole, _ := tables.userRoles.get(userRoleID)
+		if !users[userRole.UserID] || userRole.DeletedAt.Valid {
+			continue
+		}
+		role, ok := tables.roles.get(userRole.RoleID)
+		if !ok || role.DeletedAt.Valid {
+			continue
+		}
+		for _, id := range repo.liveRolePermissions(func(rolePermission model.RolePermission) bool { return rolePermission.RoleID == role.ID }) {
+			rolePermission, _ := tables.rolePermissions.get(id)
+			permission, ok := tables.permissions.get(rolePermission.PermissionID)
+			if ok && !permission.DeletedAt.Valid && names[permission.PermissionName] {
+				grants = append(grants, Grant{
+					UserID:         userRole.UserID,
+					PermissionName: permission.PermissionName,
+					RoleID:         role.ID,
+					RoleName:       role.RoleName,
+					Scope:          userRole.Scope,
+				})
+			}
+		}
+	}
+	sort.SliceStable(grants, func(i, j int) bool { return grants[i].RoleID < grants[j].RoleID })
+	return grants, nil
+}
+
+// QueryPermissions returns the page of permissions the query selects
+func (repo *memoryPermissionRepository) QueryPermissions(ctx context.Context, query ListQuery) (Page[model.Permission], error) {
+	defer repo.store.lock(ctx)()
+	var permissions []model.Permission
+	for _, id := range repo.store.tables.permissions.ids() {
+		permission, _ := repo.store.tables.permissions.get(id)
+		if !permission.DeletedAt.Valid {
+			permissions = append(permissions, permission)
+		}
+	}
+	_, byPermission := repo.store.tables.rolePermissionRelations()
+	return memoryPage(permissions, query, PermissionListFields, func(permission model.Permission) map[string]interface{} {
+		return byPermission.with(permission.ID, permissionValues(permission), "role", "role_id")
+	})
+}
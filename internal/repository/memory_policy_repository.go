@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+)
+
+type memoryPolicyRepository struct {
+	store *MemoryStore
+}
+
+// NewMemoryPolicyRepository returns a PolicyRepository over the in-memory store
+func NewMemoryPolicyRepository(store *MemoryStore) PolicyRepository {
+	return &memoryPolicyRepository{
+		store: store,
+	}
+}
+
+// save inserts or replaces a policy after checking its unique name, which
+// soft-deleted policies keep holding. The caller must hold the lock.
+func (r *memoryPolicyRepository) save(policy *model.Policy, creating bool) error {
+	policies := &r.store.tables.policies
+	if creating && policy.ID != 0 {
+		if _, ok := policies.get(policy.ID); ok {
+			return uniqueViolation("policies_pkey")
+		}
+	}
+	for _, id := range policies.ids() {
+		existing, _ := policies.get(id)
+		if id != policy.ID && existing.Name == policy.Name {
+			return uniqueViolation("policies_name_key")
+		}
+	}
+
+	if policy.ID == 0 {
+		policy.ID = policies.nextID()
+	}
+	timestamps(&policy.Model, creating)
+	policies.put(policy.ID, *policy)
+	return nil
+}
+
+// GetAllPolicies returns the live policies, oldest first
+func (r *memoryPolicyRepository) GetAllPolicies(ctx context.Context) ([]model.Policy, error) {
+	defer r.store.lock(ctx)()
+	policies := []model.Policy{}
+	for _, id := range r.store.tables.policies.ids() {
+		policy, _ := r.store.tables.policies.get(id)
+		if !policy.DeletedAt.Valid {
+			policies = append(policies, policy)
+		}
+	}
+	return policies, nil
+}
+
+func (r *memoryPolicyRepository) GetPolicyByID(ctx context.Context, id uint64) (model.Policy, error) {
+	defer r.store.lock(ctx)()
+	policy, ok := r.store.tables.policies.get(id)
+	if !ok || policy.DeletedAt.Valid {
+		return model.Policy{}, ErrNotFound
+	}
+	return policy, nil
+}
+
+func (r *memoryPolicyRepository) CreatePolicy(ctx context.Context, policy model.Policy) (model.Policy, error) {
+	defer r.store.lock(ctx)()
+	if err := r.save(&policy, true); err != nil {
+		return model.Policy{}, err
+	}
+	return policy, nil
+}
+
+func (r *memoryPolicyRepository) UpdatePolicy(ctx context.Context, policy model.Policy) (model.Policy, error) {
+	defer r.store.lock(ctx)()
+	_, exists := r.store.tables.policies.get(policy.ID)
+	if err := r.save(&policy, !exists); err != nil {
+		return model.Policy{}, err
+	}
+	return policy, nil
+}
+
+// DeletePolicy reports ErrNotFound when there is no live policy with the ID
+func (r *memoryPolicyRepository) DeletePolicy(ctx context.Context, id uint64) error {
+	defer r.store.lock(ctx)()
+	policy, ok := r.store.tables.policies.get(id)
+	if !ok || policy.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	softDelete(&policy.Model)
+	r.store.tables.policies.put(id, policy)
+	return nil
+}
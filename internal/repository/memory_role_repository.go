@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+)
+
+type memoryRoleRepository struct {
+	store *MemoryStore
+}
+
+func NewMemoryRoleRepository(store *MemoryStore) RoleRepository {
+	return &memoryRoleRepository{
+		store: store,
+	}
+}
+
+// save inserts or replaces a role after checking its unique name, which
+// soft-deleted roles keep holding. The caller must hold the lock.
+func (r *memoryRoleRepository) save(role *model.Role, creating bool) error {
+	roles := &r.store.tables.roles
+	if creating && role.ID != 0 {
+		if _, ok := roles.get(role.ID); ok {
+			return uniqueViolation("roles_pkey")
+		}
+	}
+	for _, id := range roles.ids() {
+		existing, _ := roles.get(id)
+		if id != role.ID && existing.RoleName == role.RoleName {
+			return uniqueViolation("roles_role_name_key")
+		}
+	}
+
+	if role.ID == 0 {
+		role.ID = roles.nextID()
+	}
+	timestamps(&role.Model, creating)
+	row := *role
+	row.UserRoles = nil
+	row.RolePermissions = nil
+	roles.put(row.ID, row)
+	return nil
+}
+
+// live returns the roles that are not deleted, ordered by ID. The caller must hold the lock.
+func (r *memoryRoleRepository) live() []model.Role {
+	var roles []model.Role
+	for _, id := range r.store.tables.roles.ids() {
+		role, _ := r.store.tables.roles.get(id)
+		if !role.DeletedAt.Valid {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// preload attaches the role's live user and permission assignments
+func (r *memoryRoleRepository) preload(role model.Role) model.Role {
+	tables := &r.store.tables
+	for _, id := range tables.userRoles.ids() {
+		userRole, _ := tables.userRoles.get(id)
+		if userRole.RoleID == role.ID && !userRole.DeletedAt.Valid {
+			role.UserRoles = append(role.UserRoles, userRole)
+		}
+	}
+	for _, id := range tables.rolePermissions.ids() {
+		rolePermission, _ := tables.rolePermissions.get(id)
+		if rolePermission.RoleID == role.ID && !rolePermission.DeletedAt.Valid {
+			role.RolePermissions = append(role.RolePermissions, rolePermission)
+		}
+	}
+	return role
+}
+
+// liveUserRoles returns the live assignments matching fn, ordered by ID. The caller must hold the lock.
+func (r *memoryRoleRepository) liveUserRoles(match func(model.UserRole) bool) []model.UserRole {
+	var userRoles []model.UserRole
+	for _, id := range r.store.tables.userRoles.ids() {
+		userRole, _ := r.store.tables.userRoles.get(id)
+		if !userRole.DeletedAt.Valid && match(userRole) {
+			userRoles = append(userRoles, userRole)
+		}
+	}
+	return userRoles
+}
+
+func (r *memoryRoleRepository) CreateRole(ctx context.Context, role *model.Role) (*model.Role, error) {
+	defer r.store.lock(ctx)()
+	if err := r.save(role, true); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *memoryRoleRepository) GetRoleByID(ctx context.Context, id uint64) (*model.Role, error) {
+	defer r.store.lock(ctx)()
+	role, ok := r.store.tables.roles.get(id)
+	if !ok || role.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	role = r.preload(role)
+	return &role, nil
+}
+
+// GetRoleByName returns ErrNotFound when no role has the name.
+func (r *memoryRoleRepository) GetRoleByName(ctx context.Context, name string) (*model.Role, error) {
+	defer r.store.lock(ctx)()
+	for _, role := range r.live() {
+		if role.RoleName == name {
+			return &role, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryRoleRepository) UpdateRole(ctx context.Context, role *model.Role) (*model.Role, error) {
+	defer r.store.lock(ctx)()
+	_, exists := r.store.tables.roles.get(role.ID)
+	if err := r.save(role, !exists); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *memoryRoleRepository) DeleteRole(ctx context.Context, id uint64) error {
+	defer r.store.lock(ctx)()
+	if role, ok := r.store.tables.roles.get(id); ok && !role.DeletedAt.Valid {
+		softDelete(&role.Model)
+		r.store.tables.roles.put(id, role)
+	}
+	return nil
+}
+
+func (r *memoryRoleRepository) AddUserRole(ctx context.Context, userID uint64, roleID uint64) error {
+	return r.AddScopedUserRole(ctx, userID, roleID, "")
+}
+
+func (r *memoryRoleRepository) RemoveUserRole(ctx context.Context, userID uint64, roleID uint64) error {
+	return r.RemoveScopedUserRole(ctx, userID, roleID, "")
+}
+
+func (r *memoryRoleRepository) AddScopedUserRole(ctx context.Context, userID uint64, roleID uint64, scope string) error {
+	defer r.store.lock(ctx)()
+	tables := &r.store.tables
+	if _, ok := tables.users.get(userID); !ok {
+		return foreignKeyViolation("fk_users_user_roles")
+	}
+	if _, ok := tables.roles.get(roleID); !ok {
+		return foreignKeyViolation("fk_roles_user_roles")
+	}
+
+	userRole := model.UserRole{
+		UserID: userID,
+		RoleID: roleID,
+		Scope:  scope,
+	}
+	timestamps(&userRole.Model, true)
+	tables.userRoles.put(tables.userRoles.nextID(), userRole)
+	return nil
+}
+
+func (r *memoryRoleRepository) RemoveScopedUserRole(ctx context.Context, userID uint64, roleID uint64, scope string) error {
+	defer r.store.lock(ctx)()
+	for id, userRole := range r.store.tables.userRoles.rows {
+		if userRole.UserID == userID && userRole.RoleID == roleID && userRole.Scope == scope && !userRole.DeletedAt.Valid {
+			softDelete(&userRole.Model)
+			r.store.tables.userRoles.put(id, userRole)
+		}
+	}
+	return nil
+}
+
+func (r *memoryRoleRepository) GetRoleAssignments(ctx context.Context, userID uint64) ([]RoleAssignment, error) {
+	defer r.store.lock(ctx)()
+	assignments := []RoleAssignment{}
+	for _, userRole := range r.liveUserRoles(func(userRole model.UserRole) bool { return userRole.UserID == userID }) {
+		if role, ok := r.store.tables.roles.get(userRole.RoleID); ok && !role.DeletedAt.Valid {
+			assignments = append(assignments, RoleAssignment{Role: role, Scope: userRole.Scope})
+		}
+	}
+	return assignments, nil
+}
+
+func (r *memoryRoleRepository) GetRoleAssignmentsByUserIDs(ctx context.Context, userIDs []uint64) (map[uint64][]RoleAssignment, error) {
+	defer r.store.lock(ctx)()
+	users := make(map[uint64]bool, len(userIDs))
+	for _, id := range userIDs {
+		users[id] = true
+	}
+	assignments := make(map[uint64][]RoleAssignment)
+	for _, userRole := range r.liveUserRoles(func(userRole model.UserRole) bool { return users[userRole.UserID] }) {
+		if role, ok := r.store.tables.roles.get(userRole.RoleID); ok && !role.DeletedAt.Valid {
+			assignments[userRole.UserID] = append(assignments[userRole.UserID], RoleAssignment{Role: role, Scope: userRole.Scope})
+		}
+	}
+	return assignments, nil
+}
+
+func (r *memoryRoleRepository) GetAllRoles(ctx context.Context) ([]model.Role, error) {
+	defer r.store.lock(ctx)()
+	roles := r.live()
+	for i := range roles {
+		roles[i] = r.preload(roles[i])
+	}
+	return roles, nil
+}
+
+func (r *memoryRoleRepository) GetRolesByUserID(ctx context.Context, userID uint64) ([]model.Role, error) {
+	defer r.store.lock(ctx)()
+	var roles []model.Role
+	for _, userRole := range r.liveUserRoles(func(userRole model.UserRole) bool { return userRole.UserID == userID && userRole.Scope == "" }) {
+		if role, ok := r.store.tables.roles.get(userRole.RoleID); ok && !role.DeletedAt.Valid {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+func (r *memoryRoleRepository) UserHasRole(ctx context.Context, userID uint64, roleName string) (bool, error) {
+	defer r.store.lock(ctx)()
+	for _, userRole := range r.liveUserRoles(func(userRole model.UserRole) bool { return userRole.UserID == userID && userRole.Scope == "" }) {
+		if role, ok := r.store.tables.roles.get(userRole.RoleID); ok && !role.DeletedAt.Valid && role.RoleName == roleName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryRoleRepository) GetUsersByRoleID(ctx context.Context, roleID uint64) ([]model.User, error) {
+	defer r.store.lock(ctx)()
+	var users []model.User
+	for _, userRole := range r.liveUserRoles(func(userRole model.UserRole) bool { return userRole.RoleID == roleID && userRole.Scope == "" }) {
+		if user, ok := r.store.tables.users.get(userRole.UserID); ok && !user.DeletedAt.Valid {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// FindRoles returns one page of the roles matching filter, ordered by ID,
+// along with the total number of matches. A nil filter matches every role.
+func (r *memoryRoleRepository) FindRoles(ctx context.Context, filter *Filter, offset int, limit int) ([]model.Role, int64, error) {
+	defer r.store.lock(ctx)()
+	var matches []model.Role
+	for _, role := range r.live() {
+		if filter != nil {
+			matched, err := filterMatches(*filter, roleValues(role))
+			if err != nil {
+				return nil, 0, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		matches = append(matches, role)
+	}
+
+	var roles []model.Role
+	if limit > 0 {
+		start, end := pageBounds(len(matches), offset, limit)
+		roles = matches[start:end]
+	}
+	return roles, int64(len(matches)), nil
+}
+
+// QueryRoles returns the page of roles the query selects
+func (r *memoryRoleRepository) QueryRoles(ctx context.Context, query ListQuery) (Page[model.Role], error) {
+	defer r.store.lock(ctx)()
+	_, byRole := r.store.tables.userRoleRelations()
+	grants, _ := r.store.tables.rolePermissionRelations()
+	return memoryPage(r.live(), query, RoleListFields, func(role model.Role) map[string]interface{} {
+		values := byRole.with(role.ID, roleValues(role), "user_id")
+		return grants.with(role.ID, values, "permission", "permission_id")
+	})
+}
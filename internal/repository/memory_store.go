@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"gorm.io/gorm"
+)
+
+// MemoryStore holds the tables of the in-memory backend. Repositories built
+// on the same store see each other's writes, as if they shared a database.
+// Rows are soft deleted and keep their unique keys, and missing rows and
+// unique violations are reported with ErrNotFound and ErrConflict, the same
+// as the GORM repositories.
+type MemoryStore struct {
+	mu     sync.Mutex
+	tables memoryTables
+}
+
+type memoryTables struct {
+	users           memoryTable[model.User]
+	roles           memoryTable[model.Role]
+	userRoles       memoryTable[model.UserRole]
+	permissions     memoryTable[model.Permission]
+	rolePermissions memoryTable[model.RolePermission]
+	identities      memoryTable[model.UserIdentity]
+	policies        memoryTable[model.Policy]
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tables: memoryTables{
+			users:           newMemoryTable[model.User](),
+			roles:           newMemoryTable[model.Role](),
+			userRoles:       newMemoryTable[model.UserRole](),
+			permissions:     newMemoryTable[model.Permission](),
+			rolePermissions: newMemoryTable[model.RolePermission](),
+			identities:      newMemoryTable[model.UserIdentity](),
+			policies:        newMemoryTable[model.Policy](),
+		},
+	}
+}
+
+func (t memoryTables) clone() memoryTables {
+	return memoryTables{
+		users:           t.users.clone(),
+		roles:           t.roles.clone(),
+		userRoles:       t.userRoles.clone(),
+		permissions:     t.permissions.clone(),
+		rolePermissions: t.rolePermissions.clone(),
+		identities:      t.identities.clone(),
+		policies:        t.policies.clone(),
+	}
+}
+
+// memoryTable is a table of rows keyed by primary key with an auto-increment sequence
+type memoryTable[T any] struct {
+	rows   map[uint64]T
+	lastID uint64
+}
+
+func newMemoryTable[T any]() memoryTable[T] {
+	return memoryTable[T]{rows: map[uint64]T{}}
+}
+
+func (t *memoryTable[T]) nextID() uint64 {
+	t.lastID++
+	return t.lastID
+}
+
+func (t *memoryTable[T]) put(id uint64, row T) {
+	t.rows[id] = row
+	if id > t.lastID {
+		t.lastID = id
+	}
+}
+
+func (t memoryTable[T]) get(id uint64) (T, bool) {
+	row, ok := t.rows[id]
+	return row, ok
+}
+
+// ids returns every primary key, deleted rows included, in ascending order
+func (t memoryTable[T]) ids() []uint64 {
+	ids := make([]uint64, 0, len(t.rows))
+	for id := range t.rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (t memoryTable[T]) clone() memoryTable[T] {
+	rows := make(map[uint64]T, len(t.rows))
+	for id, row := range t.rows {
+		rows[id] = row
+	}
+	return memoryTable[T]{rows: rows, lastID: t.lastID}
+}
+
+// memoryTxKey marks a context that already holds the store lock
+type memoryTxKey struct{}
+
+// lock serialises access to the store. Calls made with the context of a
+// unit of work already hold the lock and must not take it again.
+func (s *MemoryStore) lock(ctx context.Context) func() {
+	if store, ok := ctx.Value(memoryTxKey{}).(*MemoryStore); ok && store == s {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// atomic runs fn against the tables and restores them if fn fails or panics.
+// The caller must hold the lock.
+func (s *MemoryStore) atomic(fn func() error) error {
+	snapshot := s.tables.clone()
+	defer func() {
+		if p := recover(); p != nil {
+			s.tables = snapshot
+			panic(p)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		s.tables = snapshot
+		return err
+	}
+	return nil
+}
+
+type memoryUnitOfWork struct {
+	store *MemoryStore
+}
+
+// NewMemoryUnitOfWork returns a UnitOfWork over the in-memory store. A unit
+// of work holds the store lock until it finishes, so units run one at a time.
+func NewMemoryUnitOfWork(store *MemoryStore) UnitOfWork {
+	return &memoryUnitOfWork{
+		store: store,
+	}
+}
+
+// Do keeps fn's writes when it returns nil and discards them when it returns
+// an error or panics. Nested calls discard only their own writes.
+func (u *memoryUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withCommitHooks(ctx, func(ctx context.Context) error {
+		unlock := u.store.lock(ctx)
+		defer unlock()
+
+		return u.store.atomic(func() error {
+			return fn(context.WithValue(ctx, memoryTxKey{}, u.store))
+		})
+	})
+}
+
+// memoryRelations holds, for each record, the related values a list can be
+// filtered by, e.g. the names and IDs of a user's roles
+type memoryRelations map[uint64]map[string][]interface{}
+
+func (m memoryRelations) add(id uint64, field string, value interface{}) {
+	if m[id] == nil {
+		m[id] = map[string][]interface{}{}
+	}
+	m[id][field] = append(m[id][field], value)
+}
+
+// with adds a record's relation fields to its values, empty when it has none
+func (m memoryRelations) with(id uint64, values map[string]interface{}, fields ...string) map[string]interface{} {
+	for _, field := range fields {
+		values[field] = m[id][field]
+		if values[field] == nil {
+			values[field] = []interface{}{}
+		}
+	}
+	return values
+}
+
+// userRoleRelations indexes the live global assignments between live users
+// and live roles both ways. The caller must hold the lock.
+func (t *memoryTables) userRoleRelations() (byUser memoryRelations, byRole memoryRelations) {
+	byUser, byRole = memoryRelations{}, memoryRelations{}
+	for _, id := range t.userRoles.ids() {
+		userRole, _ := t.userRoles.get(id)
+		user, userOK := t.users.get(userRole.UserID)
+		role, roleOK := t.roles.get(userRole.RoleID)
+		if userRole.DeletedAt.Valid || userRole.Scope != "" || !userOK || user.DeletedAt.Valid || !roleOK || role.DeletedAt.Valid {
+			continue
+		}
+		byUser.add(user.ID, "role", role.RoleName)
+		byUser.add(user.ID, "role_id", role.ID)
+		byRole.add(role.ID, "user_id", user.ID)
+	}
+	return byUser, byRole
+}
+
+// rolePermissionRelations indexes the live grants between live roles and
+// live permissions both ways. The caller must hold the lock.
+func (t *memoryTables) rolePermissionRelations() (byRole memoryRelations, byPermission memoryRelations) {
+	byRole, byPermission = memoryRelations{}, memoryRelations{}
+	for _, id := range t.rolePermissions.ids() {
+		rolePermission, _ := t.rolePermissions.get(id)
+		role, roleOK := t.roles.get(rolePermission.RoleID)
+		permission, permissionOK := t.permissions.get(rolePermission.PermissionID)
+		if rolePermission.DeletedAt.Valid || !roleOK || role.DeletedAt.Valid || !permissionOK || permission.DeletedAt.Valid {
+			continue
+		}
+		byRole.add(role.ID, "permission", permission.PermissionName)
+		byRole.add(role.ID, "permission_id", permission.ID)
+		byPermission.add(permission.ID, "role", role.RoleName)
+		byPermission.add(permission.ID, "role_id", role.ID)
+	}
+	return byRole, byPermission
+}
+
+// timestamps fills in the creation and update times the way GORM does
+func timestamps(m *gorm.Model, creating bool) {
+	now := time.Now()
+	if creating && m.CreatedAt.IsZero() {
+		m.CreatedAt = now
+	}
+	if creating && !m.UpdatedAt.IsZero() {
+		return
+	}
+	m.UpdatedAt = now
+}
+
+func softDelete(m *gorm.Model) {
+	m.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+}
+
+func uniqueViolation(constraint string) error {
+	return fmt.Errorf("%w: unique constraint %q", ErrConflict, constraint)
+}
+
+func foreignKeyViolation(constraint string) error {
+	return fmt.Errorf("insert or update violates foreign key constraint %q", constraint)
+}
+
+// pageBounds returns the bounds of rows [offset, offset+limit) out of n, where a
+// negative limit means no limit, matching GORM's Offset and Limit.
+func pageBounds(n int, offset int, limit int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > n {
+		offset = n
+	}
+	end := n
+	if limit >= 0 && offset+limit < n {
+		end = offset + limit
+	}
+	return offset, end
+}
@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+)
+
+type memoryUserRepository struct {
+	store *MemoryStore
+}
+
+func NewMemoryUserRepository(store *MemoryStore) UserRepository {
+	return &memoryUserRepository{
+		store: store,
+	}
+}
+
+// save inserts or replaces a user after checking its unique keys, which
+// soft-deleted users keep holding. The caller must hold the lock.
+func (r *memoryUserRepository) save(user *model.User, creating bool) error {
+	users := &r.store.tables.users
+	if creating && user.ID != 0 {
+		if _, ok := users.get(user.ID); ok {
+			return uniqueViolation("users_pkey")
+		}
+	}
+	for _, id := range users.ids() {
+		existing, _ := users.get(id)
+		if id == user.ID {
+			continue
+		}
+		if existing.Username == user.Username {
+			return uniqueViolation("users_username_key")
+		}
+		if existing.Email == user.Email {
+			return uniqueViolation("users_email_key")
+		}
+	}
+
+	if user.ID == 0 {
+		user.ID = users.nextID()
+	}
+	if creating {
+		// As with the column default, which GORM writes in place of false
+		user.Active = true
+	}
+	timestamps(&user.Model, creating)
+	row := *user
+	row.UserRoles = nil
+	users.put(row.ID, row)
+	return nil
+}
+
+// live returns the users that are not deleted, ordered by ID. The caller must hold the lock.
+func (r *memoryUserRepository) live() []model.User {
+	var users []model.User
+	for _, id := range r.store.tables.users.ids() {
+		user, _ := r.store.tables.users.get(id)
+		if !user.DeletedAt.Valid {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+func (r *memoryUserRepository) find(match func(model.User) bool) (*model.User, error) {
+	for _, user := range r.live() {
+		if match(user) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUserRepository) CreateUser(ctx context.Context, user *model.User) error {
+	defer r.store.lock(ctx)()
+	return r.save(user, true)
+}
+
+func (r *memoryUserRepository) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	defer r.store.lock(ctx)()
+	return r.find(func(user model.User) bool { return user.Username == username })
+}
+
+func (r *memoryUserRepository) GetUserByID(ctx context.Context, id uint64) (*model.User, error) {
+	defer r.store.lock(ctx)()
+	return r.find(func(user model.User) bool { return user.ID == id })
+}
+
+// GetUsersByIDs returns the live users among ids, ordered by ID
+func (r *memoryUserRepository) GetUsersByIDs(ctx context.Context, ids []uint64) ([]*model.User, error) {
+	defer r.store.lock(ctx)()
+	wanted := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	users := []*model.User{}
+	for _, user := range r.live() {
+		if wanted[user.ID] {
+			user := user
+			users = append(users, &user)
+		}
+	}
+	return users, nil
+}
+
+// GetUserByEmail returns ErrNotFound when no user has the email.
+func (r *memoryUserRepository) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	defer r.store.lock(ctx)()
+	return r.find(func(user model.User) bool { return user.Email == email })
+}
+
+func (r *memoryUserRepository) UpdateUser(ctx context.Context, user *model.User) error {
+	defer r.store.lock(ctx)()
+	_, exists := r.store.tables.users.get(user.ID)
+	return r.save(user, !exists)
+}
+
+func (r *memoryUserRepository) DeleteUser(ctx context.Context, id uint64) error {
+	defer r.store.lock(ctx)()
+	if user, ok := r.store.tables.users.get(id); ok && !user.DeletedAt.Valid {
+		softDelete(&user.Model)
+		r.store.tables.users.put(id, user)
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) ListDeletedUsers(ctx context.Context, since time.Time) ([]*model.User, error) {
+	defer r.store.lock(ctx)()
+	var users []*model.User
+	for _, id := range r.store.tables.users.ids() {
+		user, _ := r.store.tables.users.get(id)
+		if user.DeletedAt.Valid && !user.DeletedAt.Time.Before(since) {
+			users = append(users, &user)
+		}
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) ListDeactivatedUsers(ctx context.Context, since time.Time) ([]*model.User, error) {
+	defer r.store.lock(ctx)()
+	var users []*model.User
+	for _, user := range r.live() {
+		if !user.Active && !user.UpdatedAt.Before(since) {
+			user := user
+			users = append(users, &user)
+		}
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) ListUsers(ctx context.Context, page int, pageSize int) ([]*model.User, error) {
+	defer r.store.lock(ctx)()
+	return pageOfUsers(r.live(), (page-1)*pageSize, pageSize), nil
+}
+
+// SearchUsers matches case-sensitively, like the LIKE query of the GORM repository.
+func (r *memoryUserRepository) SearchUsers(ctx context.Context, query string, page int, pageSize int) ([]*model.User, error) {
+	defer r.store.lock(ctx)()
+	var matches []model.User
+	for _, user := range r.live() {
+		if strings.Contains(user.Username, query) || strings.Contains(user.Email, query) {
+			matches = append(matches, user)
+		}
+	}
+	return pageOfUsers(matches, (page-1)*pageSize, pageSize), nil
+}
+
+// FuzzySearchUsers ranks users the way the Postgres repository does with pg_trgm
+func (r *memoryUserRepository) FuzzySearchUsers(ctx context.Context, query string, page int, pageSize int) ([]UserMatch, error) {
+	defer r.store.lock(ctx)()
+	return rankUsers(r.live(), strings.ToLower(query), (page-1)*pageSize, pageSize), nil
+}
+
+func (r *memoryUserRepository) CountUsers(ctx context.Context) (int64, error) {
+	defer r.store.lock(ctx)()
+	return int64(len(r.live())), nil
+}
+
+// FindUsers returns one page of the users matching filter, ordered by ID,
+// along with the total number of matches. A nil filter matches every user.
+func (r *memoryUserRepository) FindUsers(ctx context.Context, filter *Filter, offset int, limit int) ([]*model.User, int64, error) {
+	defer r.store.lock(ctx)()
+	var matches []model.User
+	for _, user := range r.live() {
+		if filter != nil {
+			matched, err := filterMatches(*filter, userValues(&user))
+			if err != nil {
+				return nil, 0, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		matches = append(matches, user)
+	}
+
+	var users []*model.User
+	if limit > 0 {
+		users = pageOfUsers(matches, offset, limit)
+	}
+	return users, int64(len(matches)), nil
+}
+
+// QueryUsers returns the page of users the query selects
+func (r *memoryUserRepository) QueryUsers(ctx context.Context, query ListQuery) (Page[*model.User], error) {
+	defer r.store.lock(ctx)()
+	byUser, _ := r.store.tables.userRoleRelations()
+	return memoryPage(pageOfUsers(r.live(), 0, -1), query, UserListFields, func(user *model.User) map[string]interface{} {
+		return byUser.with(user.ID, userValues(user), "role", "role_id")
+	})
+}
+
+func pageOfUsers(users []model.User, offset int, limit int) []*model.User {
+	start, end := pageBounds(len(users), offset, limit)
+	result := make([]*model.User, 0, end-start)
+	for i := start; i < end; i++ {
+		user := users[i]
+		result = append(result, &user)
+	}
+	return result
+}
@@ -1,23 +1,37 @@
 package repository
 
 import (
+	"context"
 	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
 	"gorm.io/gorm"
 )
 
 // PermissionRepository interface
 type PermissionRepository interface {
-	GetAllPermissions() ([]model.Permission, error)
-	GetPermissionByID(permissionID uint64) (model.Permission, error)
-	CreatePermission(permission model.Permission) (model.Permission, error)
-	UpdatePermission(permission model.Permission) (model.Permission, error)
-	DeletePermission(permissionID uint64) error
-	AssignPermissionToRole(roleID, permissionID uint64) error
-	RemovePermissionFromRole(roleID, permissionID uint64) error
-	GetPermissionsByRoleID(roleID uint64) ([]model.Permission, error)
-	GetRolesByPermissionID(permissionID uint64) ([]model.Role, error)
-	AddMultiplePermissionsToRole(roleID uint64, permissionIDs []uint64) error
-	RemoveMultiplePermissionsFromRole(roleID uint64, permissionIDs []uint64) error
+	GetAllPermissions(ctx context.Context) ([]model.Permission, error)
+	GetPermissionByID(ctx context.Context, permissionID uint64) (model.Permission, error)
+	CreatePermission(ctx context.Context, permission model.Permission) (model.Permission, error)
+	UpdatePermission(ctx context.Context, permission model.Permission) (model.Permission, error)
+	DeletePermission(ctx context.Context, permissionID uint64) error
+	AssignPermissionToRole(ctx context.Context, roleID, permissionID uint64) error
+	RemovePermissionFromRole(ctx context.Context, roleID, permissionID uint64) error
+	GetPermissionsByRoleID(ctx context.Context, roleID uint64) ([]model.Permission, error)
+	GetPermissionsByRoleIDs(ctx context.Context, roleIDs []uint64) (map[uint64][]model.Permission, error)
+	GetRolesByPermissionID(ctx context.Context, permissionID uint64) ([]model.Role, error)
+	AddMultiplePermissionsToRole(ctx context.Context, roleID uint64, permissionIDs []uint64) error
+	RemoveMultiplePermissionsFromRole(ctx context.Context, roleID uint64, permissionIDs []uint64) error
+	UserHasPermission(ctx context.Context, userID uint64, permissionName string) (bool, error)
+	GetGrants(ctx context.Context, userIDs []uint64, permissionNames []string) ([]Grant, error)
+	QueryPermissions(ctx context.Context, query ListQuery) (Page[model.Permission], error)
+}
+
+// Grant records that a user holds a permission through a role assigned in a scope
+type Grant struct {
+	UserID         uint64
+	PermissionName string
+	RoleID         uint64
+	RoleName       string
+	Scope          string
 }
 
 // permissionRepository struct
@@ -32,155 +46,207 @@ func NewPermissionRepository(db *gorm.DB) PermissionRepository {
 	}
 }
 
+func (repo *permissionRepository) conn(ctx context.Context) *gorm.DB {
+	return conn(ctx, repo.DBConn)
+}
+
 // GetAllPermissions gets all permissions from the database
-func (repo *permissionRepository) GetAllPermissions() ([]model.Permission, error) {
+func (repo *permissionRepository) GetAllPermissions(ctx context.Context) ([]model.Permission, error) {
 	var permissions []model.Permission
-	if err := repo.DBConn.Find(&permissions).Error; err != nil {
-		return nil, err
+	if err := repo.conn(ctx).Find(&permissions).Error; err != nil {
+		return nil, translate(err)
 	}
 	return permissions, nil
 }
 
 // GetPermissionByID gets a permission by its ID
-func (repo *permissionRepository) GetPermissionByID(permissionID uint64) (model.Permission, error) {
+func (repo *permissionRepository) GetPermissionByID(ctx context.Context, permissionID uint64) (model.Permission, error) {
 	var permission model.Permission
-	if err := repo.DBConn.First(&permission, permissionID).Error; err != nil {
-		return model.Permission{}, err
+	if err := repo.conn(ctx).First(&permission, permissionID).Error; err != nil {
+		return model.Permission{}, translate(err)
 	}
 	return permission, nil
 }
 
 // CreatePermission creates a new permission
-func (repo *permissionRepository) CreatePermission(permission model.Permission) (model.Permission, error) {
-	if err := repo.DBConn.Create(&permission).Error; err != nil {
-		return model.Permission{}, err
+func (repo *permissionRepository) CreatePermission(ctx context.Context, permission model.Permission) (model.Permission, error) {
+	if err := repo.conn(ctx).Create(&permission).Error; err != nil {
+		return model.Permission{}, translate(err)
 	}
 	return permission, nil
 }
 
 // UpdatePermission updates a permission
-func (repo *permissionRepository) UpdatePermission(permission model.Permission) (model.Permission, error) {
-	if err := repo.DBConn.Save(&permission).Error; err != nil {
-		return model.Permission{}, err
+func (repo *permissionRepository) UpdatePermission(ctx context.Context, permission model.Permission) (model.Permission, error) {
+	if err := repo.conn(ctx).Save(&permission).Error; err != nil {
+		return model.Permission{}, translate(err)
 	}
 	return permission, nil
 }
 
 // DeletePermission deletes a permission by its ID
-func (repo *permissionRepository) DeletePermission(permissionID uint64) error {
-	if err := repo.DBConn.Delete(&model.Permission{}, permissionID).Error; err != nil {
-		return err
+func (repo *permissionRepository) DeletePermission(ctx context.Context, permissionID uint64) error {
+	if err := repo.conn(ctx).Delete(&model.Permission{}, permissionID).Error; err != nil {
+		return translate(err)
 	}
 	return nil
 }
 
 // AssignPermissionToRole assigns a permission to a role
-func (repo *permissionRepository) AssignPermissionToRole(roleID, permissionID uint64) error {
-	tx := repo.DBConn.Begin()
-
+func (repo *permissionRepository) AssignPermissionToRole(ctx context.Context, roleID, permissionID uint64) error {
 	rolePermission := model.RolePermission{
 		RoleID:       roleID,
 		PermissionID: permissionID,
 	}
 
-	if err := tx.Create(&rolePermission).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	return tx.Commit().Error
+	return translate(repo.conn(ctx).Create(&rolePermission).Error)
 }
 
 // RemovePermissionFromRole removes a permission from a role
-func (repo *permissionRepository) RemovePermissionFromRole(roleID, permissionID uint64) error {
-	tx := repo.DBConn.Begin()
+func (repo *permissionRepository) RemovePermissionFromRole(ctx context.Context, roleID, permissionID uint64) error {
+	return repo.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		rolePermission := model.RolePermission{}
 
-	rolePermission := model.RolePermission{}
+		if err := tx.Where("role_id = ? AND permission_id = ?", roleID, permissionID).First(&rolePermission).Error; err != nil {
+			return translate(err)
+		}
 
-	if err := tx.Where("role_id = ? AND permission_id = ?", roleID, permissionID).First(&rolePermission).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
+		return translate(tx.Delete(&rolePermission).Error)
+	})
+}
 
-	if err := tx.Delete(&rolePermission).Error; err != nil {
-		tx.Rollback()
-		return err
+// GetPermissionsByRoleID gets the live permissions granted to a role, in the
+// order they were granted
+func (repo *permissionRepository) GetPermissionsByRoleID(ctx context.Context, roleID uint64) ([]model.Permission, error) {
+	permissions := []model.Permission{}
+	err := repo.conn(ctx).
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id AND role_permissions.deleted_at IS NULL").
+		Where("role_permissions.role_id = ?", roleID).
+		Order("role_permissions.id").
+		Find(&permissions).Error
+	if err != nil {
+		return nil, translate(err)
 	}
-
-	return tx.Commit().Error
+	return permissions, nil
 }
 
-// GetPermissionsByRoleID gets permissions by role ID
-func (repo *permissionRepository) GetPermissionsByRoleID(roleID uint64) ([]model.Permission, error) {
-	rolePermissions := []model.RolePermission{}
-	if err := repo.DBConn.Where("role_id = ?", roleID).Find(&rolePermissions).Error; err != nil {
-		return nil, err
+// GetPermissionsByRoleIDs gets, in one query, the live permissions granted to
+// each of the roles, in the order they were granted. Roles granted nothing
+// are left out.
+func (repo *permissionRepository) GetPermissionsByRoleIDs(ctx context.Context, roleIDs []uint64) (map[uint64][]model.Permission, error) {
+	permissions := make(map[uint64][]model.Permission)
+	if len(roleIDs) == 0 {
+		return permissions, nil
+	}
+	var rolePermissions []model.RolePermission
+	err := repo.conn(ctx).Preload("Permission").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id AND permissions.deleted_at IS NULL").
+		Where("role_permissions.role_id IN ?", roleIDs).
+		Order("role_permissions.id").
+		Find(&rolePermissions).Error
+	if err != nil {
+		return nil, translate(err)
 	}
-
-	permissions := []model.Permission{}
 	for _, rolePermission := range rolePermissions {
-		permission := model.Permission{}
-		if err := repo.DBConn.First(&permission, rolePermission.PermissionID).Error; err != nil {
-			return nil, err
-		}
-		permissions = append(permissions, permission)
+		permissions[rolePermission.RoleID] = append(permissions[rolePermission.RoleID], rolePermission.Permission)
 	}
-
 	return permissions, nil
 }
 
-func (repo *permissionRepository) GetRolesByPermissionID(permissionID uint64) ([]model.Role, error) {
-	rolePermissions := []model.RolePermission{}
-	if err := repo.DBConn.Where("permission_id = ?", permissionID).Find(&rolePermissions).Error; err != nil {
-		return nil, err
-	}
-
+// GetRolesByPermissionID gets the live roles a permission is granted to, in
+// the order they were granted
+func (repo *permissionRepository) GetRolesByPermissionID(ctx context.Context, permissionID uint64) ([]model.Role, error) {
 	roles := []model.Role{}
-	for _, rolePermission := range rolePermissions {
-		role := model.Role{}
-		if err := repo.DBConn.First(&role, rolePermission.RoleID).Error; err != nil {
-			return nil, err
-		}
-		roles = append(roles, role)
+	err := repo.conn(ctx).
+		Joins("JOIN role_permissions ON role_permissions.role_id = roles.id AND role_permissions.deleted_at IS NULL").
+		Where("role_permissions.permission_id = ?", permissionID).
+		Order("role_permissions.id").
+		Find(&roles).Error
+	if err != nil {
+		return nil, translate(err)
 	}
-
 	return roles, nil
 }
 
-func (repo *permissionRepository) AddMultiplePermissionsToRole(roleID uint64, permissionIDs []uint64) error {
-	tx := repo.DBConn.Begin()
-
-	for _, permissionID := range permissionIDs {
-		rolePermission := model.RolePermission{
-			RoleID:       roleID,
-			PermissionID: permissionID,
+func (repo *permissionRepository) AddMultiplePermissionsToRole(ctx context.Context, roleID uint64, permissionIDs []uint64) error {
+	return repo.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, permissionID := range permissionIDs {
+			rolePermission := model.RolePermission{
+				RoleID:       roleID,
+				PermissionID: permissionID,
+			}
+
+			if err := tx.Create(&rolePermission).Error; err != nil {
+				return translate(err)
+			}
 		}
+		return nil
+	})
+}
 
-		if err := tx.Create(&rolePermission).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
+func (repo *permissionRepository) RemoveMultiplePermissionsFromRole(ctx context.Context, roleID uint64, permissionIDs []uint64) error {
+	return repo.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, permissionID := range permissionIDs {
+			rolePermission := model.RolePermission{}
+
+			if err := tx.Where("role_id = ? AND permission_id = ?", roleID, permissionID).First(&rolePermission).Error; err != nil {
+				return translate(err)
+			}
 
-	return tx.Commit().Error
+			if err := tx.Delete(&rolePermission).Error; err != nil {
+				return translate(err)
+			}
+		}
+		return nil
+	})
 }
 
-func (repo *permissionRepository) RemoveMultiplePermissionsFromRole(roleID uint64, permissionIDs []uint64) error {
-	tx := repo.DBConn.Begin()
+// UserHasPermission reports whether any of the user's global roles grants the permission
+func (repo *permissionRepository) UserHasPermission(ctx context.Context, userID uint64, permissionName string) (bool, error) {
+	var count int64
+	err := repo.conn(ctx).Model(&model.UserRole{}).
+		Joins("JOIN role_permissions ON role_permissions.role_id = user_roles.role_id AND role_permissions.deleted_at IS NULL").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id AND permissions.deleted_at IS NULL").
+		Where("user_roles.user_id = ? AND user_roles.scope = '' AND permissions.permission_name = ?", userID, permissionName).
+		Count(&count).Error
+	if err != nil {
+		return false, translate(err)
+	}
+	return count > 0, nil
+}
 
-	for _, permissionID := range permissionIDs {
-		rolePermission := model.RolePermission{}
+// GetGrants returns, in one query, every way the users hold the named
+// permissions in any scope, ordered by role ID and then by assignment
+func (repo *permissionRepository) GetGrants(ctx context.Context, userIDs []uint64, permissionNames []string) ([]Grant, error) {
+	var grants []Grant
+	if len(userIDs) == 0 || len(permissionNames) == 0 {
+		return grants, nil
+	}
+	err := repo.conn(ctx).Model(&model.UserRole{}).
+		Select("user_roles.user_id, permissions.permission_name, roles.id AS role_id, roles.role_name, user_roles.scope").
+		Joins("JOIN roles ON roles.id = user_roles.role_id AND roles.deleted_at IS NULL").
+		Joins("JOIN role_permissions ON role_permissions.role_id = user_roles.role_id AND role_permissions.deleted_at IS NULL").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id AND permissions.deleted_at IS NULL").
+		Where("user_roles.user_id IN ? AND permissions.permission_name IN ?", userIDs, permissionNames).
+		Order("roles.id, user_roles.id").
+		Scan(&grants).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return grants, nil
+}
 
-		if err := tx.Where("role_id = ? AND permission_id = ?", roleID, permissionID).First(&rolePermission).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
+// QueryPermissions returns the page of permissions the query selects
+func (repo *permissionRepository) QueryPermissions(ctx context.Context, query ListQuery) (Page[model.Permission], error) {
+	return findPage(repo.conn(ctx).Model(&model.Permission{}), query, PermissionListFields, permissionFilterFields, permissionValues)
+}
 
-		if err := tx.Delete(&rolePermission).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
+// permissionValues holds the listable fields of a permission
+func permissionValues(permission model.Permission) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              permission.ID,
+		"permission_name": permission.PermissionName,
+		"created_at":      permission.CreatedAt,
+		"updated_at":      permission.UpdatedAt,
 	}
-
-	return tx.Commit().Error
 }
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"gorm.io/gorm"
+)
+
+// PolicyRepository stores the attribute-based policies
+type PolicyRepository interface {
+	GetAllPolicies(ctx context.Context) ([]model.Policy, error)
+	GetPolicyByID(ctx context.Context, id uint64) (model.Policy, error)
+	CreatePolicy(ctx context.Context, policy model.Policy) (model.Policy, error)
+	UpdatePolicy(ctx context.Context, policy model.Policy) (model.Policy, error)
+	DeletePolicy(ctx context.Context, id uint64) error
+}
+
+type policyRepository struct {
+	db *gorm.DB
+}
+
+func NewPolicyRepository(db *gorm.DB) PolicyRepository {
+	return &policyRepository{
+		db: db,
+	}
+}
+
+func (r *policyRepository) conn(ctx context.Context) *gorm.DB {
+	return conn(ctx, r.db)
+}
+
+// GetAllPolicies returns the live policies, oldest first
+func (r *policyRepository) GetAllPolicies(ctx context.Context) ([]model.Policy, error) {
+	policies := []model.Policy{}
+	if err := r.conn(ctx).Order("id").Find(&policies).Error; err != nil {
+		return nil, translate(err)
+	}
+	return policies, nil
+}
+
+func (r *policyRepository) GetPolicyByID(ctx context.Context, id uint64) (model.Policy, error) {
+	var policy model.Policy
+	if err := r.conn(ctx).First(&policy, id).Error; err != nil {
+		return model.Policy{}, translate(err)
+	}
+	return policy, nil
+}
+
+func (r *policyRepository) CreatePolicy(ctx context.Context, policy model.Policy) (model.Policy, error) {
+	if err := r.conn(ctx).Create(&policy).Error; err != nil {
+		return model.Policy{}, translate(err)
+	}
+	return policy, nil
+}
+
+func (r *policyRepository) UpdatePolicy(ctx context.Context, policy model.Policy) (model.Policy, error) {
+	if err := r.conn(ctx).Save(&policy).Error; err != nil {
+		return model.Policy{}, translate(err)
+	}
+	return policy, nil
+}
+
+// DeletePolicy reports ErrNotFound when there is no live policy with the ID
+func (r *policyRepository) DeletePolicy(ctx context.Context, id uint64) error {
+	result := r.conn(ctx).Delete(&model.Policy{}, id)
+	if result.Error != nil {
+		return translate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
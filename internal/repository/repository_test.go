@@ -0,0 +1,206 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository/repotest"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/database"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/database/migration"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	err := repotest.Run(context.Background(), func() (*repotest.Backend, error) {
+		store := repository.NewMemoryStore()
+		return &repotest.Backend{
+			Users:       repository.NewMemoryUserRepository(store),
+			Roles:       repository.NewMemoryRoleRepository(store),
+			Permissions: repository.NewMemoryPermissionRepository(store),
+			Identities:  repository.NewMemoryIdentityRepository(store),
+			Policies:    repository.NewMemoryPolicyRepository(store),
+			UnitOfWork:  repository.NewMemoryUnitOfWork(store),
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	err := repotest.Run(context.Background(), func() (*repotest.Backend, error) {
+		db, err := openSQLite()
+		if err != nil {
+			return nil, err
+		}
+		return gormBackend(db), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestPostgresConformance runs in the database TEST_POSTGRES_DSN names, which
+// it may freely change: each backend gets a fresh repotest schema, migrated
+// the way migrate up does.
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	ctx := context.Background()
+	err := repotest.Run(ctx, func() (*repotest.Backend, error) {
+		db, err := openPostgres(ctx, dsn)
+		if err != nil {
+			return nil, err
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(func() { sqlDB.Close() })
+		return gormBackend(db), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openPostgres recreates the repotest schema and applies the migrations to
+// it. One connection keeps the search path pointing at the schema.
+func openPostgres(ctx context.Context, dsn string) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true, Logger: logger.Discard})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"DROP SCHEMA IF EXISTS repotest CASCADE",
+		"CREATE SCHEMA repotest",
+		"SET search_path TO repotest, public",
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			sqlDB.Close()
+			return nil, err
+		}
+	}
+
+	migrations, err := migration.Embedded()
+	if err == nil {
+		_, err = migration.NewRunner(sqlDB, migrations).Up(ctx)
+	}
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openSQLite opens an empty in-memory database the way the sqlite driver
+// opens its file: with the same connection options, error translation and
+// single connection, which also keeps the database alive between queries
+func openSQLite() (*gorm.DB, error) {
+	cfg := config.Database{Driver: config.DriverSQLite, Path: ":memory:"}
+	db, err := gorm.Open(database.SQLiteDialector(cfg.SQLiteDSN()), &gorm.Config{TranslateError: true, Logger: logger.Discard})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(database.SQLiteModels...); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func gormBackend(db *gorm.DB) *repotest.Backend {
+	return &repotest.Backend{
+		Users:       repository.NewUserRepository(db),
+		Roles:       repository.NewRoleRepository(db),
+		Permissions: repository.NewPermissionRepository(db),
+		Identities:  repository.NewIdentityRepository(db),
+		Policies:    repository.NewPolicyRepository(db),
+		UnitOfWork:  repository.NewUnitOfWork(db),
+	}
+}
+
+func TestMissingRowsAreNotFound(t *testing.T) {
+	backends := []struct {
+		name string
+		open func() (*repotest.Backend, error)
+	}{
+		{"memory", func() (*repotest.Backend, error) {
+			store := repository.NewMemoryStore()
+			return &repotest.Backend{
+				Users:      repository.NewMemoryUserRepository(store),
+				Roles:      repository.NewMemoryRoleRepository(store),
+				Identities: repository.NewMemoryIdentityRepository(store),
+			}, nil
+		}},
+		{"sqlite", func() (*repotest.Backend, error) {
+			db, err := openSQLite()
+			if err != nil {
+				return nil, err
+			}
+			return gormBackend(db), nil
+		}},
+	}
+	lookups := []struct {
+		name   string
+		lookup func(ctx context.Context, b *repotest.Backend) error
+	}{
+		{"user by ID", func(ctx context.Context, b *repotest.Backend) error {
+			_, err := b.Users.GetUserByID(ctx, 404)
+			return err
+		}},
+		{"user by username", func(ctx context.Context, b *repotest.Backend) error {
+			_, err := b.Users.GetUserByUsername(ctx, "nobody")
+			return err
+		}},
+		{"user by email", func(ctx context.Context, b *repotest.Backend) error {
+			_, err := b.Users.GetUserByEmail(ctx, "nobody@example.com")
+			return err
+		}},
+		{"role by ID", func(ctx context.Context, b *repotest.Backend) error {
+			_, err := b.Roles.GetRoleByID(ctx, 404)
+			return err
+		}},
+		{"role by name", func(ctx context.Context, b *repotest.Backend) error {
+			_, err := b.Roles.GetRoleByName(ctx, "nobody")
+			return err
+		}},
+		{"identity", func(ctx context.Context, b *repotest.Backend) error {
+			_, err := b.Identities.GetIdentity(ctx, "google", "404")
+			return err
+		}},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range lookups {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				b, err := backend.open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := tt.lookup(context.Background(), b); !errors.Is(err, repository.ErrNotFound) {
+					t.Errorf("got %v, want ErrNotFound", err)
+				}
+			})
+		}
+	}
+}
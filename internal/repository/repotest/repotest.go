@@ -0,0 +1,1049 @@
+// Package repotest is the conformance suite every repository backend must
+// pass, so the GORM, SQLite and in-memory implementations agree on
+// uniqueness, not-found handling, soft deletes and transactions.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+)
+
+// Backend is one set of repositories sharing the same storage
+type Backend struct {
+	Users       repository.UserRepository
+	Roles       repository.RoleRepository
+	Permissions repository.PermissionRepository
+	Identities  repository.IdentityRepository
+	Policies    repository.PolicyRepository
+	UnitOfWork  repository.UnitOfWork
+}
+
+type check struct {
+	name string
+	run  func(ctx context.Context, b *Backend) error
+}
+
+var checks = []check{
+	{"users", checkUsers},
+	{"user not found", checkUserNotFound},
+	{"user soft delete", checkUserSoftDelete},
+	{"user deactivation", checkUserDeactivation},
+	{"user listing", checkUserListing},
+	{"keyset listing", checkKeysetListing},
+	{"fuzzy search", checkFuzzySearch},
+	{"fuzzy search escapes", checkFuzzySearchEscapes},
+	{"roles", checkRoles},
+	{"user roles", checkUserRoles},
+	{"permissions", checkPermissions},
+	{"role permissions", checkRolePermissions},
+	{"grants", checkGrants},
+	{"scoped roles", checkScopedRoles},
+	{"identities", checkIdentities},
+	{"policies", checkPolicies},
+	{"unit of work", checkUnitOfWork},
+	{"commit hooks", checkCommitHooks},
+}
+
+// Run runs every check against a fresh, empty backend from newBackend and
+// returns all failures joined, or nil when the backend conforms.
+func Run(ctx context.Context, newBackend func() (*Backend, error)) error {
+	var failures []error
+	for _, c := range checks {
+		backend, err := newBackend()
+		if err != nil {
+			return fmt.Errorf("create backend: %w", err)
+		}
+		if err := c.run(ctx, backend); err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return errors.Join(failures...)
+}
+
+func expect(ok bool, format string, args ...interface{}) error {
+	if ok {
+		return nil
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// expectDuplicate checks that a unique key violation is reported as ErrConflict
+func expectDuplicate(err error, what string) error {
+	return expect(errors.Is(err, repository.ErrConflict), "%s returned %v, want ErrConflict", what, err)
+}
+
+func first(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createUser(ctx context.Context, b *Backend, username string) (*model.User, error) {
+	user := &model.User{Username: username, Email: username + "@example.com", PasswordHash: "hash"}
+	if err := b.Users.CreateUser(ctx, user); err != nil {
+		return nil, fmt.Errorf("create user %s: %w", username, err)
+	}
+	return user, nil
+}
+
+func createRole(ctx context.Context, b *Backend, name string) (*model.Role, error) {
+	role, err := b.Roles.CreateRole(ctx, &model.Role{RoleName: name})
+	if err != nil {
+		return nil, fmt.Errorf("create role %s: %w", name, err)
+	}
+	return role, nil
+}
+
+func createPermission(ctx context.Context, b *Backend, name string) (model.Permission, error) {
+	permission, err := b.Permissions.CreatePermission(ctx, model.Permission{PermissionName: name})
+	if err != nil {
+		return model.Permission{}, fmt.Errorf("create permission %s: %w", name, err)
+	}
+	return permission, nil
+}
+
+func checkUsers(ctx context.Context, b *Backend) error {
+	alice, err := createUser(ctx, b, "alice")
+	if err != nil {
+		return err
+	}
+	if alice.ID == 0 || alice.CreatedAt.IsZero() {
+		return errors.New("create did not assign an ID and timestamps")
+	}
+
+	byID, err := b.Users.GetUserByID(ctx, alice.ID)
+	if err != nil {
+		return fmt.Errorf("get by ID: %w", err)
+	}
+	byName, err := b.Users.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		return fmt.Errorf("get by username: %w", err)
+	}
+	byEmail, err := b.Users.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil {
+		return fmt.Errorf("get by email: %w", err)
+	}
+	if err := first(
+		expect(byID.Username == "alice", "get by ID returned %q", byID.Username),
+		expect(byName.ID == alice.ID, "get by username returned ID %d", byName.ID),
+		expect(byEmail != nil && byEmail.ID == alice.ID, "get by email did not return alice"),
+	); err != nil {
+		return err
+	}
+
+	duplicateName := &model.User{Username: "alice", Email: "other@example.com", PasswordHash: "hash"}
+	duplicateEmail := &model.User{Username: "other", Email: "alice@example.com", PasswordHash: "hash"}
+	if err := first(
+		expectDuplicate(b.Users.CreateUser(ctx, duplicateName), "duplicate username"),
+		expectDuplicate(b.Users.CreateUser(ctx, duplicateEmail), "duplicate email"),
+	); err != nil {
+		return err
+	}
+
+	bob, err := createUser(ctx, b, "bob")
+	if err != nil {
+		return err
+	}
+	byID.Email = "alice@example.org"
+	if err := b.Users.UpdateUser(ctx, byID); err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	updated, err := b.Users.GetUserByID(ctx, alice.ID)
+	if err != nil {
+		return fmt.Errorf("get after update: %w", err)
+	}
+	bob.Username = "alice"
+	return first(
+		expect(updated.Email == "alice@example.org", "update was not saved, email is %q", updated.Email),
+		expectDuplicate(b.Users.UpdateUser(ctx, bob), "update to a taken username"),
+	)
+}
+
+func checkUserNotFound(ctx context.Context, b *Backend) error {
+	_, byID := b.Users.GetUserByID(ctx, 404)
+	_, byName := b.Users.GetUserByUsername(ctx, "nobody")
+	_, byEmail := b.Users.GetUserByEmail(ctx, "nobody@example.com")
+	return first(
+		expect(errors.Is(byID, repository.ErrNotFound), "get by ID returned %v, want ErrNotFound", byID),
+		expect(errors.Is(byName, repository.ErrNotFound), "get by username returned %v, want ErrNotFound", byName),
+		expect(errors.Is(byEmail, repository.ErrNotFound), "get by email returned %v, want ErrNotFound", byEmail),
+		expect(b.Users.DeleteUser(ctx, 404) == nil, "deleting a missing user failed"),
+	)
+}
+
+func checkUserSoftDelete(ctx context.Context, b *Backend) error {
+	alice, err := createUser(ctx, b, "alice")
+	if err != nil {
+		return err
+	}
+	bob, err := createUser(ctx, b, "bob")
+	if err != nil {
+		return err
+	}
+	before := time.Now().Add(-time.Minute)
+	if err := b.Users.DeleteUser(ctx, alice.ID); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	deleted, err := b.Users.ListDeletedUsers(ctx, before)
+	if err != nil {
+		return fmt.Errorf("list deleted: %w", err)
+	}
+	later, err := b.Users.ListDeletedUsers(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		return fmt.Errorf("list deleted later: %w", err)
+	}
+	_, getErr := b.Users.GetUserByID(ctx, alice.ID)
+	live, err := b.Users.GetUsersByIDs(ctx, []uint64{bob.ID, alice.ID, bob.ID + 100})
+	if err != nil {
+		return fmt.Errorf("get by ids: %w", err)
+	}
+	count, err := b.Users.CountUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("count: %w", err)
+	}
+	again := &model.User{Username: "alice", Email: "alice2@example.com", PasswordHash: "hash"}
+	return first(
+		expect(errors.Is(getErr, repository.ErrNotFound), "deleted user is still found: %v", getErr),
+		expect(len(live) == 1 && live[0].ID == bob.ID, "users by ID are %v, want only bob", live),
+		expect(count == 1, "count includes the deleted user: %d", count),
+		expect(len(deleted) == 1 && deleted[0].ID == alice.ID && deleted[0].DeletedAt.Valid, "deleted users are %v, want alice", deleted),
+		expect(len(later) == 0, "users deleted in the future are %v, want none", later),
+		expectDuplicate(b.Users.CreateUser(ctx, again), "reusing a deleted user's username"),
+	)
+}
+
+// checkUserDeactivation checks that users are created active, even when
+// asked otherwise, and that an update can deactivate and reactivate one
+func checkUserDeactivation(ctx context.Context, b *Backend) error {
+	alice := &model.User{Username: "alice", Email: "alice@example.com", PasswordHash: "hash"}
+	if err := b.Users.CreateUser(ctx, alice); err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	created, err := b.Users.GetUserByID(ctx, alice.ID)
+	if err != nil {
+		return fmt.Errorf("get after create: %w", err)
+	}
+	if err := expect(alice.Active && created.Active, "a created user is not active"); err != nil {
+		return err
+	}
+
+	for _, active := range []bool{false, true} {
+		created.Active = active
+		if err := b.Users.UpdateUser(ctx, created); err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+		updated, err := b.Users.GetUserByID(ctx, alice.ID)
+		if err != nil {
+			return fmt.Errorf("get after update: %w", err)
+		}
+		deactivated, err := b.Users.ListDeactivatedUsers(ctx, time.Now().Add(-time.Minute))
+		if err != nil {
+			return fmt.Errorf("list deactivated: %w", err)
+		}
+		if err := first(
+			expect(updated.Active == active, "setting active to %t was not saved", active),
+			expect((len(deactivated) == 1 && deactivated[0].ID == alice.ID) == !active, "with active %t deactivated users are %v", active, deactivated),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkUserListing(ctx context.Context, b *Backend) error {
+	for _, name := range []string{"alice", "bob", "carol", "alina"} {
+		if _, err := createUser(ctx, b, name); err != nil {
+			return err
+		}
+	}
+
+	listed, err := b.Users.ListUsers(ctx, 2, 3)
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	found, err := b.Users.SearchUsers(ctx, "ali", 1, 10)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	upper, err := b.Users.SearchUsers(ctx, "ALI", 1, 10)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	if err := first(
+		expect(len(listed) == 1, "page 2 of 3 returned %d users, want 1", len(listed)),
+		expect(len(found) == 2, "search for ali returned %d users, want 2", len(found)),
+		expect(len(upper) == 0, "search is not case-sensitive, ALI returned %d users", len(upper)),
+	); err != nil {
+		return err
+	}
+
+	filter := &repository.Filter{Op: repository.FilterOr, Operands: []repository.Filter{
+		{Op: repository.FilterStartsWith, Field: "username", Value: "AL"},
+		{Op: repository.FilterEq, Field: "email", Value: "Carol@Example.com"},
+	}}
+	users, total, err := b.Users.FindUsers(ctx, filter, 1, 10)
+	if err != nil {
+		return fmt.Errorf("find: %w", err)
+	}
+	if err := first(
+		expect(total == 3, "find matched %d users, want 3", total),
+		expect(len(users) == 2 && users[0].Username == "carol" && users[1].Username == "alina",
+			"find from offset 1 did not return carol and alina in ID order"),
+	); err != nil {
+		return err
+	}
+
+	users, total, err = b.Users.FindUsers(ctx, nil, 0, 0)
+	if err != nil {
+		return fmt.Errorf("find: %w", err)
+	}
+	_, _, badField := b.Users.FindUsers(ctx, &repository.Filter{Op: repository.FilterEq, Field: "password_hash", Value: "x"}, 0, 10)
+	return first(
+		expect(total == 4 && len(users) == 0, "find with no limit returned %d users of %d, want 0 of 4", len(users), total),
+		expect(badField != nil, "filtering on an unknown field was accepted"),
+	)
+}
+
+// usernames lists the usernames of a page in order
+func usernames(page repository.Page[*model.User]) []string {
+	names := make([]string, len(page.Items))
+	for i, user := range page.Items {
+		names[i] = user.Username
+	}
+	return names
+}
+
+func checkKeysetListing(ctx context.Context, b *Backend) error {
+	users := map[string]*model.User{}
+	for _, name := range []string{"dave", "Carol", "alina", "bob"} {
+		user, err := createUser(ctx, b, name)
+		if err != nil {
+			return err
+		}
+		users[name] = user
+	}
+	admin, err := createRole(ctx, b, "admin")
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"alina", "dave"} {
+		if err := b.Roles.AddUserRole(ctx, users[name].ID, admin.ID); err != nil {
+			return fmt.Errorf("add user role: %w", err)
+		}
+	}
+	read, err := createPermission(ctx, b, "users:read")
+	if err != nil {
+		return err
+	}
+	if err := b.Permissions.AssignPermissionToRole(ctx, admin.ID, read.ID); err != nil {
+		return fmt.Errorf("assign permission: %w", err)
+	}
+
+	byName := repository.ListQuery{Sort: "username", Limit: 2, Total: true}
+	firstPage, err := b.Users.QueryUsers(ctx, byName)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	if err := expect(fmt.Sprint(usernames(firstPage)) == "[alina bob]" && firstPage.Next != nil && firstPage.Total != nil && *firstPage.Total == 4,
+		"first page by username was %v, want [alina bob] of 4 with a next cursor", usernames(firstPage)); err != nil {
+		return err
+	}
+
+	// Deleting a listed user must not shift the next page
+	if err := b.Users.DeleteUser(ctx, users["alina"].ID); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	byName.After = firstPage.Next
+	secondPage, err := b.Users.QueryUsers(ctx, byName)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	if err := expect(fmt.Sprint(usernames(secondPage)) == "[Carol dave]" && secondPage.Next == nil,
+		"second page by username was %v, want [Carol dave] and no next cursor", usernames(secondPage)); err != nil {
+		return err
+	}
+
+	decoded, err := repository.DecodeCursor(firstPage.Next.Encode(), repository.UserListFields)
+	if err != nil {
+		return fmt.Errorf("decode cursor: %w", err)
+	}
+	newest, err := b.Users.QueryUsers(ctx, repository.ListQuery{Sort: "created_at", Desc: true, Limit: 1})
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	admins, err := b.Users.QueryUsers(ctx, repository.ListQuery{}.And(repository.Filter{Op: repository.FilterEq, Field: "role", Value: "ADMIN"}))
+	if err != nil {
+		return fmt.Errorf("query by role: %w", err)
+	}
+	roles, err := b.Roles.QueryRoles(ctx, repository.ListQuery{}.And(repository.Filter{Op: repository.FilterEq, Field: "user_id", Value: users["dave"].ID}))
+	if err != nil {
+		return fmt.Errorf("query roles: %w", err)
+	}
+	permissions, err := b.Permissions.QueryPermissions(ctx, repository.ListQuery{}.And(repository.Filter{Op: repository.FilterEq, Field: "role_id", Value: admin.ID}))
+	if err != nil {
+		return fmt.Errorf("query permissions: %w", err)
+	}
+	_, badSort := b.Users.QueryUsers(ctx, repository.ListQuery{Sort: "password_hash"})
+	return first(
+		expect(decoded.Sort == "username" && decoded.Value == "bob" && decoded.ID == users["bob"].ID, "cursor did not survive encoding: %+v", decoded),
+		expect(fmt.Sprint(usernames(newest)) == "[bob]", "newest user was %v, want [bob]", usernames(newest)),
+		expect(fmt.Sprint(usernames(admins)) == "[dave]", "users with role admin were %v, want [dave]", usernames(admins)),
+		expect(len(roles.Items) == 1 && roles.Items[0].ID == admin.ID, "dave's roles were %d, want admin", len(roles.Items)),
+		expect(len(permissions.Items) == 1 && permissions.Items[0].ID == read.ID, "admin's permissions were %d, want users:read", len(permissions.Items)),
+		expect(badSort != nil, "sorting on an unknown field was accepted"),
+	)
+}
+
+func checkFuzzySearch(ctx context.Context, b *Backend) error {
+	for _, name := range []string{"malice", "Alicia", "bob", "alice", "alyce"} {
+		if _, err := createUser(ctx, b, name); err != nil {
+			return err
+		}
+	}
+
+	prefix, err := b.Users.FuzzySearchUsers(ctx, "ALI", 1, 10)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	if err := first(
+		expect(len(prefix) == 3, "search for ALI returned %d users, want 3", len(prefix)),
+		expect(len(prefix) == 3 && prefix[0].User.Username == "alice" && prefix[1].User.Username == "Alicia" && prefix[2].User.Username == "malice",
+			"search for ALI did not rank alice, Alicia and malice in that order"),
+		expect(len(prefix) == 3 && fmt.Sprint(prefix[2].Highlights["username"]) == "[{1 4}]",
+			"malice highlights %v, want [{1 4}]", prefix[2].Highlights["username"]),
+	); err != nil {
+		return err
+	}
+
+	similar, err := b.Users.FuzzySearchUsers(ctx, "alice", 1, 10)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	var alyce *repository.UserMatch
+	for i := range similar {
+		if similar[i].User.Username == "alyce" {
+			alyce = &similar[i]
+		}
+	}
+	page, err := b.Users.FuzzySearchUsers(ctx, "alice", 2, 1)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	return first(
+		expect(len(similar) == 4 && similar[0].User.Username == "alice", "search for alice returned %d users, want 4 with alice first", len(similar)),
+		expect(alyce != nil, "search for alice missed alyce"),
+		expect(alyce == nil || fmt.Sprint(alyce.Highlights["username"]) == "[{0 2} {3 5}]",
+			"alyce highlights %v, want [{0 2} {3 5}]", alyce.Highlights),
+		expect(len(page) == 1 && page[0].User.Username == similar[1].User.Username, "page 2 of 1 is not the second best match"),
+	)
+}
+
+// checkFuzzySearchEscapes checks that LIKE wildcards in a query match only
+// themselves: were _ a wildcard, axbc would start with a_b and be boosted
+func checkFuzzySearchEscapes(ctx context.Context, b *Backend) error {
+	for _, name := range []string{"axbc", "a_bc"} {
+		if _, err := createUser(ctx, b, name); err != nil {
+			return err
+		}
+	}
+
+	matches, err := b.Users.FuzzySearchUsers(ctx, "a_b", 1, 10)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	if err := expect(len(matches) > 0 && matches[0].User.Username == "a_bc", "search for a_b did not rank a_bc first"); err != nil {
+		return err
+	}
+	for _, match := range matches {
+		if match.User.Username == "axbc" && match.Score >= 1 {
+			return fmt.Errorf("search for a_b scored axbc %v as if it started with a_b", match.Score)
+		}
+	}
+	return nil
+}
+
+func checkRoles(ctx context.Context, b *Backend) error {
+	admin, err := createRole(ctx, b, "admin")
+	if err != nil {
+		return err
+	}
+	if _, err := createRole(ctx, b, "viewer"); err != nil {
+		return err
+	}
+
+	byID, err := b.Roles.GetRoleByID(ctx, admin.ID)
+	if err != nil {
+		return fmt.Errorf("get by ID: %w", err)
+	}
+	byName, err := b.Roles.GetRoleByName(ctx, "admin")
+	if err != nil {
+		return fmt.Errorf("get by name: %w", err)
+	}
+	_, missing := b.Roles.GetRoleByName(ctx, "nobody")
+	_, notFound := b.Roles.GetRoleByID(ctx, 404)
+	_, duplicate := b.Roles.CreateRole(ctx, &model.Role{RoleName: "admin"})
+	if err := first(
+		expect(byID.RoleName == "admin", "get by ID returned %q", byID.RoleName),
+		expect(byName != nil && byName.ID == admin.ID, "get by name did not return admin"),
+		expect(errors.Is(missing, repository.ErrNotFound), "get by missing name returned %v, want ErrNotFound", missing),
+		expect(errors.Is(notFound, repository.ErrNotFound), "get by missing ID returned %v, want ErrNotFound", notFound),
+		expectDuplicate(duplicate, "duplicate role name"),
+	); err != nil {
+		return err
+	}
+
+	roles, total, err := b.Roles.FindRoles(ctx, &repository.Filter{Op: repository.FilterContains, Field: "role_name", Value: "VIEW"}, 0, 10)
+	if err != nil {
+		return fmt.Errorf("find: %w", err)
+	}
+	if err := expect(total == 1 && len(roles) == 1 && roles[0].RoleName == "viewer", "find by role name did not return viewer"); err != nil {
+		return err
+	}
+
+	if err := b.Roles.DeleteRole(ctx, admin.ID); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	_, deleted := b.Roles.GetRoleByName(ctx, "admin")
+	all, err := b.Roles.GetAllRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("get all: %w", err)
+	}
+	_, reused := b.Roles.CreateRole(ctx, &model.Role{RoleName: "admin"})
+	return first(
+		expect(errors.Is(deleted, repository.ErrNotFound), "get by name after delete returned %v, want ErrNotFound", deleted),
+		expect(len(all) == 1, "get all returned %d roles, want 1", len(all)),
+		expectDuplicate(reused, "reusing a deleted role's name"),
+	)
+}
+
+func checkUserRoles(ctx context.Context, b *Backend) error {
+	alice, err := createUser(ctx, b, "alice")
+	if err != nil {
+		return err
+	}
+	admin, err := createRole(ctx, b, "admin")
+	if err != nil {
+		return err
+	}
+	viewer, err := createRole(ctx, b, "viewer")
+	if err != nil {
+		return err
+	}
+	for _, role := range []*model.Role{admin, viewer} {
+		if err := b.Roles.AddUserRole(ctx, alice.ID, role.ID); err != nil {
+			return fmt.Errorf("add role %s: %w", role.RoleName, err)
+		}
+	}
+
+	roles, err := b.Roles.GetRolesByUserID(ctx, alice.ID)
+	if err != nil {
+		return fmt.Errorf("roles of user: %w", err)
+	}
+	users, err := b.Roles.GetUsersByRoleID(ctx, admin.ID)
+	if err != nil {
+		return fmt.Errorf("users of role: %w", err)
+	}
+	hasAdmin, err := b.Roles.UserHasRole(ctx, alice.ID, "admin")
+	if err != nil {
+		return fmt.Errorf("has role: %w", err)
+	}
+	withRoles, err := b.Roles.GetRoleByID(ctx, admin.ID)
+	if err != nil {
+		return fmt.Errorf("get role: %w", err)
+	}
+	if err := first(
+		expect(len(roles) == 2, "user has %d roles, want 2", len(roles)),
+		expect(len(users) == 1 && users[0].ID == alice.ID, "role users do not contain alice"),
+		expect(hasAdmin, "user does not have the admin role"),
+		expect(len(withRoles.UserRoles) == 1, "role preloaded %d user roles, want 1", len(withRoles.UserRoles)),
+		expect(b.Roles.AddUserRole(ctx, 404, admin.ID) != nil, "role was added to a missing user"),
+		expect(b.Roles.AddUserRole(ctx, alice.ID, 404) != nil, "a missing role was added to a user"),
+	); err != nil {
+		return err
+	}
+
+	if err := b.Roles.RemoveUserRole(ctx, alice.ID, admin.ID); err != nil {
+		return fmt.Errorf("remove role: %w", err)
+	}
+	if err := b.Roles.DeleteRole(ctx, viewer.ID); err != nil {
+		return fmt.Errorf("delete role: %w", err)
+	}
+	hasAdmin, err = b.Roles.UserHasRole(ctx, alice.ID, "admin")
+	if err != nil {
+		return fmt.Errorf("has role: %w", err)
+	}
+	hasViewer, err := b.Roles.UserHasRole(ctx, alice.ID, "viewer")
+	if err != nil {
+		return fmt.Errorf("has role: %w", err)
+	}
+	roles, err = b.Roles.GetRolesByUserID(ctx, alice.ID)
+	if err != nil {
+		return fmt.Errorf("roles of user: %w", err)
+	}
+	return first(
+		expect(!hasAdmin, "removed role is still held"),
+		expect(!hasViewer, "deleted role is still held"),
+		expect(len(roles) == 0, "user still has %d roles", len(roles)),
+		expect(b.Roles.RemoveUserRole(ctx, alice.ID, admin.ID) == nil, "removing an unheld role failed"),
+	)
+}
+
+func checkPermissions(ctx context.Context, b *Backend) error {
+	read, err := createPermission(ctx, b, "read")
+	if err != nil {
+		return err
+	}
+
+	byID, err := b.Permissions.GetPermissionByID(ctx, read.ID)
+	if err != nil {
+		return fmt.Errorf("get by ID: %w", err)
+	}
+	_, notFound := b.Permissions.GetPermissionByID(ctx, 404)
+	_, duplicate := b.Permissions.CreatePermission(ctx, model.Permission{PermissionName: "read"})
+	if err := first(
+		expect(byID.PermissionName == "read", "get by ID returned %q", byID.PermissionName),
+		expect(errors.Is(notFound, repository.ErrNotFound), "get by missing ID returned %v, want ErrNotFound", notFound),
+		expectDuplicate(duplicate, "duplicate permission name"),
+	); err != nil {
+		return err
+	}
+
+	byID.PermissionName = "read-all"
+	if _, err := b.Permissions.UpdatePermission(ctx, byID); err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	if err := b.Permissions.DeletePermission(ctx, read.ID); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	all, err := b.Permissions.GetAllPermissions(ctx)
+	if err != nil {
+		return fmt.Errorf("get all: %w", err)
+	}
+	_, getErr := b.Permissions.GetPermissionByID(ctx, read.ID)
+	_, reused := b.Permissions.CreatePermission(ctx, model.Permission{PermissionName: "read-all"})
+	return first(
+		expect(len(all) == 0, "get all returned %d permissions after delete, want 0", len(all)),
+		expect(errors.Is(getErr, repository.ErrNotFound), "deleted permission is still found: %v", getErr),
+		expectDuplicate(reused, "reusing a deleted permission's name"),
+	)
+}
+
+func checkPolicies(ctx context.Context, b *Backend) error {
+	own := model.Policy{Name: "own-profile", Effect: "deny", Permission: "users:update", Scope: "users:*", Condition: `{"attr":"subject.id","op":"ne","ref":"resource.id"}`}
+	own, err := b.Policies.CreatePolicy(ctx, own)
+	if err != nil {
+		return fmt.Errorf("create policy: %w", err)
+	}
+	hours, err := b.Policies.CreatePolicy(ctx, model.Policy{Name: "business-hours", Effect: "allow", Permission: "*"})
+	if err != nil {
+		return fmt.Errorf("create policy: %w", err)
+	}
+
+	byID, err := b.Policies.GetPolicyByID(ctx, own.ID)
+	if err != nil {
+		return fmt.Errorf("get by ID: %w", err)
+	}
+	_, notFound := b.Policies.GetPolicyByID(ctx, 404)
+	_, duplicate := b.Policies.CreatePolicy(ctx, model.Policy{Name: "own-profile", Effect: "allow", Permission: "*"})
+	if err := first(
+		expect(byID.Scope == "users:*" && byID.Condition == own.Condition, "get by ID returned %+v", byID),
+		expect(errors.Is(notFound, repository.ErrNotFound), "get by missing ID returned %v, want ErrNotFound", notFound),
+		expectDuplicate(duplicate, "duplicate policy name"),
+	); err != nil {
+		return err
+	}
+
+	byID.Effect = "allow"
+	if _, err := b.Policies.UpdatePolicy(ctx, byID); err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	if err := b.Policies.DeletePolicy(ctx, hours.ID); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	again := b.Policies.DeletePolicy(ctx, hours.ID)
+	all, err := b.Policies.GetAllPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("get all: %w", err)
+	}
+	_, reused := b.Policies.CreatePolicy(ctx, model.Policy{Name: "business-hours", Effect: "allow", Permission: "*"})
+	return first(
+		expect(errors.Is(again, repository.ErrNotFound), "deleting a deleted policy returned %v, want ErrNotFound", again),
+		expect(len(all) == 1 && all[0].ID == own.ID && all[0].Effect == "allow", "get all returned %+v, want the updated policy alone", all),
+		expectDuplicate(reused, "reusing a deleted policy's name"),
+	)
+}
+
+func checkRolePermissions(ctx context.Context, b *Backend) error {
+	alice, err := createUser(ctx, b, "alice")
+	if err != nil {
+		return err
+	}
+	editor, err := createRole(ctx, b, "editor")
+	if err != nil {
+		return err
+	}
+	var permissionIDs []uint64
+	for _, name := range []string{"read", "write", "publish"} {
+		permission, err := createPermission(ctx, b, name)
+		if err != nil {
+			return err
+		}
+		permissionIDs = append(permissionIDs, permission.ID)
+	}
+	if err := b.Roles.AddUserRole(ctx, alice.ID, editor.ID); err != nil {
+		return fmt.Errorf("add role: %w", err)
+	}
+
+	if err := b.Permissions.AssignPermissionToRole(ctx, editor.ID, permissionIDs[0]); err != nil {
+		return fmt.Errorf("assign: %w", err)
+	}
+	if err := b.Permissions.AddMultiplePermissionsToRole(ctx, editor.ID, []uint64{permissionIDs[1], 404}); err == nil {
+		return errors.New("assigning a missing permission was accepted")
+	}
+	permissions, err := b.Permissions.GetPermissionsByRoleID(ctx, editor.ID)
+	if err != nil {
+		return fmt.Errorf("permissions of role: %w", err)
+	}
+	if err := expect(len(permissions) == 1, "failed multiple assign was not rolled back, role has %d permissions", len(permissions)); err != nil {
+		return err
+	}
+
+	if err := b.Permissions.AddMultiplePermissionsToRole(ctx, editor.ID, permissionIDs[1:]); err != nil {
+		return fmt.Errorf("assign multiple: %w", err)
+	}
+	roles, err := b.Permissions.GetRolesByPermissionID(ctx, permissionIDs[2])
+	if err != nil {
+		return fmt.Errorf("roles of permission: %w", err)
+	}
+	canPublish, err := b.Permissions.UserHasPermission(ctx, alice.ID, "publish")
+	if err != nil {
+		return fmt.Errorf("has permission: %w", err)
+	}
+	canDelete, err := b.Permissions.UserHasPermission(ctx, alice.ID, "delete")
+	if err != nil {
+		return fmt.Errorf("has permission: %w", err)
+	}
+	if err := first(
+		expect(len(roles) == 1 && roles[0].ID == editor.ID, "permission roles do not contain editor"),
+		expect(canPublish, "user cannot publish through the editor role"),
+		expect(!canDelete, "user has a permission no role grants"),
+		expect(b.Permissions.AssignPermissionToRole(ctx, 404, permissionIDs[0]) != nil, "a permission was assigned to a missing role"),
+	); err != nil {
+		return err
+	}
+
+	if err := b.Permissions.RemovePermissionFromRole(ctx, editor.ID, permissionIDs[0]); err != nil {
+		return fmt.Errorf("remove: %w", err)
+	}
+	removeAgain := b.Permissions.RemovePermissionFromRole(ctx, editor.ID, permissionIDs[0])
+	removeMultiple := b.Permissions.RemoveMultiplePermissionsFromRole(ctx, editor.ID, []uint64{permissionIDs[1], permissionIDs[0]})
+	permissions, err = b.Permissions.GetPermissionsByRoleID(ctx, editor.ID)
+	if err != nil {
+		return fmt.Errorf("permissions of role: %w", err)
+	}
+	if err := first(
+		expect(errors.Is(removeAgain, repository.ErrNotFound), "removing an unassigned permission returned %v, want ErrNotFound", removeAgain),
+		expect(removeMultiple != nil, "removing an unassigned permission among several was accepted"),
+		expect(len(permissions) == 2, "failed multiple remove was not rolled back, role has %d permissions", len(permissions)),
+	); err != nil {
+		return err
+	}
+
+	if err := b.Permissions.RemoveMultiplePermissionsFromRole(ctx, editor.ID, permissionIDs[1:]); err != nil {
+		return fmt.Errorf("remove multiple: %w", err)
+	}
+	canPublish, err = b.Permissions.UserHasPermission(ctx, alice.ID, "publish")
+	if err != nil {
+		return fmt.Errorf("has permission: %w", err)
+	}
+	return expect(!canPublish, "removed permission is still granted")
+}
+
+func checkGrants(ctx context.Context, b *Backend) error {
+	alice, err := createUser(ctx, b, "alice")
+	if err != nil {
+		return err
+	}
+	bob, err := createUser(ctx, b, "bob")
+	if err != nil {
+		return err
+	}
+	var roles []*model.Role
+	for _, name := range []string{"editor", "publisher", "retired"} {
+		role, err := createRole(ctx, b, name)
+		if err != nil {
+			return err
+		}
+		roles = append(roles, role)
+	}
+	read, err := createPermission(ctx, b, "read")
+	if err != nil {
+		return err
+	}
+	publish, err := createPermission(ctx, b, "publish")
+	if err != nil {
+		return err
+	}
+	for _, step := range []struct{ user, role uint64 }{
+		{alice.ID, roles[1].ID}, {alice.ID, roles[0].ID}, {bob.ID, roles[2].ID},
+	} {
+		if err := b.Roles.AddUserRole(ctx, step.user, step.role); err != nil {
+			return fmt.Errorf("add role: %w", err)
+		}
+	}
+	for _, step := range []struct{ role, permission uint64 }{
+		{roles[0].ID, read.ID}, {roles[1].ID, read.ID}, {roles[1].ID, publish.ID}, {roles[2].ID, publish.ID},
+	} {
+		if err := b.Permissions.AssignPermissionToRole(ctx, step.role, step.permission); err != nil {
+			return fmt.Errorf("assign: %w", err)
+		}
+	}
+	if err := b.Roles.DeleteRole(ctx, roles[2].ID); err != nil {
+		return fmt.Errorf("delete role: %w", err)
+	}
+
+	grants, err := b.Permissions.GetGrants(ctx, []uint64{alice.ID, bob.ID}, []string{"read", "publish", "delete"})
+	if err != nil {
+		return fmt.Errorf("grants: %w", err)
+	}
+	got := make(map[string]bool)
+	for _, grant := range grants {
+		got[fmt.Sprintf("%d:%s:%s", grant.UserID, grant.PermissionName, grant.RoleName)] = true
+	}
+	if err := first(
+		expect(len(grants) == 3 && got[fmt.Sprintf("%d:read:editor", alice.ID)] &&
+			got[fmt.Sprintf("%d:read:publisher", alice.ID)] && got[fmt.Sprintf("%d:publish:publisher", alice.ID)],
+			"grants are %v, want read through editor and publisher and publish through publisher", grants),
+		expect(len(grants) > 0 && grants[0].RoleID == roles[0].ID, "grants are not ordered by role: %v", grants),
+	); err != nil {
+		return err
+	}
+	none, err := b.Permissions.GetGrants(ctx, []uint64{bob.ID}, []string{"publish"})
+	if err != nil {
+		return fmt.Errorf("grants: %w", err)
+	}
+	assignments, err := b.Roles.GetRoleAssignmentsByUserIDs(ctx, []uint64{alice.ID, bob.ID})
+	if err != nil {
+		return fmt.Errorf("assignments of users: %w", err)
+	}
+	if err := first(
+		expect(len(none) == 0, "a deleted role still grants: %v", none),
+		expect(len(assignments) == 1 && len(assignments[alice.ID]) == 2 &&
+			assignments[alice.ID][0].Role.ID == roles[1].ID && assignments[alice.ID][1].Role.ID == roles[0].ID,
+			"assignments are %v, want publisher then editor for alice and nothing for bob", assignments),
+	); err != nil {
+		return err
+	}
+
+	if err := b.Permissions.DeletePermission(ctx, read.ID); err != nil {
+		return fmt.Errorf("delete permission: %w", err)
+	}
+	permissions, err := b.Permissions.GetPermissionsByRoleID(ctx, roles[1].ID)
+	if err != nil {
+		return fmt.Errorf("permissions of role: %w", err)
+	}
+	holders, err := b.Permissions.GetRolesByPermissionID(ctx, publish.ID)
+	if err != nil {
+		return fmt.Errorf("roles of permission: %w", err)
+	}
+	byRole, err := b.Permissions.GetPermissionsByRoleIDs(ctx, []uint64{roles[0].ID, roles[1].ID})
+	if err != nil {
+		return fmt.Errorf("permissions of roles: %w", err)
+	}
+	return first(
+		expect(len(byRole) == 1 && len(byRole[roles[1].ID]) == 1 && byRole[roles[1].ID][0].ID == publish.ID,
+			"permissions of roles are %v, want only publish for publisher", byRole),
+		expect(len(permissions) == 1 && permissions[0].ID == publish.ID, "role permissions include a deleted permission: %v", permissions),
+		expect(len(holders) == 1 && holders[0].ID == roles[1].ID, "permission roles include a deleted role: %v", holders),
+	)
+}
+
+func checkScopedRoles(ctx context.Context, b *Backend) error {
+	alice, err := createUser(ctx, b, "alice")
+	if err != nil {
+		return err
+	}
+	viewer, err := createRole(ctx, b, "viewer")
+	if err != nil {
+		return err
+	}
+	editor, err := createRole(ctx, b, "editor")
+	if err != nil {
+		return err
+	}
+	edit, err := createPermission(ctx, b, "documents:edit")
+	if err != nil {
+		return err
+	}
+	if err := b.Permissions.AssignPermissionToRole(ctx, editor.ID, edit.ID); err != nil {
+		return fmt.Errorf("assign: %w", err)
+	}
+	if err := b.Roles.AddUserRole(ctx, alice.ID, viewer.ID); err != nil {
+		return fmt.Errorf("add role: %w", err)
+	}
+	for _, scope := range []string{"project:42", "project:7"} {
+		if err := b.Roles.AddScopedUserRole(ctx, alice.ID, editor.ID, scope); err != nil {
+			return fmt.Errorf("add scoped role: %w", err)
+		}
+	}
+
+	assignments, err := b.Roles.GetRoleAssignments(ctx, alice.ID)
+	if err != nil {
+		return fmt.Errorf("assignments: %w", err)
+	}
+	roles, err := b.Roles.GetRolesByUserID(ctx, alice.ID)
+	if err != nil {
+		return fmt.Errorf("roles of user: %w", err)
+	}
+	hasEditor, err := b.Roles.UserHasRole(ctx, alice.ID, "editor")
+	if err != nil {
+		return fmt.Errorf("has role: %w", err)
+	}
+	canEdit, err := b.Permissions.UserHasPermission(ctx, alice.ID, "documents:edit")
+	if err != nil {
+		return fmt.Errorf("has permission: %w", err)
+	}
+	editors, err := b.Roles.GetUsersByRoleID(ctx, editor.ID)
+	if err != nil {
+		return fmt.Errorf("users of role: %w", err)
+	}
+	grants, err := b.Permissions.GetGrants(ctx, []uint64{alice.ID}, []string{"documents:edit"})
+	if err != nil {
+		return fmt.Errorf("grants: %w", err)
+	}
+	if err := first(
+		expect(len(assignments) == 3 && assignments[0].Role.ID == viewer.ID && assignments[0].Scope == "" &&
+			assignments[1].Role.RoleName == "editor" && assignments[1].Scope == "project:42" && assignments[2].Scope == "project:7",
+			"assignments are %v, want viewer globally and editor on project:42 and project:7", assignments),
+		expect(len(roles) == 1 && roles[0].ID == viewer.ID, "global roles are %v, want only viewer", roles),
+		expect(!hasEditor, "a scoped role counts as a global one"),
+		expect(!canEdit, "a scoped role grants its permissions globally"),
+		expect(len(editors) == 0, "users holding editor globally are %v, want none", editors),
+		expect(len(grants) == 2 && grants[0].Scope == "project:42" && grants[1].Scope == "project:7",
+			"grants are %v, want documents:edit on project:42 and project:7", grants),
+	); err != nil {
+		return err
+	}
+
+	if err := b.Roles.RemoveScopedUserRole(ctx, alice.ID, editor.ID, "project:42"); err != nil {
+		return fmt.Errorf("remove scoped role: %w", err)
+	}
+	if err := b.Roles.RemoveUserRole(ctx, alice.ID, editor.ID); err != nil {
+		return fmt.Errorf("remove global role: %w", err)
+	}
+	assignments, err = b.Roles.GetRoleAssignments(ctx, alice.ID)
+	if err != nil {
+		return fmt.Errorf("assignments: %w", err)
+	}
+	return expect(len(assignments) == 2 && assignments[1].Scope == "project:7",
+		"after removing editor on project:42 assignments are %v, want viewer and editor on project:7", assignments)
+}
+
+func checkIdentities(ctx context.Context, b *Backend) error {
+	alice, err := createUser(ctx, b, "alice")
+	if err != nil {
+		return err
+	}
+	identity := &model.UserIdentity{UserID: alice.ID, Provider: "google", Subject: "123", Email: "alice@example.com"}
+	if err := b.Identities.CreateIdentity(ctx, identity); err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	found, err := b.Identities.GetIdentity(ctx, "google", "123")
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	_, missing := b.Identities.GetIdentity(ctx, "google", "456")
+	duplicate := &model.UserIdentity{UserID: alice.ID, Provider: "google", Subject: "123"}
+	orphan := &model.UserIdentity{UserID: 404, Provider: "google", Subject: "789"}
+	return first(
+		expect(found != nil && found.UserID == alice.ID, "get did not return alice's identity"),
+		expect(errors.Is(missing, repository.ErrNotFound), "get of an unlinked subject returned %v, want ErrNotFound", missing),
+		expectDuplicate(b.Identities.CreateIdentity(ctx, duplicate), "duplicate provider subject"),
+		expect(b.Identities.CreateIdentity(ctx, orphan) != nil, "identity of a missing user was accepted"),
+	)
+}
+
+func checkUnitOfWork(ctx context.Context, b *Backend) error {
+	errRollback := errors.New("rollback")
+
+	err := b.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+		if _, err := createUser(ctx, b, "discarded"); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		return fmt.Errorf("do returned %v, want the error of fn", err)
+	}
+
+	err = b.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+		if _, err := createUser(ctx, b, "kept"); err != nil {
+			return err
+		}
+		inner := b.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+			if _, err := createRole(ctx, b, "discarded"); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		return expect(errors.Is(inner, errRollback), "nested do returned %v, want the error of fn", inner)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, discarded := b.Users.GetUserByEmail(ctx, "discarded@example.com")
+	_, kept := b.Users.GetUserByEmail(ctx, "kept@example.com")
+	_, role := b.Roles.GetRoleByName(ctx, "discarded")
+	return first(
+		expect(errors.Is(discarded, repository.ErrNotFound), "a failed unit of work was committed"),
+		expect(kept == nil, "a successful unit of work was not committed: %v", kept),
+		expect(errors.Is(role, repository.ErrNotFound), "a failed nested unit of work was committed"),
+	)
+}
+
+func checkCommitHooks(ctx context.Context, b *Backend) error {
+	errRollback := errors.New("rollback")
+	var ran []string
+	hook := func(name string) func() {
+		return func() { ran = append(ran, name) }
+	}
+
+	repository.AfterCommit(ctx, hook("outside"))
+	err := b.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+		repository.AfterCommit(ctx, hook("outer"))
+		_ = b.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+			repository.AfterCommit(ctx, hook("rolled back"))
+			return errRollback
+		})
+		_ = b.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+			repository.AfterCommit(ctx, hook("nested"))
+			return nil
+		})
+		if err := expect(len(ran) == 1, "hooks ran before the unit of work committed: %v", ran); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_ = b.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+		repository.AfterCommit(ctx, hook("failed"))
+		return errRollback
+	})
+
+	got := strings.Join(ran, ",")
+	return expect(got == "outside,outer,nested", "hooks ran as %q, want outside,outer,nested", got)
+}
@@ -1,21 +1,37 @@
 package repository
 
 import (
+	"context"
+
 	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
 
 	"gorm.io/gorm"
 )
 
 type RoleRepository interface {
-	CreateRole(role *model.Role) (*model.Role, error)
-	GetRoleByID(id uint64) (*model.Role, error)
-	UpdateRole(role *model.Role) (*model.Role, error)
-	DeleteRole(id uint64) error
-	AddUserRole(userID uint64, roleID uint64) error
-	RemoveUserRole(userID uint64, roleID uint64) error
-	GetAllRoles() ([]model.Role, error)
-	GetRolesByUserID(userID uint64) ([]model.Role, error)
-	UserHasRole(userID uint64, roleName string) (bool, error)
+	CreateRole(ctx context.Context, role *model.Role) (*model.Role, error)
+	GetRoleByID(ctx context.Context, id uint64) (*model.Role, error)
+	GetRoleByName(ctx context.Context, name string) (*model.Role, error)
+	UpdateRole(ctx context.Context, role *model.Role) (*model.Role, error)
+	DeleteRole(ctx context.Context, id uint64) error
+	AddUserRole(ctx context.Context, userID uint64, roleID uint64) error
+	RemoveUserRole(ctx context.Context, userID uint64, roleID uint64) error
+	AddScopedUserRole(ctx context.Context, userID uint64, roleID uint64, scope string) error
+	RemoveScopedUserRole(ctx context.Context, userID uint64, roleID uint64, scope string) error
+	GetRoleAssignments(ctx context.Context, userID uint64) ([]RoleAssignment, error)
+	GetRoleAssignmentsByUserIDs(ctx context.Context, userIDs []uint64) (map[uint64][]RoleAssignment, error)
+	GetAllRoles(ctx context.Context) ([]model.Role, error)
+	GetRolesByUserID(ctx context.Context, userID uint64) ([]model.Role, error)
+	UserHasRole(ctx context.Context, userID uint64, roleName string) (bool, error)
+	GetUsersByRoleID(ctx context.Context, roleID uint64) ([]model.User, error)
+	FindRoles(ctx context.Context, filter *Filter, offset int, limit int) ([]model.Role, int64, error)
+	QueryRoles(ctx context.Context, query ListQuery) (Page[model.Role], error)
+}
+
+// RoleAssignment is a role a user holds within a scope; the empty scope is global
+type RoleAssignment struct {
+	Role  model.Role
+	Scope string
 }
 
 type roleRepository struct {
@@ -28,89 +44,192 @@ func NewRoleRepository(db *gorm.DB) RoleRepository {
 	}
 }
 
-func (r *roleRepository) CreateRole(role *model.Role) (*model.Role, error) {
-	if err := r.db.Create(&role).Error; err != nil {
-		return nil, err
+func (r *roleRepository) conn(ctx context.Context) *gorm.DB {
+	return conn(ctx, r.db)
+}
+
+func (r *roleRepository) CreateRole(ctx context.Context, role *model.Role) (*model.Role, error) {
+	if err := r.conn(ctx).Create(&role).Error; err != nil {
+		return nil, translate(err)
 	}
 	return role, nil
 }
 
-func (r *roleRepository) GetRoleByID(id uint64) (*model.Role, error) {
+func (r *roleRepository) GetRoleByID(ctx context.Context, id uint64) (*model.Role, error) {
+	var role model.Role
+	err := r.conn(ctx).Preload("UserRoles").Preload("RolePermissions").First(&role, id).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &role, nil
+}
+
+// GetRoleByName returns ErrNotFound when no role has the name.
+func (r *roleRepository) GetRoleByName(ctx context.Context, name string) (*model.Role, error) {
 	var role model.Role
-	err := r.db.Preload("UserRoles").Preload("RolePermissions").First(&role, id).Error
+	err := r.conn(ctx).Where("role_name = ?", name).First(&role).Error
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return &role, nil
 }
 
-func (r *roleRepository) UpdateRole(role *model.Role) (*model.Role, error) {
-	if err := r.db.Save(&role).Error; err != nil {
-		return nil, err
+func (r *roleRepository) UpdateRole(ctx context.Context, role *model.Role) (*model.Role, error) {
+	if err := r.conn(ctx).Save(&role).Error; err != nil {
+		return nil, translate(err)
 	}
 	return role, nil
 }
 
-func (r *roleRepository) DeleteRole(id uint64) error {
-	if err := r.db.Delete(&model.Role{}, id).Error; err != nil {
-		return err
+func (r *roleRepository) DeleteRole(ctx context.Context, id uint64) error {
+	if err := r.conn(ctx).Delete(&model.Role{}, id).Error; err != nil {
+		return translate(err)
 	}
 	return nil
 }
 
-func (r *roleRepository) AddUserRole(userID uint64, roleID uint64) error {
+// AddUserRole assigns the role globally
+func (r *roleRepository) AddUserRole(ctx context.Context, userID uint64, roleID uint64) error {
+	return r.AddScopedUserRole(ctx, userID, roleID, "")
+}
+
+// RemoveUserRole removes the global assignment of the role
+func (r *roleRepository) RemoveUserRole(ctx context.Context, userID uint64, roleID uint64) error {
+	return r.RemoveScopedUserRole(ctx, userID, roleID, "")
+}
+
+func (r *roleRepository) AddScopedUserRole(ctx context.Context, userID uint64, roleID uint64, scope string) error {
 	userRole := model.UserRole{
 		UserID: userID,
 		RoleID: roleID,
+		Scope:  scope,
 	}
 
-	// start transaction
-	tx := r.db.Begin()
-
-	if err := tx.Create(&userRole).Error; err != nil {
-		tx.Rollback() // rollback if user role creation fails
-		return err
-	}
-
-	tx.Commit() // commit transaction if all is well
-	return nil
+	return translate(r.conn(ctx).Create(&userRole).Error)
 }
 
-func (r *roleRepository) RemoveUserRole(userID uint64, roleID uint64) error {
-	// start transaction
-	tx := r.db.Begin()
+func (r *roleRepository) RemoveScopedUserRole(ctx context.Context, userID uint64, roleID uint64, scope string) error {
+	return translate(r.conn(ctx).Where("user_id = ? AND role_id = ? AND scope = ?", userID, roleID, scope).Delete(&model.UserRole{}).Error)
+}
 
-	if err := tx.Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&model.UserRole{}).Error; err != nil {
-		tx.Rollback() // rollback if deletion fails
-		return err
+// GetRoleAssignments returns the user's live roles in every scope, in the
+// order they were assigned
+func (r *roleRepository) GetRoleAssignments(ctx context.Context, userID uint64) ([]RoleAssignment, error) {
+	var userRoles []model.UserRole
+	err := r.conn(ctx).Preload("Role").
+		Joins("JOIN roles ON roles.id = user_roles.role_id AND roles.deleted_at IS NULL").
+		Where("user_roles.user_id = ?", userID).
+		Order("user_roles.id").
+		Find(&userRoles).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	assignments := make([]RoleAssignment, len(userRoles))
+	for i, userRole := range userRoles {
+		assignments[i] = RoleAssignment{Role: userRole.Role, Scope: userRole.Scope}
 	}
+	return assignments, nil
+}
 
-	tx.Commit() // commit transaction if all is well
-	return nil
+// GetRoleAssignmentsByUserIDs returns, in one query, the live roles of each
+// of the users in every scope, in the order they were assigned. Users holding
+// no role are left out.
+func (r *roleRepository) GetRoleAssignmentsByUserIDs(ctx context.Context, userIDs []uint64) (map[uint64][]RoleAssignment, error) {
+	assignments := make(map[uint64][]RoleAssignment)
+	if len(userIDs) == 0 {
+		return assignments, nil
+	}
+	var userRoles []model.UserRole
+	err := r.conn(ctx).Preload("Role").
+		Joins("JOIN roles ON roles.id = user_roles.role_id AND roles.deleted_at IS NULL").
+		Where("user_roles.user_id IN ?", userIDs).
+		Order("user_roles.id").
+		Find(&userRoles).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	for _, userRole := range userRoles {
+		assignments[userRole.UserID] = append(assignments[userRole.UserID], RoleAssignment{Role: userRole.Role, Scope: userRole.Scope})
+	}
+	return assignments, nil
 }
 
-func (r *roleRepository) GetAllRoles() ([]model.Role, error) {
+func (r *roleRepository) GetAllRoles(ctx context.Context) ([]model.Role, error) {
 	var roles []model.Role
-	if err := r.db.Preload("UserRoles").Preload("RolePermissions").Find(&roles).Error; err != nil {
-		return nil, err
+	if err := r.conn(ctx).Preload("UserRoles").Preload("RolePermissions").Find(&roles).Error; err != nil {
+		return nil, translate(err)
 	}
 	return roles, nil
 }
 
-func (r *roleRepository) GetRolesByUserID(userID uint64) ([]model.Role, error) {
+// GetRolesByUserID returns the user's global roles
+func (r *roleRepository) GetRolesByUserID(ctx context.Context, userID uint64) ([]model.Role, error) {
 	var roles []model.Role
-	if err := r.db.Joins("JOIN user_roles on user_roles.role_id = roles.id").
-		Where("user_roles.user_id = ?", userID).Find(&roles).Error; err != nil {
-		return nil, err
+	if err := r.conn(ctx).Joins("JOIN user_roles on user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ? AND user_roles.scope = '' AND user_roles.deleted_at IS NULL", userID).Find(&roles).Error; err != nil {
+		return nil, translate(err)
 	}
 	return roles, nil
 }
 
-func (r *roleRepository) UserHasRole(userID uint64, roleName string) (bool, error) {
+// UserHasRole reports whether the user holds the role globally
+func (r *roleRepository) UserHasRole(ctx context.Context, userID uint64, roleName string) (bool, error) {
 	var count int64
-	if err := r.db.Model(&model.UserRole{}).Joins("JOIN roles on roles.id = user_roles.role_id").
-		Where("user_roles.user_id = ? AND roles.role_name = ?", userID, roleName).Count(&count).Error; err != nil {
-		return false, err
+	if err := r.conn(ctx).Model(&model.UserRole{}).Joins("JOIN roles on roles.id = user_roles.role_id AND roles.deleted_at IS NULL").
+		Where("user_roles.user_id = ? AND user_roles.scope = '' AND roles.role_name = ?", userID, roleName).Count(&count).Error; err != nil {
+		return false, translate(err)
 	}
 	return count > 0, nil
 }
+
+// GetUsersByRoleID returns the users holding the role globally
+func (r *roleRepository) GetUsersByRoleID(ctx context.Context, roleID uint64) ([]model.User, error) {
+	var users []model.User
+	if err := r.conn(ctx).Joins("JOIN user_roles on user_roles.user_id = users.id").
+		Where("user_roles.role_id = ? AND user_roles.scope = '' AND user_roles.deleted_at IS NULL", roleID).Find(&users).Error; err != nil {
+		return nil, translate(err)
+	}
+	return users, nil
+}
+
+// FindRoles returns one page of the roles matching filter, ordered by ID,
+// along with the total number of matches. A nil filter matches every role.
+func (r *roleRepository) FindRoles(ctx context.Context, filter *Filter, offset int, limit int) ([]model.Role, int64, error) {
+	query := r.conn(ctx).Model(&model.Role{})
+	if filter != nil {
+		clause, args, err := filterClause(*filter, roleFilterFields)
+		if err != nil {
+			return nil, 0, translate(err)
+		}
+		query = query.Where(clause, args...)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, translate(err)
+	}
+
+	var roles []model.Role
+	if limit > 0 {
+		if err := query.Order("roles.id").Offset(offset).Limit(limit).Find(&roles).Error; err != nil {
+			return nil, 0, translate(err)
+		}
+	}
+	return roles, total, nil
+}
+
+// QueryRoles returns the page of roles the query selects
+func (r *roleRepository) QueryRoles(ctx context.Context, query ListQuery) (Page[model.Role], error) {
+	return findPage(r.conn(ctx).Model(&model.Role{}), query, RoleListFields, roleFilterFields, roleValues)
+}
+
+// roleValues holds the listable fields of a role
+func roleValues(role model.Role) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         role.ID,
+		"role_name":  role.RoleName,
+		"created_at": role.CreatedAt,
+		"updated_at": role.UpdatedAt,
+	}
+}
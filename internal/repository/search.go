@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+)
+
+// SimilarityThreshold is how similar a field must be to a fuzzy search query
+// to match it, the default pg_trgm.similarity_threshold of Postgres
+const SimilarityThreshold = 0.3
+
+// prefixBoost is added to the score of users whose username or email starts
+// with the query, so completions rank above looser matches
+const prefixBoost = 1.0
+
+// UserMatch is a user found by a fuzzy search
+type UserMatch struct {
+	User *model.User
+	// Score orders the matches: the trigram similarity of the closer of
+	// username and email, plus one when either starts with the query
+	Score float64
+	// Highlights holds the parts of each matched field that match the query
+	Highlights map[string][]Span
+}
+
+// Span is the half-open range [Start, End) of characters of a field
+type Span struct {
+	Start int
+	End   int
+}
+
+// matchUser scores user against a lower-cased query, reporting whether the
+// user matches it: either field contains the query or is similar enough.
+// It is what the Postgres query computes with pg_trgm.
+func matchUser(user *model.User, query string) (UserMatch, bool) {
+	username, email := strings.ToLower(user.Username), strings.ToLower(user.Email)
+	match := UserMatch{
+		User:  user,
+		Score: maxFloat(similarity(username, query), similarity(email, query)),
+	}
+	if match.Score < SimilarityThreshold && !strings.Contains(username, query) && !strings.Contains(email, query) {
+		return match, false
+	}
+	if strings.HasPrefix(username, query) || strings.HasPrefix(email, query) {
+		match.Score += prefixBoost
+	}
+	match.Highlights = highlightUser(user, query)
+	return match, true
+}
+
+// rankUsers returns one page of the users matching a lower-cased query, the
+// best match first and ties in ID order
+func rankUsers(users []model.User, query string, offset int, limit int) []UserMatch {
+	var matches []UserMatch
+	for i := range users {
+		if match, ok := matchUser(&users[i], query); ok {
+			matches = append(matches, match)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].User.ID < matches[j].User.ID
+	})
+
+	start, end := pageBounds(len(matches), offset, limit)
+	return matches[start:end]
+}
+
+// highlightUser marks what matches a lower-cased query in username and email,
+// leaving out fields with nothing to mark
+func highlightUser(user *model.User, query string) map[string][]Span {
+	highlights := map[string][]Span{}
+	for field, value := range map[string]string{"username": user.Username, "email": user.Email} {
+		if spans := highlight(value, query); len(spans) > 0 {
+			highlights[field] = spans
+		}
+	}
+	return highlights
+}
+
+// highlight finds what of value matches a lower-cased query: every occurrence
+// of the query when value contains it, otherwise the characters covered by
+// trigrams the two share
+func highlight(value string, query string) []Span {
+	text := []rune(strings.ToLower(value))
+	pattern := []rune(query)
+	if len(pattern) == 0 {
+		return nil
+	}
+
+	var spans []Span
+	for i := 0; i+len(pattern) <= len(text); {
+		if string(text[i:i+len(pattern)]) == query {
+			spans = append(spans, Span{Start: i, End: i + len(pattern)})
+			i += len(pattern)
+		} else {
+			i++
+		}
+	}
+	if len(spans) > 0 {
+		return spans
+	}
+
+	shared := trigrams(query)
+	marked := make([]bool, len(text))
+	for _, word := range words(text) {
+		// Position k of the padded word is character word.Start+k-2
+		padded := pad(text[word.Start:word.End])
+		for k := 0; k+3 <= len(padded); k++ {
+			if !shared[string(padded[k:k+3])] {
+				continue
+			}
+			for c := k; c < k+3; c++ {
+				if c >= 2 && c-2 < word.End-word.Start {
+					marked[word.Start+c-2] = true
+				}
+			}
+		}
+	}
+	for i := 0; i < len(marked); i++ {
+		if !marked[i] {
+			continue
+		}
+		start := i
+		for i < len(marked) && marked[i] {
+			i++
+		}
+		spans = append(spans, Span{Start: start, End: i})
+	}
+	return spans
+}
+
+// similarity is pg_trgm's similarity of two strings: the number of trigrams
+// they share divided by the number of distinct trigrams in either
+func similarity(a string, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for trigram := range ta {
+		if tb[trigram] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// trigrams extracts the trigrams of s as pg_trgm does: s is lower-cased and
+// split into words of letters and digits, and each word is padded with two
+// spaces in front and one behind
+func trigrams(s string) map[string]bool {
+	text := []rune(strings.ToLower(s))
+	set := map[string]bool{}
+	for _, word := range words(text) {
+		padded := pad(text[word.Start:word.End])
+		for k := 0; k+3 <= len(padded); k++ {
+			set[string(padded[k:k+3])] = true
+		}
+	}
+	return set
+}
+
+// pad surrounds a word with two spaces in front and one behind
+func pad(word []rune) []rune {
+	padded := make([]rune, 0, len(word)+3)
+	padded = append(padded, ' ', ' ')
+	padded = append(padded, word...)
+	return append(padded, ' ')
+}
+
+// words finds the runs of letters and digits in text
+func words(text []rune) []Span {
+	var spans []Span
+	for i := 0; i < len(text); i++ {
+		if !isWordRune(text[i]) {
+			continue
+		}
+		start := i
+		for i < len(text) && isWordRune(text[i]) {
+			i++
+		}
+		spans = append(spans, Span{Start: start, End: i})
+	}
+	return spans
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func maxFloat(a float64, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
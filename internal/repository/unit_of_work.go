@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// UnitOfWork runs a group of repository calls in one database transaction.
+// The transaction travels in the context handed to fn, so every repository
+// method called with that context joins it; calls with any other context do not.
+type UnitOfWork interface {
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+type unitOfWork struct {
+	db *gorm.DB
+}
+
+func NewUnitOfWork(db *gorm.DB) UnitOfWork {
+	return &unitOfWork{
+		db: db,
+	}
+}
+
+// Do commits when fn returns nil and rolls back when it returns an error or panics.
+// Nested calls run in a savepoint of the enclosing transaction.
+func (u *unitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withCommitHooks(ctx, func(ctx context.Context) error {
+		return conn(ctx, u.db).Transaction(func(tx *gorm.DB) error {
+			return fn(context.WithValue(ctx, txKey{}, tx))
+		})
+	})
+}
+
+type commitHooksKey struct{}
+
+// commitHooks collects the AfterCommit functions of one unit of work
+type commitHooks struct {
+	fns []func()
+}
+
+// AfterCommit runs fn once the unit of work carried by ctx commits and drops
+// it if the unit rolls back. Outside a unit of work fn runs at once.
+func AfterCommit(ctx context.Context, fn func()) {
+	if hooks, ok := ctx.Value(commitHooksKey{}).(*commitHooks); ok {
+		hooks.fns = append(hooks.fns, fn)
+		return
+	}
+	fn()
+}
+
+// InUnitOfWork reports whether ctx carries a unit of work, whose reads may
+// see writes that are not committed yet
+func InUnitOfWork(ctx context.Context) bool {
+	_, ok := ctx.Value(commitHooksKey{}).(*commitHooks)
+	return ok
+}
+
+// withCommitHooks runs do with a context collecting AfterCommit functions.
+// When do succeeds they run, or pass to the enclosing unit if there is one.
+func withCommitHooks(ctx context.Context, do func(ctx context.Context) error) error {
+	parent, nested := ctx.Value(commitHooksKey{}).(*commitHooks)
+	hooks := &commitHooks{}
+	if err := do(context.WithValue(ctx, commitHooksKey{}, hooks)); err != nil {
+		return err
+	}
+	if nested {
+		parent.fns = append(parent.fns, hooks.fns...)
+		return nil
+	}
+	for _, fn := range hooks.fns {
+		fn()
+	}
+	return nil
+}
+
+// conn returns the transaction carried by ctx, or db bound to ctx when there is none
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
+	}
+	return db.WithContext(ctx)
+}
@@ -3,19 +3,30 @@
 package repository
 
 import (
+	"context"
+	"strings"
+	"time"
+
 	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
 	"gorm.io/gorm"
 )
 
 type UserRepository interface {
-	CreateUser(user *model.User) error
-	GetUserByUsername(username string) (*model.User, error)
-	GetUserByID(id uint64) (*model.User, error)
-	UpdateUser(user *model.User) error
-	DeleteUser(id uint64) error
-	ListUsers(page int, pageSize int) ([]*model.User, error)
-	SearchUsers(query string, page int, pageSize int) ([]*model.User, error)
-	CountUsers() (int64, error)
+	CreateUser(ctx context.Context, user *model.User) error
+	GetUserByUsername(ctx context.Context, username string) (*model.User, error)
+	GetUserByID(ctx context.Context, id uint64) (*model.User, error)
+	GetUsersByIDs(ctx context.Context, ids []uint64) ([]*model.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*model.User, error)
+	UpdateUser(ctx context.Context, user *model.User) error
+	DeleteUser(ctx context.Context, id uint64) error
+	ListUsers(ctx context.Context, page int, pageSize int) ([]*model.User, error)
+	SearchUsers(ctx context.Context, query string, page int, pageSize int) ([]*model.User, error)
+	FuzzySearchUsers(ctx context.Context, query string, page int, pageSize int) ([]UserMatch, error)
+	CountUsers(ctx context.Context) (int64, error)
+	FindUsers(ctx context.Context, filter *Filter, offset int, limit int) ([]*model.User, int64, error)
+	QueryUsers(ctx context.Context, query ListQuery) (Page[*model.User], error)
+	ListDeletedUsers(ctx context.Context, since time.Time) ([]*model.User, error)
+	ListDeactivatedUsers(ctx context.Context, since time.Time) ([]*model.User, error)
 }
 
 type userRepository struct {
@@ -28,68 +39,195 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 	}
 }
 
-func (r *userRepository) CreateUser(user *model.User) error {
-	return r.db.Create(user).Error
+func (r *userRepository) conn(ctx context.Context) *gorm.DB {
+	return conn(ctx, r.db)
+}
+
+func (r *userRepository) CreateUser(ctx context.Context, user *model.User) error {
+	return translate(r.conn(ctx).Create(user).Error)
 }
 
-func (r *userRepository) GetUserByUsername(username string) (*model.User, error) {
+func (r *userRepository) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
 	var user model.User
-	err := r.db.Where("username = ?", username).First(&user).Error
+	err := r.conn(ctx).Where("username = ?", username).First(&user).Error
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return &user, nil
 }
 
-func (r *userRepository) GetUserByID(id uint64) (*model.User, error) {
+func (r *userRepository) GetUserByID(ctx context.Context, id uint64) (*model.User, error) {
 	var user model.User
-	err := r.db.First(&user, id).Error
+	err := r.conn(ctx).First(&user, id).Error
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return &user, nil
 }
 
-func (r *userRepository) UpdateUser(user *model.User) error {
-	return r.db.Save(user).Error
+// GetUsersByIDs returns, in one query, the live users among ids, ordered by ID
+func (r *userRepository) GetUsersByIDs(ctx context.Context, ids []uint64) ([]*model.User, error) {
+	users := []*model.User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+	if err := r.conn(ctx).Where("id IN ?", ids).Order("id").Find(&users).Error; err != nil {
+		return nil, translate(err)
+	}
+	return users, nil
+}
+
+func (r *userRepository) UpdateUser(ctx context.Context, user *model.User) error {
+	return translate(r.conn(ctx).Save(user).Error)
+}
+
+func (r *userRepository) DeleteUser(ctx context.Context, id uint64) error {
+	return translate(r.conn(ctx).Delete(&model.User{}, id).Error)
+}
+
+// ListDeletedUsers returns the users deleted at or after since, ordered by ID
+func (r *userRepository) ListDeletedUsers(ctx context.Context, since time.Time) ([]*model.User, error) {
+	var users []*model.User
+	err := r.conn(ctx).Unscoped().Where("deleted_at >= ?", since).Order("id").Find(&users).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return users, nil
 }
 
-func (r *userRepository) DeleteUser(id uint64) error {
-	return r.db.Delete(&model.User{}, id).Error
+// ListDeactivatedUsers returns the live users that are not active and were
+// last updated at or after since, ordered by ID
+func (r *userRepository) ListDeactivatedUsers(ctx context.Context, since time.Time) ([]*model.User, error) {
+	var users []*model.User
+	err := r.conn(ctx).Where("active = ? AND updated_at >= ?", false, since).Order("id").Find(&users).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return users, nil
 }
 
-func (r *userRepository) ListUsers(page int, pageSize int) ([]*model.User, error) {
+func (r *userRepository) ListUsers(ctx context.Context, page int, pageSize int) ([]*model.User, error) {
 	var users []*model.User
-	err := r.db.Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error
+	err := r.conn(ctx).Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return users, nil
 }
 
-func (r *userRepository) SearchUsers(query string, page int, pageSize int) ([]*model.User, error) {
+func (r *userRepository) SearchUsers(ctx context.Context, query string, page int, pageSize int) ([]*model.User, error) {
 	var users []*model.User
-	err := r.db.Where("username LIKE ? OR email LIKE ?", "%"+query+"%", "%"+query+"%").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error
+	err := r.conn(ctx).Where("username LIKE ? OR email LIKE ?", "%"+query+"%", "%"+query+"%").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return users, nil
 }
 
-func (r *userRepository) CountUsers() (int64, error) {
+// FuzzySearchUsers ranks the users whose username or email contains or
+// resembles query, ignoring case. Postgres serves it from the pg_trgm indexes
+// of the user_search migration; SQLite has no trigram support, so there every
+// user is ranked in Go the way the memory repository does.
+func (r *userRepository) FuzzySearchUsers(ctx context.Context, query string, page int, pageSize int) ([]UserMatch, error) {
+	query = strings.ToLower(query)
+	db := r.conn(ctx)
+	if db.Dialector.Name() != "postgres" {
+		var users []model.User
+		if err := db.Order("id").Find(&users).Error; err != nil {
+			return nil, translate(err)
+		}
+		return rankUsers(users, query, (page-1)*pageSize, pageSize), nil
+	}
+
+	// % is pg_trgm's similarity operator, true at SimilarityThreshold and above
+	contains, prefix := "%"+escapeLike(query)+"%", escapeLike(query)+"%"
+	var rows []scoredUser
+	err := db.Model(&model.User{}).
+		Select(`users.*, GREATEST(similarity(LOWER(username), ?), similarity(LOWER(email), ?))
+			+ CASE WHEN LOWER(username) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\' THEN ? ELSE 0 END AS score`,
+			query, query, prefix, prefix, prefixBoost).
+		Where(`LOWER(username) % ? OR LOWER(email) % ?
+			OR LOWER(username) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\'`,
+			query, query, contains, contains).
+		Order("score DESC, users.id").
+		Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&rows).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	matches := make([]UserMatch, len(rows))
+	for i := range rows {
+		user := rows[i].User
+		matches[i] = UserMatch{User: &user, Score: rows[i].Score, Highlights: highlightUser(&user, query)}
+	}
+	return matches, nil
+}
+
+// scoredUser is a row of the fuzzy search query
+type scoredUser struct {
+	model.User
+	Score float64
+}
+
+func (r *userRepository) CountUsers(ctx context.Context) (int64, error) {
 	var count int64
-	err := r.db.Model(&model.User{}).Count(&count).Error
+	err := r.conn(ctx).Model(&model.User{}).Count(&count).Error
 	if err != nil {
-		return 0, err
+		return 0, translate(err)
 	}
 	return count, nil
 }
 
-func (r *userRepository) GetUserByEmail(email string) (*model.User, error) {
+// FindUsers returns one page of the users matching filter, ordered by ID,
+// along with the total number of matches. A nil filter matches every user.
+func (r *userRepository) FindUsers(ctx context.Context, filter *Filter, offset int, limit int) ([]*model.User, int64, error) {
+	query := r.conn(ctx).Model(&model.User{})
+	if filter != nil {
+		clause, args, err := filterClause(*filter, userFilterFields)
+		if err != nil {
+			return nil, 0, translate(err)
+		}
+		query = query.Where(clause, args...)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, translate(err)
+	}
+
+	var users []*model.User
+	if limit > 0 {
+		if err := query.Order("users.id").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+			return nil, 0, translate(err)
+		}
+	}
+	return users, total, nil
+}
+
+// QueryUsers returns the page of users the query selects
+func (r *userRepository) QueryUsers(ctx context.Context, query ListQuery) (Page[*model.User], error) {
+	return findPage(r.conn(ctx).Model(&model.User{}), query, UserListFields, userFilterFields, userValues)
+}
+
+// userValues holds the listable fields of a user
+func userValues(user *model.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         user.ID,
+		"username":   user.Username,
+		"email":      user.Email,
+		"created_at": user.CreatedAt,
+		"updated_at": user.UpdatedAt,
+	}
+}
+
+// GetUserByEmail returns ErrNotFound when no user has the email.
+func (r *userRepository) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
 	var user model.User
-	err := r.db.Where("email = ?", email).First(&user).Error
+	err := r.conn(ctx).Where("email = ?", email).First(&user).Error
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return &user, nil
 }
@@ -0,0 +1,157 @@
+package scim
+
+// ServiceProviderConfig describes the SCIM features this server supports
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 Supported              `json:"patch"`
+	Bulk                  BulkSupport            `json:"bulk"`
+	Filter                FilterSupport          `json:"filter"`
+	ChangePassword        Supported              `json:"changePassword"`
+	Sort                  Supported              `json:"sort"`
+	ETag                  Supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+}
+
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+type BulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type FilterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type AuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type ResourceType struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Endpoint    string   `json:"endpoint"`
+	Description string   `json:"description"`
+	Schema      string   `json:"schema"`
+}
+
+type Schema struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Attributes  []SchemaAttribute `json:"attributes"`
+}
+
+type SchemaAttribute struct {
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	MultiValued   bool              `json:"multiValued"`
+	Required      bool              `json:"required"`
+	CaseExact     bool              `json:"caseExact"`
+	Mutability    string            `json:"mutability"`
+	Returned      string            `json:"returned"`
+	Uniqueness    string            `json:"uniqueness"`
+	SubAttributes []SchemaAttribute `json:"subAttributes,omitempty"`
+}
+
+func attribute(name string, typ string, required bool, mutability string, uniqueness string) SchemaAttribute {
+	return SchemaAttribute{
+		Name:       name,
+		Type:       typ,
+		Required:   required,
+		Mutability: mutability,
+		Returned:   "default",
+		Uniqueness: uniqueness,
+	}
+}
+
+func multiValued(name string, mutability string, subAttributes ...SchemaAttribute) SchemaAttribute {
+	a := attribute(name, "complex", false, mutability, "none")
+	a.MultiValued = true
+	a.SubAttributes = subAttributes
+	return a
+}
+
+func DefaultServiceProviderConfig() ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas:        []string{SchemaServiceProviderConfig},
+		Patch:          Supported{Supported: true},
+		Bulk:           BulkSupport{},
+		Filter:         FilterSupport{Supported: true, MaxResults: MaxResults},
+		ChangePassword: Supported{Supported: true},
+		Sort:           Supported{},
+		ETag:           Supported{},
+		AuthenticationSchemes: []AuthenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer Token",
+			Description: "Authentication with a bearer token in the Authorization header",
+		}},
+	}
+}
+
+func ResourceTypes() []ResourceType {
+	return []ResourceType{
+		{
+			Schemas:     []string{SchemaResourceType},
+			ID:          "User",
+			Name:        "User",
+			Endpoint:    "/Users",
+			Description: "User account",
+			Schema:      SchemaUser,
+		},
+		{
+			Schemas:     []string{SchemaResourceType},
+			ID:          "Group",
+			Name:        "Group",
+			Endpoint:    "/Groups",
+			Description: "Role, with its assigned users as members",
+			Schema:      SchemaGroup,
+		},
+	}
+}
+
+func Schemas() []Schema {
+	reference := []SchemaAttribute{
+		attribute("value", "string", false, "immutable", "none"),
+		attribute("$ref", "reference", false, "immutable", "none"),
+		attribute("display", "string", false, "readOnly", "none"),
+	}
+
+	return []Schema{
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          SchemaUser,
+			Name:        "User",
+			Description: "User account",
+			Attributes: []SchemaAttribute{
+				attribute("userName", "string", true, "readWrite", "server"),
+				multiValued("emails", "readWrite",
+					attribute("value", "string", true, "readWrite", "server"),
+					attribute("type", "string", false, "readWrite", "none"),
+					attribute("primary", "boolean", false, "readWrite", "none"),
+				),
+				attribute("active", "boolean", false, "readWrite", "none"),
+				{Name: "password", Type: "string", Mutability: "writeOnly", Returned: "never", Uniqueness: "none"},
+				multiValued("groups", "readOnly", reference...),
+			},
+		},
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          SchemaGroup,
+			Name:        "Group",
+			Description: "Role, with its assigned users as members",
+			Attributes: []SchemaAttribute{
+				attribute("displayName", "string", true, "readWrite", "server"),
+				multiValued("members", "readWrite", reference...),
+			},
+		},
+	}
+}
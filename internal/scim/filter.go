@@ -0,0 +1,312 @@
+package scim
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+)
+
+// AttributeType tells the filter parser how to convert comparison values
+type AttributeType int
+
+const (
+	TypeString AttributeType = iota
+	TypeID
+	TypeDateTime
+)
+
+// Attribute maps a filterable SCIM attribute onto a repository filter field
+type Attribute struct {
+	Field string
+	Type  AttributeType
+}
+
+// AttributeMap is keyed by lower-case attribute path, without the schema URN
+type AttributeMap map[string]Attribute
+
+var UserAttributes = AttributeMap{
+	"id":                {Field: "id", Type: TypeID},
+	"username":          {Field: "username", Type: TypeString},
+	"emails":            {Field: "email", Type: TypeString},
+	"emails.value":      {Field: "email", Type: TypeString},
+	"meta.created":      {Field: "created_at", Type: TypeDateTime},
+	"meta.lastmodified": {Field: "updated_at", Type: TypeDateTime},
+}
+
+var GroupAttributes = AttributeMap{
+	"id":                {Field: "id", Type: TypeID},
+	"displayname":       {Field: "role_name", Type: TypeString},
+	"meta.created":      {Field: "created_at", Type: TypeDateTime},
+	"meta.lastmodified": {Field: "updated_at", Type: TypeDateTime},
+}
+
+// ParseFilter parses a SCIM filter expression, such as
+// `userName sw "j" and not (emails co "example.org")`, into a repository
+// filter. schema is the URN that may prefix fully qualified attribute paths.
+func ParseFilter(expression string, schema string, attributes AttributeMap) (*repository.Filter, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens, schema: strings.ToLower(schema) + ":", attributes: attributes}
+	filter, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, BadRequest(ErrInvalidFilter, "unexpected %q in filter", p.peek().text)
+	}
+	return &filter, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpenParen
+	tokenCloseParen
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenOpenParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenCloseParen, ")"})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{tokenOpenBracket, "["})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokenCloseBracket, "]"})
+			i++
+		case r == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return nil, BadRequest(ErrInvalidFilter, "unterminated string in filter")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(string(runes[i:j+1])), &value); err != nil {
+				return nil, BadRequest(ErrInvalidFilter, "invalid string in filter")
+			}
+			tokens = append(tokens, token{tokenString, value})
+			i = j + 1
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune(`()[]"`, runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[i:j])})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens     []token
+	pos        int
+	schema     string
+	attributes AttributeMap
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() token {
+	if p.done() {
+		return token{kind: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *filterParser) peekKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+func (p *filterParser) expect(kind tokenKind, text string) error {
+	if p.next().kind != kind {
+		return BadRequest(ErrInvalidFilter, "expected %q in filter", text)
+	}
+	return nil
+}
+
+// parseOr parses or-expressions; prefix qualifies attributes inside a value path
+func (p *filterParser) parseOr(prefix string) (repository.Filter, error) {
+	left, err := p.parseAnd(prefix)
+	if err != nil {
+		return repository.Filter{}, err
+	}
+	operands := []repository.Filter{left}
+	for p.peekKeyword("or") {
+		p.next()
+		right, err := p.parseAnd(prefix)
+		if err != nil {
+			return repository.Filter{}, err
+		}
+		operands = append(operands, right)
+	}
+	if len(operands) == 1 {
+		return left, nil
+	}
+	return repository.Filter{Op: repository.FilterOr, Operands: operands}, nil
+}
+
+func (p *filterParser) parseAnd(prefix string) (repository.Filter, error) {
+	left, err := p.parseUnary(prefix)
+	if err != nil {
+		return repository.Filter{}, err
+	}
+	operands := []repository.Filter{left}
+	for p.peekKeyword("and") {
+		p.next()
+		right, err := p.parseUnary(prefix)
+		if err != nil {
+			return repository.Filter{}, err
+		}
+		operands = append(operands, right)
+	}
+	if len(operands) == 1 {
+		return left, nil
+	}
+	return repository.Filter{Op: repository.FilterAnd, Operands: operands}, nil
+}
+
+func (p *filterParser) parseUnary(prefix string) (repository.Filter, error) {
+	if p.peekKeyword("not") {
+		p.next()
+		if err := p.expect(tokenOpenParen, "("); err != nil {
+			return repository.Filter{}, err
+		}
+		inner, err := p.parseOr(prefix)
+		if err != nil {
+			return repository.Filter{}, err
+		}
+		if err := p.expect(tokenCloseParen, ")"); err != nil {
+			return repository.Filter{}, err
+		}
+		return repository.Filter{Op: repository.FilterNot, Operands: []repository.Filter{inner}}, nil
+	}
+
+	if p.peek().kind == tokenOpenParen {
+		p.next()
+		inner, err := p.parseOr(prefix)
+		if err != nil {
+			return repository.Filter{}, err
+		}
+		if err := p.expect(tokenCloseParen, ")"); err != nil {
+			return repository.Filter{}, err
+		}
+		return inner, nil
+	}
+
+	attr := p.next()
+	if attr.kind != tokenWord {
+		return repository.Filter{}, BadRequest(ErrInvalidFilter, "expected an attribute in filter")
+	}
+	path := prefix + strings.TrimPrefix(strings.ToLower(attr.text), p.schema)
+
+	// A value path such as emails[value co "@example.org"] filters sub-attributes
+	if p.peek().kind == tokenOpenBracket {
+		if prefix != "" {
+			return repository.Filter{}, BadRequest(ErrInvalidFilter, "nested value paths are not supported")
+		}
+		p.next()
+		inner, err := p.parseOr(path + ".")
+		if err != nil {
+			return repository.Filter{}, err
+		}
+		if err := p.expect(tokenCloseBracket, "]"); err != nil {
+			return repository.Filter{}, err
+		}
+		return inner, nil
+	}
+
+	attribute, ok := p.attributes[path]
+	if !ok {
+		return repository.Filter{}, BadRequest(ErrInvalidFilter, "attribute %q cannot be filtered", attr.text)
+	}
+
+	operator := p.next()
+	if operator.kind != tokenWord {
+		return repository.Filter{}, BadRequest(ErrInvalidFilter, "expected an operator after %q", attr.text)
+	}
+	op := repository.FilterOp(strings.ToLower(operator.text))
+
+	switch op {
+	case repository.FilterPresent:
+		return repository.Filter{Op: op, Field: attribute.Field}, nil
+	case repository.FilterEq, repository.FilterNe, repository.FilterContains, repository.FilterStartsWith,
+		repository.FilterEndsWith, repository.FilterGt, repository.FilterGe, repository.FilterLt, repository.FilterLe:
+	default:
+		return repository.Filter{}, BadRequest(ErrInvalidFilter, "unknown operator %q", operator.text)
+	}
+
+	value := p.next()
+	if value.kind != tokenString {
+		return repository.Filter{}, BadRequest(ErrInvalidFilter, "attribute %q must be compared with a string", attr.text)
+	}
+
+	converted, err := convertValue(attribute, op, value.text)
+	if err != nil {
+		return repository.Filter{}, err
+	}
+	return repository.Filter{Op: op, Field: attribute.Field, Value: converted}, nil
+}
+
+func convertValue(attribute Attribute, op repository.FilterOp, value string) (interface{}, error) {
+	switch attribute.Type {
+	case TypeID:
+		if op != repository.FilterEq && op != repository.FilterNe {
+			return nil, BadRequest(ErrInvalidFilter, "id only supports eq and ne")
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, BadRequest(ErrInvalidFilter, "invalid id %q", value)
+		}
+		return id, nil
+	case TypeDateTime:
+		if op == repository.FilterContains || op == repository.FilterStartsWith || op == repository.FilterEndsWith {
+			return nil, BadRequest(ErrInvalidFilter, "dateTime attributes do not support %s", op)
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, BadRequest(ErrInvalidFilter, "invalid dateTime %q", value)
+		}
+		return t, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+// Authentication methods recorded in the amr claim (RFC 8176 values where one exists)
+const (
+	MethodPassword  = "pwd"
+	MethodLDAP      = "ldap"
+	MethodFederated = "fed"
+	MethodMFA       = "mfa"
+)
+
+// ErrInvalidCredentials is returned when the username or password is wrong
+var ErrInvalidCredentials = errors.NewAppError(errors.CodeUnauthorized, "Invalid username or password")
+
+// ErrUserInactive is returned when a deactivated user proves who they are
+var ErrUserInactive = errors.NewAppError(errors.CodeForbidden, "User is deactivated")
+
+// Authentication is the outcome of a successful login
+type Authentication struct {
+	User *model.User
+	// Roles granted by the credential store itself, for users that are not
+	// stored locally and so cannot carry role assignments.
+	Roles []string
+	// Methods lists how the user proved their identity, for the amr claim
+	Methods []string
+	// AuthTime is when the user last actively authenticated; zero means now
+	AuthTime time.Time
+}
+
+// Authenticator checks a username and password against one credential store
+type Authenticator interface {
+	Authenticate(ctx context.Context, username string, password string) (*Authentication, error)
+}
+
+// PasswordAuthenticator checks bcrypt password hashes in the users table
+type PasswordAuthenticator struct {
+	UserRepo repository.UserRepository
+}
+
+func NewPasswordAuthenticator(repo repository.UserRepository) *PasswordAuthenticator {
+	return &PasswordAuthenticator{
+		UserRepo: repo,
+	}
+}
+
+func (a *PasswordAuthenticator) Authenticate(ctx context.Context, username string, password string) (*Authentication, error) {
+	user, err := a.UserRepo.GetUserByUsername(ctx, username)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		logs.Error("error fetching user by username: ", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+	if !CheckPassword(user.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	if !user.Active {
+		return nil, ErrUserInactive
+	}
+
+	return &Authentication{User: user, Methods: []string{MethodPassword}}, nil
+}
+
+// ChainAuthenticator tries each authenticator in order until one accepts the credentials
+type ChainAuthenticator []Authenticator
+
+func (chain ChainAuthenticator) Authenticate(ctx context.Context, username string, password string) (*Authentication, error) {
+	var firstErr error
+	for _, authenticator := range chain {
+		auth, err := authenticator.Authenticate(ctx, username, password)
+		if err == nil {
+			return auth, nil
+		}
+		if err != ErrInvalidCredentials && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrInvalidCredentials
+}
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/cache"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+// AuthzCache holds each user's roles, in every scope, each role's
+// permissions and the policies for the authorization checks. Every service
+// that changes role assignments, grants, roles, permissions or policies
+// invalidates the entries it affects once its unit of work commits. Reads made inside a unit of work bypass the cache, since
+// they may see writes that are later rolled back.
+//
+// Each invalidation, and each deleted user's token revocation, is also
+// published so that the other instances sharing the database apply it. As a
+// repository.ChangeHandler it applies theirs, and drops everything it holds
+// whenever some may have been missed.
+//
+// The slices it returns are shared and must not be modified.
+type AuthzCache struct {
+	UserRepo        repository.UserRepository
+	RoleRepo        repository.RoleRepository
+	PermissionRepo  repository.PermissionRepository
+	PolicyRepo      repository.PolicyRepository
+	Publisher       repository.ChangePublisher
+	Revocations     *TokenRevocations
+	origin          string
+	userRoles       *cache.Cache[uint64, []repository.RoleAssignment]
+	rolePermissions *cache.Cache[uint64, []model.Permission]
+	policies        *cache.Cache[struct{}, []PolicyRule]
+}
+
+// AuthzCacheStats reports on the parts of an AuthzCache
+type AuthzCacheStats struct {
+	UserRoles       cache.Stats
+	RolePermissions cache.Stats
+	Policies        cache.Stats
+}
+
+// NewAuthzCache keeps entries for ttl, and at most maxUsers users' roles and
+// maxRoles roles' permissions. A zero ttl turns caching off.
+func NewAuthzCache(userRepo repository.UserRepository, roleRepo repository.RoleRepository, permissionRepo repository.PermissionRepository, policyRepo repository.PolicyRepository, publisher repository.ChangePublisher, revocations *TokenRevocations, ttl time.Duration, maxUsers int, maxRoles int) *AuthzCache {
+	return &AuthzCache{
+		UserRepo:        userRepo,
+		RoleRepo:        roleRepo,
+		PermissionRepo:  permissionRepo,
+		PolicyRepo:      policyRepo,
+		Publisher:       publisher,
+		Revocations:     revocations,
+		origin:          newInstanceID(),
+		userRoles:       cache.New[uint64, []repository.RoleAssignment](ttl, maxUsers),
+		rolePermissions: cache.New[uint64, []model.Permission](ttl, maxRoles),
+		policies:        cache.New[struct{}, []PolicyRule](ttl, 1),
+	}
+}
+
+// UserRoles returns the user's live global roles
+func (c *AuthzCache) UserRoles(ctx context.Context, userID uint64) ([]model.Role, error) {
+	if repository.InUnitOfWork(ctx) {
+		return c.RoleRepo.GetRolesByUserID(ctx, userID)
+	}
+	assignments, err := c.RoleAssignments(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return globalRoles(assignments), nil
+}
+
+// globalRoles picks the roles assigned without a scope
+func globalRoles(assignments []repository.RoleAssignment) []model.Role {
+	roles := []model.Role{}
+	for _, assignment := range assignments {
+		if assignment.Scope == "" {
+			roles = append(roles, assignment.Role)
+		}
+	}
+	return roles
+}
+
+// RoleAssignments returns the user's live roles in every scope
+func (c *AuthzCache) RoleAssignments(ctx context.Context, userID uint64) ([]repository.RoleAssignment, error) {
+	if repository.InUnitOfWork(ctx) {
+		return c.RoleRepo.GetRoleAssignments(ctx, userID)
+	}
+	if assignments, ok := c.userRoles.Get(userID); ok {
+		return assignments, nil
+	}
+	generation := c.userRoles.Generation()
+	assignments, err := c.RoleRepo.GetRoleAssignments(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	c.userRoles.Set(userID, assignments, generation)
+	return assignments, nil
+}
+
+// Policies returns every live policy whose condition parses, oldest first
+func (c *AuthzCache) Policies(ctx context.Context) ([]PolicyRule, error) {
+	if !repository.InUnitOfWork(ctx) {
+		if rules, ok := c.policies.Get(struct{}{}); ok {
+			return rules, nil
+		}
+	}
+	generation := c.policies.Generation()
+	policies, err := c.PolicyRepo.GetAllPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]PolicyRule, 0, len(policies))
+	for _, policy := range policies {
+		if rule, ok := compilePolicy(policy); ok {
+			rules = append(rules, rule)
+		}
+	}
+	if !repository.InUnitOfWork(ctx) {
+		c.policies.Set(struct{}{}, rules, generation)
+	}
+	return rules, nil
+}
+
+// Grants answers repository.GetGrants from the cache, loading whatever
+// users' roles and roles' permissions it lacks in one query each. Inside a
+// unit of work it asks the repository instead.
+func (c *AuthzCache) Grants(ctx context.Context, userIDs []uint64, permissionNames []string) ([]repository.Grant, error) {
+	if repository.InUnitOfWork(ctx) {
+		return c.PermissionRepo.GetGrants(ctx, userIDs, permissionNames)
+	}
+	assignments, err := c.roleAssignments(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	var roleIDs []uint64
+	for _, userID := range userIDs {
+		for _, assignment := range assignments[userID] {
+			roleIDs = append(roleIDs, assignment.Role.ID)
+		}
+	}
+	permissions, err := c.rolesPermissions(ctx, roleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(permissionNames))
+	for _, name := range permissionNames {
+		names[name] = true
+	}
+	var grants []repository.Grant
+	for _, userID := range userIDs {
+		for _, assignment := range assignments[userID] {
+			for _, permission := range permissions[assignment.Role.ID] {
+				if names[permission.PermissionName] {
+					grants = append(grants, repository.Grant{
+						UserID:         userID,
+						PermissionName: permission.PermissionName,
+						RoleID:         assignment.Role.ID,
+						RoleName:       assignment.Role.RoleName,
+						Scope:          assignment.Scope,
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(grants, func(i, j int) bool { return grants[i].RoleID < grants[j].RoleID })
+	return grants, nil
+}
+
+// roleAssignments returns the live roles of each of the users, loading those
+// not cached in one query
+func (c *AuthzCache) roleAssignments(ctx context.Context, userIDs []uint64) (map[uint64][]repository.RoleAssignment, error) {
+	assignments := make(map[uint64][]repository.RoleAssignment, len(userIDs))
+	var missing []uint64
+	for _, userID := range userIDs {
+		if cached, ok := c.userRoles.Get(userID); ok {
+			assignments[userID] = cached
+		} else {
+			missing = append(missing, userID)
+		}
+	}
+	if len(missing) == 0 {
+		return assignments, nil
+	}
+	generation := c.userRoles.Generation()
+	loaded, err := c.RoleRepo.GetRoleAssignmentsByUserIDs(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range missing {
+		userAssignments := loaded[userID]
+		if userAssignments == nil {
+			userAssignments = []repository.RoleAssignment{}
+		}
+		c.userRoles.Set(userID, userAssignments, generation)
+		assignments[userID] = userAssignments
+	}
+	return assignments, nil
+}
+
+// rolesPermissions returns the live permissions granted to each of the
+// roles, loading those not cached in one query
+func (c *AuthzCache) rolesPermissions(ctx context.Context, roleIDs []uint64) (map[uint64][]model.Permission, error) {
+	permissions := make(map[uint64][]model.Permission, len(roleIDs))
+	var missing []uint64
+	for _, roleID := range roleIDs {
+		if _, seen := permissions[roleID]; seen {
+			continue
+		}
+		if cached, ok := c.rolePermissions.Get(roleID); ok {
+			permissions[roleID] = cached
+		} else {
+			permissions[roleID] = nil
+			missing = append(missing, roleID)
+		}
+	}
+	if len(missing) == 0 {
+		return permissions, nil
+	}
+	generation := c.rolePermissions.Generation()
+	loaded, err := c.PermissionRepo.GetPermissionsByRoleIDs(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, roleID := range missing {
+		rolePermissions := loaded[roleID]
+		if rolePermissions == nil {
+			rolePermissions = []model.Permission{}
+		}
+		c.rolePermissions.Set(roleID, rolePermissions, generation)
+		permissions[roleID] = rolePermissions
+	}
+	return permissions, nil
+}
+
+// UsersRoles returns the live global roles of each of the users, loading
+// those not cached in one query
+func (c *AuthzCache) UsersRoles(ctx context.Context, userIDs []uint64) (map[uint64][]model.Role, error) {
+	var assignments map[uint64][]repository.RoleAssignment
+	var err error
+	if repository.InUnitOfWork(ctx) {
+		assignments, err = c.RoleRepo.GetRoleAssignmentsByUserIDs(ctx, userIDs)
+	} else {
+		assignments, err = c.roleAssignments(ctx, userIDs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[uint64][]model.Role, len(userIDs))
+	for _, userID := range userIDs {
+		roles[userID] = globalRoles(assignments[userID])
+	}
+	return roles, nil
+}
+
+// InvalidateUsers drops the cached roles of the users
+func (c *AuthzCache) InvalidateUsers(ctx context.Context, userIDs ...uint64) {
+	repository.AfterCommit(ctx, func() { c.userRoles.Delete(userIDs...) })
+	c.publish(ctx, repository.ChangeUserRoles, userIDs)
+}
+
+// InvalidateAllUsers drops every user's cached roles, as after a role is
+// renamed or deleted
+func (c *AuthzCache) InvalidateAllUsers(ctx context.Context) {
+	repository.AfterCommit(ctx, c.userRoles.Clear)
+	c.publish(ctx, repository.ChangeAllUserRoles, nil)
+}
+
+// InvalidateRoles drops the cached permissions of the roles
+func (c *AuthzCache) InvalidateRoles(ctx context.Context, roleIDs ...uint64) {
+	repository.AfterCommit(ctx, func() { c.rolePermissions.Delete(roleIDs...) })
+	c.publish(ctx, repository.ChangeRolePermissions, roleIDs)
+}
+
+// InvalidateAllRoles drops every role's cached permissions, as after a
+// permission is renamed or deleted
+func (c *AuthzCache) InvalidateAllRoles(ctx context.Context) {
+	repository.AfterCommit(ctx, c.rolePermissions.Clear)
+	c.publish(ctx, repository.ChangeAllRolePermissions, nil)
+}
+
+// InvalidatePolicies drops the cached policies
+func (c *AuthzCache) InvalidatePolicies(ctx context.Context) {
+	repository.AfterCommit(ctx, c.policies.Clear)
+	c.publish(ctx, repository.ChangePolicies, nil)
+}
+
+// RevokeUsers rejects the tokens issued so far to the deleted or deactivated
+// users and drops their cached roles
+func (c *AuthzCache) RevokeUsers(ctx context.Context, userIDs ...uint64) {
+	at := time.Now().Unix()
+	repository.AfterCommit(ctx, func() { c.revoke(userIDs, at) })
+	c.publish(ctx, repository.ChangeUsersDeleted, userIDs)
+}
+
+func (c *AuthzCache) revoke(userIDs []uint64, at int64) {
+	for _, id := range userIDs {
+		c.Revocations.Revoke(id, at)
+	}
+	c.userRoles.Delete(userIDs...)
+}
+
+// publish announces a change to the other instances. Inside a unit of work
+// the announcement is part of the transaction, so a failure to publish makes
+// the commit fail rather than leave other instances stale.
+func (c *AuthzCache) publish(ctx context.Context, kind string, ids []uint64) {
+	change := repository.Change{Origin: c.origin, Kind: kind, IDs: ids, At: time.Now().Unix()}
+	if err := c.Publisher.Publish(ctx, change); err != nil {
+		logs.Error("Error publishing authorization change "+kind, err)
+	}
+}
+
+// Apply handles a change published by another instance
+func (c *AuthzCache) Apply(change repository.Change) {
+	if change.Origin == c.origin {
+		return
+	}
+	switch change.Kind {
+	case repository.ChangeUserRoles:
+		c.userRoles.Delete(change.IDs...)
+	case repository.ChangeAllUserRoles:
+		c.userRoles.Clear()
+	case repository.ChangeRolePermissions:
+		c.rolePermissions.Delete(change.IDs...)
+	case repository.ChangeAllRolePermissions:
+		c.rolePermissions.Clear()
+	case repository.ChangeUsersDeleted:
+		c.revoke(change.IDs, change.At)
+	case repository.ChangePolicies:
+		c.policies.Clear()
+	default:
+		// A kind this version does not know may affect anything
+		logs.Warnf("Unknown authorization change %q, dropping the cache", change.Kind)
+		c.Resync()
+	}
+}
+
+// Resync drops every cached entry and reloads the token revocations of the
+// users deleted or deactivated recently enough to still hold live tokens. A
+// deactivated user's tokens are revoked up to its last update, since it can
+// get no new ones.
+func (c *AuthzCache) Resync() {
+	c.userRoles.Clear()
+	c.rolePermissions.Clear()
+	c.policies.Clear()
+
+	ctx := context.Background()
+	since := time.Now().Add(-c.Revocations.Retain())
+	deleted, err := c.UserRepo.ListDeletedUsers(ctx, since)
+	if err != nil {
+		logs.Error("Error loading deleted users for token revocation", err)
+	}
+	for _, user := range deleted {
+		c.Revocations.Revoke(user.ID, user.DeletedAt.Time.Unix())
+	}
+	deactivated, err := c.UserRepo.ListDeactivatedUsers(ctx, since)
+	if err != nil {
+		logs.Error("Error loading deactivated users for token revocation", err)
+	}
+	for _, user := range deactivated {
+		c.Revocations.Revoke(user.ID, user.UpdatedAt.Unix())
+	}
+}
+
+// ListenerError reports a problem with the connection changes arrive on
+func (c *AuthzCache) ListenerError(err error) {
+	logs.Error("Authorization change listener", err)
+}
+
+// Stats returns the hit, miss and eviction counts of every part
+func (c *AuthzCache) Stats() AuthzCacheStats {
+	return AuthzCacheStats{
+		UserRoles:       c.userRoles.Stats(),
+		RolePermissions: c.rolePermissions.Stats(),
+		Policies:        c.policies.Stats(),
+	}
+}
+
+// newInstanceID tells this process's changes apart from other instances'
+func newInstanceID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic("failed to generate instance id: " + err.Error())
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+)
+
+// racingRoles runs during each load of role assignments, as a write
+// committing while the load is in flight would
+type racingRoles struct {
+	repository.RoleRepository
+	during func()
+}
+
+func (r *racingRoles) GetRoleAssignmentsByUserIDs(ctx context.Context, userIDs []uint64) (map[uint64][]repository.RoleAssignment, error) {
+	assignments, err := r.RoleRepository.GetRoleAssignmentsByUserIDs(ctx, userIDs)
+	if r.during != nil {
+		r.during()
+	}
+	return assignments, err
+}
+
+// grantedNames returns the names of the permissions the user is granted
+// among names, as the cache answers
+func grantedNames(ctx context.Context, t *testing.T, c *AuthzCache, userID uint64, names ...string) []string {
+	t.Helper()
+	grants, err := c.Grants(ctx, []uint64{userID}, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted := []string{}
+	for _, grant := range grants {
+		granted = append(granted, grant.PermissionName)
+	}
+	return granted
+}
+
+func TestAuthzCacheHits(t *testing.T) {
+	r := newTestRepos()
+	alice := r.createUser(t, "alice")
+	bob := r.createUser(t, "bob")
+	r.grantPermission(t, alice.ID, "documents:read")
+	r.grantPermission(t, bob.ID, "documents:write")
+
+	for i := 0; i < 3; i++ {
+		grants, err := r.authzCache.Grants(context.Background(), []uint64{alice.ID, bob.ID}, []string{"documents:read", "documents:write"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(grants) != 2 || grants[0].UserID != alice.ID || grants[1].UserID != bob.ID {
+			t.Fatalf("grants are %+v, want documents:read for alice and documents:write for bob", grants)
+		}
+	}
+	stats := r.authzCache.Stats()
+	if stats.UserRoles.Misses != 2 || stats.UserRoles.Hits != 4 || stats.UserRoles.Entries != 2 {
+		t.Errorf("user roles: %+v, want each user missed once", stats.UserRoles)
+	}
+	if stats.RolePermissions.Misses != 2 || stats.RolePermissions.Hits != 4 || stats.RolePermissions.Entries != 2 {
+		t.Errorf("role permissions: %+v, want each role missed once", stats.RolePermissions)
+	}
+}
+
+func TestAuthzCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// change alters alice's grants behind the cache, then invalidates
+		change func(t *testing.T, r *testRepos, alice *model.User, role *model.Role)
+		want   string
+	}{
+		{"user's roles", func(t *testing.T, r *testRepos, alice *model.User, role *model.Role) {
+			if err := r.roles.RemoveUserRole(ctx, alice.ID, role.ID); err != nil {
+				t.Fatal(err)
+			}
+			r.authzCache.InvalidateUsers(ctx, alice.ID)
+		}, ""},
+		{"every user's roles", func(t *testing.T, r *testRepos, alice *model.User, role *model.Role) {
+			if err := r.roles.DeleteRole(ctx, role.ID); err != nil {
+				t.Fatal(err)
+			}
+			r.authzCache.InvalidateAllUsers(ctx)
+		}, ""},
+		{"role's permissions", func(t *testing.T, r *testRepos, alice *model.User, role *model.Role) {
+			write, err := r.permissions.CreatePermission(ctx, model.Permission{PermissionName: "documents:write"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.permissions.AssignPermissionToRole(ctx, role.ID, write.ID); err != nil {
+				t.Fatal(err)
+			}
+			r.authzCache.InvalidateRoles(ctx, role.ID)
+		}, "documents:read,documents:write"},
+		{"every role's permissions", func(t *testing.T, r *testRepos, alice *model.User, role *model.Role) {
+			permissions, err := r.permissions.GetPermissionsByRoleID(ctx, role.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.permissions.DeletePermission(ctx, permissions[0].ID); err != nil {
+				t.Fatal(err)
+			}
+			r.authzCache.InvalidateAllRoles(ctx)
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepos()
+			alice := r.createUser(t, "alice")
+			r.grantPermission(t, alice.ID, "documents:read")
+			role, err := r.roles.GetRoleByName(ctx, "documents:read holder")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := grantedNames(ctx, t, r.authzCache, alice.ID, "documents:read", "documents:write"); len(got) != 1 {
+				t.Fatalf("before the change alice holds %v, want documents:read", got)
+			}
+
+			tt.change(t, r, alice, role)
+			got := strings.Join(grantedNames(ctx, t, r.authzCache, alice.ID, "documents:read", "documents:write"), ",")
+			if got != tt.want {
+				t.Errorf("after the change alice holds %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthzCacheInvalidatesOnCommit(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	alice := r.createUser(t, "alice")
+	r.grantPermission(t, alice.ID, "documents:read")
+	role, err := r.roles.GetRoleByName(ctx, "documents:read holder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grantedNames(ctx, t, r.authzCache, alice.ID, "documents:read")
+
+	err = r.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		if err := r.roles.RemoveUserRole(ctx, alice.ID, role.ID); err != nil {
+			return err
+		}
+		r.authzCache.InvalidateUsers(ctx, alice.ID)
+		if got := grantedNames(ctx, t, r.authzCache, alice.ID, "documents:read"); len(got) != 0 {
+			t.Errorf("inside the unit of work alice holds %v, want nothing", got)
+		}
+		if stats := r.authzCache.Stats(); stats.UserRoles.Entries != 1 {
+			t.Errorf("the entry was dropped before the commit: %+v", stats.UserRoles)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := grantedNames(ctx, t, r.authzCache, alice.ID, "documents:read"); len(got) != 0 {
+		t.Errorf("after the commit alice holds %v, want nothing", got)
+	}
+}
+
+func TestAuthzCacheDropsLoadsRacingAnInvalidation(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	roles := &racingRoles{RoleRepository: r.roles}
+	r.authzCache.RoleRepo = roles
+	alice := r.createUser(t, "alice")
+	r.grantPermission(t, alice.ID, "documents:read")
+	role, err := r.roles.GetRoleByName(ctx, "documents:read holder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The role is taken away after the load read it but before it is stored
+	roles.during = func() {
+		roles.during = nil
+		if err := r.roles.RemoveUserRole(ctx, alice.ID, role.ID); err != nil {
+			t.Fatal(err)
+		}
+		r.authzCache.InvalidateUsers(ctx, alice.ID)
+	}
+	if got := grantedNames(ctx, t, r.authzCache, alice.ID, "documents:read"); len(got) != 1 {
+		t.Fatalf("the racing load holds %v, want what it read", got)
+	}
+	if stats := r.authzCache.Stats(); stats.UserRoles.Entries != 0 {
+		t.Fatalf("the stale load was stored: %+v", stats.UserRoles)
+	}
+	if got := grantedNames(ctx, t, r.authzCache, alice.ID, "documents:read"); len(got) != 0 {
+		t.Errorf("the next check holds %v, want nothing", got)
+	}
+}
+
+// revoked reports whether the cache rejects a token issued to the user at
+func revoked(c *AuthzCache, userID uint64, at time.Time) bool {
+	return c.Revocations.Revoked(&Claims{UserID: strconv.FormatUint(userID, 10), IssuedAt: at.Unix()})
+}
+
+func TestAuthzCacheApply(t *testing.T) {
+	ctx := context.Background()
+	issued := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name   string
+		change func(c *AuthzCache, alice, bob uint64, role uint64) repository.Change
+		// wantAlice and wantBob are whether each user's roles stay cached
+		wantAlice, wantBob bool
+		wantRole           bool
+		wantRevoked        bool
+	}{
+		{"own change", func(c *AuthzCache, alice, bob, role uint64) repository.Change {
+			return repository.Change{Origin: c.origin, Kind: repository.ChangeAllUserRoles}
+		}, true, true, true, false},
+		{"user roles", func(c *AuthzCache, alice, bob, role uint64) repository.Change {
+			return repository.Change{Origin: "other", Kind: repository.ChangeUserRoles, IDs: []uint64{alice}}
+		}, false, true, true, false},
+		{"all user roles", func(c *AuthzCache, alice, bob, role uint64) repository.Change {
+			return repository.Change{Origin: "other", Kind: repository.ChangeAllUserRoles}
+		}, false, false, true, false},
+		{"role permissions", func(c *AuthzCache, alice, bob, role uint64) repository.Change {
+			return repository.Change{Origin: "other", Kind: repository.ChangeRolePermissions, IDs: []uint64{role}}
+		}, true, true, false, false},
+		{"users deleted", func(c *AuthzCache, alice, bob, role uint64) repository.Change {
+			return repository.Change{Origin: "other", Kind: repository.ChangeUsersDeleted, IDs: []uint64{alice}, At: time.Now().Unix()}
+		}, false, true, true, true},
+		{"unknown kind", func(c *AuthzCache, alice, bob, role uint64) repository.Change {
+			return repository.Change{Origin: "other", Kind: "from_a_newer_version"}
+		}, false, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepos()
+			alice := r.createUser(t, "alice")
+			bob := r.createUser(t, "bob")
+			r.grantPermission(t, alice.ID, "documents:read")
+			role, err := r.roles.GetRoleByName(ctx, "documents:read holder")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.roles.AddUserRole(ctx, bob.ID, role.ID); err != nil {
+				t.Fatal(err)
+			}
+			grantedNames(ctx, t, r.authzCache, alice.ID, "documents:read")
+			grantedNames(ctx, t, r.authzCache, bob.ID, "documents:read")
+
+			r.authzCache.Apply(tt.change(r.authzCache, alice.ID, bob.ID, role.ID))
+			_, aliceCached := r.authzCache.userRoles.Get(alice.ID)
+			_, bobCached := r.authzCache.userRoles.Get(bob.ID)
+			_, roleCached := r.authzCache.rolePermissions.Get(role.ID)
+			if aliceCached != tt.wantAlice || bobCached != tt.wantBob || roleCached != tt.wantRole {
+				t.Errorf("cached alice %v, bob %v, role %v; want %v, %v, %v", aliceCached, bobCached, roleCached, tt.wantAlice, tt.wantBob, tt.wantRole)
+			}
+			if got := revoked(r.authzCache, alice.ID, issued); got != tt.wantRevoked {
+				t.Errorf("alice's token revoked %v, want %v", got, tt.wantRevoked)
+			}
+			if revoked(r.authzCache, bob.ID, issued) {
+				t.Error("bob's token was revoked")
+			}
+		})
+	}
+}
+
+func TestAuthzCacheResync(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	alice := r.createUser(t, "alice")
+	bob := r.createUser(t, "bob")
+	carol := r.createUser(t, "carol")
+	r.grantPermission(t, carol.ID, "documents:read")
+	grantedNames(ctx, t, r.authzCache, carol.ID, "documents:read")
+	issued := time.Now().Add(-time.Minute)
+
+	// Behind the cache's back, as on another instance whose changes were missed
+	if err := r.users.DeleteUser(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	bob.Active = false
+	if err := r.users.UpdateUser(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	r.authzCache.Resync()
+	if stats := r.authzCache.Stats(); stats.UserRoles.Entries != 0 || stats.RolePermissions.Entries != 0 {
+		t.Errorf("entries survived: %+v", stats)
+	}
+	for _, tt := range []struct {
+		name   string
+		userID uint64
+		want   bool
+	}{
+		{"deleted", alice.ID, true},
+		{"deactivated", bob.ID, true},
+		{"active", carol.ID, false},
+	} {
+		if got := revoked(r.authzCache, tt.userID, issued); got != tt.want {
+			t.Errorf("%s user's token revoked %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if revoked(r.authzCache, bob.ID, time.Now().Add(time.Minute)) {
+		t.Error("a token issued after the deactivation was revoked")
+	}
+}
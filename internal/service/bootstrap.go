@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+// AdminRole is the role BootstrapAdmins gives the configured administrators
+const AdminRole = "admin"
+
+// AllPermissions grants every permission
+const AllPermissions = WildcardPermissionPart + ":" + WildcardPermissionPart
+
+// BootstrapAdmins makes sure the admin role exists and holds every
+// permission, and gives it to each named user that exists. Changing roles and
+// permissions takes a permission itself, so this is how the first
+// administrators get one. Users that do not exist yet are logged and skipped.
+func BootstrapAdmins(ctx context.Context, userRepo repository.UserRepository, roleRepo repository.RoleRepository, permissionRepo repository.PermissionRepository, authzCache *AuthzCache, usernames []string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	role, err := roleRepo.GetRoleByName(ctx, AdminRole)
+	if errors.Is(err, repository.ErrNotFound) {
+		role, err = roleRepo.CreateRole(ctx, &model.Role{RoleName: AdminRole})
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := grantAllPermissions(ctx, permissionRepo, role.ID); err != nil {
+		return err
+	}
+	authzCache.InvalidateRoles(ctx, role.ID)
+
+	for _, username := range usernames {
+		user, err := userRepo.GetUserByUsername(ctx, username)
+		if errors.Is(err, repository.ErrNotFound) {
+			logs.Warnf("bootstrap admin %s does not exist", username)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		hasRole, err := roleRepo.UserHasRole(ctx, user.ID, AdminRole)
+		if err != nil {
+			return err
+		}
+		if hasRole {
+			continue
+		}
+		if err := roleRepo.AddUserRole(ctx, user.ID, role.ID); err != nil {
+			return err
+		}
+		authzCache.InvalidateUsers(ctx, user.ID)
+	}
+	return nil
+}
+
+// grantAllPermissions grants the role *:*, creating that permission if needed
+func grantAllPermissions(ctx context.Context, permissionRepo repository.PermissionRepository, roleID uint64) error {
+	granted, err := permissionRepo.GetPermissionsByRoleID(ctx, roleID)
+	if err != nil {
+		return err
+	}
+	for _, permission := range granted {
+		if permission.PermissionName == AllPermissions {
+			return nil
+		}
+	}
+
+	permissions, err := permissionRepo.GetAllPermissions(ctx)
+	if err != nil {
+		return err
+	}
+	var all *model.Permission
+	for i := range permissions {
+		if permissions[i].PermissionName == AllPermissions {
+			all = &permissions[i]
+		}
+	}
+	if all == nil {
+		created, err := permissionRepo.CreatePermission(ctx, model.Permission{PermissionName: AllPermissions})
+		if err != nil {
+			return err
+		}
+		all = &created
+	}
+	return permissionRepo.AssignPermissionToRole(ctx, roleID, all.ID)
+}
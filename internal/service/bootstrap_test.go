@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+)
+
+// testRepos is one memory store's repositories and an authorization cache on them
+type testRepos struct {
+	users       repository.UserRepository
+	roles       repository.RoleRepository
+	permissions repository.PermissionRepository
+	identities  repository.IdentityRepository
+	policies    repository.PolicyRepository
+	unitOfWork  repository.UnitOfWork
+	authzCache  *AuthzCache
+}
+
+func newTestRepos() *testRepos {
+	store := repository.NewMemoryStore()
+	r := &testRepos{
+		users:       repository.NewMemoryUserRepository(store),
+		roles:       repository.NewMemoryRoleRepository(store),
+		permissions: repository.NewMemoryPermissionRepository(store),
+		identities:  repository.NewMemoryIdentityRepository(store),
+		policies:    repository.NewMemoryPolicyRepository(store),
+		unitOfWork:  repository.NewMemoryUnitOfWork(store),
+	}
+	r.authzCache = NewAuthzCache(r.users, r.roles, r.permissions, r.policies, repository.NewNopPublisher(), NewTokenRevocations(time.Minute), time.Minute, 100, 100)
+	return r
+}
+
+func (r *testRepos) createUser(t *testing.T, username string) *model.User {
+	t.Helper()
+	user := &model.User{Username: username, Email: username + "@example.com", PasswordHash: "hash"}
+	if err := r.users.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func (r *testRepos) roleNames(t *testing.T, userID uint64) []string {
+	t.Helper()
+	roles, err := r.roles.GetRolesByUserID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = role.RoleName
+	}
+	return names
+}
+
+func TestBootstrapAdmins(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	alice := r.createUser(t, "alice")
+
+	// The second run finds the role, grant and assignment the first one made
+	for run := 1; run <= 2; run++ {
+		if err := BootstrapAdmins(ctx, r.users, r.roles, r.permissions, r.authzCache, []string{"alice", "nobody"}); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+
+	if names := r.roleNames(t, alice.ID); len(names) != 1 || names[0] != AdminRole {
+		t.Errorf("alice has roles %v, want [%s]", names, AdminRole)
+	}
+	role, err := r.roles.GetRoleByName(ctx, AdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted, err := r.permissions.GetPermissionsByRoleID(ctx, role.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(granted) != 1 || granted[0].PermissionName != AllPermissions {
+		t.Errorf("admin holds %v, want only %s", granted, AllPermissions)
+	}
+}
+
+func TestGrantRolesByName(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		granted  []string
+		want     []string
+	}{
+		{"existing roles", []string{"staff", "viewer"}, []string{"staff", "viewer"}, []string{"staff", "viewer"}},
+		{"missing role is skipped", []string{"staff"}, []string{"ghost", "staff"}, []string{"staff"}},
+		{"no role exists", nil, []string{"ghost"}, []string{}},
+		{"held role is kept once", []string{"staff"}, []string{"staff", "staff"}, []string{"staff"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := newTestRepos()
+			for _, name := range tt.existing {
+				if _, err := r.roles.CreateRole(ctx, &model.Role{RoleName: name}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			user := r.createUser(t, "alice")
+
+			grantRolesByName(ctx, r.roles, r.authzCache, user.ID, tt.granted)
+
+			got := r.roleNames(t, user.ID)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got roles %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got roles %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// FederationService signs users in through upstream identity providers,
+// provisioning or linking local users and applying claim-to-role mappings.
+type FederationService struct {
+	UserRepo     repository.UserRepository
+	RoleRepo     repository.RoleRepository
+	IdentityRepo repository.IdentityRepository
+	AuthzCache   *AuthzCache
+	connectors   map[string]Connector
+	roleMappings map[string][]config.ClaimRoleMapping
+}
+
+func NewFederationService(userRepo repository.UserRepository, roleRepo repository.RoleRepository, identityRepo repository.IdentityRepository, authzCache *AuthzCache) *FederationService {
+	return &FederationService{
+		UserRepo:     userRepo,
+		RoleRepo:     roleRepo,
+		IdentityRepo: identityRepo,
+		AuthzCache:   authzCache,
+		connectors:   map[string]Connector{},
+		roleMappings: map[string][]config.ClaimRoleMapping{},
+	}
+}
+
+// RegisterConnector makes a provider available for login under its ID
+func (s *FederationService) RegisterConnector(connector Connector, mappings []config.ClaimRoleMapping) {
+	s.connectors[connector.ID()] = connector
+	s.roleMappings[connector.ID()] = mappings
+}
+
+func (s *FederationService) Connector(provider string) (Connector, bool) {
+	connector, ok := s.connectors[provider]
+	return connector, ok
+}
+
+// Login completes an authorization code flow and returns the local user,
+// along with the authentication methods and time the provider asserted.
+func (s *FederationService) Login(ctx context.Context, provider string, code string, nonce string) (*Authentication, error) {
+	connector, ok := s.Connector(provider)
+	if !ok {
+		return nil, errors.NewAppErrorf(errors.CodeNotFound, "Unknown identity provider %s", provider)
+	}
+
+	identity, err := connector.Exchange(ctx, code, nonce)
+	if err != nil {
+		logs.Error(fmt.Sprintf("Federated login through %s failed", provider), err)
+		return nil, errors.WrapAppError(errors.CodeUnauthorized, err, "Federated login failed")
+	}
+
+	user, err := s.resolveUser(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Active {
+		return nil, ErrUserInactive
+	}
+
+	s.applyRoleMappings(ctx, user, identity)
+
+	auth := &Authentication{
+		User:    user,
+		Methods: append([]string{MethodFederated}, identity.Methods...),
+	}
+	if identity.AuthTime > 0 {
+		auth.AuthTime = time.Unix(identity.AuthTime, 0)
+	}
+	return auth, nil
+}
+
+// resolveUser finds the user linked to the identity, links an existing user
+// with the same verified email, or provisions a new user.
+func (s *FederationService) resolveUser(ctx context.Context, identity *ExternalIdentity) (*model.User, error) {
+	link, err := s.IdentityRepo.GetIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		logs.Error("Error fetching linked identity", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+	if link != nil {
+		user, err := s.UserRepo.GetUserByID(ctx, link.UserID)
+		if err != nil {
+			logs.Error("Error fetching linked user", err)
+			return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+		}
+		return user, nil
+	}
+
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+	if email == "" || !identity.EmailVerified {
+		logs.Error(fmt.Sprintf("Identity %s at %s has no verified email", identity.Subject, identity.Provider))
+		return nil, errors.NewAppError(errors.CodeUnauthorized, "Identity provider did not assert a verified email")
+	}
+
+	user, err := s.UserRepo.GetUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		logs.Error("Error fetching user by email", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	if user == nil {
+		user, err = s.provisionUser(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	link = &model.UserIdentity{
+		UserID:   user.ID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    email,
+	}
+	if err := s.IdentityRepo.CreateIdentity(ctx, link); err != nil {
+		logs.Error("Error linking identity", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	return user, nil
+}
+
+// provisionUser creates a local user that can only sign in through federation
+func (s *FederationService) provisionUser(ctx context.Context, email string) (*model.User, error) {
+	passwordHash, err := unusablePasswordHash()
+	if err != nil {
+		logs.Error("Error generating password hash", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	user := &model.User{
+		Username:     email,
+		Email:        email,
+		PasswordHash: passwordHash,
+	}
+	if err := s.UserRepo.CreateUser(ctx, user); err != nil {
+		logs.Error("Error provisioning federated user", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	logs.Infof("Provisioned user %d from federated login", user.ID)
+	return user, nil
+}
+
+// applyRoleMappings assigns the roles whose claim rule matches the identity
+func (s *FederationService) applyRoleMappings(ctx context.Context, user *model.User, identity *ExternalIdentity) {
+	var roleNames []string
+	for _, mapping := range s.roleMappings[identity.Provider] {
+		if claimMatches(identity.Claims[mapping.Claim], mapping.Value) {
+			roleNames = append(roleNames, mapping.Role)
+		}
+	}
+	grantRolesByName(ctx, s.RoleRepo, s.AuthzCache, user.ID, roleNames)
+}
+
+// claimMatches reports whether a string claim equals value or a list claim contains it
+func claimMatches(claim interface{}, value string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == value
+	case bool:
+		return fmt.Sprint(v) == value
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unusablePasswordHash hashes random bytes nobody knows, so password login stays closed
+func unusablePasswordHash() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(secret)), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
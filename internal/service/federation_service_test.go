@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+)
+
+func TestFederatedLogin(t *testing.T) {
+	mappings := []config.ClaimRoleMapping{
+		{Claim: "groups", Value: "engineering", Role: "staff"},
+		{Claim: "department", Value: "finance", Role: "auditor"},
+		{Claim: "groups", Value: "ghosts", Role: "missing"},
+	}
+
+	tests := []struct {
+		name string
+		// existing users are created before the login, alice's email
+		// belonging to alice
+		existing []string
+		// linked links subject-1 to the existing user of that name
+		linked    string
+		inactive  bool
+		claims    map[string]interface{}
+		wantUser  string
+		wantRoles []string
+		wantErr   int
+	}{
+		{
+			name:     "new user is provisioned",
+			claims:   map[string]interface{}{"email": "Alice@Example.com", "email_verified": true},
+			wantUser: "alice@example.com",
+		},
+		{
+			name:     "existing email is linked",
+			existing: []string{"alice"},
+			claims:   map[string]interface{}{"email": "alice@example.com", "email_verified": true},
+			wantUser: "alice",
+		},
+		{
+			name:     "linked identity wins over the email",
+			existing: []string{"alice", "bob"},
+			linked:   "bob",
+			claims:   map[string]interface{}{"email": "alice@example.com", "email_verified": true},
+			wantUser: "bob",
+		},
+		{
+			name:     "linked identity needs no email",
+			existing: []string{"bob"},
+			linked:   "bob",
+			claims:   map[string]interface{}{},
+			wantUser: "bob",
+		},
+		{
+			name:     "unverified email is refused",
+			existing: []string{"alice"},
+			claims:   map[string]interface{}{"email": "alice@example.com", "email_verified": false},
+			wantErr:  errors.CodeUnauthorized,
+		},
+		{
+			name:     "inactive user is refused",
+			existing: []string{"alice"},
+			inactive: true,
+			claims:   map[string]interface{}{"email": "alice@example.com", "email_verified": true},
+			wantErr:  errors.CodeForbidden,
+		},
+		{
+			name: "claims map to roles",
+			claims: map[string]interface{}{
+				"email": "alice@example.com", "email_verified": true,
+				"groups": []string{"engineering", "ghosts"}, "department": "finance",
+			},
+			wantUser:  "alice@example.com",
+			wantRoles: []string{"auditor", "staff"},
+		},
+		{
+			name: "unmatched claims map to no roles",
+			claims: map[string]interface{}{
+				"email": "alice@example.com", "email_verified": true,
+				"groups": "engineering-interns", "department": []string{"sales"},
+			},
+			wantUser: "alice@example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := newTestRepos()
+			provider, connector := newTestConnector(t)
+			s := NewFederationService(r.users, r.roles, r.identities, r.authzCache)
+			s.RegisterConnector(connector, mappings)
+			for _, name := range []string{"staff", "auditor"} {
+				if _, err := r.roles.CreateRole(ctx, &model.Role{RoleName: name}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			users := map[string]*model.User{}
+			for _, name := range tt.existing {
+				users[name] = r.createUser(t, name)
+			}
+			if tt.linked != "" {
+				link := &model.UserIdentity{UserID: users[tt.linked].ID, Provider: "stub", Subject: "subject-1"}
+				if err := r.identities.CreateIdentity(ctx, link); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.inactive {
+				for _, user := range users {
+					user.Active = false
+					if err := r.users.UpdateUser(ctx, user); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			claims := map[string]interface{}{"sub": "subject-1", "nonce": "the-nonce"}
+			for name, value := range tt.claims {
+				claims[name] = value
+			}
+			code, err := provider.IssueCode(testClientID, claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			auth, err := s.Login(ctx, "stub", code, "the-nonce")
+			if tt.wantErr != 0 {
+				var appErr *errors.AppError
+				if !errors.As(err, &appErr) || appErr.Code != tt.wantErr {
+					t.Fatalf("got %v, want a %d error", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if auth.User.Username != tt.wantUser {
+				t.Errorf("signed in as %s, want %s", auth.User.Username, tt.wantUser)
+			}
+			if existing, ok := users[tt.wantUser]; ok && auth.User.ID != existing.ID {
+				t.Errorf("signed in as user %d, want the existing user %d", auth.User.ID, existing.ID)
+			}
+			if len(auth.Methods) == 0 || auth.Methods[0] != MethodFederated {
+				t.Errorf("methods are %v, want %s first", auth.Methods, MethodFederated)
+			}
+
+			link, err := r.identities.GetIdentity(ctx, "stub", "subject-1")
+			if err != nil {
+				t.Fatalf("the identity is not linked: %v", err)
+			}
+			if link.UserID != auth.User.ID {
+				t.Errorf("the identity is linked to user %d, want %d", link.UserID, auth.User.ID)
+			}
+
+			roles := r.roleNames(t, auth.User.ID)
+			sort.Strings(roles)
+			if len(roles) != 0 || len(tt.wantRoles) != 0 {
+				if !reflect.DeepEqual(roles, tt.wantRoles) {
+					t.Errorf("got roles %v, want %v", roles, tt.wantRoles)
+				}
+			}
+		})
+	}
+}
+
+func TestFederatedLoginProvisionsOnce(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	provider, connector := newTestConnector(t)
+	s := NewFederationService(r.users, r.roles, r.identities, r.authzCache)
+	s.RegisterConnector(connector, nil)
+
+	var first uint64
+	for run := 1; run <= 2; run++ {
+		code, err := provider.IssueCode(testClientID, map[string]interface{}{
+			"sub": "subject-1", "nonce": "n", "email": "alice@example.com", "email_verified": true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, err := s.Login(ctx, "stub", code, "n")
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		if run == 1 {
+			first = auth.User.ID
+		} else if auth.User.ID != first {
+			t.Errorf("the second login signed in as user %d, want %d", auth.User.ID, first)
+		}
+	}
+
+	if count, err := r.users.CountUsers(ctx); err != nil || count != 1 {
+		t.Errorf("got %d users (%v), want 1", count, err)
+	}
+}
+
+func TestFederatedLoginFailures(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	provider, connector := newTestConnector(t)
+	s := NewFederationService(r.users, r.roles, r.identities, r.authzCache)
+	s.RegisterConnector(connector, nil)
+
+	code, err := provider.IssueCode(testClientID, map[string]interface{}{
+		"sub": "subject-1", "nonce": "the-nonce", "email": "alice@example.com", "email_verified": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		code     string
+		nonce    string
+		want     int
+	}{
+		{"unknown provider", "other", code, "the-nonce", errors.CodeNotFound},
+		{"nonce of another login", "stub", code, "other-nonce", errors.CodeUnauthorized},
+		{"unknown code", "stub", "unknown", "the-nonce", errors.CodeUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Login(ctx, tt.provider, tt.code, tt.nonce)
+			var appErr *errors.AppError
+			if !errors.As(err, &appErr) || appErr.Code != tt.want {
+				t.Errorf("got %v, want a %d error", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := r.users.GetUserByEmail(ctx, "alice@example.com"); err == nil {
+		t.Error("a failed login provisioned a user")
+	}
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+// LDAPAuthenticator verifies passwords by binding to an LDAP server as the user
+type LDAPAuthenticator struct {
+	UserRepo   repository.UserRepository
+	RoleRepo   repository.RoleRepository
+	AuthzCache *AuthzCache
+	cfg        config.LDAP
+}
+
+// NewLDAPAuthenticator fills in the OpenLDAP defaults for any attribute or
+// filter left empty. The server is only contacted on login, so any LDAP
+// server reachable at cfg.URL, including an in-process test server, will do.
+func NewLDAPAuthenticator(cfg config.LDAP, userRepo repository.UserRepository, roleRepo repository.RoleRepository, authzCache *AuthzCache) *LDAPAuthenticator {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.UsernameAttribute == "" {
+		cfg.UsernameAttribute = "uid"
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = "mail"
+	}
+	if cfg.GroupFilter == "" {
+		cfg.GroupFilter = "(member=%s)"
+	}
+
+	return &LDAPAuthenticator{
+		UserRepo:   userRepo,
+		RoleRepo:   roleRepo,
+		AuthzCache: authzCache,
+		cfg:        cfg,
+	}
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, username string, password string) (*Authentication, error) {
+	// An empty password would be an unauthenticated bind, which most servers accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		logs.Error("error connecting to ldap", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+	defer conn.Close()
+
+	if err := a.bindServiceAccount(conn); err != nil {
+		logs.Error("error binding ldap service account", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	entry, err := a.findUser(conn, username)
+	if err != nil {
+		logs.Error("error searching ldap user", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+	if entry == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		logs.Error("error binding ldap user", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	// Group searches run as the service account, not the user
+	if err := a.bindServiceAccount(conn); err != nil {
+		logs.Error("error binding ldap service account", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	groups, err := a.groupsOf(conn, entry)
+	if err != nil {
+		logs.Error("error searching ldap groups", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	auth := &Authentication{
+		User: &model.User{
+			Username: entry.GetAttributeValue(a.cfg.UsernameAttribute),
+			Email:    strings.ToLower(strings.TrimSpace(entry.GetAttributeValue(a.cfg.EmailAttribute))),
+		},
+		Roles:   a.mapRoles(groups),
+		Methods: []string{MethodLDAP},
+	}
+	if auth.User.Username == "" {
+		auth.User.Username = username
+	}
+
+	if a.cfg.ShadowUsers {
+		if err := a.shadowUser(ctx, auth); err != nil {
+			return nil, err
+		}
+	}
+
+	return auth, nil
+}
+
+// dial connects to the directory. The ldap client cannot watch a context, so
+// the context's deadline bounds the connection and each request instead.
+func (a *LDAPAuthenticator) dial(ctx context.Context) (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.cfg.InsecureSkipVerify}
+	dialer := &net.Dialer{}
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		dialer.Deadline = deadline
+	}
+
+	conn, err := ldap.DialURL(a.cfg.URL, ldap.DialWithTLSConfig(tlsConfig), ldap.DialWithDialer(dialer))
+	if err != nil {
+		return nil, err
+	}
+	if hasDeadline {
+		conn.SetTimeout(time.Until(deadline))
+	}
+
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (a *LDAPAuthenticator) bindServiceAccount(conn *ldap.Conn) error {
+	if a.cfg.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(a.cfg.BindDN, a.cfg.BindPassword)
+}
+
+// findUser returns nil when no entry, or more than one, matches the username
+func (a *LDAPAuthenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		a.cfg.UserBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", a.cfg.UsernameAttribute, a.cfg.EmailAttribute, "memberOf"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		logs.Warnf("ldap user filter matched more than one entry for %s", username)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, nil
+	}
+	return result.Entries[0], nil
+}
+
+// groupsOf collects the DNs of the user's groups, from memberOf as on
+// Active Directory and from a group search when a group base DN is set.
+func (a *LDAPAuthenticator) groupsOf(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	groups := entry.GetAttributeValues("memberOf")
+
+	if a.cfg.GroupBaseDN == "" {
+		return groups, nil
+	}
+
+	request := ldap.NewSearchRequest(
+		a.cfg.GroupBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(a.cfg.GroupFilter, ldap.EscapeFilter(entry.DN)),
+		[]string{"dn"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range result.Entries {
+		groups = append(groups, group.DN)
+	}
+	return groups, nil
+}
+
+// mapRoles translates group DNs into role names; DNs compare case-insensitively
+func (a *LDAPAuthenticator) mapRoles(groups []string) []string {
+	var roles []string
+	for _, mapping := range a.cfg.GroupRoleMappings {
+		for _, group := range groups {
+			if sameDN(group, mapping.GroupDN) {
+				roles = append(roles, mapping.Role)
+				break
+			}
+		}
+	}
+	return roles
+}
+
+func sameDN(a string, b string) bool {
+	dnA, errA := ldap.ParseDN(a)
+	dnB, errB := ldap.ParseDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return dnA.EqualFold(dnB)
+}
+
+// shadowUser creates or refreshes the local copy of the directory user, matched
+// by email, and grants it the mapped roles. A deactivated copy refuses the login.
+func (a *LDAPAuthenticator) shadowUser(ctx context.Context, auth *Authentication) error {
+	if auth.User.Email == "" {
+		logs.Error(fmt.Sprintf("ldap user %s has no %s attribute to shadow by", auth.User.Username, a.cfg.EmailAttribute))
+		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+	}
+
+	user, err := a.UserRepo.GetUserByEmail(ctx, auth.User.Email)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		logs.Error("error fetching user by email", err)
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	if user == nil {
+		passwordHash, err := unusablePasswordHash()
+		if err != nil {
+			logs.Error("error generating password hash", err)
+			return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+		}
+		user = &model.User{
+			Username:     auth.User.Username,
+			Email:        auth.User.Email,
+			PasswordHash: passwordHash,
+		}
+		if err := a.UserRepo.CreateUser(ctx, user); err != nil {
+			logs.Error("error creating shadow user", err)
+			return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+		}
+	} else if !user.Active {
+		return ErrUserInactive
+	} else if user.Username != auth.User.Username {
+		user.Username = auth.User.Username
+		if err := a.UserRepo.UpdateUser(ctx, user); err != nil {
+			logs.Error("error updating shadow user", err)
+			return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+		}
+	}
+
+	grantRolesByName(ctx, a.RoleRepo, a.AuthzCache, user.ID, auth.Roles)
+
+	auth.User = user
+	return nil
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service/ldaptest"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+)
+
+const serviceAccountDN = "cn=ums,dc=example,dc=com"
+
+// newTestDirectory serves alice, a member of admins through memberOf; bob, a
+// member of engineering through the group's member attribute; carol, who has
+// no email; and two entries both named twin
+func newTestDirectory(t *testing.T) *ldaptest.Server {
+	t.Helper()
+	server, err := ldaptest.NewServer(
+		ldaptest.Entry{DN: serviceAccountDN, Password: "service-secret"},
+		ldaptest.Entry{DN: "uid=alice,ou=people,dc=example,dc=com", Password: "alice-secret", Attributes: map[string][]string{
+			"uid": {"alice"}, "mail": {"Alice@Example.com"}, "memberOf": {"cn=admins,ou=groups,dc=example,dc=com"},
+		}},
+		ldaptest.Entry{DN: "uid=bob,ou=people,dc=example,dc=com", Password: "bob-secret", Attributes: map[string][]string{
+			"uid": {"bob"}, "mail": {"bob@example.com"},
+		}},
+		ldaptest.Entry{DN: "uid=carol,ou=people,dc=example,dc=com", Password: "carol-secret", Attributes: map[string][]string{
+			"uid": {"carol"},
+		}},
+		ldaptest.Entry{DN: "uid=twin,ou=people,dc=example,dc=com", Password: "twin-secret", Attributes: map[string][]string{
+			"uid": {"twin"}, "mail": {"twin@example.com"},
+		}},
+		ldaptest.Entry{DN: "uid=twin,ou=contractors,ou=people,dc=example,dc=com", Password: "twin-secret", Attributes: map[string][]string{
+			"uid": {"twin"}, "mail": {"twin@contractors.example.com"},
+		}},
+		ldaptest.Entry{DN: "cn=engineering,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+			"cn": {"engineering"}, "member": {"uid=bob,ou=people,dc=example,dc=com"},
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testLDAPConfig binds as the service account and maps both groups, the
+// mappings spelling the DNs differently from the directory
+func testLDAPConfig(url string, shadowUsers bool) config.LDAP {
+	return config.LDAP{
+		URL:          url,
+		BindDN:       serviceAccountDN,
+		BindPassword: "service-secret",
+		UserBaseDN:   "ou=people,dc=example,dc=com",
+		GroupBaseDN:  "ou=groups,dc=example,dc=com",
+		GroupRoleMappings: []config.GroupRoleMapping{
+			{GroupDN: "CN=Admins,OU=Groups,DC=example,DC=com", Role: "admin"},
+			{GroupDN: "cn=engineering, ou=groups, dc=example, dc=com", Role: "staff"},
+			{GroupDN: "cn=finance,ou=groups,dc=example,dc=com", Role: "auditor"},
+		},
+		ShadowUsers: shadowUsers,
+	}
+}
+
+// appErrorCode is the code of the AppError err wraps, or 0 when there is none
+func appErrorCode(err error) int {
+	var appErr *errors.AppError
+	if !errors.As(err, &appErr) {
+		return 0
+	}
+	return appErr.Code
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	directory := newTestDirectory(t)
+	r := newTestRepos()
+
+	tests := []struct {
+		name      string
+		cfg       config.LDAP
+		username  string
+		password  string
+		wantUser  *model.User
+		wantRoles []string
+		wantErr   error
+		wantCode  int
+	}{
+		{
+			name:      "member through memberOf",
+			cfg:       testLDAPConfig(directory.URL(), false),
+			username:  "alice",
+			password:  "alice-secret",
+			wantUser:  &model.User{Username: "alice", Email: "alice@example.com"},
+			wantRoles: []string{"admin"},
+		},
+		{
+			name:      "member through a group search",
+			cfg:       testLDAPConfig(directory.URL(), false),
+			username:  "bob",
+			password:  "bob-secret",
+			wantUser:  &model.User{Username: "bob", Email: "bob@example.com"},
+			wantRoles: []string{"staff"},
+		},
+		{name: "wrong password", cfg: testLDAPConfig(directory.URL(), false), username: "alice", password: "bob-secret", wantErr: ErrInvalidCredentials},
+		{name: "unknown user", cfg: testLDAPConfig(directory.URL(), false), username: "mallory", password: "alice-secret", wantErr: ErrInvalidCredentials},
+		{name: "empty password", cfg: testLDAPConfig(directory.URL(), false), username: "alice", password: "", wantErr: ErrInvalidCredentials},
+		{name: "filter injection", cfg: testLDAPConfig(directory.URL(), false), username: "*", password: "alice-secret", wantErr: ErrInvalidCredentials},
+		{name: "ambiguous username", cfg: testLDAPConfig(directory.URL(), false), username: "twin", password: "twin-secret", wantErr: ErrInvalidCredentials},
+		{
+			name: "service account refused",
+			cfg: func() config.LDAP {
+				cfg := testLDAPConfig(directory.URL(), false)
+				cfg.BindPassword = "wrong"
+				return cfg
+			}(),
+			username: "alice",
+			password: "alice-secret",
+			wantCode: errors.CodeInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewLDAPAuthenticator(tt.cfg, r.users, r.roles, r.authzCache)
+			auth, err := a.Authenticate(context.Background(), tt.username, tt.password)
+			if tt.wantErr != nil || tt.wantCode != 0 {
+				if tt.wantErr != nil && err != tt.wantErr {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantCode != 0 && appErrorCode(err) != tt.wantCode {
+					t.Fatalf("got %v, want a %d error", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if auth.User.Username != tt.wantUser.Username || auth.User.Email != tt.wantUser.Email {
+				t.Errorf("got user %s <%s>, want %s <%s>", auth.User.Username, auth.User.Email, tt.wantUser.Username, tt.wantUser.Email)
+			}
+			if auth.User.ID != 0 {
+				t.Errorf("got stored user %d without shadow users", auth.User.ID)
+			}
+			if !reflect.DeepEqual(auth.Roles, tt.wantRoles) {
+				t.Errorf("got roles %v, want %v", auth.Roles, tt.wantRoles)
+			}
+			if !reflect.DeepEqual(auth.Methods, []string{MethodLDAP}) {
+				t.Errorf("got methods %v, want [%s]", auth.Methods, MethodLDAP)
+			}
+		})
+	}
+
+	if count, err := r.users.CountUsers(context.Background()); err != nil || count != 0 {
+		t.Errorf("got %d local users (%v) without shadow users, want none", count, err)
+	}
+}
+
+func TestLDAPShadowUsers(t *testing.T) {
+	directory := newTestDirectory(t)
+
+	tests := []struct {
+		name string
+		// local is the user stored before the login, if any
+		local     *model.User
+		inactive  bool
+		username  string
+		password  string
+		wantUser  string
+		wantRoles []string
+		wantErr   error
+		wantCode  int
+	}{
+		{
+			name:      "first login creates the user",
+			username:  "alice",
+			password:  "alice-secret",
+			wantUser:  "alice",
+			wantRoles: []string{"admin"},
+		},
+		{
+			name:      "existing email is reused and renamed",
+			local:     &model.User{Username: "robert", Email: "bob@example.com", PasswordHash: "hash"},
+			username:  "bob",
+			password:  "bob-secret",
+			wantUser:  "bob",
+			wantRoles: []string{"staff"},
+		},
+		{
+			name:     "deactivated user is refused",
+			local:    &model.User{Username: "bob", Email: "bob@example.com", PasswordHash: "hash"},
+			inactive: true,
+			username: "bob",
+			password: "bob-secret",
+			wantErr:  ErrUserInactive,
+		},
+		{
+			name:     "user without email",
+			username: "carol",
+			password: "carol-secret",
+			wantCode: errors.CodeInternalServerError,
+		},
+		{
+			name:     "wrong password creates nobody",
+			username: "alice",
+			password: "wrong",
+			wantErr:  ErrInvalidCredentials,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := newTestRepos()
+			for _, name := range []string{"admin", "staff"} {
+				if _, err := r.roles.CreateRole(ctx, &model.Role{RoleName: name}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var localID uint64
+			if tt.local != nil {
+				if err := r.users.CreateUser(ctx, tt.local); err != nil {
+					t.Fatal(err)
+				}
+				if tt.inactive {
+					tt.local.Active = false
+					if err := r.users.UpdateUser(ctx, tt.local); err != nil {
+						t.Fatal(err)
+					}
+				}
+				localID = tt.local.ID
+			}
+
+			a := NewLDAPAuthenticator(testLDAPConfig(directory.URL(), true), r.users, r.roles, r.authzCache)
+			for login := 1; login <= 2; login++ {
+				auth, err := a.Authenticate(ctx, tt.username, tt.password)
+				if tt.wantErr != nil || tt.wantCode != 0 {
+					if tt.wantErr != nil && err != tt.wantErr {
+						t.Fatalf("got %v, want %v", err, tt.wantErr)
+					}
+					if tt.wantCode != 0 && appErrorCode(err) != tt.wantCode {
+						t.Fatalf("got %v, want a %d error", err, tt.wantCode)
+					}
+					if tt.local == nil {
+						if count, _ := r.users.CountUsers(ctx); count != 0 {
+							t.Errorf("a failed login stored %d users", count)
+						}
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("login %d: %v", login, err)
+				}
+
+				stored, err := r.users.GetUserByUsername(ctx, tt.wantUser)
+				if err != nil {
+					t.Fatalf("login %d: the shadow user is not stored: %v", login, err)
+				}
+				if auth.User.ID != stored.ID {
+					t.Errorf("login %d signed in as user %d, want the stored user %d", login, auth.User.ID, stored.ID)
+				}
+				if localID != 0 && stored.ID != localID {
+					t.Errorf("login %d stored user %d, want the existing user %d", login, stored.ID, localID)
+				}
+				if CheckPassword(stored.PasswordHash, tt.password) {
+					t.Errorf("the shadow user's password hash accepts the directory password")
+				}
+				roles := r.roleNames(t, stored.ID)
+				sort.Strings(roles)
+				if !reflect.DeepEqual(roles, tt.wantRoles) {
+					t.Errorf("login %d: got roles %v, want %v", login, roles, tt.wantRoles)
+				}
+			}
+
+			if count, err := r.users.CountUsers(ctx); err != nil || count != 1 {
+				t.Errorf("got %d users (%v) after two logins, want 1", count, err)
+			}
+		})
+	}
+}
+
+func TestChainAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	directory := newTestDirectory(t)
+	r := newTestRepos()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("dave-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dave exists only locally; bob exists in both with different passwords
+	for _, user := range []*model.User{
+		{Username: "dave", Email: "dave@example.com", PasswordHash: string(hash)},
+		{Username: "bob", Email: "bob@example.com", PasswordHash: string(hash)},
+	} {
+		if err := r.users.CreateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ldapAuthenticator := NewLDAPAuthenticator(testLDAPConfig(directory.URL(), false), r.users, r.roles, r.authzCache)
+	unreachable := NewLDAPAuthenticator(testLDAPConfig("ldap://127.0.0.1:1", false), r.users, r.roles, r.authzCache)
+	password := NewPasswordAuthenticator(r.users)
+
+	tests := []struct {
+		name        string
+		chain       ChainAuthenticator
+		username    string
+		password    string
+		wantMethods []string
+		wantErr     error
+		wantCode    int
+	}{
+		{"directory user", ChainAuthenticator{ldapAuthenticator, password}, "alice", "alice-secret", []string{MethodLDAP}, nil, 0},
+		{"local user falls through", ChainAuthenticator{ldapAuthenticator, password}, "dave", "dave-secret", []string{MethodPassword}, nil, 0},
+		{"directory password", ChainAuthenticator{ldapAuthenticator, password}, "bob", "bob-secret", []string{MethodLDAP}, nil, 0},
+		{"local password", ChainAuthenticator{ldapAuthenticator, password}, "bob", "dave-secret", []string{MethodPassword}, nil, 0},
+		{"wrong everywhere", ChainAuthenticator{ldapAuthenticator, password}, "dave", "wrong", nil, ErrInvalidCredentials, 0},
+		{"directory down, local user", ChainAuthenticator{unreachable, password}, "dave", "dave-secret", []string{MethodPassword}, nil, 0},
+		{"directory down, wrong password", ChainAuthenticator{unreachable, password}, "dave", "wrong", nil, nil, errors.CodeInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := tt.chain.Authenticate(ctx, tt.username, tt.password)
+			if tt.wantErr != nil || tt.wantCode != 0 {
+				if tt.wantErr != nil && err != tt.wantErr {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantCode != 0 && appErrorCode(err) != tt.wantCode {
+					t.Fatalf("got %v, want a %d error", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(auth.Methods, tt.wantMethods) {
+				t.Errorf("got methods %v, want %v", auth.Methods, tt.wantMethods)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Package ldaptest is an in-process LDAP server for tests. It answers simple
+// binds and subtree searches with equality, presence, and, or and not
+// filters over a fixed set of entries, which is all the LDAP authenticator
+// asks of a directory.
+package ldaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAP protocol operations, from RFC 4511
+const (
+	opBindRequest      ber.Tag = 0
+	opBindResponse     ber.Tag = 1
+	opUnbindRequest    ber.Tag = 2
+	opSearchRequest    ber.Tag = 3
+	opSearchResultItem ber.Tag = 4
+	opSearchResultDone ber.Tag = 5
+)
+
+// Result codes the server answers with
+const (
+	resultSuccess            = 0
+	resultProtocolError      = 2
+	resultSizeLimitExceeded  = 4
+	resultInvalidCredentials = 49
+	resultInsufficientAccess = 50
+	resultUnwillingToPerform = 53
+)
+
+// Filter choices and the simple authentication choice of a bind
+const (
+	filterAnd            ber.Tag = 0
+	filterOr             ber.Tag = 1
+	filterNot            ber.Tag = 2
+	filterEqualityMatch  ber.Tag = 3
+	filterPresent        ber.Tag = 7
+	authenticationSimple ber.Tag = 0
+)
+
+// Entry is one directory object. Password, when set, is what a simple bind
+// as DN must present.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server serves the entries on a loopback port until closed
+type Server struct {
+	listener net.Listener
+	entries  []Entry
+	// Anonymous allows binds with an empty DN and password
+	Anonymous bool
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+// NewServer starts serving the entries, allowing anonymous binds
+func NewServer(entries ...Entry) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener, entries: entries, Anonymous: true, conns: map[net.Conn]bool{}}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// URL is the ldap:// URL the server listens on
+func (s *Server) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Close stops the server, dropping any connection still open
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// handle answers one connection's requests in order until it unbinds
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		request, err := ber.ReadPacket(conn)
+		if err != nil || len(request.Children) < 2 {
+			return
+		}
+		messageID, _ := request.Children[0].Value.(int64)
+		op := request.Children[1]
+
+		var responses []*ber.Packet
+		switch op.Tag {
+		case opBindRequest:
+			responses = []*ber.Packet{s.bind(op)}
+		case opSearchRequest:
+			responses = s.search(op)
+		case opUnbindRequest:
+			return
+		default:
+			responses = []*ber.Packet{result(op.Tag+1, resultProtocolError, "unsupported operation")}
+		}
+
+		for _, response := range responses {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+			envelope.AppendChild(response)
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) bind(op *ber.Packet) *ber.Packet {
+	if len(op.Children) < 3 || op.Children[2].Tag != authenticationSimple {
+		return result(opBindResponse, resultUnwillingToPerform, "only simple binds are supported")
+	}
+	dn, _ := op.Children[1].Value.(string)
+	password := op.Children[2].Data.String()
+
+	if dn == "" && password == "" {
+		if !s.Anonymous {
+			return result(opBindResponse, resultInsufficientAccess, "anonymous binds are not allowed")
+		}
+		return result(opBindResponse, resultSuccess, "")
+	}
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			return result(opBindResponse, resultSuccess, "")
+		}
+	}
+	return result(opBindResponse, resultInvalidCredentials, "invalid credentials")
+}
+
+// search returns every entry under the base DN matching the filter, then the
+// result, which reports exceeding the size limit like a real server does
+func (s *Server) search(op *ber.Packet) []*ber.Packet {
+	if len(op.Children) < 8 {
+		return []*ber.Packet{result(opSearchResultDone, resultProtocolError, "malformed search")}
+	}
+	base := strings.ToLower(op.Children[0].Data.String())
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+
+	var responses []*ber.Packet
+	for _, entry := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.DN), base) || !matches(filter, entry) {
+			continue
+		}
+		if sizeLimit > 0 && int64(len(responses)) >= sizeLimit {
+			return append(responses, result(opSearchResultDone, resultSizeLimitExceeded, ""))
+		}
+		responses = append(responses, searchEntry(entry))
+	}
+	return append(responses, result(opSearchResultDone, resultSuccess, ""))
+}
+
+func matches(filter *ber.Packet, entry Entry) bool {
+	switch filter.Tag {
+	case filterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range filter.Children {
+			if matches(child, entry) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return len(filter.Children) == 1 && !matches(filter.Children[0], entry)
+	case filterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		for _, value := range values(entry, filter.Children[0].Data.String()) {
+			if strings.EqualFold(value, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return len(values(entry, filter.Data.String())) > 0
+	}
+	return false
+}
+
+// values looks the attribute up by name, which LDAP compares case-insensitively
+func values(entry Entry, attribute string) []string {
+	for name, v := range entry.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return v
+		}
+	}
+	return nil
+}
+
+func searchEntry(entry Entry) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchResultItem, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, vals := range entry.Attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range vals {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+	return packet
+}
+
+// result is an LDAPResult under the response tag
+func result(tag ber.Tag, code int64, message string) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	return packet
+}
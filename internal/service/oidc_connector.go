@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+)
+
+// ExternalIdentity is the identity asserted by an upstream identity provider
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// Methods and AuthTime come from the ID token's amr and auth_time claims
+	Methods  []string
+	AuthTime int64
+	Claims   map[string]interface{}
+}
+
+// Connector is an upstream identity provider that users can sign in through
+type Connector interface {
+	ID() string
+	AuthCodeURL(state string, nonce string) string
+	Exchange(ctx context.Context, code string, nonce string) (*ExternalIdentity, error)
+}
+
+type oidcConnector struct {
+	id       string
+	client   *http.Client
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCConnector discovers the provider's endpoints and keys from its issuer URL.
+// A nil client uses http.DefaultClient; tests can pass the client of a stub IdP.
+func NewOIDCConnector(ctx context.Context, cfg config.OIDCProvider, client *http.Client) (Connector, error) {
+	if cfg.ID == "" || cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("oidc provider requires an id, issuer and client id")
+	}
+
+	if client != nil {
+		ctx = oidc.ClientContext(ctx, client)
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "discover oidc provider %s", cfg.ID)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+
+	return &oidcConnector{
+		id:     cfg.ID,
+		client: client,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+func (c *oidcConnector) ID() string {
+	return c.id
+}
+
+func (c *oidcConnector) AuthCodeURL(state string, nonce string) string {
+	return c.oauth2.AuthCodeURL(state, oidc.Nonce(nonce))
+}
+
+// Exchange redeems the authorization code and verifies the returned ID token
+func (c *oidcConnector) Exchange(ctx context.Context, code string, nonce string) (*ExternalIdentity, error) {
+	if c.client != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, c.client)
+	}
+
+	token, err := c.oauth2.Exchange(ctx, code)
+	if err != nil {
+		return nil, errors.Wrap(err, "exchange authorization code")
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	idToken, err := c.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "verify id token")
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, errors.Wrap(err, "decode id token claims")
+	}
+
+	identity := &ExternalIdentity{
+		Provider: c.id,
+		Subject:  idToken.Subject,
+		Claims:   claims,
+	}
+	identity.Email, _ = claims["email"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	if methods, ok := claims["amr"].([]interface{}); ok {
+		for _, method := range methods {
+			if m, ok := method.(string); ok {
+				identity.Methods = append(identity.Methods, m)
+			}
+		}
+	}
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		identity.AuthTime = int64(authTime)
+	}
+
+	return identity, nil
+}
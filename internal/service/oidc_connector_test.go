@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service/oidctest"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+)
+
+const testClientID = "ums"
+
+// newTestConnector starts a stub provider and discovers it
+func newTestConnector(t *testing.T) (*oidctest.Provider, Connector) {
+	t.Helper()
+	provider, err := oidctest.NewProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(provider.Close)
+
+	connector, err := NewOIDCConnector(context.Background(), config.OIDCProvider{
+		ID:           "stub",
+		Issuer:       provider.Issuer(),
+		ClientID:     testClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/v1/auth/stub/callback",
+	}, provider.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, connector
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	_, connector := newTestConnector(t)
+
+	u, err := url.Parse(connector.AuthCodeURL("the-state", "the-nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	want := map[string]string{
+		"client_id":     testClientID,
+		"response_type": "code",
+		"scope":         "openid profile email",
+		"state":         "the-state",
+		"nonce":         "the-nonce",
+		"redirect_uri":  "http://localhost/v1/auth/stub/callback",
+	}
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("%s is %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	provider, connector := newTestConnector(t)
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":            "subject-1",
+			"email":          "alice@example.com",
+			"email_verified": true,
+			"nonce":          "the-nonce",
+			"amr":            []string{"pwd", "otp"},
+			"auth_time":      1700000000,
+		}
+		for name, value := range overrides {
+			c[name] = value
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		nonce   string
+		want    *ExternalIdentity
+		wantErr bool
+	}{
+		{
+			name:   "verified identity",
+			claims: claims(nil),
+			nonce:  "the-nonce",
+			want: &ExternalIdentity{
+				Provider: "stub", Subject: "subject-1", Email: "alice@example.com", EmailVerified: true,
+				Methods: []string{"pwd", "otp"}, AuthTime: 1700000000,
+			},
+		},
+		{
+			name:   "email verified as a string",
+			claims: claims(map[string]interface{}{"email_verified": "true"}),
+			nonce:  "the-nonce",
+			want: &ExternalIdentity{
+				Provider: "stub", Subject: "subject-1", Email: "alice@example.com", EmailVerified: true,
+				Methods: []string{"pwd", "otp"}, AuthTime: 1700000000,
+			},
+		},
+		{
+			name:   "unverified email",
+			claims: claims(map[string]interface{}{"email_verified": false, "amr": nil, "auth_time": nil}),
+			nonce:  "the-nonce",
+			want:   &ExternalIdentity{Provider: "stub", Subject: "subject-1", Email: "alice@example.com"},
+		},
+		{name: "nonce of another login", claims: claims(nil), nonce: "other-nonce", wantErr: true},
+		{name: "no nonce", claims: claims(map[string]interface{}{"nonce": nil}), nonce: "the-nonce", wantErr: true},
+		{name: "token for another client", claims: claims(map[string]interface{}{"aud": "someone-else"}), nonce: "the-nonce", wantErr: true},
+		{name: "token from another issuer", claims: claims(map[string]interface{}{"iss": "https://idp.example.com"}), nonce: "the-nonce", wantErr: true},
+		{name: "expired token", claims: claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), nonce: "the-nonce", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := provider.IssueCode(testClientID, tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			identity, err := connector.Exchange(context.Background(), code, tt.nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got identity %+v, want an error", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			identity.Claims = nil
+			if !reflect.DeepEqual(identity, tt.want) {
+				t.Errorf("got %+v, want %+v", identity, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeRedeemsACodeOnce(t *testing.T) {
+	provider, connector := newTestConnector(t)
+	ctx := context.Background()
+
+	code, err := provider.IssueCode(testClientID, map[string]interface{}{"sub": "subject-1", "nonce": "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connector.Exchange(ctx, code, "n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connector.Exchange(ctx, code, "n"); err == nil {
+		t.Error("a redeemed code was accepted again")
+	}
+	if _, err := connector.Exchange(ctx, "unknown", "n"); err == nil {
+		t.Error("an unknown code was accepted")
+	}
+}
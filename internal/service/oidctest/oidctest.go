@@ -0,0 +1,157 @@
+// Package oidctest is a stub OpenID provider for tests: an httptest server
+// answering discovery, key and token requests, whose authorization codes
+// redeem for ID tokens carrying the claims the test chose.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+// keyID names the provider's only signing key in its key set
+const keyID = "oidctest"
+
+// Provider is a running stub provider. Close it when the test is done.
+type Provider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	signer jose.Signer
+
+	mu    sync.Mutex
+	codes map[string][]byte
+}
+
+// NewProvider starts a provider with a fresh RSA signing key
+func NewProvider() (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: keyID}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{key: key, signer: signer, codes: map[string][]byte{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/keys", p.keys)
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	return p, nil
+}
+
+// Issuer is the URL the provider is discovered from and names itself by
+func (p *Provider) Issuer() string {
+	return p.server.URL
+}
+
+// Client reaches the provider
+func (p *Provider) Client() *http.Client {
+	return p.server.Client()
+}
+
+func (p *Provider) Close() {
+	p.server.Close()
+}
+
+// IssueCode returns a single-use code that redeems for an ID token holding
+// the claims, with iss, aud, iat and exp filled in for clientID unless the
+// claims set them
+func (p *Provider) IssueCode(clientID string, claims map[string]interface{}) (string, error) {
+	now := time.Now()
+	token := map[string]interface{}{
+		"iss": p.Issuer(),
+		"aud": clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		token[name] = value
+	}
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	signed, err := p.signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	idToken, err := signed.CompactSerialize()
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(b)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = []byte(idToken)
+	return code, nil
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + "/authorize",
+		"token_endpoint":                        p.Issuer() + "/token",
+		"jwks_uri":                              p.Issuer() + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{string(jose.RS256)},
+	})
+}
+
+func (p *Provider) keys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &p.key.PublicKey,
+		KeyID:     keyID,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+// token redeems a code once, as the authorization_code grant requires
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.FormValue("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	p.mu.Lock()
+	idToken, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access-" + r.FormValue("code"),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     string(idToken),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// A permission is named resource:action, such as users:read, where either
+// part may be the wildcard *. A role granted users:* holds every action on
+// users, one granted *:read may read everything and *:* holds every
+// permission. Names created before this grammar are still matched, but only
+// exactly.
+
+// WildcardPermissionPart matches any resource or action in a permission name
+const WildcardPermissionPart = "*"
+
+var permissionName = regexp.MustCompile(`^([a-z][a-z0-9_-]*|\*):([a-z][a-z0-9_-]*|\*)$`)
+
+// ValidatePermissionPattern records the rules for a permission name that may
+// hold wildcards
+func ValidatePermissionPattern(v *validation.Validator, field string, name string) {
+	v.Length(field, name, 1, 255)
+	if name != "" && !permissionName.MatchString(name) {
+		v.Add(field, validation.CodeInvalidFormat, "must be resource:action, where either may be *, e.g. users:read or users:*")
+	}
+}
+
+// IsWildcardPermission reports whether a permission name holds a wildcard
+func IsWildcardPermission(name string) bool {
+	return permissionName.MatchString(name) && strings.Contains(name, WildcardPermissionPart)
+}
+
+// PermissionMatches reports whether holding pattern grants permission
+func PermissionMatches(pattern string, permission string) bool {
+	if pattern == permission {
+		return true
+	}
+	if !permissionName.MatchString(pattern) {
+		return false
+	}
+	patternResource, patternAction, _ := strings.Cut(pattern, ":")
+	resource, action, ok := strings.Cut(permission, ":")
+	return ok &&
+		(patternResource == WildcardPermissionPart || patternResource == resource) &&
+		(patternAction == WildcardPermissionPart || patternAction == action)
+}
+
+// permissionPatterns lists the names whose grant gives permission: itself and,
+// when it follows the grammar, the wildcards matching it
+func permissionPatterns(permission string) []string {
+	if !permissionName.MatchString(permission) {
+		return []string{permission}
+	}
+	resource, action, _ := strings.Cut(permission, ":")
+	patterns := []string{permission}
+	for _, pattern := range []string{
+		resource + ":" + WildcardPermissionPart,
+		WildcardPermissionPart + ":" + action,
+		WildcardPermissionPart + ":" + WildcardPermissionPart,
+	} {
+		if pattern != permission && !containsString(patterns, pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,21 +1,52 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
 	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
 	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
 	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// The permissions the API's own routes require
+const (
+	// PermissionImpersonateUsers lets an admin obtain a token acting as another user
+	PermissionImpersonateUsers = "users:impersonate"
+	// PermissionUpdateUsers lets a user change the username and email of the
+	// users it is held on
+	PermissionUpdateUsers = "users:update"
+	// PermissionDeleteUsers lets a user delete the users it is held on
+	PermissionDeleteUsers = "users:delete"
+	// PermissionManageRoles lets a user create, rename and delete roles
+	PermissionManageRoles = "roles:manage"
+	// PermissionAssignRoles lets a user give roles to users and take them away
+	PermissionAssignRoles = "roles:assign"
+	// PermissionManagePermissions lets a user create, rename and delete permissions
+	PermissionManagePermissions = "permissions:manage"
+	// PermissionGrantPermissions lets a user grant permissions to roles and revoke them
+	PermissionGrantPermissions = "permissions:grant"
+	// PermissionManagePolicies lets a user create, replace and delete policies
+	PermissionManagePolicies = "policies:manage"
+	// PermissionReadAuthz lets a user check other users' access and read the
+	// authorization cache's statistics
+	PermissionReadAuthz = "authz:read"
 )
 
 type PermissionService struct {
 	PermissionRepo repository.PermissionRepository
+	Cache          *AuthzCache
 }
 
-func NewPermissionService(repo repository.PermissionRepository) *PermissionService {
+func NewPermissionService(repo repository.PermissionRepository, authzCache *AuthzCache) *PermissionService {
 	return &PermissionService{
 		PermissionRepo: repo,
+		Cache:          authzCache,
 	}
 }
 
@@ -27,158 +58,432 @@ func validateAndSanitizePermission(permission *model.Permission) error {
 
 	permission.PermissionName = strings.TrimSpace(strings.ToLower(permission.PermissionName))
 
-	if len(permission.PermissionName) <= 0 {
-		logs.Error("permission name is less than three characters", nil)
-		return errors.NewAppError(errors.CodeBadRequest, "Permission name cannot be less than three characters")
+	var v validation.Validator
+	ValidatePermissionName(&v, permission.PermissionName)
+	return v.Err()
+}
+
+// ValidatePermissionName records the rules for a sanitized permission name,
+// which is resource:action and may hold wildcards
+func ValidatePermissionName(v *validation.Validator, name string) {
+	ValidatePermissionPattern(v, "permission_name", name)
+}
+
+func (s *PermissionService) GetAllPermissions(ctx context.Context) ([]model.Permission, error) {
+	permissions, err := s.PermissionRepo.GetAllPermissions(ctx)
+	if err != nil {
+		logs.Error("error getting all permissions", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
+	return permissions, nil
+}
 
-	if len(permission.PermissionName) > 255 {
-		logs.Error("permission name is too long", nil)
-		return errors.NewAppError(errors.CodeBadRequest, "Permission name must be less than 256 characters")
+// ExpandPermission returns the stored permissions without wildcards that the
+// pattern matches, by name
+func (s *PermissionService) ExpandPermission(ctx context.Context, pattern string) ([]model.Permission, error) {
+	pattern = strings.TrimSpace(strings.ToLower(pattern))
+	var v validation.Validator
+	ValidatePermissionPattern(&v, "pattern", pattern)
+	if err := v.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	permissions, err := s.PermissionRepo.GetAllPermissions(ctx)
+	if err != nil {
+		logs.Error("error expanding permission", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
+	}
+	expanded := []model.Permission{}
+	for _, permission := range permissions {
+		if !IsWildcardPermission(permission.PermissionName) && PermissionMatches(pattern, permission.PermissionName) {
+			expanded = append(expanded, permission)
+		}
+	}
+	sort.Slice(expanded, func(i, j int) bool { return expanded[i].PermissionName < expanded[j].PermissionName })
+	return expanded, nil
 }
 
-func (s *PermissionService) GetAllPermissions() ([]model.Permission, error) {
-	permissions, err := s.PermissionRepo.GetAllPermissions()
+// QueryPermissions returns one page of the permissions the query selects
+func (s *PermissionService) QueryPermissions(ctx context.Context, query repository.ListQuery) (repository.Page[model.Permission], error) {
+	page, err := s.PermissionRepo.QueryPermissions(ctx, query)
 	if err != nil {
-		logs.Error("error getting all permissions", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		logs.Error("error listing permissions", err)
+		return page, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
-	return permissions, nil
+	return page, nil
+}
+
+// ListRolePermissions returns one page of the permissions granted to a role
+func (s *PermissionService) ListRolePermissions(ctx context.Context, roleID uint64, query repository.ListQuery) (repository.Page[model.Permission], error) {
+	return s.QueryPermissions(ctx, query.And(repository.Filter{Op: repository.FilterEq, Field: "role_id", Value: roleID}))
 }
 
-func (s *PermissionService) GetPermissionByID(permissionID uint64) (model.Permission, error) {
+func (s *PermissionService) GetPermissionByID(ctx context.Context, permissionID uint64) (model.Permission, error) {
 	if permissionID <= 0 {
 		logs.Error("invalid permission id", nil)
 		return model.Permission{}, errors.NewAppError(errors.CodeBadRequest, "Invalid permission id")
 	}
-	permission, err := s.PermissionRepo.GetPermissionByID(permissionID)
+	permission, err := s.PermissionRepo.GetPermissionByID(ctx, permissionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return model.Permission{}, errors.WrapAppError(errors.CodeNotFound, err, "Permission not found")
+	}
 	if err != nil {
 		logs.Error("error getting permission by id", err)
-		return model.Permission{}, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return model.Permission{}, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
 	return permission, nil
 }
 
-func (s *PermissionService) CreatePermission(permission model.Permission) (model.Permission, error) {
+func (s *PermissionService) CreatePermission(ctx context.Context, permission model.Permission) (model.Permission, error) {
 	// Validate and sanitize permission
 	err := validateAndSanitizePermission(&permission)
 	if err != nil {
 		return model.Permission{}, err
 	}
 
-	permission, err = s.PermissionRepo.CreatePermission(permission)
+	permission, err = s.PermissionRepo.CreatePermission(ctx, permission)
+	if errors.Is(err, repository.ErrConflict) {
+		return model.Permission{}, errors.WrapAppError(errors.CodeConflict, err, "Permission name already exists")
+	}
 	if err != nil {
 		logs.Error("error creating permission", err)
-		return model.Permission{}, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return model.Permission{}, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
 	return permission, nil
 }
 
-func (s *PermissionService) UpdatePermission(permission model.Permission) (model.Permission, error) {
+func (s *PermissionService) UpdatePermission(ctx context.Context, permission model.Permission) (model.Permission, error) {
 	// Validate and sanitize permission
 	err := validateAndSanitizePermission(&permission)
 	if err != nil {
 		return model.Permission{}, err
 	}
 
-	updatedPermission, err := s.PermissionRepo.UpdatePermission(permission)
+	// Keep the stored timestamps, which callers do not send
+	existing, err := s.PermissionRepo.GetPermissionByID(ctx, permission.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return model.Permission{}, errors.WrapAppError(errors.CodeNotFound, err, "Permission not found")
+	}
+	if err != nil {
+		logs.Error("error getting permission by id", err)
+		return model.Permission{}, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
+	}
+	permission.Model = existing.Model
+
+	updatedPermission, err := s.PermissionRepo.UpdatePermission(ctx, permission)
+	if errors.Is(err, repository.ErrConflict) {
+		return model.Permission{}, errors.WrapAppError(errors.CodeConflict, err, "Permission name already exists")
+	}
 	if err != nil {
 		logs.Error("error updating permission", err)
-		return model.Permission{}, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return model.Permission{}, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
+	s.Cache.InvalidateAllRoles(ctx)
 	return updatedPermission, nil
 }
 
-func (s *PermissionService) DeletePermission(permissionID uint64) error {
+func (s *PermissionService) DeletePermission(ctx context.Context, permissionID uint64) error {
 	if permissionID <= 0 {
 		logs.Error("invalid permission id", nil)
 		return errors.NewAppError(errors.CodeBadRequest, "Invalid permission id")
 	}
-	err := s.PermissionRepo.DeletePermission(permissionID)
+	err := s.PermissionRepo.DeletePermission(ctx, permissionID)
 	if err != nil {
 		logs.Error("error deleting permission", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
+	s.Cache.InvalidateAllRoles(ctx)
 	return nil
 }
 
-func (s *PermissionService) AssignPermissionToRole(roleID, permissionID uint64) error {
+func (s *PermissionService) AssignPermissionToRole(ctx context.Context, roleID, permissionID uint64) error {
 	if roleID <= 0 || permissionID <= 0 {
 		logs.Error("invalid role or permission id", nil)
 		return errors.NewAppError(errors.CodeBadRequest, "Invalid role or permission id")
 	}
-	err := s.PermissionRepo.AssignPermissionToRole(roleID, permissionID)
+	err := s.PermissionRepo.AssignPermissionToRole(ctx, roleID, permissionID)
 	if err != nil {
 		logs.Error("error assigning permission to role", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
+	s.Cache.InvalidateRoles(ctx, roleID)
 	return nil
 }
 
-func (s *PermissionService) RemovePermissionFromRole(roleID, permissionID uint64) error {
+func (s *PermissionService) RemovePermissionFromRole(ctx context.Context, roleID, permissionID uint64) error {
 	if roleID <= 0 || permissionID <= 0 {
 		logs.Error("invalid role or permission id", nil)
 		return errors.NewAppError(errors.CodeBadRequest, "Invalid role or permission id")
 	}
-	err := s.PermissionRepo.RemovePermissionFromRole(roleID, permissionID)
+	err := s.PermissionRepo.RemovePermissionFromRole(ctx, roleID, permissionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return errors.WrapAppError(errors.CodeNotFound, err, "Permission is not assigned to the role")
+	}
 	if err != nil {
 		logs.Error("error removing permission from role", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
+	s.Cache.InvalidateRoles(ctx, roleID)
 	return nil
 }
 
-func (s *PermissionService) GetPermissionsByRoleID(roleID uint64) ([]model.Permission, error) {
+func (s *PermissionService) GetPermissionsByRoleID(ctx context.Context, roleID uint64) ([]model.Permission, error) {
 	if roleID <= 0 {
 		logs.Error("invalid role id", nil)
 		return nil, errors.NewAppError(errors.CodeBadRequest, "Invalid role id")
 	}
-	permissions, err := s.PermissionRepo.GetPermissionsByRoleID(roleID)
+	permissions, err := s.PermissionRepo.GetPermissionsByRoleID(ctx, roleID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errors.WrapAppError(errors.CodeNotFound, err, "Role not found")
+	}
 	if err != nil {
 		logs.Error("error getting permissions by role id", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
 	return permissions, nil
 }
 
-func (s *PermissionService) GetRolesByPermissionID(permissionID uint64) ([]model.Role, error) {
+func (s *PermissionService) GetRolesByPermissionID(ctx context.Context, permissionID uint64) ([]model.Role, error) {
 	if permissionID <= 0 {
 		logs.Error("invalid permission id", nil)
 		return nil, errors.NewAppError(errors.CodeBadRequest, "Invalid permission id")
 	}
-	roles, err := s.PermissionRepo.GetRolesByPermissionID(permissionID)
+	roles, err := s.PermissionRepo.GetRolesByPermissionID(ctx, permissionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errors.WrapAppError(errors.CodeNotFound, err, "Permission not found")
+	}
 	if err != nil {
 		logs.Error("error getting roles by permission id", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
 	return roles, nil
 }
 
-func (s *PermissionService) AddMultiplePermissionsToRole(roleID uint64, permissionIDs []uint64) error {
+func (s *PermissionService) AddMultiplePermissionsToRole(ctx context.Context, roleID uint64, permissionIDs []uint64) error {
 	if roleID <= 0 || len(permissionIDs) == 0 {
 		logs.Error("invalid role id or empty permissions", nil)
 		return errors.NewAppError(errors.CodeBadRequest, "Invalid role id or empty permissions")
 	}
-	err := s.PermissionRepo.AddMultiplePermissionsToRole(roleID, permissionIDs)
+	err := s.PermissionRepo.AddMultiplePermissionsToRole(ctx, roleID, permissionIDs)
 	if err != nil {
 		logs.Error("error adding multiple permissions to role", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
+	s.Cache.InvalidateRoles(ctx, roleID)
 	return nil
 }
 
-func (s *PermissionService) RemoveMultiplePermissionsFromRole(roleID uint64, permissionIDs []uint64) error {
+func (s *PermissionService) RemoveMultiplePermissionsFromRole(ctx context.Context, roleID uint64, permissionIDs []uint64) error {
 	if roleID <= 0 || len(permissionIDs) == 0 {
 		logs.Error("invalid role id or empty permissions", nil)
 		return errors.NewAppError(errors.CodeBadRequest, "Invalid role id or empty permissions")
 	}
-	err := s.PermissionRepo.RemoveMultiplePermissionsFromRole(roleID, permissionIDs)
+	err := s.PermissionRepo.RemoveMultiplePermissionsFromRole(ctx, roleID, permissionIDs)
+	if errors.Is(err, repository.ErrNotFound) {
+		return errors.WrapAppError(errors.CodeNotFound, err, "Permission is not assigned to the role")
+	}
 	if err != nil {
 		logs.Error("error removing multiple permissions from role", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred.")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
 	}
+	s.Cache.InvalidateRoles(ctx, roleID)
 	return nil
 }
+
+// MaxAccessChecks bounds the checks CheckAccess answers in one call
+const MaxAccessChecks = 100
+
+// AccessCheck asks whether a user holds a permission on a resource. Only
+// global roles answer a check that names no resource.
+type AccessCheck struct {
+	UserID     uint64
+	Permission string
+	Resource   string
+	// ResourceAttributes and Context are passed to the policies as the
+	// resource.* and context.* attributes
+	ResourceAttributes map[string]interface{}
+	Context            map[string]interface{}
+	// Claims are the caller's, whose token attributes the policies see when
+	// the caller is the subject
+	Claims *Claims
+}
+
+// AccessDecision answers an AccessCheck; Role is the role that granted the
+// permission and Scope the scope it was assigned in, both empty when denied.
+// Policy names the policy that decided the check, if the roles did not.
+type AccessDecision struct {
+	Allowed bool
+	Role    string
+	Scope   string
+	Policy  string
+}
+
+// CheckAccess answers the checks in order from the cache, which loads the
+// subjects' roles and their permissions it lacks in one query each. A role grants its permissions on every resource its
+// scope covers, and a wildcard permission such as users:* grants every
+// permission it matches. When several roles grant a permission, the oldest
+// one is reported, and of its assignments the oldest that covers the resource.
+//
+// The policies are then applied: a deny policy whose condition holds refuses
+// a check whatever the roles grant, and otherwise an allow policy whose
+// condition holds grants a check the roles refuse. The oldest such policy
+// decides.
+func (s *PermissionService) CheckAccess(ctx context.Context, checks []AccessCheck) ([]AccessDecision, error) {
+	if len(checks) == 0 || len(checks) > MaxAccessChecks {
+		logs.Error("invalid number of access checks", nil)
+		return nil, errors.NewAppError(errors.CodeBadRequest, "Between 1 and 100 checks are allowed")
+	}
+
+	var userIDs []uint64
+	var names []string
+	seenUsers := make(map[uint64]bool)
+	seenNames := make(map[string]bool)
+	var v validation.Validator
+	for i, check := range checks {
+		name := strings.TrimSpace(strings.ToLower(check.Permission))
+		if check.UserID == 0 || name == "" {
+			logs.Error("invalid access check", nil)
+			return nil, errors.NewAppError(errors.CodeBadRequest, "Invalid user id or permission")
+		}
+		ValidateResource(&v, fmt.Sprintf("checks[%d].resource", i), check.Resource)
+		if !seenUsers[check.UserID] {
+			seenUsers[check.UserID] = true
+			userIDs = append(userIDs, check.UserID)
+		}
+		for _, pattern := range permissionPatterns(name) {
+			if !seenNames[pattern] {
+				seenNames[pattern] = true
+				names = append(names, pattern)
+			}
+		}
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	grants, err := s.Cache.Grants(ctx, userIDs, names)
+	if err != nil {
+		logs.Error("error getting permission grants", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
+	}
+	byUser := make(map[uint64][]repository.Grant)
+	for _, grant := range grants {
+		byUser[grant.UserID] = append(byUser[grant.UserID], grant)
+	}
+
+	decisions := make([]AccessDecision, len(checks))
+	for i, check := range checks {
+		name := strings.TrimSpace(strings.ToLower(check.Permission))
+		for _, grant := range byUser[check.UserID] {
+			if PermissionMatches(grant.PermissionName, name) && ScopeCovers(grant.Scope, check.Resource) {
+				decisions[i] = AccessDecision{Allowed: true, Role: grant.RoleName, Scope: grant.Scope}
+				break
+			}
+		}
+	}
+
+	if err := s.applyPolicies(ctx, checks, decisions); err != nil {
+		logs.Error("error applying policies", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
+	}
+	return decisions, nil
+}
+
+// applyPolicies revises the decisions the roles made by the policies that
+// apply to each check, loading the attributes of the subjects they apply to
+// in one query
+func (s *PermissionService) applyPolicies(ctx context.Context, checks []AccessCheck, decisions []AccessDecision) error {
+	policies, err := s.Cache.Policies(ctx)
+	if err != nil || len(policies) == 0 {
+		return err
+	}
+
+	applicable := make([][]*PolicyRule, len(checks))
+	var userIDs []uint64
+	seen := make(map[uint64]bool)
+	for i, check := range checks {
+		name := strings.TrimSpace(strings.ToLower(check.Permission))
+		for j := range policies {
+			if policies[j].Applies(name, check.Resource) {
+				applicable[i] = append(applicable[i], &policies[j])
+			}
+		}
+		if len(applicable[i]) > 0 && !seen[check.UserID] {
+			seen[check.UserID] = true
+			userIDs = append(userIDs, check.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+	subjects, err := s.subjectAttributes(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, check := range checks {
+		if len(applicable[i]) > 0 {
+			attrs := checkAttributes(subjects[check.UserID], check, now)
+			decisions[i] = decide(decisions[i], applicable[i], attrs)
+		}
+	}
+	return nil
+}
+
+// decide applies the policies to the decision the roles made
+func decide(decision AccessDecision, policies []*PolicyRule, attrs Attributes) AccessDecision {
+	for _, policy := range policies {
+		if policy.Effect == EffectDeny && policy.Holds(attrs) {
+			return AccessDecision{Policy: policy.Name}
+		}
+	}
+	if decision.Allowed {
+		return decision
+	}
+	for _, policy := range policies {
+		if policy.Effect == EffectAllow && policy.Holds(attrs) {
+			return AccessDecision{Allowed: true, Policy: policy.Name}
+		}
+	}
+	return decision
+}
+
+// subjectAttributes describes each of the users, the deleted ones by their
+// ID alone
+func (s *PermissionService) subjectAttributes(ctx context.Context, userIDs []uint64) (map[uint64]Attributes, error) {
+	users, err := s.Cache.UserRepo.GetUsersByIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := s.Cache.UsersRoles(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint64]*model.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+	subjects := make(map[uint64]Attributes, len(userIDs))
+	for _, userID := range userIDs {
+		subjects[userID] = subjectAttributes(userID, byID[userID], roles[userID])
+	}
+	return subjects, nil
+}
+
+// UserCanAccess reports whether the user holds the permission on the
+// resource, through a global role or one assigned in a scope covering it,
+// as the policies leave it
+func (s *PermissionService) UserCanAccess(ctx context.Context, userID uint64, permissionName string, resource string) (bool, error) {
+	decision, err := s.Authorize(ctx, AccessCheck{UserID: userID, Permission: permissionName, Resource: resource})
+	return decision.Allowed, err
+}
+
+// Authorize answers a single check
+func (s *PermissionService) Authorize(ctx context.Context, check AccessCheck) (AccessDecision, error) {
+	decisions, err := s.CheckAccess(ctx, []AccessCheck{check})
+	if err != nil {
+		return AccessDecision{}, err
+	}
+	return decisions[0], nil
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+)
+
+// countingUsers counts the lookups of users by ID
+type countingUsers struct {
+	repository.UserRepository
+	lookups int
+}
+
+func (r *countingUsers) GetUserByID(ctx context.Context, id uint64) (*model.User, error) {
+	r.lookups++
+	return r.UserRepository.GetUserByID(ctx, id)
+}
+
+func (r *countingUsers) GetUsersByIDs(ctx context.Context, ids []uint64) ([]*model.User, error) {
+	r.lookups++
+	return r.UserRepository.GetUsersByIDs(ctx, ids)
+}
+
+// grantPermission gives the user a role holding the permission, named after it
+func (r *testRepos) grantPermission(t *testing.T, userID uint64, permissionName string) {
+	t.Helper()
+	ctx := context.Background()
+	role, err := r.roles.CreateRole(ctx, &model.Role{RoleName: permissionName + " holder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	permission, err := r.permissions.CreatePermission(ctx, model.Permission{PermissionName: permissionName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.permissions.AssignPermissionToRole(ctx, role.ID, permission.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.roles.AddUserRole(ctx, userID, role.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAccessUsesTheCache(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	alice := r.createUser(t, "alice")
+	r.grantPermission(t, alice.ID, "documents:read")
+	s := NewPermissionService(r.permissions, r.authzCache)
+
+	const checks = 5
+	for i := 0; i < checks; i++ {
+		allowed, err := s.UserCanAccess(ctx, alice.ID, "documents:read", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Fatalf("check %d was refused", i)
+		}
+	}
+	stats := r.authzCache.Stats()
+	if stats.UserRoles.Misses != 1 || stats.UserRoles.Hits != checks-1 || stats.UserRoles.Entries != 1 {
+		t.Errorf("user roles: %+v, want one miss, then hits", stats.UserRoles)
+	}
+	if stats.RolePermissions.Misses != 1 || stats.RolePermissions.Hits != checks-1 || stats.RolePermissions.Entries != 1 {
+		t.Errorf("role permissions: %+v, want one miss, then hits", stats.RolePermissions)
+	}
+
+	// A grant made through the service reaches the next check
+	write, err := r.permissions.CreatePermission(ctx, model.Permission{PermissionName: "documents:write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, err := r.roles.GetRolesByUserID(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssignPermissionToRole(ctx, roles[0].ID, write.ID); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, err := s.UserCanAccess(ctx, alice.ID, "documents:write", ""); err != nil || !allowed {
+		t.Errorf("after the grant got %v, %v, want allowed", allowed, err)
+	}
+}
+
+func TestCheckAccessLoadsSubjectsOnce(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	users := &countingUsers{UserRepository: r.users}
+	r.authzCache.UserRepo = users
+	policies := NewPolicyService(r.policies, r.authzCache)
+	if _, err := policies.CreatePolicy(ctx, model.Policy{
+		Name:       "staff read",
+		Effect:     EffectAllow,
+		Permission: "documents:read",
+		Condition:  `{"attr": "subject.roles", "op": "contains", "value": "staff"}`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	staff, err := r.roles.CreateRole(ctx, &model.Role{RoleName: "staff"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var checks []AccessCheck
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user := r.createUser(t, name)
+		if name != "carol" {
+			if err := r.roles.AddUserRole(ctx, user.ID, staff.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checks = append(checks,
+			AccessCheck{UserID: user.ID, Permission: "documents:read"},
+			AccessCheck{UserID: user.ID, Permission: "documents:read", Resource: "documents:7"})
+	}
+	s := NewPermissionService(r.permissions, r.authzCache)
+
+	decisions, err := s.CheckAccess(ctx, checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, decision := range decisions {
+		want := i < 4
+		if decision.Allowed != want || (want && decision.Policy != "staff read") {
+			t.Errorf("check %d: got %+v, want allowed %v by the policy", i, decision, want)
+		}
+	}
+	if users.lookups != 1 {
+		t.Errorf("the subjects took %d user lookups, want 1", users.lookups)
+	}
+	if stats := r.authzCache.Stats(); stats.UserRoles.Misses != 3 || stats.UserRoles.Hits != 3 {
+		t.Errorf("user roles: %+v, want each subject loaded once and then served from the cache", stats.UserRoles)
+	}
+}
+
+func TestDecide(t *testing.T) {
+	rule := func(name string, effect string, condition string) *PolicyRule {
+		compiled, ok := compilePolicy(model.Policy{Name: name, Effect: effect, Permission: AnyPermission, Condition: condition})
+		if !ok {
+			t.Fatalf("policy %s was dropped", name)
+		}
+		return &compiled
+	}
+	owner := `{"attr": "subject.id", "op": "eq", "ref": "resource.owner.id"}`
+	weekend := `{"attr": "context.weekday", "op": "in", "value": ["saturday", "sunday"]}`
+	attrs := Attributes{"subject.id": float64(7), "resource.owner.id": float64(7), "context.weekday": "saturday"}
+	byRole := AccessDecision{Allowed: true, Role: "editor"}
+	refused := AccessDecision{}
+
+	tests := []struct {
+		name     string
+		decision AccessDecision
+		policies []*PolicyRule
+		want     AccessDecision
+	}{
+		{"no policy leaves a grant", byRole, nil, byRole},
+		{"no policy leaves a refusal", refused, nil, refused},
+		{"allow grants what the roles refuse", refused, []*PolicyRule{rule("owners", EffectAllow, owner)}, AccessDecision{Allowed: true, Policy: "owners"}},
+		{"allow leaves a grant to the role", byRole, []*PolicyRule{rule("owners", EffectAllow, owner)}, byRole},
+		{"allow that does not hold", refused, []*PolicyRule{rule("others", EffectAllow, `{"attr": "subject.id", "op": "ne", "ref": "resource.owner.id"}`)}, refused},
+		{"deny refuses what the roles grant", byRole, []*PolicyRule{rule("weekends", EffectDeny, weekend)}, AccessDecision{Policy: "weekends"}},
+		{"deny that does not hold", byRole, []*PolicyRule{rule("nights", EffectDeny, `{"attr": "context.hour", "op": "lt", "value": 6}`)}, byRole},
+		{"deny overrides an older allow", refused, []*PolicyRule{rule("owners", EffectAllow, owner), rule("weekends", EffectDeny, weekend)}, AccessDecision{Policy: "weekends"}},
+		{"deny overrides a newer allow", refused, []*PolicyRule{rule("weekends", EffectDeny, weekend), rule("owners", EffectAllow, owner)}, AccessDecision{Policy: "weekends"}},
+		{"oldest deny decides", byRole, []*PolicyRule{rule("weekends", EffectDeny, weekend), rule("always", EffectDeny, "")}, AccessDecision{Policy: "weekends"}},
+		{"oldest allow decides", refused, []*PolicyRule{rule("always", EffectAllow, ""), rule("owners", EffectAllow, owner)}, AccessDecision{Allowed: true, Policy: "always"}},
+		{"deny that does not parse refuses", byRole, []*PolicyRule{rule("broken", EffectDeny, `{"attr": "subject.id"}`)}, AccessDecision{Policy: "broken"}},
+		{"condition on an unknown attribute does not hold", refused, []*PolicyRule{rule("teams", EffectAllow, `{"attr": "subject.team", "op": "eq", "value": "ops"}`)}, refused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decide(tt.decision, tt.policies, attrs); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
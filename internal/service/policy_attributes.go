@@ -0,0 +1,94 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+)
+
+// The attributes a policy condition can test are, for every check:
+//
+//	subject.id, subject.username,   the user, when it exists
+//	subject.email, subject.created_at
+//	subject.roles                   the names of the user's global roles
+//	subject.impersonated,           from the token, when the subject is the
+//	subject.actor_id,               caller
+//	subject.auth_time, subject.amr
+//	resource.path                   the resource, e.g. project:42/documents:7
+//	resource.type, resource.id      its last segment, e.g. documents and 7
+//	resource.<type>                 the ID of each segment, e.g. resource.project
+//	context.time, context.hour,     when the check is made, in the server's
+//	context.weekday                 time zone
+//
+// and the resource and context attributes passed with the check, with nested
+// objects flattened, e.g. resource.owner.id. Where a passed attribute has the
+// name of one above, the one above is used.
+
+// subjectAttributes describes a user, which is nil when it does not exist
+func subjectAttributes(userID uint64, user *model.User, roles []model.Role) Attributes {
+	attrs := Attributes{"subject.id": float64(userID)}
+	if user != nil {
+		attrs["subject.username"] = user.Username
+		attrs["subject.email"] = user.Email
+		attrs["subject.created_at"] = user.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	names := make([]interface{}, len(roles))
+	for i, role := range roles {
+		names[i] = role.RoleName
+	}
+	attrs["subject.roles"] = names
+	return attrs
+}
+
+// checkAttributes adds what one check knows to the attributes of its subject
+func checkAttributes(subject Attributes, check AccessCheck, now time.Time) Attributes {
+	attrs := Attributes{}
+	flattenAttributes(attrs, "resource", check.ResourceAttributes)
+	flattenAttributes(attrs, "context", check.Context)
+	for name, value := range subject {
+		attrs[name] = value
+	}
+
+	if claims := check.Claims; claims != nil && claims.UserID == strconv.FormatUint(check.UserID, 10) {
+		attrs["subject.impersonated"] = claims.Actor != nil
+		if claims.Actor != nil {
+			attrs["subject.actor_id"] = claims.Actor.UserID
+		}
+		attrs["subject.auth_time"] = float64(claims.AuthTime)
+		amr := make([]interface{}, len(claims.AMR))
+		for i, method := range claims.AMR {
+			amr[i] = method
+		}
+		attrs["subject.amr"] = amr
+	}
+
+	if check.Resource != "" {
+		segments := strings.Split(check.Resource, "/")
+		for _, segment := range segments {
+			resourceType, id, _ := strings.Cut(segment, ":")
+			attrs["resource."+resourceType] = id
+		}
+		attrs["resource.type"], attrs["resource.id"], _ = strings.Cut(segments[len(segments)-1], ":")
+		attrs["resource.path"] = check.Resource
+	}
+
+	attrs["context.time"] = now.Format(time.RFC3339)
+	attrs["context.hour"] = float64(now.Hour())
+	attrs["context.weekday"] = strings.ToLower(now.Weekday().String())
+	return attrs
+}
+
+// flattenAttributes adds values to attrs under prefix, naming the fields of
+// nested objects with dots
+func flattenAttributes(attrs Attributes, prefix string, values map[string]interface{}) {
+	for key, value := range values {
+		name := prefix + "." + key
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenAttributes(attrs, name, nested)
+			continue
+		}
+		attrs[name] = value
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// Condition is the test of a policy, written as JSON. A comparison names an
+// attribute, an operator and either a value or, with ref, another attribute:
+//
+//	{"attr": "subject.id", "op": "eq", "ref": "resource.id"}
+//	{"attr": "context.hour", "op": "gte", "value": 9}
+//
+// and all, any and not combine conditions:
+//
+//	{"all": [{"attr": "subject.roles", "op": "contains", "value": "staff"},
+//	         {"not": {"attr": "context.weekday", "op": "in", "value": ["saturday", "sunday"]}}]}
+//
+// Numbers compare with numbers and with strings that hold one, and strings
+// with strings. A comparison with an attribute the check lacks is false;
+// exists tests whether it has one.
+type Condition struct {
+	All   []Condition `json:"all,omitempty"`
+	Any   []Condition `json:"any,omitempty"`
+	Not   *Condition  `json:"not,omitempty"`
+	Attr  string      `json:"attr,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+	Ref   string      `json:"ref,omitempty"`
+}
+
+// Condition operators
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpIn       = "in"
+	OpContains = "contains"
+	OpExists   = "exists"
+)
+
+var conditionOps = []string{OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpIn, OpContains, OpExists}
+
+// MaxConditionDepth bounds how deeply all, any and not may nest
+const MaxConditionDepth = 8
+
+var attributeName = regexp.MustCompile(`^(subject|resource|context)\.[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+// Attributes are what a condition is tested against, keyed by attribute name,
+// e.g. subject.id. Values are strings, float64s, bools or lists of them.
+type Attributes map[string]interface{}
+
+// ValidateCondition records what is wrong with a condition's JSON; the empty
+// condition, which always holds, is valid
+func ValidateCondition(v *validation.Validator, field string, raw string) {
+	parseCondition(v, field, raw)
+}
+
+// parseCondition decodes and checks a condition, recording its problems in v.
+// It returns nil for the empty condition and for an invalid one.
+func parseCondition(v *validation.Validator, field string, raw string) *Condition {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	var condition Condition
+	if err := decoder.Decode(&condition); err != nil || decoder.More() {
+		v.Add(field, validation.CodeInvalidFormat, "must be a condition object of all, any, not or attr, op and value or ref")
+		return nil
+	}
+	var problems validation.Validator
+	condition.validate(&problems, field, 1)
+	invalid := problems.Err() != nil
+	v.Merge(&problems)
+	if invalid {
+		return nil
+	}
+	return &condition
+}
+
+func (c *Condition) validate(v *validation.Validator, field string, depth int) {
+	if depth > MaxConditionDepth {
+		v.Add(field, validation.CodeInvalidValue, fmt.Sprintf("must nest at most %d levels deep", MaxConditionDepth))
+		return
+	}
+	kinds := 0
+	for _, set := range []bool{c.All != nil, c.Any != nil, c.Not != nil, c.Attr != "" || c.Op != "" || c.Value != nil || c.Ref != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		v.Add(field, validation.CodeInvalidValue, "must have exactly one of all, any, not or a comparison")
+		return
+	}
+
+	switch {
+	case c.All != nil:
+		validateConditions(v, field+".all", c.All, depth)
+	case c.Any != nil:
+		validateConditions(v, field+".any", c.Any, depth)
+	case c.Not != nil:
+		c.Not.validate(v, field+".not", depth+1)
+	default:
+		c.validateComparison(v, field)
+	}
+}
+
+func validateConditions(v *validation.Validator, field string, conditions []Condition, depth int) {
+	if len(conditions) == 0 {
+		v.Add(field, validation.CodeRequired, "must list at least one condition")
+	}
+	for i := range conditions {
+		conditions[i].validate(v, fmt.Sprintf("%s[%d]", field, i), depth+1)
+	}
+}
+
+func (c *Condition) validateComparison(v *validation.Validator, field string) {
+	if !attributeName.MatchString(c.Attr) {
+		v.Add(field+".attr", validation.CodeInvalidFormat, "must name a subject., resource. or context. attribute")
+	}
+	known := false
+	for _, op := range conditionOps {
+		known = known || c.Op == op
+	}
+	if !known {
+		v.Add(field+".op", validation.CodeInvalidValue, "must be one of "+strings.Join(conditionOps, ", "))
+		return
+	}
+
+	if c.Op == OpExists {
+		if c.Value != nil || c.Ref != "" {
+			v.Add(field, validation.CodeInvalidValue, "exists takes neither value nor ref")
+		}
+		return
+	}
+	if (c.Value == nil) == (c.Ref == "") {
+		v.Add(field, validation.CodeInvalidValue, "must have exactly one of value or ref")
+		return
+	}
+	if c.Ref != "" {
+		if !attributeName.MatchString(c.Ref) {
+			v.Add(field+".ref", validation.CodeInvalidFormat, "must name a subject., resource. or context. attribute")
+		}
+		return
+	}
+
+	switch value := c.Value.(type) {
+	case []interface{}:
+		if c.Op != OpIn {
+			v.Add(field+".value", validation.CodeInvalidType, "must be a string, number or boolean")
+			return
+		}
+		for i, item := range value {
+			if !isScalar(item) {
+				v.Add(fmt.Sprintf("%s.value[%d]", field, i), validation.CodeInvalidType, "must be a string, number or boolean")
+			}
+		}
+	default:
+		switch {
+		case c.Op == OpIn:
+			v.Add(field+".value", validation.CodeInvalidType, "must be an array")
+		case !isScalar(value):
+			v.Add(field+".value", validation.CodeInvalidType, "must be a string, number or boolean")
+		}
+	}
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, float64, bool:
+		return true
+	}
+	return false
+}
+
+// compactCondition returns the condition's JSON without insignificant space,
+// or "" for the empty condition. raw must be valid.
+func compactCondition(raw string) string {
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(raw)); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// Holds reports whether the condition is true of attrs; the nil condition always holds
+func (c *Condition) Holds(attrs Attributes) bool {
+	switch {
+	case c == nil:
+		return true
+	case c.All != nil:
+		for i := range c.All {
+			if !c.All[i].Holds(attrs) {
+				return false
+			}
+		}
+		return true
+	case c.Any != nil:
+		for i := range c.Any {
+			if c.Any[i].Holds(attrs) {
+				return true
+			}
+		}
+		return false
+	case c.Not != nil:
+		return !c.Not.Holds(attrs)
+	}
+
+	actual, ok := attrs[c.Attr]
+	if c.Op == OpExists {
+		return ok
+	}
+	if !ok {
+		return false
+	}
+	expected := c.Value
+	if c.Ref != "" {
+		if expected, ok = attrs[c.Ref]; !ok {
+			return false
+		}
+	}
+
+	switch c.Op {
+	case OpEq:
+		return equalValues(actual, expected)
+	case OpNe:
+		return !equalValues(actual, expected)
+	case OpIn:
+		return listContains(expected, actual)
+	case OpContains:
+		if s, ok := actual.(string); ok {
+			sub, ok := expected.(string)
+			return ok && strings.Contains(s, sub)
+		}
+		return listContains(actual, expected)
+	}
+	order, ok := compareValues(actual, expected)
+	switch c.Op {
+	case OpLt:
+		return ok && order < 0
+	case OpLte:
+		return ok && order <= 0
+	case OpGt:
+		return ok && order > 0
+	case OpGte:
+		return ok && order >= 0
+	}
+	return false
+}
+
+func listContains(list interface{}, value interface{}) bool {
+	items, ok := list.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range items {
+		if equalValues(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalValues(a interface{}, b interface{}) bool {
+	if order, ok := compareValues(a, b); ok {
+		return order == 0
+	}
+	if x, ok := a.(bool); ok {
+		y, ok := b.(bool)
+		return ok && x == y
+	}
+	return false
+}
+
+// compareValues orders two strings, or two numbers where either may be a
+// string holding one, and reports false for anything else
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	x, aString := a.(string)
+	y, bString := b.(string)
+	if aString && bString {
+		return strings.Compare(x, y), true
+	}
+	m, ok := toNumber(a)
+	if !ok {
+		return 0, false
+	}
+	n, ok := toNumber(b)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case m < n:
+		return -1, true
+	case m > n:
+		return 1, true
+	}
+	return 0, true
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
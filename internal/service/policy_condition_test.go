@@ -0,0 +1,148 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// mustParseCondition parses a condition the test knows to be valid
+func mustParseCondition(t *testing.T, raw string) *Condition {
+	t.Helper()
+	var v validation.Validator
+	condition := parseCondition(&v, "condition", raw)
+	if err := v.Err(); err != nil {
+		t.Fatalf("%s: %v", raw, err)
+	}
+	return condition
+}
+
+// hasFieldError reports whether err is a validation error rejecting field
+func hasFieldError(err error, field string) bool {
+	var appErr *errors.AppError
+	if !errors.As(err, &appErr) {
+		return false
+	}
+	for _, fieldErr := range appErr.Fields {
+		if fieldErr.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConditionHolds(t *testing.T) {
+	attrs := Attributes{
+		"subject.id":           float64(7),
+		"subject.username":     "alice",
+		"subject.roles":        []interface{}{"staff", "editor"},
+		"subject.impersonated": false,
+		"resource.id":          "7",
+		"resource.owner.id":    float64(7),
+		"resource.project":     "42",
+		"resource.path":        "project:42/documents:7",
+		"context.hour":         float64(10),
+		"context.weekday":      "monday",
+	}
+
+	tests := []struct {
+		name      string
+		condition string
+		want      bool
+	}{
+		{"empty condition", "", true},
+
+		{"eq string", `{"attr": "subject.username", "op": "eq", "value": "alice"}`, true},
+		{"eq other string", `{"attr": "subject.username", "op": "eq", "value": "bob"}`, false},
+		{"eq number", `{"attr": "subject.id", "op": "eq", "value": 7}`, true},
+		{"eq number and numeric string", `{"attr": "resource.id", "op": "eq", "value": 7}`, true},
+		{"eq bool", `{"attr": "subject.impersonated", "op": "eq", "value": false}`, true},
+		{"eq bool and string", `{"attr": "subject.impersonated", "op": "eq", "value": "false"}`, false},
+		{"ne", `{"attr": "subject.username", "op": "ne", "value": "bob"}`, true},
+		{"ne equal", `{"attr": "subject.username", "op": "ne", "value": "alice"}`, false},
+		{"lt", `{"attr": "context.hour", "op": "lt", "value": 11}`, true},
+		{"lt equal", `{"attr": "context.hour", "op": "lt", "value": 10}`, false},
+		{"lte equal", `{"attr": "context.hour", "op": "lte", "value": 10}`, true},
+		{"gt", `{"attr": "context.hour", "op": "gt", "value": 9}`, true},
+		{"gt equal", `{"attr": "context.hour", "op": "gt", "value": 10}`, false},
+		{"gte equal", `{"attr": "context.hour", "op": "gte", "value": 10}`, true},
+		{"gte numeric string", `{"attr": "resource.project", "op": "gte", "value": 40}`, true},
+		{"order of strings", `{"attr": "subject.username", "op": "lt", "value": "bob"}`, true},
+		{"order of a string and a number", `{"attr": "subject.username", "op": "lt", "value": 100}`, false},
+		{"order of bools", `{"attr": "subject.impersonated", "op": "lt", "value": true}`, false},
+		{"in", `{"attr": "context.weekday", "op": "in", "value": ["saturday", "monday"]}`, true},
+		{"not in", `{"attr": "context.weekday", "op": "in", "value": ["saturday", "sunday"]}`, false},
+		{"in with numbers", `{"attr": "resource.id", "op": "in", "value": [6, 7]}`, true},
+		{"contains in a list", `{"attr": "subject.roles", "op": "contains", "value": "staff"}`, true},
+		{"list lacks", `{"attr": "subject.roles", "op": "contains", "value": "admin"}`, false},
+		{"contains in a string", `{"attr": "resource.path", "op": "contains", "value": "documents:"}`, true},
+		{"string lacks", `{"attr": "resource.path", "op": "contains", "value": "users:"}`, false},
+		{"contains in a number", `{"attr": "subject.id", "op": "contains", "value": 7}`, false},
+
+		{"ref equal", `{"attr": "subject.id", "op": "eq", "ref": "resource.owner.id"}`, true},
+		{"ref across types", `{"attr": "resource.id", "op": "eq", "ref": "subject.id"}`, true},
+		{"ref differs", `{"attr": "resource.project", "op": "eq", "ref": "subject.id"}`, false},
+		{"ref unknown", `{"attr": "subject.id", "op": "eq", "ref": "resource.creator.id"}`, false},
+		{"ref unknown under ne", `{"attr": "subject.id", "op": "ne", "ref": "resource.creator.id"}`, false},
+
+		{"nested path", `{"attr": "resource.owner.id", "op": "eq", "value": 7}`, true},
+		{"unknown attribute", `{"attr": "resource.owner.name", "op": "eq", "value": "alice"}`, false},
+		{"unknown attribute under ne", `{"attr": "resource.owner.name", "op": "ne", "value": "alice"}`, false},
+		{"unknown attribute under not", `{"not": {"attr": "resource.owner.name", "op": "eq", "value": "alice"}}`, true},
+		{"exists", `{"attr": "resource.owner.id", "op": "exists"}`, true},
+		{"does not exist", `{"attr": "resource.owner.name", "op": "exists"}`, false},
+
+		{"all hold", `{"all": [{"attr": "subject.id", "op": "eq", "value": 7}, {"attr": "context.hour", "op": "gte", "value": 9}]}`, true},
+		{"one of all fails", `{"all": [{"attr": "subject.id", "op": "eq", "value": 7}, {"attr": "context.hour", "op": "gte", "value": 17}]}`, false},
+		{"one of any holds", `{"any": [{"attr": "subject.id", "op": "eq", "value": 8}, {"attr": "context.hour", "op": "gte", "value": 9}]}`, true},
+		{"none of any holds", `{"any": [{"attr": "subject.id", "op": "eq", "value": 8}, {"attr": "context.hour", "op": "gte", "value": 17}]}`, false},
+		{"not", `{"not": {"attr": "context.weekday", "op": "in", "value": ["saturday", "sunday"]}}`, true},
+		{"nested", `{"all": [{"attr": "subject.roles", "op": "contains", "value": "staff"},
+			{"not": {"any": [{"attr": "context.hour", "op": "lt", "value": 9}, {"attr": "context.hour", "op": "gte", "value": 17}]}}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustParseCondition(t, tt.condition).Holds(attrs); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCondition(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		wantField string
+	}{
+		{"not JSON", `{"attr":`, "condition"},
+		{"unknown field", `{"attr": "subject.id", "op": "eq", "value": 7, "extra": 1}`, "condition"},
+		{"two kinds", `{"all": [{"attr": "subject.id", "op": "exists"}], "attr": "subject.id"}`, "condition"},
+		{"empty all", `{"all": []}`, "condition.all"},
+		{"attribute outside the namespaces", `{"attr": "user.id", "op": "exists"}`, "condition.attr"},
+		{"unknown operator", `{"attr": "subject.id", "op": "like", "value": "a"}`, "condition.op"},
+		{"value and ref", `{"attr": "subject.id", "op": "eq", "value": 7, "ref": "resource.id"}`, "condition"},
+		{"exists with a value", `{"attr": "subject.id", "op": "exists", "value": 7}`, "condition"},
+		{"in without a list", `{"attr": "subject.id", "op": "in", "value": 7}`, "condition.value"},
+		{"list outside in", `{"attr": "subject.id", "op": "eq", "value": [7]}`, "condition.value"},
+		{"object value", `{"attr": "subject.id", "op": "eq", "value": {"id": 7}}`, "condition.value"},
+		{"bad ref", `{"attr": "subject.id", "op": "eq", "ref": "owner"}`, "condition.ref"},
+		{"too deep", `{"not": {"not": {"not": {"not": {"not": {"not": {"not": {"not": {"attr": "subject.id", "op": "exists"}}}}}}}}}`, "condition.not.not.not.not.not.not.not.not"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validation.Validator
+			if condition := parseCondition(&v, "condition", tt.condition); condition != nil {
+				t.Errorf("an invalid condition parsed: %+v", condition)
+			}
+			err := v.Err()
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if !hasFieldError(err, tt.wantField) {
+				t.Errorf("got %v, want an error on %s", err, tt.wantField)
+			}
+		})
+	}
+}
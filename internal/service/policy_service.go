@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// Policy effects
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// AnyPermission makes a policy apply to checks of every permission, as *:*
+// does
+const AnyPermission = "*"
+
+// PolicyRule is a stored policy with its condition parsed, as the access
+// checks use it
+type PolicyRule struct {
+	model.Policy
+	condition *Condition
+}
+
+// compilePolicy parses a stored policy's condition. A policy whose condition
+// does not parse is logged and, to fail safe, dropped when it allows and
+// applied unconditionally when it denies.
+func compilePolicy(policy model.Policy) (PolicyRule, bool) {
+	var v validation.Validator
+	condition := parseCondition(&v, "condition", policy.Condition)
+	if err := v.Err(); err != nil {
+		logs.Error("invalid condition in policy "+policy.Name, err)
+		return PolicyRule{Policy: policy}, policy.Effect == EffectDeny
+	}
+	return PolicyRule{Policy: policy, condition: condition}, true
+}
+
+// Applies reports whether the policy covers a check of the permission on the
+// resource; its permission may hold wildcards
+func (r *PolicyRule) Applies(permission string, resource string) bool {
+	return (r.Permission == AnyPermission || PermissionMatches(r.Permission, permission)) && ScopeCovers(r.Scope, resource)
+}
+
+// Holds reports whether the policy's condition is true of attrs
+func (r *PolicyRule) Holds(attrs Attributes) bool {
+	return r.condition.Holds(attrs)
+}
+
+type PolicyService struct {
+	PolicyRepo repository.PolicyRepository
+	Cache      *AuthzCache
+}
+
+func NewPolicyService(repo repository.PolicyRepository, authzCache *AuthzCache) *PolicyService {
+	return &PolicyService{
+		PolicyRepo: repo,
+		Cache:      authzCache,
+	}
+}
+
+func validateAndSanitizePolicy(policy *model.Policy) error {
+	policy.Name = strings.TrimSpace(policy.Name)
+	policy.Description = strings.TrimSpace(policy.Description)
+	policy.Effect = strings.TrimSpace(strings.ToLower(policy.Effect))
+	policy.Permission = strings.TrimSpace(strings.ToLower(policy.Permission))
+	policy.Scope = strings.TrimSpace(policy.Scope)
+
+	var v validation.Validator
+	ValidatePolicy(&v, *policy)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	policy.Condition = compactCondition(policy.Condition)
+	return nil
+}
+
+// ValidatePolicy records the rules for a sanitized policy
+func ValidatePolicy(v *validation.Validator, policy model.Policy) {
+	v.Length("name", policy.Name, 1, 255)
+	if len(policy.Description) > 1024 {
+		v.Add("description", validation.CodeTooLong, "must be at most 1024 characters")
+	}
+	if policy.Effect != EffectAllow && policy.Effect != EffectDeny {
+		v.Add("effect", validation.CodeInvalidValue, "must be allow or deny")
+	}
+	if policy.Permission != AnyPermission {
+		ValidatePermissionPattern(v, "permission", policy.Permission)
+	}
+	ValidateScope(v, "scope", policy.Scope)
+	ValidateCondition(v, "condition", policy.Condition)
+}
+
+// GetAllPolicies returns every policy, oldest first
+func (s *PolicyService) GetAllPolicies(ctx context.Context) ([]model.Policy, error) {
+	policies, err := s.PolicyRepo.GetAllPolicies(ctx)
+	if err != nil {
+		logs.Error("error getting all policies", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
+	}
+	return policies, nil
+}
+
+func (s *PolicyService) GetPolicyByID(ctx context.Context, id uint64) (model.Policy, error) {
+	if id == 0 {
+		logs.Error("invalid policy id", nil)
+		return model.Policy{}, errors.NewAppError(errors.CodeBadRequest, "Invalid policy id")
+	}
+	policy, err := s.PolicyRepo.GetPolicyByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return model.Policy{}, errors.WrapAppError(errors.CodeNotFound, err, "Policy not found")
+	}
+	if err != nil {
+		logs.Error("error getting policy by id", err)
+		return model.Policy{}, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
+	}
+	return policy, nil
+}
+
+func (s *PolicyService) CreatePolicy(ctx context.Context, policy model.Policy) (model.Policy, error) {
+	if err := validateAndSanitizePolicy(&policy); err != nil {
+		return model.Policy{}, err
+	}
+
+	policy, err := s.PolicyRepo.CreatePolicy(ctx, policy)
+	if errors.Is(err, repository.ErrConflict) {
+		return model.Policy{}, errors.WrapAppError(errors.CodeConflict, err, "Policy name already exists")
+	}
+	if err != nil {
+		logs.Error("error creating policy", err)
+		return model.Policy{}, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
+	}
+	s.Cache.InvalidatePolicies(ctx)
+	return policy, nil
+}
+
+// UpdatePolicy replaces every field of the policy with the given ID
+func (s *PolicyService) UpdatePolicy(ctx context.Context, policy model.Policy) (model.Policy, error) {
+	if err := validateAndSanitizePolicy(&policy); err != nil {
+		return model.Policy{}, err
+	}
+
+	// Keep the stored timestamps, which callers do not send
+	existing, err := s.GetPolicyByID(ctx, policy.ID)
+	if err != nil {
+		return model.Policy{}, err
+	}
+	policy.Model = existing.Model
+
+	policy, err = s.PolicyRepo.UpdatePolicy(ctx, policy)
+	if errors.Is(err, repository.ErrConflict) {
+		return model.Policy{}, errors.WrapAppError(errors.CodeConflict, err, "Policy name already exists")
+	}
+	if err != nil {
+		logs.Error("error updating policy", err)
+		return model.Policy{}, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
+	}
+	s.Cache.InvalidatePolicies(ctx)
+	return policy, nil
+}
+
+func (s *PolicyService) DeletePolicy(ctx context.Context, id uint64) error {
+	if id == 0 {
+		logs.Error("invalid policy id", nil)
+		return errors.NewAppError(errors.CodeBadRequest, "Invalid policy id")
+	}
+	err := s.PolicyRepo.DeletePolicy(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return errors.WrapAppError(errors.CodeNotFound, err, "Policy not found")
+	}
+	if err != nil {
+		logs.Error("error deleting policy", err)
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred.")
+	}
+	s.Cache.InvalidatePolicies(ctx)
+	return nil
+}
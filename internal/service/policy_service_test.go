@@ -0,0 +1,74 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+)
+
+func TestCompilePolicy(t *testing.T) {
+	holds := Attributes{"subject.id": float64(7)}
+	fails := Attributes{"subject.id": float64(8)}
+
+	tests := []struct {
+		name      string
+		effect    string
+		condition string
+		wantKept  bool
+		// wantHolds and wantFails are whether the compiled policy holds of
+		// the attributes its condition is true and false of
+		wantHolds bool
+		wantFails bool
+	}{
+		{"allow", EffectAllow, `{"attr": "subject.id", "op": "eq", "value": 7}`, true, true, false},
+		{"deny", EffectDeny, `{"attr": "subject.id", "op": "eq", "value": 7}`, true, true, false},
+		{"allow without a condition", EffectAllow, "", true, true, true},
+		{"allow that does not parse is dropped", EffectAllow, `{"attr": "subject.id", "op": "like"}`, false, false, false},
+		{"deny that does not parse always holds", EffectDeny, `{"attr": "subject.id", "op": "like"}`, true, true, true},
+		{"deny that is not JSON always holds", EffectDeny, `{"attr"`, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, kept := compilePolicy(model.Policy{Name: tt.name, Effect: tt.effect, Permission: AnyPermission, Condition: tt.condition})
+			if kept != tt.wantKept {
+				t.Fatalf("kept %v, want %v", kept, tt.wantKept)
+			}
+			if !kept {
+				return
+			}
+			if got := rule.Holds(holds); got != tt.wantHolds {
+				t.Errorf("holds where the condition is true: got %v, want %v", got, tt.wantHolds)
+			}
+			if got := rule.Holds(fails); got != tt.wantFails {
+				t.Errorf("holds where the condition is false: got %v, want %v", got, tt.wantFails)
+			}
+		})
+	}
+}
+
+func TestPolicyApplies(t *testing.T) {
+	tests := []struct {
+		name       string
+		permission string
+		scope      string
+		check      string
+		resource   string
+		want       bool
+	}{
+		{"same permission", "documents:read", "", "documents:read", "", true},
+		{"other permission", "documents:read", "", "documents:write", "", false},
+		{"any permission", AnyPermission, "", "users:delete", "users:7", true},
+		{"wildcard action", "documents:*", "", "documents:write", "", true},
+		{"scope covers the resource", "documents:read", "project:42", "documents:read", "project:42/documents:7", true},
+		{"scope misses the resource", "documents:read", "project:42", "documents:read", "project:7/documents:7", false},
+		{"scoped policy on a global check", "documents:read", "project:42", "documents:read", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, _ := compilePolicy(model.Policy{Effect: EffectAllow, Permission: tt.permission, Scope: tt.scope})
+			if got := rule.Applies(tt.check, tt.resource); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
+)
+
+// A resource is a path of type:id segments from the outermost parent down,
+// such as project:42/documents:7. A scope is a resource path in which any ID
+// may be the wildcard *, such as project:*. A role assigned in a scope applies
+// to every resource the scope matches and to everything beneath them, so
+// editor on project:42 can edit project:42/documents:7. The empty scope is
+// global and applies to every resource, and to checks made without one.
+
+// WildcardID matches any ID in its segment of a scope
+const WildcardID = "*"
+
+// MaxScopeLength is the longest resource or scope that can be stored
+const MaxScopeLength = 255
+
+var (
+	resourceSegment = regexp.MustCompile(`^[a-z][a-z0-9_-]*:[A-Za-z0-9._-]+$`)
+	scopeSegment    = regexp.MustCompile(`^[a-z][a-z0-9_-]*:([A-Za-z0-9._-]+|\*)$`)
+)
+
+// ValidateResource records the rules for a resource path; the empty path is valid
+func ValidateResource(v *validation.Validator, field string, resource string) {
+	validatePath(v, field, resource, resourceSegment, "type:id segments separated by /, e.g. project:42/documents:7")
+}
+
+// ValidateScope records the rules for a scope; the empty scope is valid
+func ValidateScope(v *validation.Validator, field string, scope string) {
+	validatePath(v, field, scope, scopeSegment, "type:id segments separated by /, where an id may be *, e.g. project:*/documents:7")
+}
+
+func validatePath(v *validation.Validator, field string, path string, segment *regexp.Regexp, expected string) {
+	if path == "" {
+		return
+	}
+	if len(path) > MaxScopeLength {
+		v.Length(field, path, 0, MaxScopeLength)
+		return
+	}
+	for _, part := range strings.Split(path, "/") {
+		if !segment.MatchString(part) {
+			v.Add(field, validation.CodeInvalidFormat, "must be "+expected)
+			return
+		}
+	}
+}
+
+// ScopeCovers reports whether a role assigned in scope applies to resource
+func ScopeCovers(scope string, resource string) bool {
+	if scope == "" {
+		return true
+	}
+	if resource == "" {
+		return false
+	}
+	scopeParts := strings.Split(scope, "/")
+	resourceParts := strings.Split(resource, "/")
+	if len(scopeParts) > len(resourceParts) {
+		return false
+	}
+	for i, part := range scopeParts {
+		scopeType, scopeID, _ := strings.Cut(part, ":")
+		resourceType, resourceID, _ := strings.Cut(resourceParts[i], ":")
+		if scopeType != resourceType || (scopeID != WildcardID && scopeID != resourceID) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,22 +1,26 @@
 package service
 
 import (
+	"context"
 	"strings"
 
 	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
 	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
 	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
 	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
 )
 
 type RoleService struct {
 	RoleRepo repository.RoleRepository
+	Cache    *AuthzCache
 }
 
 // NewUserService creates a new UserService with the provided repo
-func NewRoleService(repo repository.RoleRepository) *RoleService {
+func NewRoleService(repo repository.RoleRepository, authzCache *AuthzCache) *RoleService {
 	return &RoleService{
 		RoleRepo: repo,
+		Cache:    authzCache,
 	}
 }
 
@@ -26,81 +30,106 @@ func validateAndSanitizeRole(role *model.Role) error {
 		return errors.NewAppError(errors.CodeBadRequest, "role cannot be nil")
 	}
 
-	if len(role.RoleName) < 3 || len(role.RoleName) > 255 {
-		logs.Error("role name length is out of allowed range", nil)
-		return errors.NewAppError(errors.CodeBadRequest, "role name length must be between 3 and 255 characters")
-	}
-
 	// Sanitize input
 	role.RoleName = strings.TrimSpace(role.RoleName)
 
-	return nil
+	var v validation.Validator
+	ValidateRoleName(&v, role.RoleName)
+	return v.Err()
+}
+
+// ValidateRoleName records the rules for a sanitized role name
+func ValidateRoleName(v *validation.Validator, name string) {
+	v.Length("role_name", name, 3, 255)
 }
 
-func (s *RoleService) CreateRole(role *model.Role) (*model.Role, error) {
+func (s *RoleService) CreateRole(ctx context.Context, role *model.Role) (*model.Role, error) {
 	// Validate and Sanitize input
 	err := validateAndSanitizeRole(role)
 	if err != nil {
 		return nil, err
 	}
 
-	newRole, err := s.RoleRepo.CreateRole(role)
+	newRole, err := s.RoleRepo.CreateRole(ctx, role)
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, errors.WrapAppError(errors.CodeConflict, err, "Role name already exists")
+	}
 	if err != nil {
 		logs.Error("error creating role", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 
 	return newRole, nil
 }
 
-func (s *RoleService) GetRoleByID(id uint64) (*model.Role, error) {
+func (s *RoleService) GetRoleByID(ctx context.Context, id uint64) (*model.Role, error) {
 	// Check if id is valid
 	if id == 0 {
 		logs.Error("invalid role id", errors.NewAppError(errors.CodeBadRequest, "invalid role id"))
 		return nil, errors.NewAppError(errors.CodeBadRequest, "invalid role id")
 	}
 
-	role, err := s.RoleRepo.GetRoleByID(id)
+	role, err := s.RoleRepo.GetRoleByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errors.WrapAppError(errors.CodeNotFound, err, "Role not found")
+	}
 	if err != nil {
 		logs.Error("error fetching role by id", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 
 	return role, nil
 }
 
-func (s *RoleService) UpdateRole(role *model.Role) (*model.Role, error) {
+func (s *RoleService) UpdateRole(ctx context.Context, role *model.Role) (*model.Role, error) {
 	err := validateAndSanitizeRole(role)
 	if err != nil {
 		return nil, err
 	}
 
-	updatedRole, err := s.RoleRepo.UpdateRole(role)
+	// Keep the stored timestamps, which callers do not send
+	existing, err := s.RoleRepo.GetRoleByID(ctx, role.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errors.WrapAppError(errors.CodeNotFound, err, "Role not found")
+	}
+	if err != nil {
+		logs.Error("error fetching role by id", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+	role.Model = existing.Model
+
+	updatedRole, err := s.RoleRepo.UpdateRole(ctx, role)
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, errors.WrapAppError(errors.CodeConflict, err, "Role name already exists")
+	}
 	if err != nil {
 		logs.Error("error updating role", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
+	s.Cache.InvalidateAllUsers(ctx)
 
 	return updatedRole, nil
 }
 
-func (s *RoleService) DeleteRole(id uint64) error {
+func (s *RoleService) DeleteRole(ctx context.Context, id uint64) error {
 	// Check if id is valid
 	if id == 0 {
 		logs.Error("invalid role id", errors.NewAppError(errors.CodeBadRequest, "invalid role id"))
-		return errors.NewAppError(errors.CodeBadRequest, "Username already exists")
+		return errors.NewAppError(errors.CodeBadRequest, "invalid role id")
 	}
 
-	err := s.RoleRepo.DeleteRole(id)
+	err := s.RoleRepo.DeleteRole(ctx, id)
 	if err != nil {
 		logs.Error("error deleting role", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
+	s.Cache.InvalidateAllUsers(ctx)
+	s.Cache.InvalidateRoles(ctx, id)
 
 	return nil
 }
 
-func (s *RoleService) AddUserRole(userID uint64, roleID uint64) error {
+func (s *RoleService) AddUserRole(ctx context.Context, userID uint64, roleID uint64) error {
 	// Validate user id and role id
 	if userID == 0 {
 		logs.Error("invalid user id", errors.NewAppError(errors.CodeBadRequest, "user id cannot be zero"))
@@ -112,16 +141,17 @@ func (s *RoleService) AddUserRole(userID uint64, roleID uint64) error {
 		return errors.NewAppError(errors.CodeBadRequest, "role id cannot be zero")
 	}
 
-	err := s.RoleRepo.AddUserRole(userID, roleID)
+	err := s.RoleRepo.AddUserRole(ctx, userID, roleID)
 	if err != nil {
 		logs.Error("error adding role to user", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
+	s.Cache.InvalidateUsers(ctx, userID)
 
 	return nil
 }
 
-func (s *RoleService) RemoveUserRole(userID uint64, roleID uint64) error {
+func (s *RoleService) RemoveUserRole(ctx context.Context, userID uint64, roleID uint64) error {
 	// Validate user id and role id
 	if userID == 0 {
 		logs.Error("invalid user id", errors.NewAppError(errors.CodeBadRequest, "user id cannot be zero"))
@@ -133,42 +163,141 @@ func (s *RoleService) RemoveUserRole(userID uint64, roleID uint64) error {
 		return errors.NewAppError(errors.CodeBadRequest, "role id cannot be zero")
 	}
 
-	err := s.RoleRepo.RemoveUserRole(userID, roleID)
+	err := s.RoleRepo.RemoveUserRole(ctx, userID, roleID)
 	if err != nil {
 		logs.Error("error removing role from user", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+	s.Cache.InvalidateUsers(ctx, userID)
+
+	return nil
+}
+
+// AddScopedUserRole assigns the role to the user within scope, or globally
+// when scope is empty. Assigning a role the user already holds in the same
+// scope changes nothing.
+func (s *RoleService) AddScopedUserRole(ctx context.Context, userID uint64, roleID uint64, scope string) error {
+	scope = strings.TrimSpace(scope)
+	if scope == "" {
+		return s.AddUserRole(ctx, userID, roleID)
+	}
+	if err := validateUserRoleScope(userID, roleID, scope); err != nil {
+		return err
+	}
+
+	assignments, err := s.RoleRepo.GetRoleAssignments(ctx, userID)
+	if err != nil {
+		logs.Error("error fetching role assignments", err)
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+	for _, assignment := range assignments {
+		if assignment.Role.ID == roleID && assignment.Scope == scope {
+			return nil
+		}
+	}
+
+	if err := s.RoleRepo.AddScopedUserRole(ctx, userID, roleID, scope); err != nil {
+		logs.Error("error adding scoped role to user", err)
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+	s.Cache.InvalidateUsers(ctx, userID)
+
+	return nil
+}
+
+// RemoveScopedUserRole takes the role assigned within scope away from the
+// user, or the global assignment when scope is empty
+func (s *RoleService) RemoveScopedUserRole(ctx context.Context, userID uint64, roleID uint64, scope string) error {
+	scope = strings.TrimSpace(scope)
+	if scope == "" {
+		return s.RemoveUserRole(ctx, userID, roleID)
+	}
+	if err := validateUserRoleScope(userID, roleID, scope); err != nil {
+		return err
+	}
+
+	if err := s.RoleRepo.RemoveScopedUserRole(ctx, userID, roleID, scope); err != nil {
+		logs.Error("error removing scoped role from user", err)
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
+	s.Cache.InvalidateUsers(ctx, userID)
 
 	return nil
 }
 
-func (s *RoleService) GetAllRoles() ([]model.Role, error) {
-	roles, err := s.RoleRepo.GetAllRoles()
+func validateUserRoleScope(userID uint64, roleID uint64, scope string) error {
+	var v validation.Validator
+	v.RequiredID("user_id", userID)
+	v.RequiredID("role_id", roleID)
+	ValidateScope(&v, "scope", scope)
+	return v.Err()
+}
+
+// GetRoleAssignments returns the user's roles in every scope, in the order
+// they were assigned
+func (s *RoleService) GetRoleAssignments(ctx context.Context, userID uint64) ([]repository.RoleAssignment, error) {
+	if userID == 0 {
+		logs.Error("invalid user id", errors.NewAppError(errors.CodeBadRequest, "user id cannot be zero"))
+		return nil, errors.NewAppError(errors.CodeBadRequest, "user id cannot be zero")
+	}
+
+	assignments, err := s.Cache.RoleAssignments(ctx, userID)
+	if err != nil {
+		logs.Error("error fetching role assignments", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	return assignments, nil
+}
+
+func (s *RoleService) GetAllRoles(ctx context.Context) ([]model.Role, error) {
+	roles, err := s.RoleRepo.GetAllRoles(ctx)
 	if err != nil {
 		logs.Error("error fetching all roles", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 
 	return roles, nil
 }
 
-func (s *RoleService) GetRolesByUserID(userID uint64) ([]model.Role, error) {
+// QueryRoles returns one page of the roles the query selects
+func (s *RoleService) QueryRoles(ctx context.Context, query repository.ListQuery) (repository.Page[model.Role], error) {
+	page, err := s.RoleRepo.QueryRoles(ctx, query)
+	if err != nil {
+		logs.Error("error listing roles", err)
+		return page, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	return page, nil
+}
+
+// ListUserRoles returns one page of a user's roles
+func (s *RoleService) ListUserRoles(ctx context.Context, userID uint64, query repository.ListQuery) (repository.Page[model.Role], error) {
+	return s.QueryRoles(ctx, query.And(repository.Filter{Op: repository.FilterEq, Field: "user_id", Value: userID}))
+}
+
+// ListPermissionRoles returns one page of the roles a permission is granted to
+func (s *RoleService) ListPermissionRoles(ctx context.Context, permissionID uint64, query repository.ListQuery) (repository.Page[model.Role], error) {
+	return s.QueryRoles(ctx, query.And(repository.Filter{Op: repository.FilterEq, Field: "permission_id", Value: permissionID}))
+}
+
+func (s *RoleService) GetRolesByUserID(ctx context.Context, userID uint64) ([]model.Role, error) {
 	// Validate user id
 	if userID == 0 {
 		logs.Error("invalid user id", errors.NewAppError(errors.CodeBadRequest, "user id cannot be zero"))
 		return nil, errors.NewAppError(errors.CodeBadRequest, "user id cannot be zero")
 	}
 
-	roles, err := s.RoleRepo.GetRolesByUserID(userID)
+	roles, err := s.Cache.UserRoles(ctx, userID)
 	if err != nil {
 		logs.Error("error fetching roles by user id", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 
 	return roles, nil
 }
 
-func (s *RoleService) UserHasRole(userID uint64, roleName string) (bool, error) {
+func (s *RoleService) UserHasRole(ctx context.Context, userID uint64, roleName string) (bool, error) {
 	// Validate user id and role name
 	if userID == 0 {
 		logs.Error("invalid user id", errors.NewAppError(errors.CodeBadRequest, "user id cannot be zero"))
@@ -180,11 +309,47 @@ func (s *RoleService) UserHasRole(userID uint64, roleName string) (bool, error)
 		return false, errors.NewAppError(errors.CodeBadRequest, "role name must be at least 2 characters long")
 	}
 
-	hasRole, err := s.RoleRepo.UserHasRole(userID, roleName)
+	roles, err := s.Cache.UserRoles(ctx, userID)
 	if err != nil {
 		logs.Error("error checking user role", err)
-		return false, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return false, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+	for _, role := range roles {
+		if role.RoleName == roleName {
+			return true, nil
+		}
 	}
 
-	return hasRole, nil
+	return false, nil
+}
+
+// grantRolesByName assigns every named role the user does not have yet.
+// Externally mapped roles are best effort, so failures are logged rather than returned.
+func grantRolesByName(ctx context.Context, roleRepo repository.RoleRepository, authzCache *AuthzCache, userID uint64, roleNames []string) {
+	for _, roleName := range roleNames {
+		hasRole, err := roleRepo.UserHasRole(ctx, userID, roleName)
+		if err != nil {
+			logs.Error("error checking mapped role", err)
+			continue
+		}
+		if hasRole {
+			continue
+		}
+
+		role, err := roleRepo.GetRoleByName(ctx, roleName)
+		if errors.Is(err, repository.ErrNotFound) {
+			logs.Warnf("mapped role %s does not exist", roleName)
+			continue
+		}
+		if err != nil {
+			logs.Error("error fetching mapped role", err)
+			continue
+		}
+
+		if err := roleRepo.AddUserRole(ctx, userID, role.ID); err != nil {
+			logs.Error("error assigning mapped role", err)
+			continue
+		}
+		authzCache.InvalidateUsers(ctx, userID)
+	}
 }
@@ -0,0 +1,740 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/scim"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+// SCIMService maps SCIM Users onto users and SCIM Groups onto roles, with
+// group members stored as user role assignments. Every error it returns is a
+// *scim.Error. A user marked inactive is kept but cannot log in; only DELETE
+// removes one. Writes take several steps, so callers run them in a unit of
+// work.
+type SCIMService struct {
+	UserRepo   repository.UserRepository
+	RoleRepo   repository.RoleRepository
+	AuthzCache *AuthzCache
+}
+
+func NewSCIMService(userRepo repository.UserRepository, roleRepo repository.RoleRepository, authzCache *AuthzCache) *SCIMService {
+	return &SCIMService{
+		UserRepo:   userRepo,
+		RoleRepo:   roleRepo,
+		AuthzCache: authzCache,
+	}
+}
+
+func scimInternalError(msg string, err error) *scim.Error {
+	logs.Error(msg, err)
+	return scim.NewError(http.StatusInternalServerError, "", "Internal server error occurred")
+}
+
+// pageBounds converts SCIM's 1-based startIndex and count into an offset and limit
+func pageBounds(startIndex int, count int) (int, int, int) {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > scim.MaxResults {
+		count = scim.MaxResults
+	}
+	return startIndex, startIndex - 1, count
+}
+
+func idFilter(ids ...uint64) *repository.Filter {
+	operands := make([]repository.Filter, 0, len(ids))
+	for _, id := range ids {
+		operands = append(operands, repository.Filter{Op: repository.FilterEq, Field: "id", Value: id})
+	}
+	return &repository.Filter{Op: repository.FilterOr, Operands: operands}
+}
+
+func parseResourceID(id string, resourceType string) (uint64, *scim.Error) {
+	parsed, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || parsed == 0 {
+		return 0, scim.NotFound("%s %s not found", resourceType, id)
+	}
+	return parsed, nil
+}
+
+// ListUsers returns one page of the users matching the SCIM filter expression
+func (s *SCIMService) ListUsers(ctx context.Context, filter string, startIndex int, count int) (*scim.ListResponse, error) {
+	var parsed *repository.Filter
+	if filter != "" {
+		var err error
+		if parsed, err = scim.ParseFilter(filter, scim.SchemaUser, scim.UserAttributes); err != nil {
+			return nil, err
+		}
+	}
+
+	startIndex, offset, limit := pageBounds(startIndex, count)
+	users, total, err := s.UserRepo.FindUsers(ctx, parsed, offset, limit)
+	if err != nil {
+		return nil, scimInternalError("error listing scim users", err)
+	}
+
+	resources := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		resource, err := s.toSCIMUser(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return scim.NewListResponse(resources, total, startIndex), nil
+}
+
+func (s *SCIMService) GetUser(ctx context.Context, id string) (*scim.User, error) {
+	user, err := s.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.toSCIMUser(ctx, user)
+}
+
+func (s *SCIMService) CreateUser(ctx context.Context, resource *scim.User) (*scim.User, error) {
+	if resource.Active != nil && !*resource.Active {
+		return nil, scim.BadRequest(scim.ErrInvalidValue, "users cannot be created inactive")
+	}
+
+	user := &model.User{}
+	if err := s.applyUserResource(ctx, user, resource.UserName, resource.PrimaryEmail(), resource.Password); err != nil {
+		return nil, err
+	}
+
+	if err := s.UserRepo.CreateUser(ctx, user); err != nil {
+		return nil, scimInternalError("error creating scim user", err)
+	}
+	return s.toSCIMUser(ctx, user)
+}
+
+// ReplaceUser overwrites the user with the resource, as for PUT
+func (s *SCIMService) ReplaceUser(ctx context.Context, id string, resource *scim.User) (*scim.User, error) {
+	user, err := s.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	state := userPatchState{
+		UserName: resource.UserName,
+		Email:    resource.PrimaryEmail(),
+		Password: resource.Password,
+		Active:   resource.Active == nil || *resource.Active,
+	}
+	return s.saveUser(ctx, user, state)
+}
+
+// PatchUser applies the PATCH operations to the user
+func (s *SCIMService) PatchUser(ctx context.Context, id string, patch *scim.PatchRequest) (*scim.User, error) {
+	user, err := s.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	state := userPatchState{UserName: user.Username, Email: user.Email, Active: user.Active}
+	for _, operation := range patch.Operations {
+		if err := state.apply(strings.ToLower(operation.Op), operation.Path, operation.Value); err != nil {
+			return nil, err
+		}
+	}
+	return s.saveUser(ctx, user, state)
+}
+
+func (s *SCIMService) DeleteUser(ctx context.Context, id string) error {
+	user, err := s.findUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.UserRepo.DeleteUser(ctx, user.ID); err != nil {
+		return scimInternalError("error deleting scim user", err)
+	}
+	s.AuthzCache.RevokeUsers(ctx, user.ID)
+	return nil
+}
+
+func (s *SCIMService) findUser(ctx context.Context, id string) (*model.User, error) {
+	userID, scimErr := parseResourceID(id, "User")
+	if scimErr != nil {
+		return nil, scimErr
+	}
+
+	users, _, err := s.UserRepo.FindUsers(ctx, idFilter(userID), 0, 1)
+	if err != nil {
+		return nil, scimInternalError("error fetching scim user", err)
+	}
+	if len(users) == 0 {
+		return nil, scim.NotFound("User %s not found", id)
+	}
+	return users[0], nil
+}
+
+// applyUserResource validates the writable attributes and copies them onto the user
+func (s *SCIMService) applyUserResource(ctx context.Context, user *model.User, userName string, email string, password string) error {
+	candidate := &model.User{
+		Username:     userName,
+		Email:        email,
+		PasswordHash: user.PasswordHash,
+	}
+	sanitizeInput(candidate)
+
+	if password != "" {
+		hash, err := HashPassword(&model.User{PasswordHash: password})
+		if err != nil {
+			return scimInternalError("error hashing scim password", err)
+		}
+		candidate.PasswordHash = string(hash)
+	} else if candidate.PasswordHash == "" {
+		hash, err := unusablePasswordHash()
+		if err != nil {
+			return scimInternalError("error generating password hash", err)
+		}
+		candidate.PasswordHash = hash
+	}
+
+	if err := validateInput(candidate); err != nil {
+		return scim.BadRequest(scim.ErrInvalidValue, "%s", err.Error())
+	}
+
+	conflict := &repository.Filter{Op: repository.FilterOr, Operands: []repository.Filter{
+		{Op: repository.FilterEq, Field: "username", Value: candidate.Username},
+		{Op: repository.FilterEq, Field: "email", Value: candidate.Email},
+	}}
+	if user.ID != 0 {
+		conflict = &repository.Filter{Op: repository.FilterAnd, Operands: []repository.Filter{
+			*conflict,
+			{Op: repository.FilterNe, Field: "id", Value: user.ID},
+		}}
+	}
+	_, matches, err := s.UserRepo.FindUsers(ctx, conflict, 0, 0)
+	if err != nil {
+		return scimInternalError("error checking scim user uniqueness", err)
+	}
+	if matches > 0 {
+		return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "userName or email already in use")
+	}
+
+	user.Username = candidate.Username
+	user.Email = candidate.Email
+	user.PasswordHash = candidate.PasswordHash
+	return nil
+}
+
+// saveUser stores the patched state. Deactivating a user also revokes the
+// tokens it holds.
+func (s *SCIMService) saveUser(ctx context.Context, user *model.User, state userPatchState) (*scim.User, error) {
+	if err := s.applyUserResource(ctx, user, state.UserName, state.Email, state.Password); err != nil {
+		return nil, err
+	}
+
+	deactivated := user.Active && !state.Active
+	user.Active = state.Active
+	if err := s.UserRepo.UpdateUser(ctx, user); err != nil {
+		return nil, scimInternalError("error updating scim user", err)
+	}
+	if deactivated {
+		s.AuthzCache.RevokeUsers(ctx, user.ID)
+	}
+
+	return s.toSCIMUser(ctx, user)
+}
+
+func (s *SCIMService) toSCIMUser(ctx context.Context, user *model.User) (*scim.User, error) {
+	roles, err := s.RoleRepo.GetRolesByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, scimInternalError("error fetching scim user groups", err)
+	}
+
+	active := user.Active
+	resource := &scim.User{
+		Schemas:  []string{scim.SchemaUser},
+		ID:       strconv.FormatUint(user.ID, 10),
+		UserName: user.Username,
+		Emails:   []scim.Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:   &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+		},
+	}
+	for _, role := range roles {
+		resource.Groups = append(resource.Groups, scim.Reference{
+			Value:   strconv.FormatUint(role.ID, 10),
+			Display: role.RoleName,
+		})
+	}
+	return resource, nil
+}
+
+// userPatchState holds the user attributes a PATCH request can change
+type userPatchState struct {
+	UserName string
+	Email    string
+	Password string
+	Active   bool
+}
+
+func (u *userPatchState) apply(op string, path string, value json.RawMessage) error {
+	if op != "add" && op != "replace" && op != "remove" {
+		return scim.BadRequest(scim.ErrInvalidSyntax, "unknown patch operation %q", op)
+	}
+
+	path = strings.TrimPrefix(strings.ToLower(path), strings.ToLower(scim.SchemaUser)+":")
+
+	// Without a path the value is an object of attributes to set
+	if path == "" {
+		if op == "remove" {
+			return scim.BadRequest(scim.ErrNoTarget, "remove requires a path")
+		}
+		var attributes map[string]json.RawMessage
+		if err := json.Unmarshal(value, &attributes); err != nil {
+			return scim.BadRequest(scim.ErrInvalidValue, "patch value must be an object when no path is given")
+		}
+		for name, attributeValue := range attributes {
+			if err := u.apply(op, name, attributeValue); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// emails[type eq "work"].value and emails.value both address the one email
+	if strings.HasPrefix(path, "emails[") || path == "emails.value" {
+		path = "emails.value"
+	}
+
+	switch path {
+	case "username":
+		if op == "remove" {
+			return scim.BadRequest(scim.ErrMutability, "userName is required")
+		}
+		return decodePatchValue(value, &u.UserName)
+	case "emails":
+		if op == "remove" {
+			return scim.BadRequest(scim.ErrMutability, "an email is required")
+		}
+		var emails []scim.Email
+		if err := json.Unmarshal(value, &emails); err != nil {
+			return scim.BadRequest(scim.ErrInvalidValue, "emails must be a list")
+		}
+		user := scim.User{Emails: emails}
+		if user.PrimaryEmail() != "" {
+			u.Email = user.PrimaryEmail()
+		}
+		return nil
+	case "emails.value":
+		if op == "remove" {
+			return scim.BadRequest(scim.ErrMutability, "an email is required")
+		}
+		return decodePatchValue(value, &u.Email)
+	case "password":
+		if op == "remove" {
+			return scim.BadRequest(scim.ErrMutability, "password cannot be removed")
+		}
+		return decodePatchValue(value, &u.Password)
+	case "active":
+		if op == "remove" {
+			u.Active = false
+			return nil
+		}
+		// Some clients send booleans as the strings "True" and "False"
+		var raw interface{}
+		if err := json.Unmarshal(value, &raw); err != nil {
+			return scim.BadRequest(scim.ErrInvalidValue, "active must be a boolean")
+		}
+		switch active := raw.(type) {
+		case bool:
+			u.Active = active
+		case string:
+			parsed, err := strconv.ParseBool(active)
+			if err != nil {
+				return scim.BadRequest(scim.ErrInvalidValue, "active must be a boolean")
+			}
+			u.Active = parsed
+		default:
+			return scim.BadRequest(scim.ErrInvalidValue, "active must be a boolean")
+		}
+		return nil
+	}
+
+	// Attributes such as name or externalId are accepted but not stored
+	logs.Debugf("ignoring scim patch of unsupported user attribute %s", path)
+	return nil
+}
+
+func decodePatchValue(value json.RawMessage, target *string) error {
+	if err := json.Unmarshal(value, target); err != nil {
+		return scim.BadRequest(scim.ErrInvalidValue, "patch value must be a string")
+	}
+	return nil
+}
+
+// ListGroups returns one page of the roles matching the SCIM filter expression
+func (s *SCIMService) ListGroups(ctx context.Context, filter string, startIndex int, count int) (*scim.ListResponse, error) {
+	var parsed *repository.Filter
+	if filter != "" {
+		var err error
+		if parsed, err = scim.ParseFilter(filter, scim.SchemaGroup, scim.GroupAttributes); err != nil {
+			return nil, err
+		}
+	}
+
+	startIndex, offset, limit := pageBounds(startIndex, count)
+	roles, total, err := s.RoleRepo.FindRoles(ctx, parsed, offset, limit)
+	if err != nil {
+		return nil, scimInternalError("error listing scim groups", err)
+	}
+
+	resources := make([]interface{}, 0, len(roles))
+	for i := range roles {
+		resource, err := s.toSCIMGroup(ctx, &roles[i])
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return scim.NewListResponse(resources, total, startIndex), nil
+}
+
+func (s *SCIMService) GetGroup(ctx context.Context, id string) (*scim.Group, error) {
+	role, err := s.findRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.toSCIMGroup(ctx, role)
+}
+
+func (s *SCIMService) CreateGroup(ctx context.Context, resource *scim.Group) (*scim.Group, error) {
+	members, err := memberIDs(resource.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	role := &model.Role{RoleName: resource.DisplayName}
+	if err := s.validateGroupName(ctx, role, 0); err != nil {
+		return nil, err
+	}
+	if err := s.checkMembersExist(ctx, members); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.RoleRepo.CreateRole(ctx, role); err != nil {
+		return nil, scimInternalError("error creating scim group", err)
+	}
+	if err := s.setMembers(ctx, role.ID, members); err != nil {
+		return nil, err
+	}
+	return s.toSCIMGroup(ctx, role)
+}
+
+// ReplaceGroup overwrites the role name and members, as for PUT
+func (s *SCIMService) ReplaceGroup(ctx context.Context, id string, resource *scim.Group) (*scim.Group, error) {
+	role, err := s.findRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	members, err := memberIDs(resource.Members)
+	if err != nil {
+		return nil, err
+	}
+	return s.saveGroup(ctx, role, resource.DisplayName, members)
+}
+
+// PatchGroup applies the PATCH operations to the role and its members
+func (s *SCIMService) PatchGroup(ctx context.Context, id string, patch *scim.PatchRequest) (*scim.Group, error) {
+	role, err := s.findRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.RoleRepo.GetUsersByRoleID(ctx, role.ID)
+	if err != nil {
+		return nil, scimInternalError("error fetching scim group members", err)
+	}
+
+	state := groupPatchState{DisplayName: role.RoleName, Members: map[uint64]bool{}}
+	for _, user := range current {
+		state.Members[user.ID] = true
+	}
+	for _, operation := range patch.Operations {
+		if err := state.apply(strings.ToLower(operation.Op), operation.Path, operation.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	members := make([]uint64, 0, len(state.Members))
+	for userID := range state.Members {
+		members = append(members, userID)
+	}
+	return s.saveGroup(ctx, role, state.DisplayName, members)
+}
+
+func (s *SCIMService) DeleteGroup(ctx context.Context, id string) error {
+	role, err := s.findRole(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.RoleRepo.DeleteRole(ctx, role.ID); err != nil {
+		return scimInternalError("error deleting scim group", err)
+	}
+	s.AuthzCache.InvalidateAllUsers(ctx)
+	s.AuthzCache.InvalidateRoles(ctx, role.ID)
+	return nil
+}
+
+func (s *SCIMService) findRole(ctx context.Context, id string) (*model.Role, error) {
+	roleID, scimErr := parseResourceID(id, "Group")
+	if scimErr != nil {
+		return nil, scimErr
+	}
+
+	roles, _, err := s.RoleRepo.FindRoles(ctx, idFilter(roleID), 0, 1)
+	if err != nil {
+		return nil, scimInternalError("error fetching scim group", err)
+	}
+	if len(roles) == 0 {
+		return nil, scim.NotFound("Group %s not found", id)
+	}
+	return &roles[0], nil
+}
+
+func (s *SCIMService) validateGroupName(ctx context.Context, role *model.Role, exceptID uint64) error {
+	if err := validateAndSanitizeRole(role); err != nil {
+		return scim.BadRequest(scim.ErrInvalidValue, "%s", err.Error())
+	}
+
+	conflict := &repository.Filter{Op: repository.FilterAnd, Operands: []repository.Filter{
+		{Op: repository.FilterEq, Field: "role_name", Value: role.RoleName},
+		{Op: repository.FilterNe, Field: "id", Value: exceptID},
+	}}
+	_, matches, err := s.RoleRepo.FindRoles(ctx, conflict, 0, 0)
+	if err != nil {
+		return scimInternalError("error checking scim group uniqueness", err)
+	}
+	if matches > 0 {
+		return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "displayName already in use")
+	}
+	return nil
+}
+
+func (s *SCIMService) checkMembersExist(ctx context.Context, members []uint64) error {
+	if len(members) == 0 {
+		return nil
+	}
+	_, found, err := s.UserRepo.FindUsers(ctx, idFilter(members...), 0, 0)
+	if err != nil {
+		return scimInternalError("error fetching scim group members", err)
+	}
+	if found != int64(len(members)) {
+		return scim.BadRequest(scim.ErrInvalidValue, "members must reference existing users")
+	}
+	return nil
+}
+
+func (s *SCIMService) saveGroup(ctx context.Context, role *model.Role, displayName string, members []uint64) (*scim.Group, error) {
+	if displayName != role.RoleName {
+		renamed := &model.Role{RoleName: displayName}
+		if err := s.validateGroupName(ctx, renamed, role.ID); err != nil {
+			return nil, err
+		}
+		role.RoleName = renamed.RoleName
+		if _, err := s.RoleRepo.UpdateRole(ctx, role); err != nil {
+			return nil, scimInternalError("error renaming scim group", err)
+		}
+		s.AuthzCache.InvalidateAllUsers(ctx)
+	}
+
+	if err := s.checkMembersExist(ctx, members); err != nil {
+		return nil, err
+	}
+	if err := s.setMembers(ctx, role.ID, members); err != nil {
+		return nil, err
+	}
+	return s.toSCIMGroup(ctx, role)
+}
+
+// setMembers assigns and unassigns the role so exactly members hold it
+func (s *SCIMService) setMembers(ctx context.Context, roleID uint64, members []uint64) error {
+	current, err := s.RoleRepo.GetUsersByRoleID(ctx, roleID)
+	if err != nil {
+		return scimInternalError("error fetching scim group members", err)
+	}
+
+	wanted := map[uint64]bool{}
+	for _, userID := range members {
+		wanted[userID] = true
+	}
+
+	for _, user := range current {
+		if wanted[user.ID] {
+			delete(wanted, user.ID)
+			continue
+		}
+		if err := s.RoleRepo.RemoveUserRole(ctx, user.ID, roleID); err != nil {
+			return scimInternalError("error removing scim group member", err)
+		}
+		s.AuthzCache.InvalidateUsers(ctx, user.ID)
+	}
+	for userID := range wanted {
+		if err := s.RoleRepo.AddUserRole(ctx, userID, roleID); err != nil {
+			return scimInternalError("error adding scim group member", err)
+		}
+		s.AuthzCache.InvalidateUsers(ctx, userID)
+	}
+	return nil
+}
+
+func (s *SCIMService) toSCIMGroup(ctx context.Context, role *model.Role) (*scim.Group, error) {
+	users, err := s.RoleRepo.GetUsersByRoleID(ctx, role.ID)
+	if err != nil {
+		return nil, scimInternalError("error fetching scim group members", err)
+	}
+
+	resource := &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          strconv.FormatUint(role.ID, 10),
+		DisplayName: role.RoleName,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      role.CreatedAt,
+			LastModified: role.UpdatedAt,
+		},
+	}
+	for _, user := range users {
+		resource.Members = append(resource.Members, scim.Reference{
+			Value:   strconv.FormatUint(user.ID, 10),
+			Display: user.Username,
+		})
+	}
+	return resource, nil
+}
+
+func memberIDs(members []scim.Reference) ([]uint64, error) {
+	ids := make([]uint64, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.ParseUint(member.Value, 10, 64)
+		if err != nil {
+			return nil, scim.BadRequest(scim.ErrInvalidValue, "invalid member %q", member.Value)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// memberAttributes lets member value filters, as in members[value eq "7"], be parsed
+var memberAttributes = scim.AttributeMap{
+	"members.value": {Field: "id", Type: scim.TypeID},
+}
+
+// groupPatchState holds the role attributes a PATCH request can change
+type groupPatchState struct {
+	DisplayName string
+	Members     map[uint64]bool
+}
+
+func (g *groupPatchState) apply(op string, path string, value json.RawMessage) error {
+	if op != "add" && op != "replace" && op != "remove" {
+		return scim.BadRequest(scim.ErrInvalidSyntax, "unknown patch operation %q", op)
+	}
+
+	path = strings.TrimPrefix(strings.ToLower(path), strings.ToLower(scim.SchemaGroup)+":")
+
+	if path == "" {
+		if op == "remove" {
+			return scim.BadRequest(scim.ErrNoTarget, "remove requires a path")
+		}
+		var attributes map[string]json.RawMessage
+		if err := json.Unmarshal(value, &attributes); err != nil {
+			return scim.BadRequest(scim.ErrInvalidValue, "patch value must be an object when no path is given")
+		}
+		for name, attributeValue := range attributes {
+			if err := g.apply(op, name, attributeValue); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case path == "displayname":
+		if op == "remove" {
+			return scim.BadRequest(scim.ErrMutability, "displayName is required")
+		}
+		return decodePatchValue(value, &g.DisplayName)
+
+	case path == "members":
+		if op == "remove" && len(value) == 0 {
+			g.Members = map[uint64]bool{}
+			return nil
+		}
+		var references []scim.Reference
+		if err := json.Unmarshal(value, &references); err != nil {
+			return scim.BadRequest(scim.ErrInvalidValue, "members must be a list")
+		}
+		ids, err := memberIDs(references)
+		if err != nil {
+			return err
+		}
+		if op == "replace" {
+			g.Members = map[uint64]bool{}
+		}
+		for _, id := range ids {
+			if op == "remove" {
+				delete(g.Members, id)
+			} else {
+				g.Members[id] = true
+			}
+		}
+		return nil
+
+	case strings.HasPrefix(path, "members["):
+		if op != "remove" {
+			return scim.BadRequest(scim.ErrInvalidPath, "only remove supports a member filter")
+		}
+		expression := strings.TrimSuffix(strings.TrimPrefix(path, "members["), "]")
+		filter, err := scim.ParseFilter("members["+expression+"]", scim.SchemaGroup, memberAttributes)
+		if err != nil {
+			return err
+		}
+		ids, ok := filterIDs(*filter)
+		if !ok {
+			return scim.BadRequest(scim.ErrInvalidFilter, "member filters may only compare value with eq")
+		}
+		for _, id := range ids {
+			delete(g.Members, id)
+		}
+		return nil
+	}
+
+	return scim.BadRequest(scim.ErrInvalidPath, "unsupported group attribute %s", path)
+}
+
+// filterIDs extracts the IDs from a filter made only of "id eq" comparisons joined by or
+func filterIDs(filter repository.Filter) ([]uint64, bool) {
+	switch filter.Op {
+	case repository.FilterEq:
+		id, ok := filter.Value.(uint64)
+		return []uint64{id}, ok && filter.Field == "id"
+	case repository.FilterOr:
+		var ids []uint64
+		for _, operand := range filter.Operands {
+			operandIDs, ok := filterIDs(operand)
+			if !ok {
+				return nil, false
+			}
+			ids = append(ids, operandIDs...)
+		}
+		return ids, true
+	}
+	return nil, false
+}
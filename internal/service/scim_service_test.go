@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/scim"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+)
+
+func TestSCIMDeactivationKeepsTheUser(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	s := NewSCIMService(r.users, r.roles, r.authzCache)
+	login := NewPasswordAuthenticator(r.users)
+
+	created, err := s.CreateUser(ctx, &scim.User{
+		UserName: "alice",
+		Emails:   []scim.Email{{Value: "alice@example.com", Primary: true}},
+		Password: "correct horse battery staple",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setActive := func(active bool) *scim.User {
+		t.Helper()
+		value, _ := json.Marshal(active)
+		patch := &scim.PatchRequest{Operations: []scim.PatchOperation{{Op: "replace", Path: "active", Value: value}}}
+		var resource *scim.User
+		err := r.unitOfWork.Do(ctx, func(ctx context.Context) error {
+			var err error
+			resource, err = s.PatchUser(ctx, created.ID, patch)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resource
+	}
+
+	tests := []struct {
+		name      string
+		active    bool
+		wantLogin error
+	}{
+		{"deactivated", false, ErrUserInactive},
+		{"reactivated", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource := setActive(tt.active)
+			if resource.Active == nil || *resource.Active != tt.active {
+				t.Errorf("resource active is %v, want %t", resource.Active, tt.active)
+			}
+
+			got, err := s.GetUser(ctx, created.ID)
+			if err != nil {
+				t.Fatalf("the user is gone: %v", err)
+			}
+			if *got.Active != tt.active {
+				t.Errorf("stored active is %t, want %t", *got.Active, tt.active)
+			}
+
+			if _, err := login.Authenticate(ctx, "alice", "correct horse battery staple"); err != tt.wantLogin {
+				t.Errorf("login returned %v, want %v", err, tt.wantLogin)
+			}
+		})
+	}
+
+	if err := s.DeleteUser(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.users.GetUserByUsername(ctx, "alice"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("after DELETE the user lookup returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TokenRevocations rejects the tokens of deleted and deactivated users. It
+// remembers, per user, the time up to which the user's tokens were revoked,
+// and forgets it once every token issued by then has expired. It is safe for
+// concurrent use.
+type TokenRevocations struct {
+	mu      sync.Mutex
+	retain  time.Duration
+	cutoffs map[uint64]int64
+}
+
+// NewTokenRevocations keeps each revocation for retain, which must be at
+// least the lifetime of the longest-lived token
+func NewTokenRevocations(retain time.Duration) *TokenRevocations {
+	return &TokenRevocations{
+		retain:  retain,
+		cutoffs: make(map[uint64]int64),
+	}
+}
+
+// Revoke rejects the user's tokens issued at or before at, in unix seconds
+func (r *TokenRevocations) Revoke(userID uint64, at int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expired := time.Now().Add(-r.retain).Unix()
+	for id, cutoff := range r.cutoffs {
+		if cutoff < expired {
+			delete(r.cutoffs, id)
+		}
+	}
+	if at > r.cutoffs[userID] {
+		r.cutoffs[userID] = at
+	}
+}
+
+// Revoked reports whether the token's user, or the admin acting through an
+// impersonation token, had their tokens revoked after it was issued
+func (r *TokenRevocations) Revoked(claims *Claims) bool {
+	if r == nil {
+		return false
+	}
+	if r.revoked(claims.UserID, claims.IssuedAt) {
+		return true
+	}
+	return claims.Actor != nil && r.revoked(claims.Actor.UserID, claims.IssuedAt)
+}
+
+func (r *TokenRevocations) revoked(userID string, issuedAt int64) bool {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cutoff, ok := r.cutoffs[id]
+	return ok && issuedAt <= cutoff
+}
+
+// Retain is how far back revocations must be known
+func (r *TokenRevocations) Retain() time.Duration {
+	return r.retain
+}
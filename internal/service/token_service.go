@@ -4,7 +4,6 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"io"
-	"log"
 	"os"
 	"time"
 
@@ -13,39 +12,65 @@ import (
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
+// AccessTokenTTL is how long a login token stays valid
+var AccessTokenTTL = 15 * time.Minute
+
 type Claims struct {
-	UserID    string `json:"userId"`
-	Username  string `json:"username"`
-	Email     string `json:"email"`
-	ExpiresAt int64  `json:"exp"`
-	IssuedAt  int64  `json:"iat"`
-	NotBefore int64  `json:"nbf"`
+	UserID    string   `json:"userId"`
+	Username  string   `json:"username"`
+	Email     string   `json:"email"`
+	Roles     []string `json:"roles,omitempty"`
+	Actor     *Actor   `json:"act,omitempty"`
+	AuthTime  int64    `json:"auth_time"`
+	AMR       []string `json:"amr,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// Actor identifies the admin acting through an impersonation token (RFC 8693 "act")
+type Actor struct {
+	UserID   string `json:"sub"`
+	Username string `json:"username"`
 }
 
-func GenerateKey() []byte {
+func GenerateKey() ([]byte, error) {
 	key := make([]byte, chacha20poly1305.KeySize)
 	if _, err := io.ReadFull(rand.Reader, key); err != nil {
-		panic(err.Error()) // handle error in production code
+		return nil, errors.Wrap(err, "generating token key")
 	}
-	return key
+	return key, nil
 }
 
-func StoreKeyInFile(key []byte) {
-	err := os.WriteFile("../../pkg/utils/keyfile", key, 0600)
-	if err != nil {
-		log.Fatal(err)
+// LoadOrCreateKey reads the token signing key from path, or generates one and
+// stores it there when the file does not exist. Every token is signed and
+// verified with this one key, so it is loaded once at startup.
+func LoadOrCreateKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if key, err = GenerateKey(); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, key, 0600); err != nil {
+			return nil, errors.Wrap(err, "storing token key")
+		}
+		return key, nil
 	}
-}
-
-func RetrieveKeyInFile() []byte {
-	key, err := os.ReadFile("../../pkg/utils/keyfile")
 	if err != nil {
-		log.Fatal(err)
+		return nil, errors.Wrap(err, "reading token key")
+	}
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, errors.Errorf("token key in %s must be %d bytes, not %d", path, chacha20poly1305.KeySize, len(key))
 	}
-	return key
+	return key, nil
 }
 
 func GeneratePasetoToken(claims *Claims, key []byte) (string, error) {
+	return GeneratePasetoTokenWithTTL(claims, key, AccessTokenTTL)
+}
+
+// GeneratePasetoTokenWithTTL issues a token that expires after ttl
+func GeneratePasetoTokenWithTTL(claims *Claims, key []byte, ttl time.Duration) (string, error) {
 	// Ensure the key is the correct size
 	if len(key) != chacha20poly1305.KeySize {
 		return "", errors.New("incorrect key size")
@@ -56,7 +81,7 @@ func GeneratePasetoToken(claims *Claims, key []byte) (string, error) {
 
 	// Set the expiration time in the token
 	now := time.Now()
-	claims.ExpiresAt = now.Add(15 * time.Minute).Unix() // Expires in 24 hours
+	claims.ExpiresAt = now.Add(ttl).Unix()
 	claims.IssuedAt = now.Unix()
 	claims.NotBefore = now.Unix()
 
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -8,45 +9,48 @@ import (
 	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
 	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
 	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/validation"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserService struct {
-	UserRepo repository.UserRepository
+	UserRepo   repository.UserRepository
+	AuthzCache *AuthzCache
 }
 
-// NewUserService creates a new UserService with the provided repo
-func NewUserService(repo repository.UserRepository) *UserService {
+// NewUserService creates a new UserService with the provided repo. Deleting a
+// user revokes its tokens through authzCache.
+func NewUserService(repo repository.UserRepository, authzCache *AuthzCache) *UserService {
 	return &UserService{
-		UserRepo: repo,
+		UserRepo:   repo,
+		AuthzCache: authzCache,
 	}
 }
 
+// validateInput checks a sanitized user and reports every invalid field
 func validateInput(user *model.User) error {
 	if user == nil {
 		logs.Error("Received nil user for creation")
 		return errors.NewAppError(errors.CodeBadRequest, "User cannot be nil")
 	}
 
-	if len(user.Username) < 3 || len(user.Username) > 255 {
-		logs.Error("Username length is out of allowed range")
-		return errors.NewAppError(errors.CodeBadRequest, "Username length must be between 3 and 255 characters")
-	}
-
-	if len(user.PasswordHash) < 6 {
-		logs.Error("Password too short")
-		return errors.NewAppError(errors.CodeBadRequest, "Password must be at least 6 characters long")
-	}
-
-	if !strings.Contains(user.Email, "@") {
-		logs.Error("Invalid email address")
-		return errors.NewAppError(errors.CodeBadRequest, "Email must be a valid address")
-	}
+	var v validation.Validator
+	ValidateUserFields(&v, user.Username, user.Email)
+	v.Length("password", user.PasswordHash, 6, 0)
+	return v.Err()
+}
 
-	return nil
+// ValidateUserFields records the rules for a sanitized username and email,
+// so request bodies can check them alongside their own fields.
+func ValidateUserFields(v *validation.Validator, username string, email string) {
+	v.Length("username", username, 3, 255)
+	v.Email("email", email)
 }
 
 func sanitizeInput(user *model.User) {
+	if user == nil {
+		return
+	}
 	user.Username = strings.TrimSpace(user.Username)
 	user.Email = strings.ToLower(strings.TrimSpace(user.Email))
 }
@@ -66,88 +70,106 @@ func CheckPassword(hashedPassword, password string) bool {
 	return err == nil
 }
 
-func (s *UserService) CreateUser(user *model.User) error {
-	// Validate input
+func (s *UserService) CreateUser(ctx context.Context, user *model.User) error {
+	// Sanitize and validate input
+	sanitizeInput(user)
 	if err := validateInput(user); err != nil {
-		return errors.NewAppError(errors.CodeBadRequest, "Invalid user data")
+		return err
 	}
 
-	// Sanitize input
-	sanitizeInput(user)
-
 	// Check if username already exists
-	if existingUser, err := s.UserRepo.GetUserByUsername(user.Username); err != nil {
+	if _, err := s.UserRepo.GetUserByUsername(ctx, user.Username); err == nil {
+		return errors.NewAppError(errors.CodeConflict, "Username already exists")
+	} else if !errors.Is(err, repository.ErrNotFound) {
 		logs.Error("Error fetching user by username", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "An unexpected error occurred")
-	} else if existingUser != nil {
-		return errors.NewAppError(errors.CodeBadRequest, "Username already exists")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "An unexpected error occurred")
 	}
 
-	// Create the user
-	if err := s.UserRepo.CreateUser(user); err != nil {
+	// Create the user; the unique constraints still catch a concurrent registration
+	if err := s.UserRepo.CreateUser(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return errors.WrapAppError(errors.CodeConflict, err, "Username or email already exists")
+		}
 		logs.Error("Error creating user", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "An unexpected error occurred")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "An unexpected error occurred")
 	}
 
 	return nil
 }
 
-func (s *UserService) GetUserByUsername(username string) (*model.User, error) {
-	user, err := s.UserRepo.GetUserByUsername(username)
+func (s *UserService) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	user, err := s.UserRepo.GetUserByUsername(ctx, username)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errors.WrapAppError(errors.CodeNotFound, err, "User not found")
+	}
 	if err != nil {
 		logs.Error("error fetching user by username: ", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 	return user, nil
 }
 
-func (s *UserService) GetUserByID(id uint64) (*model.User, error) {
-	user, err := s.UserRepo.GetUserByID(id)
+func (s *UserService) GetUserByID(ctx context.Context, id uint64) (*model.User, error) {
+	user, err := s.UserRepo.GetUserByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errors.WrapAppError(errors.CodeNotFound, err, "User not found")
+	}
 	if err != nil {
 		logs.Error("error fetching user by id: ", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 	return user, nil
 }
 
-func (s *UserService) UpdateUser(user *model.User) error {
+func (s *UserService) UpdateUser(ctx context.Context, user *model.User) error {
 	// Validate input
 	if user.ID == 0 {
 		logs.Error("User ID cannot be zero for update")
 		return errors.NewAppError(errors.CodeBadRequest, "User ID cannot be zero")
 	}
 
-	// Validate input
-	err := validateInput(user)
-	if err != nil {
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+	// Sanitize and validate input
+	sanitizeInput(user)
+	if err := validateInput(user); err != nil {
+		return err
 	}
 
-	// Sanitize input
-	sanitizeInput(user)
+	// Check if user exists, since saving a missing one would create it
+	_, err := s.UserRepo.GetUserByID(ctx, user.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		logs.Error(fmt.Sprintf("User does not exist for id: %d", user.ID))
+		return errors.WrapAppError(errors.CodeNotFound, err, "User not found")
+	}
+	if err != nil {
+		logs.Error("Error fetching user by id", err)
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
 
 	// Check if username already exists
-	existingUser, err := s.UserRepo.GetUserByUsername(user.Username)
-	if err != nil {
+	existingUser, err := s.UserRepo.GetUserByUsername(ctx, user.Username)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
 		logs.Error("Error fetching user by username", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
-	if existingUser != nil && existingUser.ID != user.ID {
+	if err == nil && existingUser.ID != user.ID {
 		logs.Error(fmt.Sprintf("Username already exists: %s", user.Username))
-		return errors.NewAppError(errors.CodeBadRequest, "Username already exists")
+		return errors.NewAppError(errors.CodeConflict, "Username already exists")
 	}
 
 	// Update the user
-	err = s.UserRepo.UpdateUser(user)
+	err = s.UserRepo.UpdateUser(ctx, user)
+	if errors.Is(err, repository.ErrConflict) {
+		return errors.WrapAppError(errors.CodeConflict, err, "Username or email already exists")
+	}
 	if err != nil {
 		logs.Error("Error updating user", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 
 	return nil
 }
 
-func (s *UserService) DeleteUser(id uint64) error {
+func (s *UserService) DeleteUser(ctx context.Context, id uint64) error {
 	// Validate input
 	if id == 0 {
 		logs.Error("User ID cannot be zero for delete")
@@ -155,61 +177,94 @@ func (s *UserService) DeleteUser(id uint64) error {
 	}
 
 	// Check if user exists
-	existingUser, err := s.UserRepo.GetUserByID(id)
+	_, err := s.UserRepo.GetUserByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		logs.Error(fmt.Sprintf("User does not exist for id: %d", id))
+		return errors.WrapAppError(errors.CodeNotFound, err, "User not found")
+	}
 	if err != nil {
 		logs.Error("Error fetching user by id", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
-	}
-	if existingUser == nil {
-		logs.Error(fmt.Sprintf("User does not exist for id: %d", id))
-		return errors.NewAppError(errors.CodeNotFound, "User not found")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 
 	// Delete the user
-	err = s.UserRepo.DeleteUser(id)
+	err = s.UserRepo.DeleteUser(ctx, id)
 	if err != nil {
 		logs.Error("Error deleting user", err)
-		return errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
+	s.AuthzCache.RevokeUsers(ctx, id)
 
 	return nil
 }
 
-func (s *UserService) ListUsers(page int, pageSize int) ([]*model.User, error) {
-	if page < 0 || pageSize <= 0 {
-		logs.Error("Invalid pagination parameters", errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred"))
-		return nil, errors.NewAppError(errors.CodeBadRequest, "User ID cannot be zero")
+func (s *UserService) ListUsers(ctx context.Context, page int, pageSize int) ([]*model.User, error) {
+	if page < 1 || pageSize <= 0 {
+		logs.Error("Invalid pagination parameters", errors.NewAppError(errors.CodeBadRequest, "Invalid pagination parameters"))
+		return nil, errors.NewAppError(errors.CodeBadRequest, "Invalid pagination parameters")
 	}
 
-	users, err := s.UserRepo.ListUsers(page, pageSize)
+	users, err := s.UserRepo.ListUsers(ctx, page, pageSize)
 	if err != nil {
 		logs.Error("Failed to fetch users", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 
 	return users, nil
 }
 
-func (s *UserService) SearchUsers(query string, page int, pageSize int) ([]*model.User, error) {
-	if page < 0 || pageSize <= 0 {
-		logs.Error("Invalid pagination parameters", errors.NewAppError(errors.CodeBadRequest, "User ID cannot be zero"))
-		return nil, errors.NewAppError(errors.CodeBadRequest, "User ID cannot be zero")
+func (s *UserService) SearchUsers(ctx context.Context, query string, page int, pageSize int) ([]*model.User, error) {
+	if page < 1 || pageSize <= 0 {
+		logs.Error("Invalid pagination parameters", errors.NewAppError(errors.CodeBadRequest, "Invalid pagination parameters"))
+		return nil, errors.NewAppError(errors.CodeBadRequest, "Invalid pagination parameters")
 	}
 
-	users, err := s.UserRepo.SearchUsers(query, page, pageSize)
+	users, err := s.UserRepo.SearchUsers(ctx, query, page, pageSize)
 	if err != nil {
 		logs.Error("Failed to search users", err)
-		return nil, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 
 	return users, nil
 }
 
-func (s *UserService) CountUsers() (int64, error) {
-	count, err := s.UserRepo.CountUsers()
+// FuzzySearchUsers ranks the users whose username or email resembles query
+func (s *UserService) FuzzySearchUsers(ctx context.Context, query string, page int, pageSize int) ([]repository.UserMatch, error) {
+	if page < 1 || pageSize <= 0 {
+		logs.Error("Invalid pagination parameters", errors.NewAppError(errors.CodeBadRequest, "Invalid pagination parameters"))
+		return nil, errors.NewAppError(errors.CodeBadRequest, "Invalid pagination parameters")
+	}
+
+	matches, err := s.UserRepo.FuzzySearchUsers(ctx, query, page, pageSize)
+	if err != nil {
+		logs.Error("Failed to search users", err)
+		return nil, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	return matches, nil
+}
+
+// QueryUsers returns one page of the users the query selects
+func (s *UserService) QueryUsers(ctx context.Context, query repository.ListQuery) (repository.Page[*model.User], error) {
+	page, err := s.UserRepo.QueryUsers(ctx, query)
+	if err != nil {
+		logs.Error("Failed to list users", err)
+		return page, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
+	}
+
+	return page, nil
+}
+
+// ListRoleUsers returns one page of the users that have a role
+func (s *UserService) ListRoleUsers(ctx context.Context, roleID uint64, query repository.ListQuery) (repository.Page[*model.User], error) {
+	return s.QueryUsers(ctx, query.And(repository.Filter{Op: repository.FilterEq, Field: "role_id", Value: roleID}))
+}
+
+func (s *UserService) CountUsers(ctx context.Context) (int64, error) {
+	count, err := s.UserRepo.CountUsers(ctx)
 	if err != nil {
 		logs.Error("Failed to count users", err)
-		return 0, errors.NewAppError(errors.CodeInternalServerError, "Internal server error occurred")
+		return 0, errors.WrapAppError(errors.CodeInternalServerError, err, "Internal server error occurred")
 	}
 
 	return count, nil
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+)
+
+// brokenUsers fails every call as a lost database connection would
+type brokenUsers struct {
+	repository.UserRepository
+}
+
+var errConnectionLost = errors.New("connection lost")
+
+func (brokenUsers) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	return nil, errConnectionLost
+}
+
+func (brokenUsers) GetUserByID(ctx context.Context, id uint64) (*model.User, error) {
+	return nil, errConnectionLost
+}
+
+func TestUserServiceErrors(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	alice := r.createUser(t, "alice")
+	bob := r.createUser(t, "bob")
+	s := NewUserService(r.users, r.authzCache)
+	broken := NewUserService(brokenUsers{r.users}, r.authzCache)
+
+	// user returns a copy of a stored user with the changes applied, and a
+	// hash long enough to pass validation
+	user := func(stored *model.User, change func(*model.User)) *model.User {
+		copied := *stored
+		copied.PasswordHash = "stored password hash"
+		change(&copied)
+		return &copied
+	}
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode int
+	}{
+		{"create", func() error {
+			return s.CreateUser(ctx, &model.User{Username: "carol", Email: "carol@example.com", PasswordHash: "secret password"})
+		}, 0},
+		{"create with a taken username", func() error {
+			return s.CreateUser(ctx, &model.User{Username: "alice", Email: "other@example.com", PasswordHash: "secret password"})
+		}, errors.CodeConflict},
+		{"create with a taken email", func() error {
+			return s.CreateUser(ctx, &model.User{Username: "dave", Email: "alice@example.com", PasswordHash: "secret password"})
+		}, errors.CodeConflict},
+		{"create invalid", func() error {
+			return s.CreateUser(ctx, &model.User{Username: "al", Email: "not an email", PasswordHash: "secret password"})
+		}, errors.CodeUnprocessableEntity},
+		{"create on a broken repository", func() error {
+			return broken.CreateUser(ctx, &model.User{Username: "erin", Email: "erin@example.com", PasswordHash: "secret password"})
+		}, errors.CodeInternalServerError},
+
+		{"update", func() error {
+			return s.UpdateUser(ctx, user(alice, func(u *model.User) { u.Email = "alice@example.org" }))
+		}, 0},
+		{"update to a taken username", func() error {
+			return s.UpdateUser(ctx, user(alice, func(u *model.User) { u.Username = "bob" }))
+		}, errors.CodeConflict},
+		{"update to a taken email", func() error {
+			return s.UpdateUser(ctx, user(alice, func(u *model.User) { u.Email = "bob@example.com" }))
+		}, errors.CodeConflict},
+		{"update a missing user", func() error {
+			return s.UpdateUser(ctx, user(alice, func(u *model.User) { u.ID = 1000; u.Username = "ghost"; u.Email = "ghost@example.com" }))
+		}, errors.CodeNotFound},
+		{"update without an ID", func() error {
+			return s.UpdateUser(ctx, user(alice, func(u *model.User) { u.ID = 0 }))
+		}, errors.CodeBadRequest},
+		{"update on a broken repository", func() error {
+			return broken.UpdateUser(ctx, user(alice, func(u *model.User) {}))
+		}, errors.CodeInternalServerError},
+
+		{"delete a missing user", func() error { return s.DeleteUser(ctx, 1000) }, errors.CodeNotFound},
+		{"delete without an ID", func() error { return s.DeleteUser(ctx, 0) }, errors.CodeBadRequest},
+		{"delete on a broken repository", func() error { return broken.DeleteUser(ctx, bob.ID) }, errors.CodeInternalServerError},
+		{"delete", func() error { return s.DeleteUser(ctx, bob.ID) }, 0},
+		{"delete a deleted user", func() error { return s.DeleteUser(ctx, bob.ID) }, errors.CodeNotFound},
+		{"update a deleted user", func() error {
+			return s.UpdateUser(ctx, user(bob, func(u *model.User) { u.Email = "bob@example.org" }))
+		}, errors.CodeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if code := appErrorCode(err); code != tt.wantCode || (tt.wantCode == 0 && err != nil) {
+				t.Errorf("got %v (code %d), want code %d", err, code, tt.wantCode)
+			}
+		})
+	}
+
+	if _, err := r.users.GetUserByUsername(ctx, "ghost"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("updating a missing user created it: %v", err)
+	}
+}
+
+func TestPasswordAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepos()
+	hash, err := HashPassword(&model.User{PasswordHash: "correct password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		user := &model.User{Username: name, Email: name + "@example.com", PasswordHash: string(hash)}
+		if err := r.users.CreateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		if name == "bob" {
+			// Users are created active, so deactivate bob after
+			user.Active = false
+			if err := r.users.UpdateUser(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	authenticator := NewPasswordAuthenticator(r.users)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"right password", "alice", "correct password", nil},
+		{"wrong password", "alice", "wrong password", ErrInvalidCredentials},
+		{"empty password", "alice", "", ErrInvalidCredentials},
+		{"unknown user", "mallory", "correct password", ErrInvalidCredentials},
+		{"deactivated user", "bob", "correct password", ErrUserInactive},
+		{"deactivated user with the wrong password", "bob", "wrong password", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := authenticator.Authenticate(ctx, tt.username, tt.password)
+			if err != tt.wantErr {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if auth.User.Username != tt.username || len(auth.Methods) != 1 || auth.Methods[0] != MethodPassword {
+				t.Errorf("got %+v, want %s by password", auth, tt.username)
+			}
+		})
+	}
+	if code := appErrorCode(ErrInvalidCredentials); code != errors.CodeUnauthorized {
+		t.Errorf("invalid credentials are %d, want 401", code)
+	}
+	if code := appErrorCode(ErrUserInactive); code != errors.CodeForbidden {
+		t.Errorf("a deactivated user is %d, want 403", code)
+	}
+}
@@ -1,30 +1,77 @@
 package middleware
 
 import (
-	"net/http"
+	"crypto/subtle"
+	"strings"
 
 	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
-func AuthMiddleware(userService *service.UserService) gin.HandlerFunc {
+// AuthMiddleware verifies the token in the Authorization header, with or
+// without a "Bearer " prefix, against key and stores its claims in the
+// context. Tokens in revocations are refused. Requests made with an impersonation token are
+// logged with both identities.
+func AuthMiddleware(key []byte, revocations *service.TokenRevocations) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.GetHeader("Authorization")
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
 		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is missing"})
+			c.Error(errors.NewAppError(errors.CodeUnauthorized, "Authorization header is missing"))
 			c.Abort()
 			return
 		}
 
-		claims, err := service.VerifyAndExtractClaims(token, []byte("key"))
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		claims, err := service.VerifyAndExtractClaims(token, key)
+		if err != nil || revocations.Revoked(claims) {
+			c.Error(errors.NewAppError(errors.CodeUnauthorized, "Unauthorized"))
 			c.Abort()
 			return
 		}
 
-		// You can also store user information from the token in the context if needed
+		// Store the claims in the context for later use
+		c.Set("claims", claims)
 		c.Set("userID", claims.UserID)
+
+		c.Next()
+
+		if claims.Actor != nil {
+			logs.WithFields(logrus.Fields{
+				"event":          "impersonated_request",
+				"user_id":        claims.UserID,
+				"username":       claims.Username,
+				"actor_id":       claims.Actor.UserID,
+				"actor_username": claims.Actor.Username,
+				"method":         c.Request.Method,
+				"path":           c.Request.URL.Path,
+				"status":         c.Writer.Status(),
+			}).Info("request made with impersonation token")
+		}
+	}
+}
+
+// CurrentClaims returns the claims stored by AuthMiddleware, or nil
+func CurrentClaims(c *gin.Context) *service.Claims {
+	value, ok := c.Get("claims")
+	if !ok {
+		return nil
+	}
+	claims, _ := value.(*service.Claims)
+	return claims
+}
+
+// StaticBearerToken admits requests that present the configured shared secret
+// as a bearer token, as provisioning clients such as SCIM connectors do.
+func StaticBearerToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		presented := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			c.Error(errors.NewAppError(errors.CodeUnauthorized, "Unauthorized"))
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
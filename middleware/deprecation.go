@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks the responses of routes that have been replaced. It sends
+// a Deprecation header (RFC 9745) with the date the routes were deprecated, a
+// Sunset header (RFC 8594) with the date they will be removed and, when
+// successors lists the route under "METHOD /path", a Link to its successor.
+// Path parameters in a successor, such as :id, are filled in from the
+// request, and those the request's path lacks are left as {id}.
+func Deprecated(since time.Time, sunset time.Time, successors map[string]string) gin.HandlerFunc {
+	deprecation := "@" + strconv.FormatInt(since.Unix(), 10)
+	sunsetDate := sunset.UTC().Format(http.TimeFormat)
+
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("Deprecation", deprecation)
+		header.Set("Sunset", sunsetDate)
+		if successor, ok := successors[c.Request.Method+" "+c.FullPath()]; ok {
+			header.Add("Link", "<"+fillPath(successor, c.Params)+`>; rel="successor-version"`)
+		}
+		c.Next()
+	}
+}
+
+// fillPath replaces the :name segments of a route with the request's values,
+// or with {name} where the request has none
+func fillPath(route string, params gin.Params) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			if value, ok := params.Get(segment[1:]); ok {
+				segments[i] = value
+			} else {
+				segments[i] = "{" + segment[1:] + "}"
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/bhanupbalusu/gocomboums_v4/cmd/http/openapi"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+// ValidateRequests rejects requests whose parameters or body do not match the
+// OpenAPI operation of their route. Routes the spec does not describe pass
+// through. It must run after ErrorHandler.
+func ValidateRequests(spec *openapi.Spec) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := spec.Operation(c.Request.Method, c.FullPath())
+		if op == nil {
+			c.Next()
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.Error(errors.WrapAppError(errors.CodeBadRequest, err, "The request body could not be read"))
+				c.Abort()
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		params := make(map[string]string, len(c.Params))
+		for _, param := range c.Params {
+			params[param.Key] = param.Value
+		}
+
+		if err := spec.ValidateRequest(op, c.Request, params, body); err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ValidateResponses holds every response back until it has been checked
+// against the OpenAPI operation of its route, and replaces one that does not
+// match, or that comes from an undocumented route, with a 500 problem. It is
+// a test mode: it buffers whole responses, so it belongs in tests and
+// staging rather than production. It must run before ErrorHandler so that
+// problem responses are checked too.
+func ValidateResponses(spec *openapi.Spec) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			c.Next()
+			return
+		}
+
+		writer := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		var err error
+		if op := spec.Operation(c.Request.Method, route); op == nil {
+			err = errors.Newf("%s %s is not documented", c.Request.Method, route)
+		} else {
+			err = spec.ValidateResponse(op, writer.status, writer.Header().Get("Content-Type"), writer.body.Bytes())
+		}
+		if err != nil {
+			logs.WithFields(logrus.Fields{
+				"request_id": c.GetString("request_id"),
+				"method":     c.Request.Method,
+				"route":      route,
+				"violation":  err.Error(),
+			}).Error("response does not match the OpenAPI document")
+			writeProblem(c, errors.WrapAppError(errors.CodeInternalServerError, err, "The response does not match the API specification"))
+			return
+		}
+
+		c.Writer.WriteHeader(writer.status)
+		if writer.body.Len() == 0 {
+			c.Writer.WriteHeaderNow()
+			return
+		}
+		c.Writer.Write(writer.body.Bytes())
+	}
+}
+
+// bufferedWriter keeps the status and body of a response instead of sending
+// them; headers still go straight to the underlying writer.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	written bool
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.written
+}
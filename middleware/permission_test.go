@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+)
+
+// grant is a role holding permission, assigned to the user in scope
+type grant struct {
+	permission string
+	scope      string
+}
+
+// newAccessRouter serves GET /projects/:projectID/documents/:id to a user
+// holding the grants, guarded by documents:update on the document
+func newAccessRouter(t *testing.T, grants []grant) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	store := repository.NewMemoryStore()
+	users := repository.NewMemoryUserRepository(store)
+	roles := repository.NewMemoryRoleRepository(store)
+	permissions := repository.NewMemoryPermissionRepository(store)
+	policies := repository.NewMemoryPolicyRepository(store)
+	authzCache := service.NewAuthzCache(users, roles, permissions, policies, repository.NewNopPublisher(), service.NewTokenRevocations(time.Minute), time.Minute, 10, 10)
+
+	user := &model.User{Username: "alice", Email: "alice@example.com", PasswordHash: "hash"}
+	if err := users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	for i, g := range grants {
+		role, err := roles.CreateRole(ctx, &model.Role{RoleName: "role" + strconv.Itoa(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		permission, err := permissions.CreatePermission(ctx, model.Permission{PermissionName: g.permission})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := permissions.AssignPermissionToRole(ctx, role.ID, permission.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := roles.AddScopedUserRole(ctx, user.ID, role.ID, g.scope); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.Use(ErrorHandler(), func(c *gin.Context) {
+		c.Set("claims", &service.Claims{UserID: strconv.FormatUint(user.ID, 10), Username: user.Username})
+	})
+	r.GET("/projects/:projectID/documents/:id",
+		RequireAccess(service.NewPermissionService(permissions, authzCache), "documents:update", "project:{projectID}/documents:{id}"),
+		func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return r
+}
+
+func TestRequireAccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		grants []grant
+		path   string
+		want   int
+	}{
+		{"no grant", nil, "/projects/42/documents/7", http.StatusForbidden},
+		{"global grant", []grant{{"documents:update", ""}}, "/projects/42/documents/7", http.StatusNoContent},
+		{"grant on the document", []grant{{"documents:update", "project:42/documents:7"}}, "/projects/42/documents/7", http.StatusNoContent},
+		{"grant on another document", []grant{{"documents:update", "project:42/documents:8"}}, "/projects/42/documents/7", http.StatusForbidden},
+		{"grant on the parent project", []grant{{"documents:update", "project:42"}}, "/projects/42/documents/7", http.StatusNoContent},
+		{"grant on another project", []grant{{"documents:update", "project:43"}}, "/projects/42/documents/7", http.StatusForbidden},
+		{"grant beneath the document", []grant{{"documents:update", "project:42/documents:7/pages:1"}}, "/projects/42/documents/7", http.StatusForbidden},
+		{"wildcard project", []grant{{"documents:update", "project:*"}}, "/projects/42/documents/7", http.StatusNoContent},
+		{"wildcard document", []grant{{"documents:update", "project:42/documents:*"}}, "/projects/42/documents/7", http.StatusNoContent},
+		{"wildcard document in another project", []grant{{"documents:update", "project:43/documents:*"}}, "/projects/42/documents/7", http.StatusForbidden},
+		{"wildcard project, other document", []grant{{"documents:update", "project:*/documents:8"}}, "/projects/42/documents/7", http.StatusForbidden},
+		{"wildcard permission on the parent", []grant{{"documents:*", "project:42"}}, "/projects/42/documents/7", http.StatusNoContent},
+		{"other permission on the parent", []grant{{"documents:read", "project:42"}}, "/projects/42/documents/7", http.StatusForbidden},
+		{"other resource type", []grant{{"documents:update", "team:42"}}, "/projects/42/documents/7", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newAccessRouter(t, tt.grants).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("got %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	stderrors "errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/repository"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// ProblemContentType is the media type of RFC 7807 error responses
+const ProblemContentType = "application/problem+json"
+
+// Problem types are stable identifiers clients can branch on; titles and
+// details are for humans and may change.
+const (
+	ProblemBadRequest   = "/problems/bad-request"
+	ProblemUnauthorized = "/problems/unauthorized"
+	ProblemForbidden    = "/problems/forbidden"
+	ProblemNotFound     = "/problems/not-found"
+	ProblemConflict     = "/problems/conflict"
+	ProblemValidation   = "/problems/validation"
+	ProblemTimeout      = "/problems/timeout"
+	ProblemInternal     = "/problems/internal"
+	// ProblemStepUpRequired asks for a fresher login
+	ProblemStepUpRequired = "/problems/step-up-required"
+)
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors lists the rejected fields of a validation problem
+	Errors []errors.FieldError `json:"errors,omitempty"`
+	// MaxAge says how recent a login a step-up problem asks for
+	MaxAge int64 `json:"max_age,omitempty"`
+}
+
+var problemTypes = map[int]string{
+	http.StatusBadRequest:          ProblemBadRequest,
+	http.StatusUnauthorized:        ProblemUnauthorized,
+	http.StatusForbidden:           ProblemForbidden,
+	http.StatusNotFound:            ProblemNotFound,
+	http.StatusConflict:            ProblemConflict,
+	http.StatusUnprocessableEntity: ProblemValidation,
+	http.StatusGatewayTimeout:      ProblemTimeout,
+	http.StatusInternalServerError: ProblemInternal,
+}
+
+// RequestID reuses the caller's X-Request-ID or assigns a random one, and
+// echoes it on the response so logs and error bodies can be correlated.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err == nil {
+				id = hex.EncodeToString(b)
+			}
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// ErrorHandler turns the last error a handler recorded with c.Error into a
+// problem+json response, unless the handler already wrote one.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		writeProblem(c, c.Errors.Last().Err)
+	}
+}
+
+// writeProblem responds with the problem for err, logging server errors
+func writeProblem(c *gin.Context, err error) {
+	problem := NewProblem(err)
+	if problem.Status >= http.StatusInternalServerError {
+		logs.WithFields(logrus.Fields{
+			"request_id": c.GetString("request_id"),
+			"path":       c.Request.URL.Path,
+			"error":      err.Error(),
+		}).Error("request failed")
+	}
+	respondProblem(c, problem)
+}
+
+// respondProblem sends problem as the response to the request
+func respondProblem(c *gin.Context, problem Problem) {
+	problem.Instance = c.Request.URL.Path
+	problem.RequestID = c.GetString("request_id")
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(problem.Status, problem)
+}
+
+// NewProblem maps an error to its status and problem type. An AppError's
+// own code wins unless it is a generic 500 wrapping a recognized cause; record
+// not found is 404, duplicate keys are 409 and expired deadlines are 504.
+// Validation errors keep their rejected fields. The detail of unrecognized
+// errors is never shown.
+func NewProblem(err error) Problem {
+	var appErr *errors.AppError
+	isAppErr := stderrors.As(err, &appErr)
+	if isAppErr && appErr.Code != errors.CodeInternalServerError {
+		p := problem(appErr.Code, appErr.Message)
+		p.Errors = appErr.Fields
+		return p
+	}
+
+	switch {
+	case stderrors.Is(err, repository.ErrNotFound):
+		return problem(http.StatusNotFound, "The requested resource was not found")
+	case stderrors.Is(err, repository.ErrConflict):
+		return problem(http.StatusConflict, "The resource conflicts with one that already exists")
+	case stderrors.Is(err, context.DeadlineExceeded):
+		return problem(http.StatusGatewayTimeout, "The request took too long to complete")
+	case isAppErr:
+		return problem(appErr.Code, appErr.Message)
+	}
+	return problem(http.StatusInternalServerError, "An unexpected error occurred")
+}
+
+func problem(status int, detail string) Problem {
+	problemType, ok := problemTypes[status]
+	if !ok {
+		problemType = "about:blank"
+	}
+	return Problem{
+		Type:   problemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+)
+
+// StepUpRequired is the gRPC error reason returned when a call needs a
+// fresher login than the presented token carries; HTTP routes
+// answer with a ProblemStepUpRequired problem.
+const StepUpRequired = "step_up_required"
+
+// RequireRecentAuth rejects tokens whose user last authenticated more than
+// maxAge ago, so that sensitive routes force a fresh login.
+func RequireRecentAuth(maxAge time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := CurrentClaims(c)
+		if claims == nil {
+			c.Error(errors.NewAppError(errors.CodeUnauthorized, "Unauthorized"))
+			c.Abort()
+			return
+		}
+
+		if claims.AuthTime == 0 || time.Since(time.Unix(claims.AuthTime, 0)) > maxAge {
+			seconds := int64(maxAge / time.Second)
+			c.Header("WWW-Authenticate", fmt.Sprintf(
+				`Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age=%d`, seconds))
+			stepUp := problem(http.StatusUnauthorized, "Re-authenticate within the last "+strconv.FormatInt(seconds, 10)+" seconds to continue")
+			stepUp.Type = ProblemStepUpRequired
+			stepUp.MaxAge = seconds
+			respondProblem(c, stepUp)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
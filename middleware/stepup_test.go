@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+)
+
+func TestRequireRecentAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		claims *service.Claims
+		want   int
+		stepUp bool
+	}{
+		{"no claims", nil, http.StatusUnauthorized, false},
+		{"fresh login", &service.Claims{AuthTime: now.Add(-time.Minute).Unix()}, http.StatusNoContent, false},
+		{"stale login", &service.Claims{AuthTime: now.Add(-10 * time.Minute).Unix()}, http.StatusUnauthorized, true},
+		{"no auth_time", &service.Claims{}, http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(ErrorHandler(), func(c *gin.Context) {
+				if tt.claims != nil {
+					c.Set("claims", tt.claims)
+				}
+			})
+			r.DELETE("/users/:id", RequireRecentAuth(5*time.Minute), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/users/7", nil))
+			if rec.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+			if rec.Code == http.StatusNoContent {
+				return
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != "application/problem+json" {
+				t.Errorf("got content type %q, want application/problem+json", contentType)
+			}
+			var body Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if !tt.stepUp {
+				if body.Type == ProblemStepUpRequired || strings.Contains(challenge, "insufficient_user_authentication") {
+					t.Errorf("got a step-up problem %+v, %q without claims", body, challenge)
+				}
+				return
+			}
+			if body.Type != ProblemStepUpRequired || body.MaxAge != 300 {
+				t.Errorf("got %+v, want %s with max_age 300", body, ProblemStepUpRequired)
+			}
+			if !strings.HasPrefix(challenge, `Bearer error="insufficient_user_authentication"`) || !strings.HasSuffix(challenge, "max_age=300") {
+				t.Errorf("got WWW-Authenticate %q, want an insufficient_user_authentication challenge with max_age=300", challenge)
+			}
+		})
+	}
+}
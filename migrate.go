@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gorm.io/gorm"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/database/migration"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
+)
+
+const migrateUsage = `usage: migrate <command>
+
+commands:
+  up            apply every pending migration
+  down N        revert the N most recently applied migrations
+  status        list migrations and whether they are applied
+  create NAME   write empty up and down files for a new migration`
+
+// runMigrate handles the "migrate" subcommand and returns the process exit code
+func runMigrate(args []string, openDB func() (*gorm.DB, error)) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		up, down, err := migration.Create(migration.Dir, args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "create migration:", err)
+			return 1
+		}
+		fmt.Println("created", up)
+		fmt.Println("created", down)
+		return 0
+	}
+
+	db, err := openDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "connect database:", err)
+		return 1
+	}
+	runner, err := newMigrationRunner(db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := runner.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied  %06d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			fmt.Fprintln(os.Stderr, "down needs a positive number of migrations to revert")
+			return 2
+		}
+		reverted, err := runner.Down(ctx, n)
+		for _, m := range reverted {
+			fmt.Printf("reverted %06d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "status":
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%06d_%-40s %s\n", status.Version, status.Name, state)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
+
+// migrateUp brings the schema up to date before the server starts
+func migrateUp(db *gorm.DB) error {
+	runner, err := newMigrationRunner(db)
+	if err != nil {
+		return err
+	}
+	applied, err := runner.Up(context.Background())
+	for _, m := range applied {
+		logs.Infof("applied migration %06d_%s", m.Version, m.Name)
+	}
+	return err
+}
+
+func newMigrationRunner(db *gorm.DB) (*migration.Runner, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := migration.Embedded()
+	if err != nil {
+		return nil, err
+	}
+	return migration.NewRunner(sqlDB, migrations), nil
+}
@@ -1,39 +1,361 @@
 package config
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
 )
 
+// Config is assembled from, in increasing precedence, the defaults below, an
+// optional YAML or TOML file, environment variables and command-line flags.
 type Config struct {
-	DBHost     string
-	DBUser     string
-	DBPassword string
-	DBName     string
-	DBPort     int
-	LogLevel   string
+	Server        Server         `yaml:"server" toml:"server"`
+	Database      Database       `yaml:"database" toml:"database"`
+	Tokens        Tokens         `yaml:"tokens" toml:"tokens"`
+	Keys          Keys           `yaml:"keys" toml:"keys"`
+	AuthzCache    AuthzCache     `yaml:"authz_cache" toml:"authz_cache"`
+	Log           Log            `yaml:"log" toml:"log"`
+	Features      Features       `yaml:"features" toml:"features"`
+	OIDCProviders []OIDCProvider `yaml:"oidc_providers" toml:"oidc_providers"`
+	LDAP          *LDAP          `yaml:"ldap" toml:"ldap"`
+	SCIMToken     string         `yaml:"scim_token" toml:"scim_token"`
+	// BootstrapAdmins are the usernames given the admin role, which holds
+	// every permission, at startup
+	BootstrapAdmins []string `yaml:"bootstrap_admins" toml:"bootstrap_admins"`
+}
+
+// Server configures the HTTP and gRPC listeners
+type Server struct {
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+	// GRPCListenAddr is where the gRPC API listens; empty turns it off. Any
+	// address but a loopback one needs GRPCCertFile and GRPCKeyFile, since
+	// every call carries a bearer token.
+	GRPCListenAddr string `yaml:"grpc_listen_addr" toml:"grpc_listen_addr"`
+	// GRPCCertFile and GRPCKeyFile are the PEM certificate and key the gRPC
+	// API serves TLS with
+	GRPCCertFile string `yaml:"grpc_cert_file" toml:"grpc_cert_file"`
+	GRPCKeyFile  string `yaml:"grpc_key_file" toml:"grpc_key_file"`
+	// ValidateRequests rejects requests that do not match the OpenAPI document
+	ValidateRequests bool `yaml:"validate_requests" toml:"validate_requests"`
+	// ValidateResponses turns responses that do not match the OpenAPI document
+	// into errors. It buffers every response and is meant for tests.
+	ValidateResponses bool `yaml:"validate_responses" toml:"validate_responses"`
+}
+
+// Database drivers. Postgres is the production backend; SQLite and the
+// in-memory store are meant for development and tests.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+// Database configures the storage backend, its connection and its pool
+type Database struct {
+	// Driver selects postgres, sqlite or memory
+	Driver string `yaml:"driver" toml:"driver"`
+	// Path is the SQLite database file, used by the sqlite driver only
+	Path string `yaml:"path" toml:"path"`
+	// DSN, when set, is used as-is instead of the individual connection fields
+	DSN             string   `yaml:"dsn" toml:"dsn"`
+	Host            string   `yaml:"host" toml:"host"`
+	Port            int      `yaml:"port" toml:"port"`
+	User            string   `yaml:"user" toml:"user"`
+	Password        string   `yaml:"password" toml:"password"`
+	Name            string   `yaml:"name" toml:"name"`
+	SSLMode         string   `yaml:"sslmode" toml:"sslmode"`
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+	// QueryTimeout bounds the database work of a request; zero disables it
+	QueryTimeout Duration `yaml:"query_timeout" toml:"query_timeout"`
+	// RouteQueryTimeouts overrides QueryTimeout per route, keyed by "METHOD /path"
+	// with the path as registered, e.g. "GET /v1/users/search"
+	RouteQueryTimeouts map[string]Duration `yaml:"route_query_timeouts" toml:"route_query_timeouts"`
+	// MigrateOnStart applies pending migrations before the server starts
+	MigrateOnStart bool `yaml:"migrate_on_start" toml:"migrate_on_start"`
+}
+
+// Tokens configures the lifetime of issued tokens
+type Tokens struct {
+	AccessTTL        Duration `yaml:"access_ttl" toml:"access_ttl"`
+	ImpersonationTTL Duration `yaml:"impersonation_ttl" toml:"impersonation_ttl"`
+	// StepUpMaxAge is how recent a login must be for sensitive routes
+	StepUpMaxAge Duration `yaml:"step_up_max_age" toml:"step_up_max_age"`
+}
+
+// Keys configures where the token signing key is stored
+type Keys struct {
+	File string `yaml:"file" toml:"file"`
+}
+
+// AuthzCache bounds the in-process cache of user roles and role permissions
+// that authorization checks read
+type AuthzCache struct {
+	// TTL is how long an entry is trusted; zero turns the cache off
+	TTL      Duration `yaml:"ttl" toml:"ttl"`
+	MaxUsers int      `yaml:"max_users" toml:"max_users"`
+	MaxRoles int      `yaml:"max_roles" toml:"max_roles"`
+	// NotifyChannel is the Postgres channel on which instances tell each other
+	// to drop stale entries and revoke deleted users' tokens; empty turns it off
+	NotifyChannel string `yaml:"notify_channel" toml:"notify_channel"`
+}
+
+// Log configures the application logger
+type Log struct {
+	Level  string `yaml:"level" toml:"level"`
+	Format string `yaml:"format" toml:"format"`
+}
+
+// Features switches optional endpoints on or off
+type Features struct {
+	Registration  bool `yaml:"registration" toml:"registration"`
+	Federation    bool `yaml:"federation" toml:"federation"`
+	Impersonation bool `yaml:"impersonation" toml:"impersonation"`
 }
 
-func LoadConfig() (*Config, error) {
-	dbPort, err := strconv.Atoi(getEnv("DB_PORT", "5432")) // Default port is 5432
+// OIDCProvider configures one upstream OpenID Connect identity provider
+type OIDCProvider struct {
+	ID           string             `json:"id" yaml:"id" toml:"id"`
+	Issuer       string             `json:"issuer" yaml:"issuer" toml:"issuer"`
+	ClientID     string             `json:"client_id" yaml:"client_id" toml:"client_id"`
+	ClientSecret string             `json:"client_secret" yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string             `json:"redirect_url" yaml:"redirect_url" toml:"redirect_url"`
+	Scopes       []string           `json:"scopes" yaml:"scopes" toml:"scopes"`
+	RoleMappings []ClaimRoleMapping `json:"role_mappings" yaml:"role_mappings" toml:"role_mappings"`
+}
+
+// ClaimRoleMapping assigns Role to users whose Claim equals, or contains, Value
+type ClaimRoleMapping struct {
+	Claim string `json:"claim" yaml:"claim" toml:"claim"`
+	Value string `json:"value" yaml:"value" toml:"value"`
+	Role  string `json:"role" yaml:"role" toml:"role"`
+}
+
+// LDAP configures password authentication against an LDAP or Active Directory server
+type LDAP struct {
+	URL                string             `json:"url" yaml:"url" toml:"url"`
+	StartTLS           bool               `json:"start_tls" yaml:"start_tls" toml:"start_tls"`
+	InsecureSkipVerify bool               `json:"insecure_skip_verify" yaml:"insecure_skip_verify" toml:"insecure_skip_verify"`
+	BindDN             string             `json:"bind_dn" yaml:"bind_dn" toml:"bind_dn"`
+	BindPassword       string             `json:"bind_password" yaml:"bind_password" toml:"bind_password"`
+	UserBaseDN         string             `json:"user_base_dn" yaml:"user_base_dn" toml:"user_base_dn"`
+	UserFilter         string             `json:"user_filter" yaml:"user_filter" toml:"user_filter"`
+	UsernameAttribute  string             `json:"username_attribute" yaml:"username_attribute" toml:"username_attribute"`
+	EmailAttribute     string             `json:"email_attribute" yaml:"email_attribute" toml:"email_attribute"`
+	GroupBaseDN        string             `json:"group_base_dn" yaml:"group_base_dn" toml:"group_base_dn"`
+	GroupFilter        string             `json:"group_filter" yaml:"group_filter" toml:"group_filter"`
+	GroupRoleMappings  []GroupRoleMapping `json:"group_role_mappings" yaml:"group_role_mappings" toml:"group_role_mappings"`
+	ShadowUsers        bool               `json:"shadow_users" yaml:"shadow_users" toml:"shadow_users"`
+}
+
+// GroupRoleMapping assigns Role to members of the LDAP group GroupDN
+type GroupRoleMapping struct {
+	GroupDN string `json:"group_dn" yaml:"group_dn" toml:"group_dn"`
+	Role    string `json:"role" yaml:"role" toml:"role"`
+}
+
+// Duration is a time.Duration written as a string such as "15m" in config files
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
 	if err != nil {
-		return nil, err
+		return err
 	}
+	*d = Duration(parsed)
+	return nil
+}
 
+// Default returns the configuration used when nothing overrides it
+func Default() *Config {
 	return &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBUser:     getEnv("DB_USER", "postgres"),
-		DBPassword: getEnv("DB_PASSWORD", ""),
-		DBName:     getEnv("DB_NAME", "user_management"),
-		DBPort:     dbPort,
-		LogLevel:   getEnv("LOG_LEVEL", "info"),
-	}, nil
+		Server: Server{
+			ListenAddr: ":8080",
+		},
+		Database: Database{
+			Driver:             DriverPostgres,
+			Path:               "ums.db",
+			Host:               "localhost",
+			Port:               5432,
+			User:               "postgres",
+			Name:               "user_management",
+			SSLMode:            "disable",
+			MaxOpenConns:       25,
+			MaxIdleConns:       5,
+			ConnMaxLifetime:    Duration(30 * time.Minute),
+			ConnMaxIdleTime:    Duration(5 * time.Minute),
+			QueryTimeout:       Duration(5 * time.Second),
+			RouteQueryTimeouts: map[string]Duration{},
+			MigrateOnStart:     true,
+		},
+		Tokens: Tokens{
+			AccessTTL:        Duration(15 * time.Minute),
+			ImpersonationTTL: Duration(5 * time.Minute),
+			StepUpMaxAge:     Duration(5 * time.Minute),
+		},
+		Keys: Keys{
+			File: "../../pkg/utils/keyfile",
+		},
+		AuthzCache: AuthzCache{
+			TTL:           Duration(time.Minute),
+			MaxUsers:      10000,
+			MaxRoles:      1000,
+			NotifyChannel: "ums_authz",
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Features: Features{
+			Registration:  true,
+			Federation:    true,
+			Impersonation: true,
+		},
+	}
 }
 
-// getEnv gets the environment variable if present, else returns the default value
-func getEnv(key string, defaultVal string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// LoadConfig builds the configuration from the process environment and the
+// given command-line arguments, without the program name, and validates it.
+// The file is named by the -config flag or the CONFIG_FILE variable. The
+// arguments left after the flags are returned for the caller to interpret.
+func LoadConfig(args []string) (*Config, []string, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("gocomboums", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	for _, opt := range options {
+		flags.String(opt.flag, "", opt.usage+" (env "+opt.env+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(*configFile, cfg); err != nil {
+			return nil, nil, fmt.Errorf("config file %s: %w", *configFile, err)
+		}
+	}
+
+	// Provider and directory settings may also live in their own JSON files
+	if path := os.Getenv("OIDC_PROVIDERS_FILE"); path != "" {
+		if err := loadJSONFile(path, &cfg.OIDCProviders); err != nil {
+			return nil, nil, fmt.Errorf("OIDC_PROVIDERS_FILE: %w", err)
+		}
+	}
+	if path := os.Getenv("LDAP_CONFIG_FILE"); path != "" {
+		cfg.LDAP = &LDAP{}
+		if err := loadJSONFile(path, cfg.LDAP); err != nil {
+			return nil, nil, fmt.Errorf("LDAP_CONFIG_FILE: %w", err)
+		}
+	}
+
+	for _, opt := range options {
+		if value, ok := os.LookupEnv(opt.env); ok {
+			if err := opt.set(cfg, value); err != nil {
+				return nil, nil, fmt.Errorf("environment variable %s: %w", opt.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, opt := range options {
+			if opt.flag == f.Name && flagErr == nil {
+				if err := opt.set(cfg, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %w", opt.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+// ConnectionString returns DSN, or a Postgres URL built from the connection fields
+func (d Database) ConnectionString() string {
+	if d.DSN != "" {
+		return d.DSN
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     fmt.Sprintf("%s:%d", d.Host, d.Port),
+		Path:     "/" + d.Name,
+		RawQuery: url.Values{"sslmode": {d.SSLMode}}.Encode(),
+	}
+	if d.Password == "" {
+		dsn.User = url.User(d.User)
+	}
+	return dsn.String()
+}
+
+// SQLiteDSN returns Path with the connection options every SQLite
+// connection needs to enforce the same constraints as Postgres
+func (d Database) SQLiteDSN() string {
+	return "file:" + d.Path + "?_foreign_keys=on&_case_sensitive_like=on&_busy_timeout=5000"
+}
+
+// RouteTimeouts returns RouteQueryTimeouts as plain durations
+func (d Database) RouteTimeouts() map[string]time.Duration {
+	timeouts := make(map[string]time.Duration, len(d.RouteQueryTimeouts))
+	for route, timeout := range d.RouteQueryTimeouts {
+		timeouts[route] = time.Duration(timeout)
+	}
+	return timeouts
+}
+
+// loadFile decodes a YAML or TOML file, chosen by its extension, into cfg
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	case ".toml":
+		return toml.NewDecoder(strings.NewReader(string(data))).DisallowUnknownFields().Decode(cfg)
+	default:
+		return fmt.Errorf("unsupported extension %q, use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+}
+
+// loadJSONFile decodes a JSON file into v
+func loadJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
 	}
-	return defaultVal
+	return json.Unmarshal(data, v)
 }
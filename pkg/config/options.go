@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// option is a setting that can be overridden by an environment variable and a flag
+type option struct {
+	env   string
+	flag  string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+var options = []option{
+	{"LISTEN_ADDR", "listen", "address the HTTP server listens on", setString(func(c *Config) *string { return &c.Server.ListenAddr })},
+	{"GRPC_LISTEN_ADDR", "grpc-listen", "address the gRPC server listens on; empty turns it off, and any but a loopback address needs TLS", setString(func(c *Config) *string { return &c.Server.GRPCListenAddr })},
+	{"GRPC_CERT_FILE", "grpc-cert-file", "PEM certificate the gRPC server serves TLS with", setString(func(c *Config) *string { return &c.Server.GRPCCertFile })},
+	{"GRPC_KEY_FILE", "grpc-key-file", "PEM private key of the gRPC server's certificate", setString(func(c *Config) *string { return &c.Server.GRPCKeyFile })},
+	{"VALIDATE_REQUESTS", "validate-requests", "reject requests that do not match the OpenAPI document", setBool(func(c *Config) *bool { return &c.Server.ValidateRequests })},
+	{"VALIDATE_RESPONSES", "validate-responses", "fail responses that do not match the OpenAPI document; for tests", setBool(func(c *Config) *bool { return &c.Server.ValidateResponses })},
+
+	{"DB_DRIVER", "db-driver", "storage backend: postgres, sqlite or memory", setString(func(c *Config) *string { return &c.Database.Driver })},
+	{"DB_PATH", "db-path", "SQLite database file, used by the sqlite driver", setString(func(c *Config) *string { return &c.Database.Path })},
+	{"DB_DSN", "db-dsn", "Postgres connection string, overriding the individual db settings", setString(func(c *Config) *string { return &c.Database.DSN })},
+	{"DB_HOST", "db-host", "database host", setString(func(c *Config) *string { return &c.Database.Host })},
+	{"DB_PORT", "db-port", "database port", setInt(func(c *Config) *int { return &c.Database.Port })},
+	{"DB_USER", "db-user", "database user", setString(func(c *Config) *string { return &c.Database.User })},
+	{"DB_PASSWORD", "db-password", "database password", setString(func(c *Config) *string { return &c.Database.Password })},
+	{"DB_NAME", "db-name", "database name", setString(func(c *Config) *string { return &c.Database.Name })},
+	{"DB_SSLMODE", "db-sslmode", "database sslmode", setString(func(c *Config) *string { return &c.Database.SSLMode })},
+	{"DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open database connections, 0 for unlimited", setInt(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle database connections", setInt(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum lifetime of a database connection", setDuration(func(c *Config) *Duration { return &c.Database.ConnMaxLifetime })},
+	{"DB_CONN_MAX_IDLE_TIME", "db-conn-max-idle-time", "maximum idle time of a database connection", setDuration(func(c *Config) *Duration { return &c.Database.ConnMaxIdleTime })},
+	{"DB_QUERY_TIMEOUT", "db-query-timeout", "default deadline for the database work of a request", setDuration(func(c *Config) *Duration { return &c.Database.QueryTimeout })},
+	{"DB_ROUTE_QUERY_TIMEOUTS", "db-route-query-timeouts", `per-route deadlines, e.g. "GET /v1/users/search=10s,POST /v1/roles/:id/permissions=30s"`, setRouteTimeouts},
+	{"DB_MIGRATE_ON_START", "db-migrate-on-start", "apply pending migrations before serving", setBool(func(c *Config) *bool { return &c.Database.MigrateOnStart })},
+
+	{"ACCESS_TOKEN_TTL", "access-token-ttl", "lifetime of login tokens", setDuration(func(c *Config) *Duration { return &c.Tokens.AccessTTL })},
+	{"IMPERSONATION_TOKEN_TTL", "impersonation-token-ttl", "lifetime of impersonation tokens", setDuration(func(c *Config) *Duration { return &c.Tokens.ImpersonationTTL })},
+	{"STEP_UP_MAX_AGE", "step-up-max-age", "how recent a login must be for sensitive routes", setDuration(func(c *Config) *Duration { return &c.Tokens.StepUpMaxAge })},
+
+	{"KEY_FILE", "key-file", "path of the token signing key file", setString(func(c *Config) *string { return &c.Keys.File })},
+
+	{"AUTHZ_CACHE_TTL", "authz-cache-ttl", "how long cached roles and permissions are trusted; 0 turns the cache off", setDuration(func(c *Config) *Duration { return &c.AuthzCache.TTL })},
+	{"AUTHZ_CACHE_MAX_USERS", "authz-cache-max-users", "number of users whose roles are cached", setInt(func(c *Config) *int { return &c.AuthzCache.MaxUsers })},
+	{"AUTHZ_CACHE_MAX_ROLES", "authz-cache-max-roles", "number of roles whose permissions are cached", setInt(func(c *Config) *int { return &c.AuthzCache.MaxRoles })},
+	{"AUTHZ_NOTIFY_CHANNEL", "authz-notify-channel", "postgres channel for authorization changes; empty turns it off", setString(func(c *Config) *string { return &c.AuthzCache.NotifyChannel })},
+
+	{"LOG_LEVEL", "log-level", "log level: debug, info, warn or error", setString(func(c *Config) *string { return &c.Log.Level })},
+	{"LOG_FORMAT", "log-format", "log format: json or text", setString(func(c *Config) *string { return &c.Log.Format })},
+
+	{"FEATURE_REGISTRATION", "feature-registration", "allow self-registration through POST /register", setBool(func(c *Config) *bool { return &c.Features.Registration })},
+	{"FEATURE_FEDERATION", "feature-federation", "allow login through the configured OIDC providers", setBool(func(c *Config) *bool { return &c.Features.Federation })},
+	{"FEATURE_IMPERSONATION", "feature-impersonation", "allow admins to impersonate users", setBool(func(c *Config) *bool { return &c.Features.Impersonation })},
+
+	{"SCIM_BEARER_TOKEN", "scim-token", "bearer token for SCIM provisioning clients; SCIM is off when empty", setString(func(c *Config) *string { return &c.SCIMToken })},
+
+	{"BOOTSTRAP_ADMINS", "bootstrap-admins", "comma-separated usernames given the admin role at startup", setList(func(c *Config) *[]string { return &c.BootstrapAdmins })},
+}
+
+func setString(field func(*Config) *string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		*field(cfg) = value
+		return nil
+	}
+}
+
+// setList reads a comma-separated list, dropping empty entries
+func setList(field func(*Config) *[]string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		var list []string
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				list = append(list, entry)
+			}
+		}
+		*field(cfg) = list
+		return nil
+	}
+}
+
+func setInt(field func(*Config) *int) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+		*field(cfg) = parsed
+		return nil
+	}
+}
+
+func setBool(field func(*Config) *bool) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		*field(cfg) = parsed
+		return nil
+	}
+}
+
+func setDuration(field func(*Config) *Duration) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		parsed, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 15m", value)
+		}
+		*field(cfg) = Duration(parsed)
+		return nil
+	}
+}
+
+// setRouteTimeouts reads a comma-separated list of "METHOD /path=duration"
+// entries and merges them over the timeouts already configured.
+func setRouteTimeouts(cfg *Config, value string) error {
+	if cfg.Database.RouteQueryTimeouts == nil {
+		cfg.Database.RouteQueryTimeouts = map[string]Duration{}
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, timeout, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("route timeout %q: expected METHOD /path=duration", entry)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(timeout))
+		if err != nil {
+			return fmt.Errorf("route timeout %q: %w", entry, err)
+		}
+		cfg.Database.RouteQueryTimeouts[strings.Join(strings.Fields(route), " ")] = Duration(duration)
+	}
+	return nil
+}
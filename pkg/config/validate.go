@@ -0,0 +1,204 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Validate reports every invalid setting at once, one per line
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.ListenAddr); err != nil {
+		add("server.listen_addr %q must be host:port or :port", c.Server.ListenAddr)
+	}
+	if grpcAddr := c.Server.GRPCListenAddr; grpcAddr != "" {
+		if host, _, err := net.SplitHostPort(grpcAddr); err != nil {
+			add("server.grpc_listen_addr %q must be host:port or :port", grpcAddr)
+		} else if grpcAddr == c.Server.ListenAddr {
+			add("server.grpc_listen_addr must differ from server.listen_addr")
+		} else if c.Server.GRPCCertFile == "" && !isLoopback(host) {
+			add("server.grpc_cert_file and grpc_key_file are required for server.grpc_listen_addr %q; only a loopback address may serve gRPC without TLS", grpcAddr)
+		}
+	}
+	if (c.Server.GRPCCertFile == "") != (c.Server.GRPCKeyFile == "") {
+		add("server.grpc_cert_file and server.grpc_key_file must be set together")
+	}
+
+	db := c.Database
+	switch db.Driver {
+	case DriverPostgres, DriverMemory:
+	case DriverSQLite:
+		if db.Path == "" {
+			add("database.path is required for the sqlite driver")
+		}
+	default:
+		add("database.driver %q must be postgres, sqlite or memory", db.Driver)
+	}
+	if db.Driver == DriverPostgres && db.DSN == "" {
+		if db.Host == "" {
+			add("database.host is required")
+		}
+		if db.Port < 1 || db.Port > 65535 {
+			add("database.port %d must be between 1 and 65535", db.Port)
+		}
+		if db.User == "" {
+			add("database.user is required")
+		}
+		if db.Name == "" {
+			add("database.name is required")
+		}
+	}
+	if db.MaxOpenConns < 0 {
+		add("database.max_open_conns must not be negative")
+	}
+	if db.MaxIdleConns < 0 {
+		add("database.max_idle_conns must not be negative")
+	}
+	if db.MaxOpenConns > 0 && db.MaxIdleConns > db.MaxOpenConns {
+		add("database.max_idle_conns %d must not exceed max_open_conns %d", db.MaxIdleConns, db.MaxOpenConns)
+	}
+	if db.ConnMaxLifetime < 0 || db.ConnMaxIdleTime < 0 || db.QueryTimeout < 0 {
+		add("database durations must not be negative")
+	}
+	for route, timeout := range db.RouteQueryTimeouts {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || method != strings.ToUpper(method) || !strings.HasPrefix(path, "/") {
+			add("database.route_query_timeouts key %q must look like \"GET /users\"", route)
+		}
+		if timeout < 0 {
+			add("database.route_query_timeouts[%q] must not be negative", route)
+		}
+	}
+
+	if c.Tokens.AccessTTL <= 0 {
+		add("tokens.access_ttl must be positive")
+	}
+	if c.Tokens.ImpersonationTTL <= 0 {
+		add("tokens.impersonation_ttl must be positive")
+	}
+	if c.Tokens.StepUpMaxAge <= 0 {
+		add("tokens.step_up_max_age must be positive")
+	}
+
+	if c.Keys.File == "" {
+		add("keys.file is required")
+	}
+
+	if c.AuthzCache.TTL < 0 || c.AuthzCache.MaxUsers < 0 || c.AuthzCache.MaxRoles < 0 {
+		add("authz_cache settings must not be negative")
+	}
+
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		add("log.level %q must be one of debug, info, warn or error", c.Log.Level)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		add("log.format %q must be json or text", c.Log.Format)
+	}
+
+	seen := map[string]bool{}
+	for i, provider := range c.OIDCProviders {
+		if provider.ID == "" || provider.Issuer == "" || provider.ClientID == "" {
+			add("oidc_providers[%d] needs an id, issuer and client_id", i)
+		}
+		if seen[provider.ID] {
+			add("oidc_providers[%d] repeats id %q", i, provider.ID)
+		}
+		seen[provider.ID] = true
+	}
+
+	if c.LDAP != nil && c.LDAP.URL == "" {
+		add("ldap.url is required when ldap is configured")
+	}
+	// Mapped roles are only granted by assigning them to the shadow user
+	if c.LDAP != nil && len(c.LDAP.GroupRoleMappings) > 0 && !c.LDAP.ShadowUsers {
+		add("ldap.group_role_mappings needs ldap.shadow_users")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+}
+
+// Redacted returns a copy of the configuration with every secret masked
+func (c *Config) Redacted() *Config {
+	clone := *c
+
+	if clone.Database.Password != "" {
+		clone.Database.Password = redacted
+	}
+	if clone.Database.DSN != "" {
+		clone.Database.DSN = redactDSN(clone.Database.DSN)
+	}
+	if clone.SCIMToken != "" {
+		clone.SCIMToken = redacted
+	}
+
+	clone.OIDCProviders = append([]OIDCProvider(nil), c.OIDCProviders...)
+	for i := range clone.OIDCProviders {
+		if clone.OIDCProviders[i].ClientSecret != "" {
+			clone.OIDCProviders[i].ClientSecret = redacted
+		}
+	}
+
+	if c.LDAP != nil {
+		ldap := *c.LDAP
+		if ldap.BindPassword != "" {
+			ldap.BindPassword = redacted
+		}
+		clone.LDAP = &ldap
+	}
+	return &clone
+}
+
+// Print writes the configuration as YAML with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// isLoopback reports whether host, from a listen address, only accepts local
+// connections. An empty host listens on every interface.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// redactDSN masks the password in a URL or key=value connection string
+func redactDSN(dsn string) string {
+	if parsed, err := url.Parse(dsn); err == nil && parsed.User != nil {
+		if _, ok := parsed.User.Password(); ok {
+			parsed.User = url.UserPassword(parsed.User.Username(), redacted)
+			return strings.Replace(parsed.String(), url.QueryEscape(redacted), redacted, 1)
+		}
+		return dsn
+	}
+
+	fields := strings.Fields(dsn)
+	for i, field := range fields {
+		if strings.HasPrefix(strings.ToLower(field), "password=") {
+			fields[i] = "password=" + redacted
+		}
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateGRPCListener(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     string
+		certFile string
+		keyFile  string
+		wantErr  string
+	}{
+		{name: "off by default"},
+		{name: "loopback without TLS", addr: "127.0.0.1:9090"},
+		{name: "IPv6 loopback without TLS", addr: "[::1]:9090"},
+		{name: "localhost without TLS", addr: "localhost:9090"},
+		{name: "every interface without TLS", addr: ":9090", wantErr: "server.grpc_cert_file and grpc_key_file are required"},
+		{name: "public address without TLS", addr: "10.0.0.5:9090", wantErr: "server.grpc_cert_file and grpc_key_file are required"},
+		{name: "every interface with TLS", addr: ":9090", certFile: "server.crt", keyFile: "server.key"},
+		{name: "certificate without key", addr: ":9090", certFile: "server.crt", wantErr: "must be set together"},
+		{name: "key without certificate", addr: "127.0.0.1:9090", keyFile: "server.key", wantErr: "must be set together"},
+		{name: "same port as HTTP", addr: ":8080", certFile: "server.crt", keyFile: "server.key", wantErr: "must differ from server.listen_addr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Server.GRPCListenAddr = tt.addr
+			cfg.Server.GRPCCertFile = tt.certFile
+			cfg.Server.GRPCKeyFile = tt.keyFile
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateLDAP(t *testing.T) {
+	mappings := []GroupRoleMapping{{GroupDN: "cn=admins,ou=groups,dc=example,dc=com", Role: "admin"}}
+	tests := []struct {
+		name    string
+		ldap    *LDAP
+		wantErr string
+	}{
+		{name: "off by default"},
+		{name: "without a URL", ldap: &LDAP{}, wantErr: "ldap.url is required"},
+		{name: "without mappings", ldap: &LDAP{URL: "ldaps://ldap.example.com"}},
+		{name: "mappings with shadow users", ldap: &LDAP{URL: "ldaps://ldap.example.com", GroupRoleMappings: mappings, ShadowUsers: true}},
+		{name: "mappings without shadow users", ldap: &LDAP{URL: "ldaps://ldap.example.com", GroupRoleMappings: mappings}, wantErr: "ldap.group_role_mappings needs ldap.shadow_users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.LDAP = tt.ldap
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package database
 
 import (
 	"database/sql"
-	"fmt"
 
 	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
 	"github.com/bhanupbalusu/gocomboums_v4/pkg/logs"
@@ -10,10 +9,7 @@ import (
 )
 
 func NewDB(cfg *config.Config) (*sql.DB, error) {
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
-
-	db, err := sql.Open("postgres", psqlInfo)
+	db, err := sql.Open("postgres", cfg.Database.ConnectionString())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,8 @@
+-- Trigram indexes for the fuzzy user search. They index the lower-cased
+-- columns, which is what the search compares, and serve both the similarity
+-- operator and LIKE '%...%'.
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING gin (LOWER(username) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING gin (LOWER(email) gin_trgm_ops);
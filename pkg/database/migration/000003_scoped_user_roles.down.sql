@@ -0,0 +1,6 @@
+-- Scoped assignments would become global once the column is gone
+DELETE FROM user_roles WHERE scope <> '';
+
+DROP INDEX IF EXISTS idx_user_roles_user_id_scope;
+
+ALTER TABLE user_roles DROP COLUMN IF EXISTS scope;
@@ -0,0 +1,6 @@
+-- Role assignments scoped to a resource, e.g. project:42. Every assignment
+-- made before scopes existed is global, which the empty scope stands for.
+
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS scope VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_user_id_scope ON user_roles (user_id, scope);
@@ -0,0 +1,17 @@
+-- Attribute-based policies, checked after the roles. The condition is a JSON
+-- expression, empty when the policy applies unconditionally.
+
+CREATE TABLE IF NOT EXISTS policies (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description VARCHAR(1024) NOT NULL DEFAULT '',
+    effect VARCHAR(16) NOT NULL,
+    permission VARCHAR(255) NOT NULL,
+    scope VARCHAR(255) NOT NULL DEFAULT '',
+    condition TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_policies_deleted_at ON policies (deleted_at);
@@ -0,0 +1,4 @@
+-- Deactivated users would be able to log in again once the column is gone
+DELETE FROM users WHERE NOT active;
+
+ALTER TABLE users DROP COLUMN IF EXISTS active;
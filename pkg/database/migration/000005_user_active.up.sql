@@ -0,0 +1,4 @@
+-- Users can be deactivated, as SCIM clients do, which stops them logging in
+-- without deleting them. Every existing user stays active.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+)
+
+// SQLiteModels are the models SQLite's tables are migrated from. The SQL
+// migrations are written for Postgres, so SQLite gets the same tables and
+// constraints from the models instead.
+var SQLiteModels = []interface{}{
+	&model.User{}, &model.Role{}, &model.UserRole{}, &model.Permission{}, &model.RolePermission{}, &model.UserIdentity{}, &model.Policy{},
+}
+
+// SQLiteDialector opens the SQLite database at dsn
+func SQLiteDialector(dsn string) gorm.Dialector {
+	return sqliteDialector{sqlite.Open(dsn).(*sqlite.Dialector)}
+}
+
+// sqliteDialector reports unique and primary key violations as
+// gorm.ErrDuplicatedKey. The driver's own translation looks for a
+// *sqlite3.Error, but go-sqlite3 returns the error by value.
+type sqliteDialector struct {
+	*sqlite.Dialector
+}
+
+func (d sqliteDialector) Translate(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+		return gorm.ErrDuplicatedKey
+	}
+	return err
+}
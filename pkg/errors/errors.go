@@ -2,27 +2,48 @@ package errors
 
 import (
 	"fmt"
+	"strings"
 
 	pkgErrors "github.com/pkg/errors"
 )
 
-// AppError represents a custom error structure with an error code
+// AppError represents a custom error structure with an error code.
+// Err, when set, is the underlying cause and is never shown to clients.
 type AppError struct {
 	Code    int
 	Message string
+	Err     error
+	// Fields lists each rejected request field of a validation error
+	Fields []FieldError
+}
+
+// FieldError explains why one request field was rejected. Code is a stable
+// identifier such as "required" or "invalid_format"; Message is for humans.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 const (
 	CodeInternalServerError = 500
 	CodeBadRequest          = 400
 	CodeUnauthorized        = 401
+	CodeForbidden           = 403
 	CodeNotFound            = 404
+	CodeConflict            = 409
+	CodeUnprocessableEntity = 422
 )
 
 func (e *AppError) Error() string {
 	return e.Message
 }
 
+// Unwrap returns the underlying cause so errors.Is and errors.As can see it
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
 // New creates a new simple error with a message
 func New(message string) error {
 	return pkgErrors.New(message)
@@ -64,6 +85,40 @@ func NewAppErrorf(code int, format string, args ...interface{}) error {
 	}
 }
 
+// NewValidationError reports every rejected field of a request at once. Its
+// message summarizes the fields for callers that only show a string.
+func NewValidationError(fields []FieldError) error {
+	summary := make([]string, len(fields))
+	for i, field := range fields {
+		summary[i] = field.Field + " " + field.Message
+	}
+	return &AppError{
+		Code:    CodeUnprocessableEntity,
+		Message: strings.Join(summary, "; "),
+		Fields:  fields,
+	}
+}
+
+// WrapAppError creates an application error with a code and a message that
+// keeps err as its cause
+func WrapAppError(code int, err error, message string) error {
+	return &AppError{
+		Code:    code,
+		Message: message,
+		Err:     err,
+	}
+}
+
+// Is reports whether any error in err's chain matches target
+func Is(err, target error) bool {
+	return pkgErrors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target
+func As(err error, target interface{}) bool {
+	return pkgErrors.As(err, target)
+}
+
 // Cause returns the underlying cause of the error, if possible
 func Cause(err error) error {
 	return pkgErrors.Cause(err)
@@ -16,6 +16,22 @@ func init() {
 	Logger.SetLevel(log.InfoLevel) // Change this level based on your need
 }
 
+// Configure sets the minimum level and the output format, "json" or "text"
+func Configure(level string, format string) error {
+	parsed, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	Logger.SetLevel(parsed)
+
+	if format == "text" {
+		Logger.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	} else {
+		Logger.SetFormatter(&log.JSONFormatter{})
+	}
+	return nil
+}
+
 // Debug logs a message at level Debug
 func Debug(msg string) {
 	Logger.Debug(msg)
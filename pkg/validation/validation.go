@@ -0,0 +1,103 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"unicode/utf8"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/errors"
+)
+
+// Codes identify why a field was rejected; clients can branch on them.
+const (
+	CodeRequired      = "required"
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeInvalidFormat = "invalid_format"
+	CodeInvalidType   = "invalid_type"
+	CodeInvalidValue  = "invalid_value"
+)
+
+// Validator collects field errors so a request reports all of its problems
+// at once rather than only the first. The zero value is ready to use.
+type Validator struct {
+	fields []errors.FieldError
+}
+
+// Add records a rejected field
+func (v *Validator) Add(field string, code string, message string) {
+	v.fields = append(v.fields, errors.FieldError{
+		Field:   field,
+		Code:    code,
+		Message: message,
+	})
+}
+
+// Merge records every field other rejected
+func (v *Validator) Merge(other *Validator) {
+	v.fields = append(v.fields, other.fields...)
+}
+
+// Required rejects an empty value and reports whether it was present
+func (v *Validator) Required(field string, value string) bool {
+	if value == "" {
+		v.Add(field, CodeRequired, "is required")
+		return false
+	}
+	return true
+}
+
+// RequiredID rejects a zero ID and reports whether it was set
+func (v *Validator) RequiredID(field string, id uint64) bool {
+	if id == 0 {
+		v.Add(field, CodeRequired, "is required")
+		return false
+	}
+	return true
+}
+
+// Length checks that a present value has between min and max characters; a
+// max of zero means no upper bound.
+func (v *Validator) Length(field string, value string, min int, max int) {
+	if !v.Required(field, value) {
+		return
+	}
+	n := utf8.RuneCountInString(value)
+	switch {
+	case n < min && max > 0:
+		v.Add(field, CodeTooShort, fmt.Sprintf("must be between %d and %d characters", min, max))
+	case n < min:
+		v.Add(field, CodeTooShort, fmt.Sprintf("must be at least %d characters", min))
+	case max > 0 && n > max:
+		v.Add(field, CodeTooLong, fmt.Sprintf("must be between %d and %d characters", min, max))
+	}
+}
+
+// Email checks that a present value is a bare email address
+func (v *Validator) Email(field string, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+	if addr, err := mail.ParseAddress(value); err != nil || addr.Address != value {
+		v.Add(field, CodeInvalidFormat, "must be a valid email address")
+	}
+}
+
+// Err returns a 422 AppError listing every rejected field, or nil when the
+// input is valid.
+func (v *Validator) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return errors.NewValidationError(v.fields)
+}
+
+// BadRequest is Err for input that names no resource, such as a path
+// parameter: a 400 AppError listing every rejected field, or nil.
+func (v *Validator) BadRequest() error {
+	err := v.Err()
+	if appErr, ok := err.(*errors.AppError); ok {
+		appErr.Code = errors.CodeBadRequest
+	}
+	return err
+}
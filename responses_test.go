@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+)
+
+// credentialFields are substrings no response key may contain, compared
+// without case, underscores or dashes
+var credentialFields = []string{"password", "hash", "secret"}
+
+// credentialKeys returns the path of every key in body naming a credential
+func credentialKeys(path string, body interface{}) []string {
+	var found []string
+	switch v := body.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			normalized := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+			for _, field := range credentialFields {
+				if strings.Contains(normalized, field) {
+					found = append(found, path+"."+key)
+				}
+			}
+			found = append(found, credentialKeys(path+"."+key, value)...)
+		}
+	case []interface{}:
+		for i, value := range v {
+			found = append(found, credentialKeys(fmt.Sprintf("%s[%d]", path, i), value)...)
+		}
+	}
+	return found
+}
+
+// TestResponsesHideCredentials reads users back through every API that
+// returns them, after the passwords were set through each API that takes one.
+// Responses go unchecked against the OpenAPI document, as they do by default,
+// so the test sees what a production server would send.
+func TestResponsesHideCredentials(t *testing.T) {
+	const scimToken = "scim-token"
+	const scimPassword = "scim provisioned password"
+	const registeredPassword = "registered password"
+
+	cfg := config.Default()
+	cfg.Server.ValidateRequests = true
+	cfg.BootstrapAdmins = []string{"alice"}
+	cfg.SCIMToken = scimToken
+	a := newConfiguredTestApp(t, cfg, []string{"alice"})
+	admin := a.login(t, "alice")
+
+	// decode returns the response object, failing unless the status is 2xx
+	decode := func(method, path, token string, body interface{}) map[string]interface{} {
+		t.Helper()
+		rec := a.serve(method, path, token, body)
+		if rec.Code < 200 || rec.Code > 299 {
+			t.Fatalf("%s %s: %d %s", method, path, rec.Code, rec.Body)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return decoded
+	}
+
+	alice := decode(http.MethodGet, "/v1/users/by-username/alice", admin, nil)
+	aliceID := fmt.Sprint(alice["id"])
+	role := decode(http.MethodPost, "/v1/roles", admin, map[string]string{"role_name": "staff"})
+	roleID := fmt.Sprint(role["id"])
+	decode(http.MethodPut, "/v1/users/"+aliceID+"/roles/"+roleID, admin, nil)
+	scimUser := decode(http.MethodPost, "/scim/v2/Users", scimToken, map[string]interface{}{
+		"schemas":  []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"userName": "carol",
+		"emails":   []map[string]interface{}{{"value": "carol@example.com", "primary": true}},
+		"password": scimPassword,
+	})
+	scimUserID := fmt.Sprint(scimUser["id"])
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		body   interface{}
+	}{
+		{http.MethodPost, "/v1/auth/register", "", map[string]string{"username": "bob", "email": "bob@example.com", "password": registeredPassword}},
+		{http.MethodPost, "/v1/auth/login", "", map[string]string{"username": "bob", "password": registeredPassword}},
+		{http.MethodGet, "/v1/users", admin, nil},
+		{http.MethodGet, "/v1/users/search?q=ali", admin, nil},
+		{http.MethodGet, "/v1/users/" + aliceID, admin, nil},
+		{http.MethodGet, "/v1/users/by-username/carol", admin, nil},
+		{http.MethodPut, "/v1/users/" + aliceID, admin, map[string]string{"username": "alice", "email": "alice@example.org"}},
+		{http.MethodGet, "/v1/users/" + aliceID + "/roles", admin, nil},
+		{http.MethodGet, "/v1/users/" + aliceID + "/role-assignments", admin, nil},
+		{http.MethodGet, "/v1/roles", admin, nil},
+		{http.MethodGet, "/v1/roles/" + roleID, admin, nil},
+		{http.MethodGet, "/v1/roles/" + roleID + "/users", admin, nil},
+		{http.MethodPost, "/register", "", map[string]string{"username": "dave", "email": "dave@example.com", "password": registeredPassword}},
+		{http.MethodGet, "/users", admin, nil},
+		{http.MethodGet, "/user/" + aliceID, admin, nil},
+		{http.MethodGet, "/user/name/alice", admin, nil},
+		{http.MethodGet, "/scim/v2/Users", scimToken, nil},
+		{http.MethodGet, "/scim/v2/Users/" + scimUserID, scimToken, nil},
+		{http.MethodPut, "/scim/v2/Users/" + scimUserID, scimToken, map[string]interface{}{
+			"schemas":  []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+			"userName": "carol",
+			"emails":   []map[string]interface{}{{"value": "carol@example.com", "primary": true}},
+			"password": scimPassword,
+		}},
+		{http.MethodPatch, "/scim/v2/Users/" + scimUserID, scimToken, map[string]interface{}{
+			"schemas":    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+			"Operations": []map[string]interface{}{{"op": "replace", "path": "password", "value": scimPassword}},
+		}},
+		{http.MethodGet, "/scim/v2/Groups", scimToken, nil},
+		{http.MethodGet, "/scim/v2/Groups/" + roleID, scimToken, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := a.serve(tt.method, tt.path, tt.token, tt.body)
+			if rec.Code < 200 || rec.Code > 299 {
+				t.Fatalf("got %d %s", rec.Code, rec.Body)
+			}
+			body := rec.Body.String()
+			for _, secret := range []string{"$2a$", "$2b$", testPassword, scimPassword, registeredPassword} {
+				if strings.Contains(body, secret) {
+					t.Errorf("the response holds %q: %s", secret, body)
+				}
+			}
+			var decoded interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if keys := credentialKeys("$", decoded); len(keys) != 0 {
+				t.Errorf("the response names credentials at %v: %s", keys, body)
+			}
+		})
+	}
+}
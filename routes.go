@@ -0,0 +1,236 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bhanupbalusu/gocomboums_v4/cmd/http/handler"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/middleware"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+)
+
+// The unversioned routes were deprecated when /v1 was introduced and are
+// removed at the sunset date
+var (
+	legacyDeprecated = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	legacySunset     = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// apiRoutes holds what the route tables need
+type apiRoutes struct {
+	features    config.Features
+	users       *handler.UserHandler
+	roles       *handler.RoleHandler
+	permissions *handler.PermissionHandler
+	authz       *handler.AuthzHandler
+	policies    *handler.PolicyHandler
+	federation  *handler.FederationHandler
+
+	// tokenKey verifies access tokens
+	tokenKey []byte
+	// revocations lists the tokens of deleted users
+	revocations *service.TokenRevocations
+	// recentAuth guards destructive and privileged routes
+	recentAuth gin.HandlerFunc
+	// require admits only users holding the named permission
+	require func(permission string) gin.HandlerFunc
+	// access admits only users holding the named permission on the resource
+	// the request addresses, as in middleware.RequireAccess
+	access func(permission string, resource string) gin.HandlerFunc
+}
+
+// registerV1 adds the /v1 API, in which every collection is a plural noun,
+// members are addressed by ID in the path and relationships are nested
+// collections, e.g. /v1/users/{id}/roles/{roleID}.
+func (a *apiRoutes) registerV1(r *gin.Engine) {
+	v1 := r.Group("/v1")
+
+	auth := v1.Group("/auth")
+	{
+		if a.features.Registration {
+			auth.POST("/register", a.users.RegisterUser)
+		}
+		auth.POST("/login", a.users.LoginUser)
+		if a.features.Federation {
+			auth.GET("/:provider/login", a.federation.StartLogin)
+			auth.GET("/:provider/callback", a.federation.Callback)
+		}
+	}
+
+	private := v1.Group("/")
+	private.Use(middleware.AuthMiddleware(a.tokenKey, a.revocations))
+
+	// Changing roles, permissions and who holds them takes a permission of its
+	// own, or anyone could grant themselves anything. Changing a user takes
+	// a permission on that user, held globally or through a role scoped to
+	// users:{id}.
+	users := private.Group("/users")
+	{
+		users.GET("", a.users.QueryUsers)
+		users.GET("/search", a.users.SearchUsers)
+		users.GET("/count", a.users.CountUsers)
+		users.GET("/by-username/:username", a.users.GetUserByUsername)
+		users.GET("/:id", a.users.GetUserByID)
+		users.PUT("/:id", a.access(service.PermissionUpdateUsers, "users:{id}"), a.users.UpdateUserByID)
+		users.DELETE("/:id", a.recentAuth, a.access(service.PermissionDeleteUsers, "users:{id}"), a.users.DeleteUser)
+		if a.features.Impersonation {
+			users.POST("/:id/impersonate", a.recentAuth, a.require(service.PermissionImpersonateUsers), a.users.ImpersonateUser)
+		}
+		users.GET("/:id/roles", a.roles.ListUserRoles)
+		users.GET("/:id/role-assignments", a.roles.ListRoleAssignments)
+		users.GET("/:id/roles/:roleID", a.roles.GetUserRole)
+		users.PUT("/:id/roles/:roleID", a.require(service.PermissionAssignRoles), a.roles.AssignUserRole)
+		users.DELETE("/:id/roles/:roleID", a.require(service.PermissionAssignRoles), a.roles.RevokeUserRole)
+	}
+
+	roles := private.Group("/roles")
+	{
+		roles.GET("", a.roles.QueryRoles)
+		roles.POST("", a.require(service.PermissionManageRoles), a.roles.CreateRole)
+		roles.GET("/:id", a.roles.GetRoleByID)
+		roles.PUT("/:id", a.require(service.PermissionManageRoles), a.roles.UpdateRoleByID)
+		roles.DELETE("/:id", a.recentAuth, a.require(service.PermissionManageRoles), a.roles.DeleteRole)
+		roles.GET("/:id/users", a.users.ListRoleUsers)
+		roles.GET("/:id/permissions", a.permissions.ListRolePermissions)
+		roles.POST("/:id/permissions", a.recentAuth, a.require(service.PermissionGrantPermissions), a.permissions.GrantRolePermissions)
+		roles.DELETE("/:id/permissions", a.recentAuth, a.require(service.PermissionGrantPermissions), a.permissions.RevokeRolePermissions)
+		roles.PUT("/:id/permissions/:permissionID", a.recentAuth, a.require(service.PermissionGrantPermissions), a.permissions.GrantRolePermission)
+		roles.DELETE("/:id/permissions/:permissionID", a.recentAuth, a.require(service.PermissionGrantPermissions), a.permissions.RevokeRolePermission)
+	}
+
+	permissions := private.Group("/permissions")
+	{
+		permissions.GET("", a.permissions.QueryPermissions)
+		permissions.POST("", a.require(service.PermissionManagePermissions), a.permissions.CreatePermission)
+		permissions.GET("/expand", a.permissions.ExpandPermission)
+		permissions.GET("/:id", a.permissions.GetPermissionByID)
+		permissions.PUT("/:id", a.require(service.PermissionManagePermissions), a.permissions.UpdatePermissionByID)
+		permissions.DELETE("/:id", a.recentAuth, a.require(service.PermissionManagePermissions), a.permissions.DeletePermission)
+		permissions.GET("/:id/roles", a.roles.ListPermissionRoles)
+	}
+
+	// Policies can grant access, so changing them takes a recent login and a
+	// permission like changing grants does
+	policies := private.Group("/policies")
+	{
+		policies.GET("", a.policies.ListPolicies)
+		policies.POST("", a.recentAuth, a.require(service.PermissionManagePolicies), a.policies.CreatePolicy)
+		policies.GET("/:id", a.policies.GetPolicyByID)
+		policies.PUT("/:id", a.recentAuth, a.require(service.PermissionManagePolicies), a.policies.UpdatePolicyByID)
+		policies.DELETE("/:id", a.recentAuth, a.require(service.PermissionManagePolicies), a.policies.DeletePolicy)
+	}
+
+	authz := private.Group("/authz")
+	{
+		authz.POST("/check", a.require(service.PermissionReadAuthz), a.authz.CheckAccess)
+		authz.GET("/cache", a.require(service.PermissionReadAuthz), a.authz.CacheStats)
+	}
+}
+
+// legacySuccessors names the /v1 route that replaces each legacy route.
+// Legacy routes that take IDs in the body name successors with unfilled
+// parameters, which the Link shows as {id}.
+var legacySuccessors = map[string]string{
+	"POST /register":               "/v1/auth/register",
+	"POST /login":                  "/v1/auth/login",
+	"GET /auth/:provider/login":    "/v1/auth/:provider/login",
+	"GET /auth/:provider/callback": "/v1/auth/:provider/callback",
+	"GET /users":                   "/v1/users",
+	"GET /users/search":            "/v1/users/search",
+	"GET /users/count":             "/v1/users/count",
+	"GET /user/name/:username":     "/v1/users/by-username/:username",
+	"GET /user/:id":                "/v1/users/:id",
+	"PUT /user":                    "/v1/users/:id",
+	"DELETE /user/:id":             "/v1/users/:id",
+	"POST /users/:id/impersonate":  "/v1/users/:id/impersonate",
+	"POST /roles/":                 "/v1/roles",
+	"PUT /roles/":                  "/v1/roles/:id",
+	"DELETE /roles/:id":            "/v1/roles/:id",
+	"GET /roles/:id":               "/v1/roles/:id",
+	"GET /roles/":                  "/v1/roles",
+	"POST /user-roles/":            "/v1/users/:id/roles/:roleID",
+	"DELETE /user-roles/":          "/v1/users/:id/roles/:roleID",
+	"GET /users/user/:userID/has-role/:roleName": "/v1/users/:userID/roles",
+	"GET /users/user/:userID/roles":              "/v1/users/:userID/roles",
+	"POST /permission":                           "/v1/permissions",
+	"PUT /permission":                            "/v1/permissions/:id",
+	"DELETE /permission/:id":                     "/v1/permissions/:id",
+	"GET /permission":                            "/v1/permissions",
+	"GET /permission/:id":                        "/v1/permissions/:id",
+	"POST /permission/assign":                    "/v1/roles/:id/permissions/:permissionID",
+	"POST /permission/remove":                    "/v1/roles/:id/permissions/:permissionID",
+	"POST /permission/assign/multiple":           "/v1/roles/:id/permissions",
+	"POST /permission/remove/multiple":           "/v1/roles/:id/permissions",
+}
+
+// registerLegacy keeps the routes that predate /v1 working until the sunset.
+// Each one behaves as before and points to its /v1 successor.
+func (a *apiRoutes) registerLegacy(r *gin.Engine) {
+	legacy := r.Group("/", middleware.Deprecated(legacyDeprecated, legacySunset, legacySuccessors))
+
+	// Create a group for routes which don't require authentication
+	publicRoutes := legacy.Group("/")
+	{
+		if a.features.Registration {
+			publicRoutes.POST("/register", a.users.RegisterUser)
+		}
+		publicRoutes.POST("/login", a.users.LoginUser)
+		if a.features.Federation {
+			publicRoutes.GET("/auth/:provider/login", a.federation.StartLogin)
+			publicRoutes.GET("/auth/:provider/callback", a.federation.Callback)
+		}
+	}
+
+	// Create a group for routes which require authentication
+	privateRoutes := legacy.Group("/")
+	privateRoutes.Use(middleware.AuthMiddleware(a.tokenKey, a.revocations))
+	{
+		privateRoutes.GET("/users", a.users.ListUsers)
+		privateRoutes.GET("/users/search", a.users.SearchUsers)
+		privateRoutes.GET("/users/count", a.users.CountUsers)
+		privateRoutes.GET("/user/name/:username", a.users.GetUserByUsername)
+		privateRoutes.GET("/user/:id", a.users.GetUserByID)
+		privateRoutes.PUT("/user", a.require(service.PermissionUpdateUsers), a.users.UpdateUser)
+		privateRoutes.DELETE("/user/:id", a.recentAuth, a.access(service.PermissionDeleteUsers, "users:{id}"), a.users.DeleteUser)
+		if a.features.Impersonation {
+			privateRoutes.POST("/users/:id/impersonate", a.recentAuth, a.require(service.PermissionImpersonateUsers), a.users.ImpersonateUser)
+		}
+
+		roles := privateRoutes.Group("/roles")
+		{
+			roles.POST("/", a.require(service.PermissionManageRoles), a.roles.CreateRole)
+			roles.PUT("/", a.require(service.PermissionManageRoles), a.roles.UpdateRole)
+			roles.DELETE("/:id", a.recentAuth, a.require(service.PermissionManageRoles), a.roles.DeleteRole)
+			roles.GET("/:id", a.roles.GetRoleByID)
+			roles.GET("/", a.roles.GetAllRoles)
+		}
+
+		userRoles := privateRoutes.Group("/user-roles")
+		{
+			userRoles.POST("/", a.require(service.PermissionAssignRoles), a.roles.AddUserRole)
+			userRoles.DELETE("/", a.require(service.PermissionAssignRoles), a.roles.RemoveUserRole)
+		}
+
+		users := privateRoutes.Group("/users")
+		{
+			users.GET("/user/:userID/has-role/:roleName", a.roles.UserHasRole)
+			users.GET("/user/:userID/roles", a.roles.GetRolesByUserID)
+		}
+
+		// Permission related routes
+		permissionGroup := privateRoutes.Group("/permission")
+		{
+			permissionGroup.POST("", a.require(service.PermissionManagePermissions), a.permissions.CreatePermission)
+			permissionGroup.PUT("", a.require(service.PermissionManagePermissions), a.permissions.UpdatePermission)
+			permissionGroup.DELETE("/:id", a.recentAuth, a.require(service.PermissionManagePermissions), a.permissions.DeletePermission)
+			permissionGroup.GET("", a.permissions.GetAllPermissions)
+			permissionGroup.GET("/:id", a.permissions.GetPermissionByID)
+			permissionGroup.POST("/assign", a.recentAuth, a.require(service.PermissionGrantPermissions), a.permissions.AssignPermissionToRole)
+			permissionGroup.POST("/remove", a.recentAuth, a.require(service.PermissionGrantPermissions), a.permissions.RemovePermissionFromRole)
+			permissionGroup.POST("/assign/multiple", a.recentAuth, a.require(service.PermissionGrantPermissions), a.permissions.AddMultiplePermissionsToRole)
+			permissionGroup.POST("/remove/multiple", a.recentAuth, a.require(service.PermissionGrantPermissions), a.permissions.RemoveMultiplePermissionsFromRole)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/bhanupbalusu/gocomboums_v4/cmd/http/openapi"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/model"
+	"github.com/bhanupbalusu/gocomboums_v4/internal/service"
+	"github.com/bhanupbalusu/gocomboums_v4/pkg/config"
+)
+
+const testPassword = "correct horse battery staple"
+
+// newTestApp builds the app on the memory backend with requests and responses
+// checked against the OpenAPI document. The users are created first, each
+// with testPassword, and the admins among them hold every permission.
+func newTestApp(t *testing.T, users []string, admins []string) *app {
+	t.Helper()
+	cfg := testConfig()
+	cfg.BootstrapAdmins = admins
+	return newConfiguredTestApp(t, cfg, users)
+}
+
+// testConfig is the configuration newTestApp starts from
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Server.ValidateRequests = true
+	cfg.Server.ValidateResponses = true
+	return cfg
+}
+
+// newConfiguredTestApp is newTestApp on cfg, which only the driver is changed in
+func newConfiguredTestApp(t *testing.T, cfg *config.Config, users []string) *app {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg.Database.Driver = config.DriverMemory
+
+	repos, err := openRepositories(cfg.Database, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range users {
+		user := &model.User{Username: username, PasswordHash: string(hash), Email: username + "@example.com"}
+		if err := repos.users.CreateUser(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := service.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := newApp(cfg, repos, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// serve sends a request to the app, with a bearer token unless token is empty
+func (a *app) serve(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	var encoded []byte
+	if body != nil {
+		encoded, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// login returns a token for username, which must have testPassword
+func (a *app) login(t *testing.T, username string) string {
+	t.Helper()
+	rec := a.serve(http.MethodPost, "/v1/auth/login", "", map[string]string{"username": username, "password": testPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login %s: %d %s", username, rec.Code, rec.Body)
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Token
+}
+
+func TestPolicyWritesRequirePermission(t *testing.T) {
+	a := newTestApp(t, []string{"alice", "bob"}, []string{"alice"})
+	admin := a.login(t, "alice")
+	user := a.login(t, "bob")
+
+	policy := map[string]interface{}{
+		"name":       "office-hours",
+		"effect":     "deny",
+		"permission": "users:read",
+		"condition":  map[string]interface{}{"attr": "context.hour", "op": "lt", "value": 9},
+	}
+
+	rec := a.serve(http.MethodPost, "/v1/policies", admin, policy)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("admin create: %d %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		ID uint64 `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	path := "/v1/policies/" + strconv.FormatUint(created.ID, 10)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"create", http.MethodPost, "/v1/policies", policy},
+		{"update", http.MethodPut, path, policy},
+		{"delete", http.MethodDelete, path, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := a.serve(tt.method, tt.path, user, tt.body)
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("got %d %s, want 403", rec.Code, rec.Body)
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != "application/problem+json" {
+				t.Errorf("got content type %q, want application/problem+json", contentType)
+			}
+		})
+	}
+
+	if rec := a.serve(http.MethodGet, path, user, nil); rec.Code != http.StatusOK {
+		t.Errorf("the policy was changed or cannot be read: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAuthzRoutesRequirePermission(t *testing.T) {
+	a := newTestApp(t, []string{"alice", "bob"}, []string{"alice"})
+	admin := a.login(t, "alice")
+	user := a.login(t, "bob")
+
+	check := map[string]interface{}{
+		"checks": []map[string]interface{}{{"subject": 1, "permission": "users:delete"}},
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"check", http.MethodPost, "/v1/authz/check", check},
+		{"cache", http.MethodGet, "/v1/authz/cache", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := a.serve(tt.method, tt.path, user, tt.body); rec.Code != http.StatusForbidden {
+				t.Errorf("without the permission: got %d %s, want 403", rec.Code, rec.Body)
+			}
+			if rec := a.serve(tt.method, tt.path, admin, tt.body); rec.Code != http.StatusOK {
+				t.Errorf("with the permission: got %d %s, want 200", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestMalformedPathIDIsBadRequest(t *testing.T) {
+	a := newTestApp(t, []string{"alice"}, []string{"alice"})
+	admin := a.login(t, "alice")
+
+	for _, path := range []string{"/v1/permissions/abc", "/v1/permissions/0", "/v1/permissions/-1"} {
+		rec := a.serve(http.MethodDelete, path, admin, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("DELETE %s: got %d %s, want 400", path, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestUserWritesRequirePermissionOnTheUser(t *testing.T) {
+	a := newTestApp(t, []string{"alice", "bob"}, []string{"alice"})
+	admin := a.login(t, "alice")
+
+	// created decodes the ID of what rec holds
+	created := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+			t.Fatalf("got %d %s", rec.Code, rec.Body)
+		}
+		var body struct {
+			ID uint64 `json:"id"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return strconv.FormatUint(body.ID, 10)
+	}
+	aliceID := created(a.serve(http.MethodGet, "/v1/users/by-username/alice", admin, nil))
+	bobID := created(a.serve(http.MethodGet, "/v1/users/by-username/bob", admin, nil))
+
+	// bob may change himself and nobody else
+	roleID := created(a.serve(http.MethodPost, "/v1/roles", admin, map[string]string{"role_name": "self-service"}))
+	rec := a.serve(http.MethodPost, "/v1/permissions", admin, map[string]string{"permission_name": service.PermissionUpdateUsers})
+	var permission struct {
+		Permission struct {
+			ID uint64 `json:"id"`
+		} `json:"permission"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &permission); err != nil {
+		t.Fatal(err)
+	}
+	permissionID := strconv.FormatUint(permission.Permission.ID, 10)
+	if rec := a.serve(http.MethodPut, "/v1/roles/"+roleID+"/permissions/"+permissionID, admin, nil); rec.Code >= 300 {
+		t.Fatalf("grant: %d %s", rec.Code, rec.Body)
+	}
+	if rec := a.serve(http.MethodPut, "/v1/users/"+bobID+"/roles/"+roleID+"?scope=users:"+bobID, admin, nil); rec.Code >= 300 {
+		t.Fatalf("assign: %d %s", rec.Code, rec.Body)
+	}
+	user := a.login(t, "bob")
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"update self", user, http.MethodPut, "/v1/users/" + bobID, map[string]string{"username": "bob", "email": "bob@example.org"}, http.StatusOK},
+		{"update another user", user, http.MethodPut, "/v1/users/" + aliceID, map[string]string{"username": "alice", "email": "alice@example.org"}, http.StatusForbidden},
+		{"delete another user", user, http.MethodDelete, "/v1/users/" + aliceID, nil, http.StatusForbidden},
+		{"delete self without the permission", user, http.MethodDelete, "/v1/users/" + bobID, nil, http.StatusForbidden},
+		{"admin updates any user", admin, http.MethodPut, "/v1/users/" + bobID, map[string]string{"username": "bob", "email": "bob@example.net"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := a.serve(tt.method, tt.path, tt.token, tt.body); rec.Code != tt.want {
+				t.Errorf("got %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+// routeShape is a route's path with its parameter names dropped, so routes
+// naming their parameters differently compare equal
+func routeShape(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = ":"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func TestLegacyRoutesNameTheirSuccessors(t *testing.T) {
+	// Requests without a body must reach the routes to see their headers
+	cfg := testConfig()
+	cfg.Server.ValidateRequests = false
+	a := newConfiguredTestApp(t, cfg, nil)
+
+	current := map[string]bool{}
+	for _, route := range a.router.Routes() {
+		if strings.HasPrefix(route.Path, "/v1/") {
+			current[routeShape(route.Path)] = true
+		}
+	}
+
+	legacy := 0
+	for _, route := range a.router.Routes() {
+		if strings.HasPrefix(route.Path, "/v1/") {
+			continue
+		}
+		rec := a.serve(route.Method, strings.ReplaceAll(routeShape(route.Path), ":", "1"), "", nil)
+		if rec.Header().Get("Deprecation") == "" {
+			continue
+		}
+		legacy++
+		key := route.Method + " " + route.Path
+		successor, ok := legacySuccessors[key]
+		if !ok {
+			t.Errorf("%s names no successor", key)
+			continue
+		}
+		if !current[routeShape(successor)] {
+			t.Errorf("%s names %s, which is not a /v1 route", key, successor)
+		}
+		if link := rec.Header().Get("Link"); !strings.HasSuffix(link, `>; rel="successor-version"`) || strings.Contains(link, ":") {
+			t.Errorf("%s links %q, want its successor with the parameters filled in", key, link)
+		}
+	}
+	if legacy != len(legacySuccessors) {
+		t.Errorf("found %d legacy routes, want one for each of the %d successors", legacy, len(legacySuccessors))
+	}
+}
+
+func TestRoutesAreDocumented(t *testing.T) {
+	// Every optional route is on: the features by default, SCIM by its token
+	cfg := testConfig()
+	cfg.SCIMToken = "scim-token"
+	a := newConfiguredTestApp(t, cfg, nil)
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range a.router.Routes() {
+		// The document writes gin's :id as {id}
+		segments := strings.Split(route.Path, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				segments[i] = "{" + segment[1:] + "}"
+			}
+		}
+		path := strings.Join(segments, "/")
+		if spec.Paths[path][strings.ToLower(route.Method)] == nil {
+			t.Errorf("%s %s has no operation in the OpenAPI document", route.Method, path)
+		}
+	}
+}